@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/cell/task"
+	"github.com/project-nano/framework"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/mem"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AlertRule : raise when value crosses threshold for duration, clear when value crosses back over recover
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Threshold float64 `json:"threshold"`
+	Recover   float64 `json:"recover,omitempty"`  //same as threshold when omitted
+	Duration  int     `json:"duration,omitempty"` //seconds
+}
+
+type alertSample struct {
+	Metric string
+	Target string
+	Value  float64
+}
+
+type alertState struct {
+	service.Alert
+	BreachSince time.Time
+	Raised      bool
+}
+
+type usageResult struct {
+	Usage *disk.UsageStat
+	Error error
+}
+
+type alertCommand struct {
+	AlertChan chan []service.Alert
+}
+
+// AlertManager : evaluate threshold rules of host, storage and guest, notify observers when alert raised or cleared
+type AlertManager struct {
+	rules          []AlertRule
+	states         map[string]*alertState //key = rule name + target
+	collector      *CollectorModule
+	insManager     *service.InstanceManager
+	storageManager *service.StorageManager
+	commands       chan alertCommand
+	//usage query not finished in previous rounds, key = path
+	usageQueries map[string]chan usageResult
+	notify       func(alert service.Alert, raised bool)
+	runner       *framework.SimpleRunner
+}
+
+const (
+	alertCheckInterval = 5 * time.Second
+	alertQueryTimeout  = 5 * time.Second
+	alertTargetHost    = "host"
+)
+
+func defaultAlertRules() []AlertRule {
+	return []AlertRule{
+		{Name: "low_memory", Metric: service.AlertMetricMemoryAvailable, Threshold: 5, Recover: 10},
+		{Name: "host_swapping", Metric: service.AlertMetricSwapUsed, Threshold: 20, Recover: 10, Duration: 60},
+		{Name: "low_storage", Metric: service.AlertMetricStorageFree, Threshold: 10, Recover: 15},
+		{Name: "guest_cpu_busy", Metric: service.AlertMetricGuestCPU, Threshold: 95, Recover: 85, Duration: 300},
+		{Name: "pool_unmounted", Metric: service.AlertMetricPoolUnmounted, Threshold: 0},
+	}
+}
+
+func CreateAlertManager(rules []AlertRule, collector *CollectorModule, insManager *service.InstanceManager,
+	storageManager *service.StorageManager) (manager *AlertManager, err error) {
+	const (
+		DefaultQueueSize = 1 << 4
+	)
+	if 0 == len(rules) {
+		rules = defaultAlertRules()
+	}
+	var names = map[string]bool{}
+	for index, rule := range rules {
+		if "" == rule.Name {
+			err = fmt.Errorf("name required for alert rule %d", index)
+			return
+		}
+		if _, exists := names[rule.Name]; exists {
+			err = fmt.Errorf("duplicate alert rule '%s'", rule.Name)
+			return
+		}
+		names[rule.Name] = true
+		switch rule.Metric {
+		case service.AlertMetricMemoryAvailable, service.AlertMetricSwapUsed, service.AlertMetricStorageFree,
+			service.AlertMetricGuestCPU, service.AlertMetricPoolUnmounted:
+			break
+		default:
+			err = fmt.Errorf("invalid metric '%s' of alert rule '%s'", rule.Metric, rule.Name)
+			return
+		}
+		if rule.Threshold < 0 || rule.Recover < 0 || rule.Duration < 0 {
+			err = fmt.Errorf("negative value in alert rule '%s'", rule.Name)
+			return
+		}
+		if 0 == rule.Recover {
+			rules[index].Recover = rule.Threshold
+		} else if service.IsLowerBad(rule.Metric) && rule.Recover < rule.Threshold {
+			err = fmt.Errorf("recover %.2f must not less than threshold %.2f in alert rule '%s'",
+				rule.Recover, rule.Threshold, rule.Name)
+			return
+		} else if !service.IsLowerBad(rule.Metric) && rule.Recover > rule.Threshold {
+			err = fmt.Errorf("recover %.2f must not greater than threshold %.2f in alert rule '%s'",
+				rule.Recover, rule.Threshold, rule.Name)
+			return
+		}
+	}
+	manager = &AlertManager{}
+	manager.rules = rules
+	manager.states = map[string]*alertState{}
+	manager.collector = collector
+	manager.insManager = insManager
+	manager.storageManager = storageManager
+	manager.commands = make(chan alertCommand, DefaultQueueSize)
+	manager.usageQueries = map[string]chan usageResult{}
+	manager.notify = manager.notifyAlert
+	manager.runner = framework.CreateSimpleRunner(manager.Routine)
+	return manager, nil
+}
+
+func (manager *AlertManager) Start() error {
+	return manager.runner.Start()
+}
+
+func (manager *AlertManager) Stop() error {
+	return manager.runner.Stop()
+}
+
+// GetAlerts : current raised alerts, sorted by raised time
+func (manager *AlertManager) GetAlerts(respChan chan []service.Alert) {
+	manager.commands <- alertCommand{AlertChan: respChan}
+}
+
+func (manager *AlertManager) Routine(c framework.RoutineController) {
+	log.Printf("<alert> started with %d rule(s)", len(manager.rules))
+	var checkTicker = time.NewTicker(alertCheckInterval)
+	for !c.IsStopping() {
+		select {
+		case <-c.GetNotifyChannel():
+			c.SetStopping()
+		case cmd := <-manager.commands:
+			cmd.AlertChan <- manager.currentAlerts()
+		case <-checkTicker.C:
+			samples, unavailable, err := manager.collectSamples()
+			if err != nil {
+				log.Printf("<alert> collect samples fail: %s", err.Error())
+			}
+			manager.evaluate(samples, unavailable, time.Now())
+		}
+	}
+	checkTicker.Stop()
+	log.Println("<alert> stopped")
+	c.NotifyExit()
+}
+
+func (manager *AlertManager) currentAlerts() (alerts []service.Alert) {
+	for _, state := range manager.states {
+		if state.Raised {
+			alerts = append(alerts, state.Alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].RaisedTime != alerts[j].RaisedTime {
+			return alerts[i].RaisedTime < alerts[j].RaisedTime
+		}
+		return alerts[i].Rule+alerts[i].Target < alerts[j].Rule+alerts[j].Target
+	})
+	return
+}
+
+// collectSamples : samples available still returned when some source fail, metrics of failed source marked unavailable
+func (manager *AlertManager) collectSamples() (samples []alertSample, unavailable map[string]bool, err error) {
+	var errs []string
+	unavailable = map[string]bool{}
+	if vm, err := mem.VirtualMemory(); err != nil {
+		errs = append(errs, fmt.Sprintf("get memory fail: %s", err.Error()))
+		unavailable[service.AlertMetricMemoryAvailable] = true
+	} else if 0 != vm.Total {
+		samples = append(samples, alertSample{service.AlertMetricMemoryAvailable, alertTargetHost,
+			float64(vm.Available) * 100 / float64(vm.Total)})
+	}
+	if swap, err := mem.SwapMemory(); err != nil {
+		errs = append(errs, fmt.Sprintf("get swap fail: %s", err.Error()))
+		unavailable[service.AlertMetricSwapUsed] = true
+	} else if 0 != swap.Total {
+		samples = append(samples, alertSample{service.AlertMetricSwapUsed, alertTargetHost, swap.UsedPercent})
+	}
+	storageSamples, partial, storageError := manager.collectStorageSamples()
+	if storageError != nil {
+		errs = append(errs, storageError.Error())
+		unavailable[service.AlertMetricPoolUnmounted] = true
+		unavailable[service.AlertMetricStorageFree] = true
+	} else {
+		samples = append(samples, storageSamples...)
+		if partial {
+			unavailable[service.AlertMetricStorageFree] = true
+		}
+	}
+	{
+		var respChan = make(chan []service.InstanceStatus, 1)
+		manager.insManager.GetAllInstanceStatus(respChan)
+		select {
+		case instances := <-respChan:
+			for _, instance := range instances {
+				if !instance.Running {
+					continue
+				}
+				samples = append(samples, alertSample{service.AlertMetricGuestCPU, instance.ID, instance.CpuUsages})
+			}
+		case <-time.After(alertQueryTimeout):
+			errs = append(errs, "query instance status timeout")
+			unavailable[service.AlertMetricGuestCPU] = true
+		}
+	}
+	if 0 != len(errs) {
+		err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return
+}
+
+// collectStorageSamples : partial when usage of some path not available
+func (manager *AlertManager) collectStorageSamples() (samples []alertSample, partial bool, err error) {
+	const (
+		nfsPrefix = "nfs"
+	)
+	var respChan = make(chan service.StorageResult, 1)
+	manager.storageManager.QueryStoragePaths(respChan)
+	var result service.StorageResult
+	select {
+	case result = <-respChan:
+	case <-time.After(alertQueryTimeout):
+		err = fmt.Errorf("query storage paths timeout")
+		return
+	}
+	var paths = map[string]bool{}
+	for _, path := range append(result.SystemPaths, result.DataPaths...) {
+		paths[path] = true
+	}
+	if 0 != len(result.Devices) {
+		partitions, err := disk.Partitions(true)
+		if err != nil {
+			return nil, false, fmt.Errorf("get partitions fail: %s", err.Error())
+		}
+		var mounted = map[string]bool{}
+		for _, partition := range partitions {
+			if strings.HasPrefix(partition.Fstype, nfsPrefix) {
+				mounted[partition.Mountpoint] = true
+			}
+		}
+		for _, pool := range result.Devices {
+			var value float64 = 0
+			if !mounted[pool.Path] {
+				value = 1
+			} else {
+				paths[pool.Path] = true
+			}
+			samples = append(samples, alertSample{service.AlertMetricPoolUnmounted, pool.Name, value})
+		}
+	}
+	usages, incomplete := manager.queryUsages(paths)
+	for path, usage := range usages {
+		if 0 == usage.Total {
+			continue
+		}
+		samples = append(samples, alertSample{service.AlertMetricStorageFree, path,
+			float64(usage.Free) * 100 / float64(usage.Total)})
+	}
+	return samples, partial || incomplete, nil
+}
+
+// queryUsages : query usage of all paths concurrently in alertQueryTimeout, so that a hung NFS mount only
+// delays this round. a path not returned yet is not queried again until previous query finished
+func (manager *AlertManager) queryUsages(paths map[string]bool) (usages map[string]*disk.UsageStat, partial bool) {
+	for path, resultChan := range manager.usageQueries {
+		if !paths[path] {
+			//path removed, result dropped when finished
+			delete(manager.usageQueries, path)
+			continue
+		}
+		select {
+		case <-resultChan:
+			//finished after previous timeout, result stale so query again
+			log.Printf("<alert> usage query of '%s' recovered", path)
+			delete(manager.usageQueries, path)
+		default:
+		}
+	}
+	for path := range paths {
+		if _, exists := manager.usageQueries[path]; exists {
+			continue
+		}
+		var resultChan = make(chan usageResult, 1)
+		manager.usageQueries[path] = resultChan
+		go func(path string) {
+			usage, err := disk.Usage(path)
+			resultChan <- usageResult{Usage: usage, Error: err}
+		}(path)
+	}
+	usages = map[string]*disk.UsageStat{}
+	var timer = time.NewTimer(alertQueryTimeout)
+	defer timer.Stop()
+	var expired = false
+	for path := range paths {
+		var resultChan = manager.usageQueries[path]
+		var result usageResult
+		var finished = false
+		if expired {
+			select {
+			case result = <-resultChan:
+				finished = true
+			default:
+			}
+		} else {
+			select {
+			case result = <-resultChan:
+				finished = true
+			case <-timer.C:
+				expired = true
+			}
+		}
+		if !finished {
+			log.Printf("<alert> warning: get usage of '%s' timeout", path)
+			partial = true
+			continue
+		}
+		delete(manager.usageQueries, path)
+		if result.Error != nil {
+			log.Printf("<alert> warning: get usage of '%s' fail: %s", path, result.Error.Error())
+			partial = true
+			continue
+		}
+		usages[path] = result.Usage
+	}
+	return
+}
+
+// evaluate : apply rules to samples, alert of target without sample cleared,
+// unless metric unavailable in this round, so that failed source neither clears alert nor restarts duration
+func (manager *AlertManager) evaluate(samples []alertSample, unavailable map[string]bool, now time.Time) {
+	var sampled = map[string]bool{}
+	for _, rule := range manager.rules {
+		var lowerBad = service.IsLowerBad(rule.Metric)
+		var duration = time.Duration(rule.Duration) * time.Second
+		for _, sample := range samples {
+			if sample.Metric != rule.Metric {
+				continue
+			}
+			var key = rule.Name + ":" + sample.Target
+			sampled[key] = true
+			state, exists := manager.states[key]
+			if !exists {
+				state = &alertState{Alert: service.Alert{Rule: rule.Name, Metric: rule.Metric,
+					Target: sample.Target, Threshold: rule.Threshold}}
+				manager.states[key] = state
+			}
+			state.Value = sample.Value
+			var breached, recovered bool
+			if lowerBad {
+				breached = sample.Value < rule.Threshold
+				recovered = sample.Value >= rule.Recover
+			} else {
+				breached = sample.Value > rule.Threshold
+				recovered = sample.Value <= rule.Recover
+			}
+			if state.Raised {
+				if recovered {
+					state.Raised = false
+					state.BreachSince = time.Time{}
+					manager.notify(state.Alert, false)
+				}
+				continue
+			}
+			if !breached {
+				state.BreachSince = time.Time{}
+				continue
+			}
+			if state.BreachSince.IsZero() {
+				state.BreachSince = now
+			}
+			if now.Sub(state.BreachSince) >= duration {
+				state.Raised = true
+				state.RaisedTime = now.Format(service.TimeFormatLayout)
+				manager.notify(state.Alert, true)
+			}
+		}
+	}
+	for key, state := range manager.states {
+		if sampled[key] || unavailable[state.Metric] {
+			continue
+		}
+		//target removed or stopped
+		if state.Raised {
+			manager.notify(state.Alert, false)
+		}
+		delete(manager.states, key)
+	}
+}
+
+func (manager *AlertManager) notifyAlert(alert service.Alert, raised bool) {
+	var msg framework.Message
+	var err error
+	if raised {
+		log.Printf("<alert> '%s' raised on %s, %s %.2f, threshold %.2f",
+			alert.Rule, alert.Target, alert.Metric, alert.Value, alert.Threshold)
+		msg, err = framework.CreateJsonMessage(task.CellAlertRaisedEvent)
+	} else {
+		log.Printf("<alert> '%s' cleared on %s, %s %.2f",
+			alert.Rule, alert.Target, alert.Metric, alert.Value)
+		msg, err = framework.CreateJsonMessage(task.CellAlertClearedEvent)
+	}
+	if err != nil {
+		log.Printf("<alert> build notify message fail: %s", err.Error())
+		return
+	}
+	msg.SetFromSession(0)
+	service.MarshalAlerts(msg, []service.Alert{alert})
+	manager.collector.Broadcast(msg)
+}
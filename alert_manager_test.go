@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-nano/cell/service"
+)
+
+func TestAlertManager_Evaluate(t *testing.T) {
+	const target = "guest"
+	type step struct {
+		Elapsed     int //seconds
+		Value       float64
+		Sampled     bool
+		Unavailable bool
+		Raised      bool
+		Notified    []bool
+	}
+	var cases = []struct {
+		Name  string
+		Rule  AlertRule
+		Steps []step
+	}{
+		{
+			Name: "lower bad with hysteresis",
+			Rule: AlertRule{Name: "low_memory", Metric: service.AlertMetricMemoryAvailable, Threshold: 5, Recover: 10},
+			Steps: []step{
+				{Elapsed: 0, Value: 20, Sampled: true},
+				{Elapsed: 5, Value: 4, Sampled: true, Raised: true, Notified: []bool{true}},
+				{Elapsed: 10, Value: 3, Sampled: true, Raised: true},
+				{Elapsed: 15, Value: 8, Sampled: true, Raised: true},
+				{Elapsed: 20, Value: 10, Sampled: true, Notified: []bool{false}},
+				{Elapsed: 25, Value: 6, Sampled: true},
+			},
+		},
+		{
+			Name: "higher bad with duration",
+			Rule: AlertRule{Name: "guest_cpu_busy", Metric: service.AlertMetricGuestCPU, Threshold: 95, Recover: 85, Duration: 300},
+			Steps: []step{
+				{Elapsed: 0, Value: 99, Sampled: true},
+				{Elapsed: 200, Value: 90, Sampled: true},
+				{Elapsed: 250, Value: 99, Sampled: true},
+				{Elapsed: 549, Value: 99, Sampled: true},
+				{Elapsed: 550, Value: 99, Sampled: true, Raised: true, Notified: []bool{true}},
+				{Elapsed: 600, Value: 90, Sampled: true, Raised: true},
+				{Elapsed: 650, Value: 85, Sampled: true, Notified: []bool{false}},
+			},
+		},
+		{
+			Name: "unavailable metric keeps duration",
+			Rule: AlertRule{Name: "guest_cpu_busy", Metric: service.AlertMetricGuestCPU, Threshold: 95, Recover: 85, Duration: 300},
+			Steps: []step{
+				{Elapsed: 0, Value: 99, Sampled: true},
+				{Elapsed: 100, Unavailable: true},
+				{Elapsed: 300, Value: 99, Sampled: true, Raised: true, Notified: []bool{true}},
+				{Elapsed: 305, Unavailable: true, Raised: true},
+			},
+		},
+		{
+			Name: "target removed",
+			Rule: AlertRule{Name: "low_storage", Metric: service.AlertMetricStorageFree, Threshold: 10, Recover: 15},
+			Steps: []step{
+				{Elapsed: 0, Value: 5, Sampled: true, Raised: true, Notified: []bool{true}},
+				{Elapsed: 5, Notified: []bool{false}},
+				{Elapsed: 10, Value: 5, Sampled: true, Raised: true, Notified: []bool{true}},
+			},
+		},
+	}
+	var start = time.Now()
+	for _, c := range cases {
+		var notified []bool
+		var manager = &AlertManager{
+			rules:  []AlertRule{c.Rule},
+			states: map[string]*alertState{},
+			notify: func(alert service.Alert, raised bool) {
+				notified = append(notified, raised)
+			},
+		}
+		for index, s := range c.Steps {
+			notified = nil
+			var samples []alertSample
+			if s.Sampled {
+				samples = append(samples, alertSample{Metric: c.Rule.Metric, Target: target, Value: s.Value})
+			}
+			var unavailable = map[string]bool{}
+			if s.Unavailable {
+				unavailable[c.Rule.Metric] = true
+			}
+			manager.evaluate(samples, unavailable, start.Add(time.Duration(s.Elapsed)*time.Second))
+			var alerts = manager.currentAlerts()
+			if s.Raised != (1 == len(alerts)) {
+				t.Fatalf("%s: step %d expect raised %t, but %d alert(s)", c.Name, index, s.Raised, len(alerts))
+			}
+			if len(s.Notified) != len(notified) {
+				t.Fatalf("%s: step %d expect notified %v, but %v", c.Name, index, s.Notified, notified)
+			}
+			for i := range notified {
+				if s.Notified[i] != notified[i] {
+					t.Fatalf("%s: step %d expect notified %v, but %v", c.Name, index, s.Notified, notified)
+				}
+			}
+		}
+	}
+}
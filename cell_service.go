@@ -3,12 +3,15 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/libvirt/libvirt-go"
 	"github.com/project-nano/cell/service"
+	"github.com/project-nano/cell/task"
 	"github.com/project-nano/framework"
 )
 
@@ -27,6 +30,16 @@ type CellService struct {
 	virConnect     *libvirt.Connect
 	initiator      *service.GuestInitiator
 	dhcpService    *service.DHCPService
+	ipv6Service    *service.IPv6Service
+	exporter       *MetricsExporter
+	exporterListen string
+	diagnostic     *DiagnosticServer
+	diagnosticAddr string
+	console        *ConsoleProxy
+	consoleListen  string
+	consoleTTL     time.Duration
+	alertManager   *AlertManager
+	alertRules     []AlertRule
 }
 
 func CreateCellService(config DomainConfig, workingPath string) (service *CellService, err error) {
@@ -41,6 +54,14 @@ func CreateCellService(config DomainConfig, workingPath string) (service *CellSe
 	}
 	service = &CellService{}
 	service.DataPath = dataPath
+	service.exporterListen = config.MetricsExporter
+	service.diagnosticAddr = config.DiagnosticListen
+	service.consoleListen = config.ConsoleListen
+	service.consoleTTL = defaultConsoleTokenTTL * time.Second
+	if config.ConsoleTokenTTL > 0 {
+		service.consoleTTL = time.Duration(config.ConsoleTokenTTL) * time.Second
+	}
+	service.alertRules = config.AlertRules
 	if service.EndpointService, err = framework.CreatePeerEndpoint(config.GroupAddress, config.GroupPort, config.Domain); err != nil {
 		err = fmt.Errorf("create new endpoint fail: %s", err.Error())
 		return
@@ -73,6 +94,7 @@ func (cell *CellService) OnMessageReceived(msg framework.Message) {
 	case framework.ModifyAuthRequest:
 	case framework.ModifyGuestNameRequest:
 	case framework.ModifyAutoStartRequest:
+	case task.ModifyRestartPolicyRequest:
 	case framework.GetAuthRequest:
 	case framework.ResizeDiskRequest:
 	case framework.ShrinkDiskRequest:
@@ -101,6 +123,44 @@ func (cell *CellService) OnMessageReceived(msg framework.Message) {
 	case framework.ChangeGuestRuleOrderRequest:
 	case framework.RemoveGuestRuleRequest:
 
+	//metrics
+	case task.QueryInstanceMetricsRequest:
+
+	//host
+	case task.GetCellInventoryRequest:
+	case task.QueryCellAlertRequest:
+	case task.SetCellMaintenanceRequest:
+	case task.GetCellMaintenanceRequest:
+	case task.QueryCellCapacityRequest:
+
+	//diagnostics
+	case task.CaptureGuestScreenshotRequest:
+	case task.DumpGuestMemoryRequest:
+	case task.QueryGuestDiagnosticRequest:
+	case task.SendGuestKeysRequest:
+	case task.InjectGuestNMIRequest:
+
+	//console
+	case task.IssueConsoleTokenRequest:
+
+	//dhcp
+	case task.QueryDHCPLeaseRequest:
+	case task.AddDHCPReservationRequest:
+	case task.RemoveDHCPReservationRequest:
+
+	//overlay network
+	case task.UpdateOverlayNetworkRequest:
+	case task.RemoveOverlayNetworkRequest:
+
+	//port forward
+	case task.AddPortForwardRequest:
+	case task.RemovePortForwardRequest:
+	case task.QueryPortForwardRequest:
+
+	//floating address
+	case task.AttachExternalAddressRequest:
+	case task.DetachExternalAddressRequest:
+
 	default:
 		cell.handleIncomingMessage(msg)
 		return
@@ -117,6 +177,14 @@ func (cell *CellService) GetVersion() string {
 
 func (cell *CellService) handleIncomingMessage(msg framework.Message) {
 	switch msg.GetID() {
+	case task.InstanceEventAcknowledgeEvent:
+		//Core processed all instance events not greater than index
+		sequence, err := msg.GetUInt(framework.ParamKeyIndex)
+		if err != nil {
+			log.Printf("<cell> invalid event acknowledge from %s: %s", msg.GetSender(), err.Error())
+			return
+		}
+		cell.collector.AcknowledgeEvents(msg.GetSender(), uint64(sequence))
 	default:
 		log.Printf("<cell> message [%08X] from %s.[%08X] ignored", msg.GetID(), msg.GetSender(), msg.GetFromSession())
 	}
@@ -152,6 +220,12 @@ func (cell *CellService) InitialEndpoint() (err error) {
 	const (
 		DefaultLibvirtURL = "qemu:///system"
 	)
+	//event loop must registered before connect
+	if err = libvirt.EventRegisterDefaultImpl(); err != nil {
+		err = fmt.Errorf("register libvirt event loop fail: %s", err.Error())
+		return
+	}
+	go runLibvirtEventLoop()
 	if cell.virConnect, err = libvirt.NewConnect(DefaultLibvirtURL); err != nil {
 		return err
 	}
@@ -164,7 +238,7 @@ func (cell *CellService) InitialEndpoint() (err error) {
 		err = fmt.Errorf("initial instance manager fail: %s", err.Error())
 		return
 	}
-	if cell.collector, err = CreateCollectorModule(cell,
+	if cell.collector, err = CreateCollectorModule(cell, cell.DataPath, cell.virConnect,
 		cell.insManager.GetEventChannel(), cell.storageManager.GetOutputEventChannel()); err != nil {
 		return err
 	}
@@ -184,17 +258,58 @@ func (cell *CellService) InitialEndpoint() (err error) {
 	if cell.initiator, err = service.CreateInitiator(cell.networkManager, cell.insManager); err != nil {
 		return err
 	}
-	if cell.dhcpService, err = service.CreateDHCPService(cell.networkManager); err != nil {
+	if cell.dhcpService, err = service.CreateDHCPService(cell.networkManager, cell.DataPath, cell.insManager.GetEventChannel()); err != nil {
 		return err
 	}
+	if mode, _ := service.GetConfigurator().GetIPv6(); service.IPv6ModeNone != mode {
+		if cell.ipv6Service, err = service.CreateIPv6Service(cell.networkManager, cell.insManager.GetEventChannel()); err != nil {
+			err = fmt.Errorf("initial IPv6 service fail: %s", err.Error())
+			return
+		}
+	}
 
-	cell.transManager, err = CreateTransactionManager(cell, cell.insManager, cell.storageManager, cell.networkManager)
+	if cell.alertManager, err = CreateAlertManager(cell.alertRules, cell.collector,
+		cell.insManager, cell.storageManager); err != nil {
+		err = fmt.Errorf("initial alert manager fail: %s", err.Error())
+		return
+	}
+	var diagnosticModule service.DiagnosticModule
+	if "" != cell.diagnosticAddr {
+		if cell.diagnostic, err = CreateDiagnosticServer(cell.diagnosticAddr, cell.insManager); err != nil {
+			return err
+		}
+		diagnosticModule = cell.diagnostic
+	}
+	var consoleModule service.ConsoleModule
+	if "" != cell.consoleListen {
+		if cell.console, err = CreateConsoleProxy(cell.consoleListen, cell.consoleTTL, cell.insManager); err != nil {
+			return err
+		}
+		consoleModule = cell.console
+	}
+	cell.transManager, err = CreateTransactionManager(cell, cell.insManager, cell.storageManager, cell.networkManager,
+		cell.collector, cell.alertManager, consoleModule, cell.dhcpService, diagnosticModule)
 	if err != nil {
 		return err
 	}
+	if "" != cell.exporterListen {
+		if cell.exporter, err = CreateMetricsExporter(cell.exporterListen, cell.collector,
+			cell.insManager, cell.storageManager, cell.networkManager); err != nil {
+			return err
+		}
+	}
 	log.Println("<cell> all module ready")
 	return nil
 }
+func runLibvirtEventLoop() {
+	for {
+		if err := libvirt.EventRunDefaultImpl(); err != nil {
+			log.Printf("<cell> run libvirt event loop fail: %s", err.Error())
+			return
+		}
+	}
+}
+
 func (cell *CellService) OnEndpointStarted() (err error) {
 	if err = cell.collector.Start(); err != nil {
 		return err
@@ -205,6 +320,7 @@ func (cell *CellService) OnEndpointStarted() (err error) {
 	if err = cell.storageManager.Start(); err != nil {
 		return err
 	}
+	cell.networkManager.ReserveHostPorts(cell.hostPorts())
 	if err = cell.networkManager.Start(); err != nil {
 		return err
 	}
@@ -214,19 +330,89 @@ func (cell *CellService) OnEndpointStarted() (err error) {
 	if err = cell.dhcpService.Start(); err != nil {
 		return
 	}
+	if nil != cell.ipv6Service {
+		if err = cell.ipv6Service.Start(); err != nil {
+			return
+		}
+	}
 	if err = cell.transManager.Start(); err != nil {
 		return err
 	}
+	if err = cell.alertManager.Start(); err != nil {
+		return err
+	}
+	if nil != cell.exporter {
+		if err = cell.exporter.Start(); err != nil {
+			return err
+		}
+	}
+	if nil != cell.diagnostic {
+		if err = cell.diagnostic.Start(); err != nil {
+			return err
+		}
+	}
+	if nil != cell.console {
+		if err = cell.console.Start(); err != nil {
+			return err
+		}
+	}
 	log.Println("<cell> started")
 	return nil
 }
+
+// hostPorts : ports of endpoint and enabled services, never forwarded to guests
+func (cell *CellService) hostPorts() (ports []uint) {
+	ports = append(ports, uint(cell.GetListenPort()), uint(cell.GetGroupPort()))
+	for _, address := range []string{cell.exporterListen, cell.diagnosticAddr, cell.consoleListen} {
+		if "" == address {
+			continue
+		}
+		if _, value, err := net.SplitHostPort(address); err == nil {
+			if port, err := strconv.ParseUint(value, 10, 16); err == nil {
+				ports = append(ports, uint(port))
+			}
+		}
+	}
+	return ports
+}
+
 func (cell *CellService) OnEndpointStopped() {
+	if nil != cell.console {
+		if err := cell.console.Stop(); err != nil {
+			log.Printf("<cell> stop console proxy fail: %s", err.Error())
+		}
+	}
+	if nil != cell.diagnostic {
+		if err := cell.diagnostic.Stop(); err != nil {
+			log.Printf("<cell> stop diagnostic server fail: %s", err.Error())
+		}
+	}
+	if nil != cell.exporter {
+		if err := cell.exporter.Stop(); err != nil {
+			log.Printf("<cell> stop metrics exporter fail: %s", err.Error())
+		}
+	}
+	if err := cell.alertManager.Stop(); err != nil {
+		log.Printf("<cell> stop alert manager fail: %s", err.Error())
+	}
 	if err := cell.transManager.Stop(); err != nil {
 		log.Printf("<cell> stop transaction manger fail: %s", err.Error())
 	}
+	if timeout := service.GetConfigurator().GetGuestShutdownTimeout(); timeout > 0 {
+		var respChan = make(chan error, 1)
+		cell.insManager.ShutdownAllGuests(timeout, service.GetConfigurator().GetGuestShutdownLimit(), respChan)
+		if err := <-respChan; err != nil {
+			log.Printf("<cell> shutdown guests fail: %s", err.Error())
+		}
+	}
 	if err := cell.dhcpService.Stop(); err != nil {
 		log.Printf("<cell> stop dhcp service fail: %s", err.Error())
 	}
+	if nil != cell.ipv6Service {
+		if err := cell.ipv6Service.Stop(); err != nil {
+			log.Printf("<cell> stop IPv6 service fail: %s", err.Error())
+		}
+	}
 	if err := cell.initiator.Stop(); err != nil {
 		log.Printf("<cell> stop guest initiator fail: %s", err.Error())
 	}
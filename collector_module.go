@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"github.com/libvirt/libvirt-go"
 	"github.com/project-nano/cell/service"
+	"github.com/project-nano/cell/task"
 	"github.com/project-nano/framework"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/net"
 	"log"
+	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -27,6 +31,7 @@ type ioSnapshot struct {
 	DiskRead       uint64
 	NetworkSend    uint64
 	NetworkReceive uint64
+	Devices        []service.HostDeviceIO
 }
 
 type ioCounter struct {
@@ -40,11 +45,16 @@ type ioCounter struct {
 	ReadSpeed     uint64
 	SendSpeed     uint64
 	ReceiveSpeed  uint64
+	Devices       []service.HostDeviceIO
 }
 
 type collectorCmd struct {
-	Command collectorCommandType
-	Name    string
+	Command  collectorCommandType
+	Name     string
+	Refresh  bool
+	Sequence uint64
+	Message  framework.Message
+	HostChan chan service.HostResult
 }
 
 type collectorCommandType int
@@ -52,6 +62,10 @@ type collectorCommandType int
 const (
 	collectCommandAdd = iota
 	collectCommandRemove
+	collectCommandGetDeviceIO
+	collectCommandGetInventory
+	collectCommandBroadcast
+	collectCommandAcknowledge
 )
 
 type CollectorModule struct {
@@ -60,16 +74,34 @@ type CollectorModule struct {
 	instanceEvents        chan service.InstanceStatusChangedEvent
 	onStoragePathsChanged chan []string
 	localStoragePaths     []string
+	journal               *eventJournal
+	virConnect            *libvirt.Connect
+	inventory             service.HostInventory
 	runner                *framework.SimpleRunner
 }
 
-func CreateCollectorModule(sender framework.MessageSender,
+func CreateCollectorModule(sender framework.MessageSender, dataPath string, virConnect *libvirt.Connect,
 	eventChan chan service.InstanceStatusChangedEvent, storageChan chan []string) (*CollectorModule, error) {
 	const (
-		DefaultQueueSize = 1 << 10
+		DefaultQueueSize   = 1 << 10
+		JournalFileName    = "event.journal"
+		MaxJournaledEvents = 1 << 12
 	)
+	var err error
 	var module = CollectorModule{}
 	module.sender = sender
+	if module.journal, err = loadEventJournal(filepath.Join(dataPath, JournalFileName), MaxJournaledEvents); err != nil {
+		return nil, err
+	}
+	module.virConnect = virConnect
+	if module.inventory, err = collectHostInventory(virConnect); err != nil {
+		//partial inventory still usable, refresh on demand later
+		log.Printf("<collector> warning: collect host inventory fail: %s", err.Error())
+	}
+	log.Printf("<collector> host inventory: %s, %d cores, %d NUMA node(s), kernel %s, libvirt %s, qemu %s, kvm %t",
+		module.inventory.CPUModel, module.inventory.LogicalCores, len(module.inventory.NUMANodes),
+		module.inventory.KernelVersion, module.inventory.LibvirtVersion, module.inventory.QEMUVersion,
+		module.inventory.KVMAvailable)
 	module.commands = make(chan collectorCmd, DefaultQueueSize)
 	module.instanceEvents = eventChan
 	module.onStoragePathsChanged = storageChan
@@ -78,15 +110,38 @@ func CreateCollectorModule(sender framework.MessageSender,
 }
 
 func (collector *CollectorModule) AddObserver(name string) error {
-	collector.commands <- collectorCmd{collectCommandAdd, name}
+	collector.commands <- collectorCmd{Command: collectCommandAdd, Name: name}
 	return nil
 }
 
 func (collector *CollectorModule) RemoveObserver(name string) error {
-	collector.commands <- collectorCmd{collectCommandRemove, name}
+	collector.commands <- collectorCmd{Command: collectCommandRemove, Name: name}
 	return nil
 }
 
+// AcknowledgeEvents : Core processed all journaled events not greater than sequence
+func (collector *CollectorModule) AcknowledgeEvents(name string, sequence uint64) {
+	collector.commands <- collectorCmd{Command: collectCommandAcknowledge, Name: name, Sequence: sequence}
+}
+
+func (collector *CollectorModule) GetDeviceIO(respChan chan service.HostResult) {
+	collector.commands <- collectorCmd{Command: collectCommandGetDeviceIO, HostChan: respChan}
+}
+
+func (collector *CollectorModule) GetInventory(refresh bool, respChan chan service.HostResult) {
+	collector.commands <- collectorCmd{Command: collectCommandGetInventory, Refresh: refresh, HostChan: respChan}
+}
+
+// Broadcast : send message to all observers
+func (collector *CollectorModule) Broadcast(message framework.Message) {
+	collector.commands <- collectorCmd{Command: collectCommandBroadcast, Message: message}
+}
+
+// GetQueueDepth : count of commands waiting for handle
+func (collector *CollectorModule) GetQueueDepth() int {
+	return len(collector.commands)
+}
+
 func (collector *CollectorModule) Start() error {
 	return collector.runner.Start()
 }
@@ -101,11 +156,12 @@ func (collector *CollectorModule) Routine(c framework.RoutineController) {
 		collectInterval = reportInterval
 	)
 	log.Println("<collector> module started")
-	var observerMap = map[string]bool{}
+	var observerMap = map[string]*eventCursor{}
 	var latestIOSnapshot ioSnapshot
 	var latestSnapshotAvailable = false
 	var reportAvailable = false
 	var reportMessage framework.Message
+	var latestCounter ioCounter
 	var reportTicker = time.NewTicker(reportInterval)
 	var collectTicker = time.NewTicker(collectInterval)
 
@@ -123,6 +179,8 @@ func (collector *CollectorModule) Routine(c framework.RoutineController) {
 				//no observer available
 				break
 			}
+			//retry events failed in previous broadcast
+			collector.replayEvents(observerMap)
 			for target, _ := range observerMap {
 				if err := collector.sender.SendMessage(reportMessage, target); err != nil {
 					log.Printf("<collector> warning: send report to %s fail: %s", target, err.Error())
@@ -153,6 +211,7 @@ func (collector *CollectorModule) Routine(c framework.RoutineController) {
 			}
 			counter := computeIOCounter(latestIOSnapshot, currentSnapshot)
 			latestIOSnapshot = currentSnapshot
+			latestCounter = counter
 			reportMessage, err = buildObserverNotifyMessage(status, counter)
 			if err != nil {
 				log.Printf("<collector> marshal report message fail: %s", err.Error())
@@ -170,8 +229,12 @@ func (collector *CollectorModule) Routine(c framework.RoutineController) {
 					log.Printf("<collector> observer %s already exists", coreName)
 					break
 				}
-				observerMap[coreName] = true
+				observerMap[coreName] = &eventCursor{}
+				if err := collector.journal.Register(coreName); err != nil {
+					log.Printf("<collector> warning: register observer %s to journal fail: %s", coreName, err.Error())
+				}
 				log.Printf("<collector> new observer %s added", coreName)
+				collector.replayEvents(observerMap)
 			case collectCommandRemove:
 				if _, exists := observerMap[cmd.Name]; !exists {
 					log.Printf("<collector> invalid observer %s", cmd.Name)
@@ -179,6 +242,26 @@ func (collector *CollectorModule) Routine(c framework.RoutineController) {
 					delete(observerMap, cmd.Name)
 					log.Printf("<collector> observer %s removed", cmd.Name)
 				}
+			case collectCommandGetDeviceIO:
+				cmd.HostChan <- service.HostResult{Devices: latestCounter.Devices}
+			case collectCommandGetInventory:
+				if cmd.Refresh {
+					inventory, err := collectHostInventory(collector.virConnect)
+					if err != nil {
+						log.Printf("<collector> refresh host inventory fail: %s", err.Error())
+						cmd.HostChan <- service.HostResult{Error: err}
+						break
+					}
+					collector.inventory = inventory
+					log.Println("<collector> host inventory refreshed")
+				}
+				cmd.HostChan <- service.HostResult{Inventory: collector.inventory}
+			case collectCommandBroadcast:
+				collector.broadCastMessage(cmd.Message, observerMap)
+			case collectCommandAcknowledge:
+				if err := collector.journal.Acknowledge(cmd.Name, cmd.Sequence); err != nil {
+					log.Printf("<collector> warning: acknowledge events from %s fail: %s", cmd.Name, err.Error())
+				}
 			default:
 				log.Printf("<collector> invalid collector command %d", cmd.Command)
 			}
@@ -186,42 +269,123 @@ func (collector *CollectorModule) Routine(c framework.RoutineController) {
 			collector.localStoragePaths = paths
 			log.Printf("<collector> local storage paths changed to %s", paths)
 		case event := <-collector.instanceEvents:
-			var msg framework.Message
 			switch event.Event {
-			case service.InstanceStarted:
-				msg, _ = framework.CreateJsonMessage(framework.GuestStartedEvent)
-				msg.SetFromSession(0)
-				msg.SetString(framework.ParamKeyInstance, event.ID)
-			case service.InstanceStopped:
-				msg, _ = framework.CreateJsonMessage(framework.GuestStoppedEvent)
-				msg.SetFromSession(0)
-				msg.SetString(framework.ParamKeyInstance, event.ID)
-			case service.AddressChanged:
-				msg, _ = framework.CreateJsonMessage(framework.AddressChangedEvent)
-				msg.SetFromSession(0)
-				msg.SetString(framework.ParamKeyInstance, event.ID)
-				msg.SetString(framework.ParamKeyAddress, event.Address)
+			case service.InstanceStarted, service.InstanceStopped, service.AddressChanged, service.GuestRestartPolicy,
+				service.GuestWatchdogTriggered, service.GuestPanicked, service.AddressConflicted:
 			default:
 				log.Printf("<collector> ignore invalid instance event type %d", event.Event)
+				continue
 			}
-			collector.broadCastMessage(msg, observerMap)
+			if _, err := collector.journal.Append(event); err != nil {
+				log.Printf("<collector> warning: save event of instance '%s' to journal fail: %s", event.ID, err.Error())
+			}
+			if 0 == len(observerMap) {
+				log.Printf("<collector> no observer available, event of instance '%s' journaled", event.ID)
+				break
+			}
+			collector.replayEvents(observerMap)
 		}
 	}
 
+	if err := collector.journal.Close(); err != nil {
+		log.Printf("<collector> warning: close event journal fail: %s", err.Error())
+	}
 	log.Println("<collector> module stopped")
 	c.NotifyExit()
 }
 
-func (collector *CollectorModule) broadCastMessage(message framework.Message, observers map[string]bool) {
+//replayEvents send events not sent or acknowledged to each observer by sequence,
+//stop sending to an observer when an event can't delivered, events kept in journal until acknowledged by Core
+func (collector *CollectorModule) replayEvents(observers map[string]*eventCursor) {
+	var now = time.Now()
+	for receiver, cursor := range observers {
+		var unsent = collector.journal.Unsent(receiver, cursor, now)
+		if 0 == len(unsent) {
+			continue
+		}
+		var delivered = 0
+		for _, event := range unsent {
+			msg, err := buildInstanceEventMessage(event)
+			if err != nil {
+				log.Printf("<collector> build message for event %d fail: %s", event.Sequence, err.Error())
+				//skip invalid event
+				cursor.Sent = event.Sequence
+				continue
+			}
+			if err = collector.sender.SendMessage(msg, receiver); err != nil {
+				log.Printf("<collector> warning: send event %d to %s fail: %s", event.Sequence, receiver, err.Error())
+				break
+			}
+			cursor.Sent = event.Sequence
+			delivered++
+		}
+		if 1 < len(unsent) {
+			log.Printf("<collector> %d / %d journaled event(s) replayed to %s", delivered, len(unsent), receiver)
+		}
+	}
+}
+
+//broadCastMessage returns true when message delivered to at least one observer
+func (collector *CollectorModule) broadCastMessage(message framework.Message, observers map[string]*eventCursor) (delivered bool) {
 	if 0 == len(observers) {
 		log.Println("<collector> ignore broadcast, cause no observer available")
-		return
+		return false
 	}
 	for receiver, _ := range observers {
 		if err := collector.sender.SendMessage(message, receiver); err != nil {
 			log.Printf("<collector> warnning: notify message %08X to %s fail: %s", message.GetID(), receiver, err.Error())
+		} else {
+			delivered = true
 		}
 	}
+	return delivered
+}
+
+func buildInstanceEventMessage(event journalEvent) (msg framework.Message, err error) {
+	switch event.Event {
+	case service.InstanceStarted:
+		msg, err = framework.CreateJsonMessage(framework.GuestStartedEvent)
+	case service.InstanceStopped:
+		msg, err = framework.CreateJsonMessage(framework.GuestStoppedEvent)
+	case service.AddressChanged:
+		msg, err = framework.CreateJsonMessage(framework.AddressChangedEvent)
+	case service.GuestRestartPolicy:
+		msg, err = framework.CreateJsonMessage(task.GuestRestartPolicyEvent)
+	case service.GuestWatchdogTriggered:
+		msg, err = framework.CreateJsonMessage(task.GuestWatchdogEvent)
+	case service.GuestPanicked:
+		msg, err = framework.CreateJsonMessage(task.GuestPanicEvent)
+	case service.AddressConflicted:
+		msg, err = framework.CreateJsonMessage(task.AddressConflictEvent)
+	default:
+		err = fmt.Errorf("invalid instance event type %d", event.Event)
+	}
+	if err != nil {
+		return
+	}
+	msg.SetFromSession(0)
+	msg.SetString(framework.ParamKeyInstance, event.Instance)
+	if service.AddressChanged == event.Event {
+		msg.SetString(framework.ParamKeyAddress, event.Address)
+		//same key of IPv6 address as guest config
+		msg.SetStringArray(framework.ParamKeyNetwork, []string{event.AddressV6})
+	}
+	if service.GuestRestartPolicy == event.Event {
+		msg.SetString(framework.ParamKeyType, event.Action)
+		msg.SetUInt(framework.ParamKeyCount, event.Count)
+	}
+	if service.GuestWatchdogTriggered == event.Event || service.GuestPanicked == event.Event {
+		msg.SetString(framework.ParamKeyType, event.Action)
+		msg.SetString(framework.ParamKeyPath, event.Path)
+	}
+	if service.AddressConflicted == event.Event {
+		//instance is empty when address not allocated to guest
+		msg.SetString(framework.ParamKeyAddress, event.Address)
+		msg.SetString(framework.ParamKeyHardware, event.HardwareAddress)
+	}
+	//sequence for ordering and duplicate detection on Core
+	msg.SetUInt(framework.ParamKeyIndex, uint(event.Sequence))
+	return msg, nil
 }
 
 func (collector *CollectorModule)collectHostStatus() (hostStatus, error) {
@@ -256,54 +420,90 @@ func (collector *CollectorModule)collectHostStatus() (hostStatus, error) {
 	return status, nil
 }
 
+// captureIOSnapshot : counters of physical disks and interfaces, virtual devices excluded
 func captureIOSnapshot() (ioSnapshot, error) {
-	var snapshot ioSnapshot
-	partitions, err := disk.Partitions(false)
+	var snapshot = ioSnapshot{Timestamp: time.Now()}
+	diskCounters, err := disk.IOCounters()
 	if err != nil {
 		return snapshot, err
 	}
-	for _, partitionStat := range partitions {
-		counters, err := disk.IOCounters(partitionStat.Device)
-		if err != nil {
-			return snapshot, err
-		}
-		for _, counter := range counters {
-			//for devName, counter := range counters{
-			snapshot.DiskWrite += counter.WriteBytes
-			snapshot.DiskRead += counter.ReadBytes
-			//log.Printf("disk io %s > %s: %d / %d", partitionStat.Device, devName, counter.WriteBytes, counter.ReadBytes)
+	for devName, counter := range diskCounters {
+		if !service.IsPhysicalDisk(devName) {
+			continue
 		}
+		snapshot.DiskWrite += counter.WriteBytes
+		snapshot.DiskRead += counter.ReadBytes
+		snapshot.Devices = append(snapshot.Devices, service.HostDeviceIO{
+			Name:         devName,
+			Type:         service.HostDeviceDisk,
+			BytesRead:    counter.ReadBytes,
+			BytesWritten: counter.WriteBytes,
+		})
 	}
 
-	netStats, err := net.IOCounters(false)
+	netStats, err := net.IOCounters(true)
 	if err != nil {
 		return snapshot, err
 	}
 	for _, stat := range netStats {
+		if !service.IsPhysicalInterface(stat.Name) {
+			continue
+		}
 		snapshot.NetworkReceive += stat.BytesRecv
 		snapshot.NetworkSend += stat.BytesSent
-		//log.Printf("interface %s: %d / %d", stat.Name, stat.BytesSent, stat.BytesRecv)
+		snapshot.Devices = append(snapshot.Devices, service.HostDeviceIO{
+			Name:          stat.Name,
+			Type:          service.HostDeviceInterface,
+			BytesReceived: stat.BytesRecv,
+			BytesSent:     stat.BytesSent,
+		})
 	}
-	//log.Printf("debug: snapshot disk %d / %d, network %d / %d", snapshot.DiskWrite, snapshot.DiskRead, snapshot.NetworkSend, snapshot.NetworkReceive)
+	sort.Slice(snapshot.Devices, func(i, j int) bool {
+		if snapshot.Devices[i].Type != snapshot.Devices[j].Type {
+			return snapshot.Devices[i].Type < snapshot.Devices[j].Type
+		}
+		return snapshot.Devices[i].Name < snapshot.Devices[j].Name
+	})
 	return snapshot, nil
 }
 
 func computeIOCounter(previous, current ioSnapshot) ioCounter {
 	elapsed := current.Timestamp.Sub(previous.Timestamp)
 	if elapsed < time.Second*1 {
-		return ioCounter{current.Timestamp, elapsed, 0, 0, 0, 0,
-			0, 0, 0, 0}
+		return ioCounter{Timestamp: current.Timestamp, Duration: elapsed}
 	}
 	elapsedMilliSeconds := uint64(elapsed / time.Millisecond)
 	var result = ioCounter{Timestamp: current.Timestamp, Duration: elapsed}
-	result.BytesRead = current.DiskRead - previous.DiskRead
-	result.BytesWritten = current.DiskWrite - previous.DiskWrite
-	result.BytesSent = current.NetworkSend - previous.NetworkSend
-	result.BytesReceived = current.NetworkReceive - previous.NetworkReceive
+	result.BytesRead = service.CounterDelta(previous.DiskRead, current.DiskRead)
+	result.BytesWritten = service.CounterDelta(previous.DiskWrite, current.DiskWrite)
+	result.BytesSent = service.CounterDelta(previous.NetworkSend, current.NetworkSend)
+	result.BytesReceived = service.CounterDelta(previous.NetworkReceive, current.NetworkReceive)
 	result.WriteSpeed = result.BytesWritten * 1000 / elapsedMilliSeconds
 	result.ReadSpeed = result.BytesRead * 1000 / elapsedMilliSeconds
 	result.SendSpeed = result.BytesSent * 1000 / elapsedMilliSeconds
 	result.ReceiveSpeed = result.BytesReceived * 1000 / elapsedMilliSeconds
+
+	var previousDevices = map[string]service.HostDeviceIO{}
+	for _, device := range previous.Devices {
+		previousDevices[device.Name] = device
+	}
+	for _, device := range current.Devices {
+		last, exists := previousDevices[device.Name]
+		if !exists || last.Type != device.Type {
+			//new device
+			continue
+		}
+		var counter = service.HostDeviceIO{Name: device.Name, Type: device.Type}
+		counter.BytesRead = service.CounterDelta(last.BytesRead, device.BytesRead)
+		counter.BytesWritten = service.CounterDelta(last.BytesWritten, device.BytesWritten)
+		counter.BytesReceived = service.CounterDelta(last.BytesReceived, device.BytesReceived)
+		counter.BytesSent = service.CounterDelta(last.BytesSent, device.BytesSent)
+		counter.ReadSpeed = counter.BytesRead * 1000 / elapsedMilliSeconds
+		counter.WriteSpeed = counter.BytesWritten * 1000 / elapsedMilliSeconds
+		counter.ReceiveSpeed = counter.BytesReceived * 1000 / elapsedMilliSeconds
+		counter.SendSpeed = counter.BytesSent * 1000 / elapsedMilliSeconds
+		result.Devices = append(result.Devices, counter)
+	}
 	return result
 }
 
@@ -318,5 +518,8 @@ func buildObserverNotifyMessage(status hostStatus, io ioCounter) (msg framework.
 	msg.SetUIntArray(framework.ParamKeyDisk, []uint64{status.DiskAvailable, status.Disk})
 	msg.SetUIntArray(framework.ParamKeyIO, []uint64{io.BytesRead, io.BytesWritten, io.BytesReceived, io.BytesSent})
 	msg.SetUIntArray(framework.ParamKeySpeed, []uint64{io.ReadSpeed, io.WriteSpeed, io.ReceiveSpeed, io.SendSpeed})
+	if service.GetConfigurator().IsReportDeviceIOEnabled() {
+		service.MarshalHostDeviceIO(msg, io.Devices)
+	}
 	return msg, nil
 }
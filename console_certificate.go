@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// file names required by QEMU in x509 directory
+const (
+	consoleCACertFile     = "ca-cert.pem"
+	consoleCAKeyFile      = "ca-key.pem"
+	consoleServerCertFile = "server-cert.pem"
+	consoleServerKeyFile  = "server-key.pem"
+)
+
+const (
+	defaultConsoleCertPath = "/etc/pki/nano-console"
+	//CA key kept out of certificate path readable by QEMU
+	consoleCAKeyPathSuffix = "-ca"
+	qemuConfigFile         = "/etc/libvirt/qemu.conf"
+	qemuGroup              = "qemu"
+	consoleCertPathPerm    = 0755
+	consoleCAKeyPathPerm   = 0700
+	consoleCertPerm        = 0644
+	consoleKeyPerm         = 0640
+	consoleKeyBits         = 3072
+	consoleCAValidYears    = 10
+	consoleCertValidYears  = 5
+)
+
+// pid files of libvirtd, or virtqemud of modular daemons
+var libvirtPIDFiles = []string{"/run/libvirtd.pid", "/var/run/libvirtd.pid", "/run/virtqemud.pid"}
+
+type qemuOption struct {
+	Key   string
+	Value string
+}
+
+// prepareConsoleTLS : generate certificates when absent, and enable TLS of SPICE in qemu.conf.
+// VNC keeps plain, since VeNCrypt not supported by browser console relayed by proxy.
+// TLS is active only when libvirt started after qemu.conf modified
+func prepareConsoleTLS(certPath string) (fingerprint string, active bool, err error) {
+	var serverCertFile = filepath.Join(certPath, consoleServerCertFile)
+	if _, err = os.Stat(serverCertFile); os.IsNotExist(err) {
+		if err = generateConsoleCertificates(certPath); err != nil {
+			err = fmt.Errorf("generate certificates fail: %s", err.Error())
+			return
+		}
+		log.Printf("console certificates generated in '%s'", certPath)
+	} else if err != nil {
+		err = fmt.Errorf("check certificate '%s' fail: %s", serverCertFile, err.Error())
+		return
+	}
+	if fingerprint, err = certificateFingerprint(serverCertFile); err != nil {
+		return
+	}
+	changed, err := setQEMUOptions(qemuConfigFile, []qemuOption{
+		{"spice_tls", "1"},
+		{"spice_tls_x509_cert_dir", strconv.Quote(certPath)},
+	}, true)
+	if err != nil {
+		err = fmt.Errorf("enable TLS in '%s' fail: %s", qemuConfigFile, err.Error())
+		return
+	}
+	if changed || !qemuConfigLoaded(qemuConfigFile) {
+		log.Printf("warning: TLS enabled in '%s' but not loaded, restart libvirtd then cell to apply", qemuConfigFile)
+		return fingerprint, false, nil
+	}
+	return fingerprint, true, nil
+}
+
+// disableConsoleTLS : revert TLS of SPICE when enabled with certificate path by cell
+func disableConsoleTLS(certPath string) (err error) {
+	var lines []string
+	if lines, err = readQEMUConfig(qemuConfigFile); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	var enabledByCell = false
+	for _, line := range lines {
+		if key, value, commented := parseQEMUOption(line); !commented && "spice_tls_x509_cert_dir" == key {
+			enabledByCell = strconv.Quote(certPath) == value
+		}
+	}
+	if !enabledByCell {
+		return nil
+	}
+	changed, err := setQEMUOptions(qemuConfigFile, []qemuOption{
+		{"spice_tls", "0"},
+	}, false)
+	if err != nil {
+		err = fmt.Errorf("disable TLS in '%s' fail: %s", qemuConfigFile, err.Error())
+		return
+	}
+	if changed {
+		log.Printf("warning: TLS disabled in '%s', restart libvirtd to apply", qemuConfigFile)
+	}
+	return nil
+}
+
+// qemuConfigLoaded : libvirt started after last modification of config, true when unable to check
+func qemuConfigLoaded(configFile string) bool {
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return true
+	}
+	for _, pidFile := range libvirtPIDFiles {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			continue
+		}
+		startTime, err := processStartTime(pid)
+		if err != nil {
+			log.Printf("warning: get start time of libvirt process %d fail: %s", pid, err.Error())
+			return true
+		}
+		return startTime.After(info.ModTime())
+	}
+	log.Println("warning: can not find running libvirt process")
+	return true
+}
+
+// processStartTime : start ticks since boot in /proc/<pid>/stat, plus boot time in /proc/stat
+func processStartTime(pid int) (startTime time.Time, err error) {
+	const (
+		//USER_HZ of Linux
+		clockTicks      = 100
+		startTimeIndex  = 19 //field 22, counted after command
+		bootTimePrefix  = "btime "
+		procStatFile    = "/proc/stat"
+		processStatFile = "/proc/%d/stat"
+	)
+	data, err := os.ReadFile(fmt.Sprintf(processStatFile, pid))
+	if err != nil {
+		return
+	}
+	//command in parentheses may contain spaces
+	var content = string(data)
+	var fields = strings.Fields(content[strings.LastIndex(content, ")")+1:])
+	if len(fields) <= startTimeIndex {
+		err = fmt.Errorf("invalid stat of process %d", pid)
+		return
+	}
+	ticks, err := strconv.ParseUint(fields[startTimeIndex], 10, 64)
+	if err != nil {
+		return
+	}
+	if data, err = os.ReadFile(procStatFile); err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, bootTimePrefix) {
+			continue
+		}
+		bootTime, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, bootTimePrefix)), 10, 64)
+		if err != nil {
+			return startTime, err
+		}
+		var elapsed = time.Duration(ticks) * time.Second / clockTicks
+		return time.Unix(bootTime, 0).Add(elapsed), nil
+	}
+	err = fmt.Errorf("no boot time in '%s'", procStatFile)
+	return
+}
+
+func consoleCAKeyPath(certPath string) string {
+	return filepath.Clean(certPath) + consoleCAKeyPathSuffix
+}
+
+func generateConsoleCertificates(certPath string) (err error) {
+	if err = os.MkdirAll(certPath, consoleCertPathPerm); err != nil {
+		return
+	}
+	var keyPath = consoleCAKeyPath(certPath)
+	if err = os.MkdirAll(keyPath, consoleCAKeyPathPerm); err != nil {
+		return
+	}
+	var now = time.Now()
+	caKey, err := rsa.GenerateKey(rand.Reader, consoleKeyBits)
+	if err != nil {
+		return
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return
+	}
+	var caTemplate = x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"Nano"}, CommonName: fmt.Sprintf("Nano Console CA %s", hostname)},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(consoleCAValidYears, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if caTemplate.SerialNumber, err = newSerialNumber(); err != nil {
+		return
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return
+	}
+	serverKey, err := rsa.GenerateKey(rand.Reader, consoleKeyBits)
+	if err != nil {
+		return
+	}
+	var serverTemplate = x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"Nano"}, CommonName: hostname},
+		NotBefore:   now,
+		NotAfter:    now.AddDate(consoleCertValidYears, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{hostname},
+	}
+	if serverTemplate.SerialNumber, err = newSerialNumber(); err != nil {
+		return
+	}
+	if addresses, err := net.InterfaceAddrs(); nil == err {
+		for _, address := range addresses {
+			if ipNet, ok := address.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, &serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return
+	}
+	if err = writePEM(filepath.Join(keyPath, consoleCAKeyFile), "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(caKey), consoleKeyPerm); err != nil {
+		return
+	}
+	if err = writePEM(filepath.Join(certPath, consoleCACertFile), "CERTIFICATE", caDER, consoleCertPerm); err != nil {
+		return
+	}
+	var serverKeyFile = filepath.Join(certPath, consoleServerKeyFile)
+	if err = writePEM(serverKeyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(serverKey), consoleKeyPerm); err != nil {
+		return
+	}
+	//readable by QEMU process
+	if group, err := user.LookupGroup(qemuGroup); err != nil {
+		log.Printf("warning: lookup group '%s' fail: %s", qemuGroup, err.Error())
+	} else if gid, err := strconv.Atoi(group.Gid); nil == err {
+		if err = os.Chown(serverKeyFile, -1, gid); err != nil {
+			log.Printf("warning: change group of '%s' fail: %s", serverKeyFile, err.Error())
+		}
+	}
+	return writePEM(filepath.Join(certPath, consoleServerCertFile), "CERTIFICATE", serverDER, consoleCertPerm)
+}
+
+func newSerialNumber() (*big.Int, error) {
+	var limit = new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+func writePEM(filename, blockType string, data []byte, perm os.FileMode) error {
+	var content = pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+	return os.WriteFile(filename, content, perm)
+}
+
+// certificateFingerprint : SHA-256 of DER, formatted as colon separated hex
+func certificateFingerprint(certFile string) (fingerprint string, err error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		err = fmt.Errorf("read certificate '%s' fail: %s", certFile, err.Error())
+		return
+	}
+	block, _ := pem.Decode(data)
+	if nil == block {
+		err = fmt.Errorf("invalid certificate '%s'", certFile)
+		return
+	}
+	return formatFingerprint(block.Bytes), nil
+}
+
+func formatFingerprint(der []byte) string {
+	var digest = sha256.Sum256(der)
+	var parts = make([]string, 0, len(digest))
+	for _, value := range digest {
+		parts = append(parts, fmt.Sprintf("%02X", value))
+	}
+	return fmt.Sprintf("SHA256:%s", strings.Join(parts, ":"))
+}
+
+// setQEMUOptions : replace existing options, commented or absent options appended only when required
+func setQEMUOptions(configFile string, options []qemuOption, required bool) (changed bool, err error) {
+	var lines []string
+	if lines, err = readQEMUConfig(configFile); err != nil {
+		return
+	}
+	for _, option := range options {
+		var expected = fmt.Sprintf("%s = %s", option.Key, option.Value)
+		var found = false
+		for index, line := range lines {
+			var key, _, commented = parseQEMUOption(line)
+			if option.Key != key || (commented && !required) {
+				continue
+			}
+			found = true
+			if expected != line {
+				lines[index] = expected
+				changed = true
+			}
+			break
+		}
+		if !found && required {
+			lines = append(lines, expected)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if err = replaceFile(configFile, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+		return
+	}
+	return true, nil
+}
+
+// replaceFile : write to temporary file in same directory then rename, mode and owner of original file kept
+func replaceFile(filename string, content []byte) (err error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+	temp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return
+	}
+	var tempFile = temp.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempFile)
+		}
+	}()
+	if _, err = temp.Write(content); err != nil {
+		temp.Close()
+		return
+	}
+	if err = temp.Sync(); err != nil {
+		temp.Close()
+		return
+	}
+	if err = temp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tempFile, info.Mode()); err != nil {
+		return
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err = os.Chown(tempFile, int(stat.Uid), int(stat.Gid)); err != nil {
+			return
+		}
+	}
+	return os.Rename(tempFile, filename)
+}
+
+func readQEMUConfig(configFile string) (lines []string, err error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return
+	}
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// parseQEMUOption : key and value of option line, empty key when not an option
+func parseQEMUOption(line string) (key, value string, commented bool) {
+	var content = strings.TrimSpace(line)
+	commented = strings.HasPrefix(content, "#")
+	var fields = strings.SplitN(strings.TrimSpace(strings.TrimLeft(content, "#")), "=", 2)
+	if 2 != len(fields) {
+		return "", "", commented
+	}
+	return strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1]), commented
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"golang.org/x/net/websocket"
+	"io"
+	"log"
+	goNet "net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type consoleTarget struct {
+	GuestID  string
+	Name     string
+	Port     uint
+	Protocol string
+	//SPICE listen on TLS port only when console TLS enabled
+	TLS    bool
+	Expire time.Time
+}
+
+type consoleCommandType int
+
+const (
+	consoleCmdIssue = iota
+	consoleCmdConsume
+	consoleCmdOpen
+	consoleCmdClose
+)
+
+type consoleCommand struct {
+	Type       consoleCommandType
+	Token      string
+	Target     consoleTarget
+	Session    string
+	Connection io.Closer
+	ResultChan chan service.ConsoleResult
+	TargetChan chan consoleTarget
+	ErrorChan  chan error
+}
+
+// ConsoleProxy : relay WebSocket of noVNC/spice-html5 to monitor port of guest, authenticated by one-time token
+type ConsoleProxy struct {
+	listenAddress string
+	listener      goNet.Listener
+	server        http.Server
+	tokenTTL      time.Duration
+	tokens        map[string]consoleTarget
+	sessions      map[string]io.Closer
+	insManager    *service.InstanceManager
+	commands      chan consoleCommand
+	stopped       chan bool //closed when routine stopped
+	runner        *framework.SimpleRunner
+}
+
+const (
+	//GET /console?token=<token>
+	consolePath          = "/console"
+	consoleTokenParam    = "token"
+	consoleTokenSize     = 16
+	consoleQueryTimeout  = 5 * time.Second
+	consoleDialTimeout   = 5 * time.Second
+	consoleCheckInterval = 5 * time.Second
+	consoleSubProtocol   = "binary"
+	loopbackIPv4         = "127.0.0.1"
+	loopbackIPv6         = "::1"
+	//seconds
+	defaultConsoleTokenTTL = 30
+	//wait for handlers in progress when stopping
+	consoleShutdownTimeout = 5 * time.Second
+)
+
+func CreateConsoleProxy(listenAddress string, tokenTTL time.Duration, insManager *service.InstanceManager) (proxy *ConsoleProxy, err error) {
+	const (
+		Protocol         = "tcp"
+		DefaultQueueSize = 1 << 5
+	)
+	proxy = &ConsoleProxy{}
+	proxy.listenAddress = listenAddress
+	proxy.tokenTTL = tokenTTL
+	proxy.tokens = map[string]consoleTarget{}
+	proxy.sessions = map[string]io.Closer{}
+	proxy.insManager = insManager
+	proxy.commands = make(chan consoleCommand, DefaultQueueSize)
+	proxy.stopped = make(chan bool)
+	if proxy.listener, err = goNet.Listen(Protocol, listenAddress); err != nil {
+		err = fmt.Errorf("listen console proxy at '%s' fail: %s", listenAddress, err.Error())
+		return
+	}
+	var mux = http.NewServeMux()
+	mux.HandleFunc(consolePath, proxy.serveConsole)
+	proxy.server.Addr = listenAddress
+	proxy.server.Handler = mux
+	proxy.runner = framework.CreateSimpleRunner(proxy.Routine)
+	return proxy, nil
+}
+
+// GetPort : actual listen port, available when listen address omit port
+func (proxy *ConsoleProxy) GetPort() uint {
+	if address, ok := proxy.listener.Addr().(*goNet.TCPAddr); ok {
+		return uint(address.Port)
+	}
+	return 0
+}
+
+func (proxy *ConsoleProxy) Start() error {
+	return proxy.runner.Start()
+}
+
+func (proxy *ConsoleProxy) Stop() error {
+	return proxy.runner.Stop()
+}
+
+// IssueToken : token for single connection to monitor of running guest, expired when not used in TTL.
+// status of guest queried in caller routine, not blocking the proxy
+func (proxy *ConsoleProxy) IssueToken(guestID string, respChan chan service.ConsoleResult) {
+	var statusChan = make(chan service.InstanceResult, 1)
+	proxy.insManager.GetInstanceStatus(guestID, statusChan)
+	var result service.InstanceResult
+	select {
+	case result = <-statusChan:
+	case <-time.After(consoleQueryTimeout):
+		respChan <- service.ConsoleResult{Error: fmt.Errorf("query guest '%s' timeout", guestID)}
+		return
+	}
+	if result.Error != nil {
+		respChan <- service.ConsoleResult{Error: result.Error}
+		return
+	}
+	var ins = result.Instance
+	if !ins.Running {
+		respChan <- service.ConsoleResult{Error: fmt.Errorf("guest '%s' not running", ins.Name)}
+		return
+	}
+	var protocol = service.RemoteControlVNC
+	if nil != ins.Template {
+		protocol = ins.Template.Control
+	}
+	var target = consoleTarget{
+		GuestID:  guestID,
+		Name:     ins.Name,
+		Port:     ins.MonitorPort,
+		Protocol: protocol,
+	}
+	if enabled, _ := service.GetConfigurator().GetConsoleTLS(); enabled && service.RemoteControlSPICE == protocol {
+		target.TLS = true
+	}
+	if err := proxy.sendCommand(consoleCommand{Type: consoleCmdIssue, Target: target, ResultChan: respChan}); err != nil {
+		respChan <- service.ConsoleResult{Error: err}
+	}
+}
+
+func (proxy *ConsoleProxy) consumeToken(token string, respChan chan consoleTarget, errChan chan error) {
+	if err := proxy.sendCommand(consoleCommand{Type: consoleCmdConsume, Token: token, TargetChan: respChan, ErrorChan: errChan}); err != nil {
+		errChan <- err
+	}
+}
+
+func (proxy *ConsoleProxy) openSession(session string, connection io.Closer) error {
+	return proxy.sendCommand(consoleCommand{Type: consoleCmdOpen, Session: session, Connection: connection})
+}
+
+func (proxy *ConsoleProxy) closeSession(session string) {
+	_ = proxy.sendCommand(consoleCommand{Type: consoleCmdClose, Session: session})
+}
+
+// sendCommand : fail when routine stopped, instead of blocking caller forever
+func (proxy *ConsoleProxy) sendCommand(cmd consoleCommand) error {
+	select {
+	case <-proxy.stopped:
+		return errors.New("console proxy stopped")
+	default:
+	}
+	select {
+	case proxy.commands <- cmd:
+		return nil
+	case <-proxy.stopped:
+		return errors.New("console proxy stopped")
+	}
+}
+
+func (proxy *ConsoleProxy) Routine(c framework.RoutineController) {
+	go func() {
+		log.Printf("<console> proxy available at ws://%s%s, token expire in %s",
+			proxy.listener.Addr().String(), consolePath, proxy.tokenTTL)
+		if err := proxy.server.Serve(proxy.listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("<console> http server finished: %s", err.Error())
+		}
+	}()
+	var checkTicker = time.NewTicker(consoleCheckInterval)
+	for !c.IsStopping() {
+		select {
+		case <-c.GetNotifyChannel():
+			c.SetStopping()
+		case cmd := <-proxy.commands:
+			proxy.handleCommand(cmd)
+		case <-checkTicker.C:
+			proxy.clearExpiredTokens(time.Now())
+		}
+	}
+	checkTicker.Stop()
+	//release handlers waiting for token before shutdown
+	close(proxy.stopped)
+	ctx, cancel := context.WithTimeout(context.Background(), consoleShutdownTimeout)
+	defer cancel()
+	if err := proxy.server.Shutdown(ctx); err != nil {
+		log.Printf("<console> shutdown http server: %s", err.Error())
+	}
+	//hijacked connections not closed by server
+	for session, connection := range proxy.sessions {
+		if err := connection.Close(); err != nil {
+			log.Printf("<console> close session '%s' fail: %s", session, err.Error())
+		}
+	}
+	proxy.rejectPendingCommands()
+	log.Println("<console> stopped")
+	c.NotifyExit()
+}
+
+func (proxy *ConsoleProxy) handleCommand(cmd consoleCommand) {
+	switch cmd.Type {
+	case consoleCmdIssue:
+		proxy.handleIssueToken(cmd.Target, cmd.ResultChan)
+	case consoleCmdConsume:
+		target, exists := proxy.tokens[cmd.Token]
+		if !exists || time.Now().After(target.Expire) {
+			cmd.ErrorChan <- fmt.Errorf("invalid or expired token")
+			return
+		}
+		delete(proxy.tokens, cmd.Token)
+		cmd.TargetChan <- target
+	case consoleCmdOpen:
+		proxy.sessions[cmd.Session] = cmd.Connection
+	case consoleCmdClose:
+		delete(proxy.sessions, cmd.Session)
+	default:
+		log.Printf("<console> unsupported command type %d", cmd.Type)
+	}
+}
+
+// rejectPendingCommands : commands queued before stopped
+func (proxy *ConsoleProxy) rejectPendingCommands() {
+	var err = errors.New("console proxy stopped")
+	for {
+		select {
+		case cmd := <-proxy.commands:
+			switch cmd.Type {
+			case consoleCmdIssue:
+				cmd.ResultChan <- service.ConsoleResult{Error: err}
+			case consoleCmdConsume:
+				cmd.ErrorChan <- err
+			case consoleCmdOpen:
+				_ = cmd.Connection.Close()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (proxy *ConsoleProxy) handleIssueToken(target consoleTarget, respChan chan service.ConsoleResult) {
+	var buffer = make([]byte, consoleTokenSize)
+	if _, err := rand.Read(buffer); err != nil {
+		respChan <- service.ConsoleResult{Error: fmt.Errorf("generate token fail: %s", err.Error())}
+		return
+	}
+	var token = hex.EncodeToString(buffer)
+	target.Expire = time.Now().Add(proxy.tokenTTL)
+	proxy.tokens[token] = target
+	log.Printf("<console> token issued for %s monitor of guest '%s'", target.Protocol, target.Name)
+	respChan <- service.ConsoleResult{Token: token, Port: proxy.GetPort(), Protocol: target.Protocol, Expire: target.Expire}
+}
+
+func (proxy *ConsoleProxy) clearExpiredTokens(now time.Time) {
+	for token, target := range proxy.tokens {
+		if now.After(target.Expire) {
+			delete(proxy.tokens, token)
+		}
+	}
+}
+
+func (proxy *ConsoleProxy) serveConsole(w http.ResponseWriter, r *http.Request) {
+	var token = r.URL.Query().Get(consoleTokenParam)
+	if "" == token {
+		http.Error(w, "token required", http.StatusUnauthorized)
+		return
+	}
+	var targetChan = make(chan consoleTarget, 1)
+	var errChan = make(chan error, 1)
+	proxy.consumeToken(token, targetChan, errChan)
+	var target consoleTarget
+	select {
+	case target = <-targetChan:
+	case err := <-errChan:
+		log.Printf("<console> reject connection from %s: %s", r.RemoteAddr, err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case <-time.After(consoleQueryTimeout):
+		http.Error(w, "check token timeout", http.StatusServiceUnavailable)
+		return
+	}
+	var server = websocket.Server{
+		//origin not checked, authenticated by token
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			for _, protocol := range config.Protocol {
+				if consoleSubProtocol == protocol {
+					config.Protocol = []string{consoleSubProtocol}
+					return nil
+				}
+			}
+			config.Protocol = nil
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			proxy.relay(conn, target, token)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (proxy *ConsoleProxy) relay(conn *websocket.Conn, target consoleTarget, session string) {
+	var remoteAddress = conn.Request().RemoteAddr
+	monitor, err := dialMonitor(target)
+	if err != nil {
+		log.Printf("<console> connect %s monitor of guest '%s' fail: %s", target.Protocol, target.GuestID, err.Error())
+		_ = conn.Close()
+		return
+	}
+	conn.PayloadType = websocket.BinaryFrame
+	if err = proxy.openSession(session, monitor); err != nil {
+		log.Printf("<console> reject connection from %s: %s", remoteAddress, err.Error())
+		_ = monitor.Close()
+		_ = conn.Close()
+		return
+	}
+	log.Printf("<console> %s connected to %s monitor of guest '%s'", remoteAddress, target.Protocol, target.GuestID)
+	var finished = make(chan bool, 2)
+	go func() {
+		_, _ = io.Copy(monitor, conn)
+		finished <- true
+	}()
+	go func() {
+		_, _ = io.Copy(conn, monitor)
+		finished <- true
+	}()
+	<-finished
+	_ = monitor.Close()
+	_ = conn.Close()
+	<-finished
+	proxy.closeSession(session)
+	log.Printf("<console> %s disconnected from guest '%s'", remoteAddress, target.GuestID)
+}
+
+// monitorHost : listen address of monitor, loopback when listen on all addresses
+func monitorHost() string {
+	var listen = service.GetConfigurator().GetMonitorListen()
+	var ip = goNet.ParseIP(listen)
+	if nil == ip || !ip.IsUnspecified() {
+		return listen
+	}
+	if nil == ip.To4() {
+		return loopbackIPv6
+	}
+	return loopbackIPv4
+}
+
+// dialMonitor : TLS port of SPICE verified by fingerprint of cell certificate, relayed in plain
+func dialMonitor(target consoleTarget) (conn goNet.Conn, err error) {
+	var address = goNet.JoinHostPort(monitorHost(), strconv.Itoa(int(target.Port)))
+	var dialer = goNet.Dialer{Timeout: consoleDialTimeout}
+	if !target.TLS {
+		return dialer.Dial("tcp", address)
+	}
+	var _, fingerprint = service.GetConfigurator().GetConsoleTLS()
+	var config = tls.Config{
+		//self-signed certificate issued for host name, checked by fingerprint
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if 0 == len(state.PeerCertificates) {
+				return fmt.Errorf("no certificate of monitor")
+			}
+			if current := formatFingerprint(state.PeerCertificates[0].Raw); current != fingerprint {
+				return fmt.Errorf("unexpected certificate %s of monitor", current)
+			}
+			return nil
+		},
+	}
+	return tls.DialWithDialer(&dialer, "tcp", address, &config)
+}
@@ -22,6 +22,49 @@ type DomainConfig struct {
 	GroupAddress string `json:"group_address"`
 	GroupPort    int    `json:"group_port"`
 	Timeout      int    `json:"timeout,omitempty"`
+	//instance metrics
+	MetricsResolution int  `json:"metrics_resolution,omitempty"`
+	MetricsHistory    int  `json:"metrics_history,omitempty"`
+	MetricsRollup     bool `json:"metrics_rollup,omitempty"`
+	//listen address of Prometheus exporter, disabled when empty
+	MetricsExporter string `json:"metrics_exporter,omitempty"`
+	ReportDeviceIO  bool   `json:"report_device_io,omitempty"`
+	//threshold alerting, default rules used when empty
+	AlertRules []AlertRule `json:"alert_rules,omitempty"`
+	//capacity admission
+	CPUOvercommit    float64 `json:"cpu_overcommit,omitempty"`
+	MemoryOvercommit float64 `json:"memory_overcommit,omitempty"`
+	DiskOvercommit   float64 `json:"disk_overcommit,omitempty"`
+	ReservedCores    *uint   `json:"reserved_cores,omitempty"`
+	ReservedMemory   *uint   `json:"reserved_memory,omitempty"` //in MB
+	//guest shutdown on stop, disabled when zero, and auto start after host reboot
+	ShutdownTimeout      int  `json:"shutdown_timeout,omitempty"`
+	ShutdownLimit        int  `json:"shutdown_limit,omitempty"`
+	AutoStartConcurrency int  `json:"autostart_concurrency,omitempty"`
+	AutoStartDelay       *int `json:"autostart_delay,omitempty"`
+	//restart policy of guests
+	RestartBackoff    int `json:"restart_backoff,omitempty"`
+	RestartBackoffMax int `json:"restart_backoff_max,omitempty"`
+	CrashLoopFailures int `json:"crash_loop_failures,omitempty"`
+	CrashLoopWindow   int `json:"crash_loop_window,omitempty"`
+	//guest diagnostics, download server disabled when listen address empty
+	ScreenshotRetention int    `json:"screenshot_retention,omitempty"`
+	MemoryDumpRetention int    `json:"memory_dump_retention,omitempty"`
+	DiagnosticListen    string `json:"diagnostic_listen,omitempty"`
+	//WebSocket console proxy, disabled when listen address empty
+	ConsoleListen   string `json:"console_listen,omitempty"`
+	ConsoleTokenTTL int    `json:"console_token_ttl,omitempty"`
+	MonitorListen   string `json:"monitor_listen,omitempty"`
+	//TLS of VNC/SPICE, certificates generated in path when absent
+	ConsoleTLS      bool   `json:"console_tls,omitempty"`
+	ConsoleCertPath string `json:"console_cert_path,omitempty"`
+	//IPv6 of guests, router advertisement and DHCPv6 disabled when mode empty
+	IPv6Mode   string `json:"ipv6_mode,omitempty"`
+	IPv6Prefix string `json:"ipv6_prefix,omitempty"`
+	//gateway of cell-local NAT network in CIDR, such as "172.30.0.1/24", disabled when empty
+	PrivateNetwork string `json:"private_network,omitempty"`
+	//type of default bridge, "linux" or "openvswitch", Linux bridge used when empty
+	BridgeType string `json:"bridge_type,omitempty"`
 }
 
 type MainService struct {
@@ -68,14 +111,6 @@ func (service *MainService) Snapshot() (output string, err error) {
 }
 
 func generateConfigure(workingPath string) (err error) {
-	if err = configureNetworkForCell(); err != nil {
-		fmt.Printf("configure cell network fail: %s\n", err.Error())
-		return
-	}
-	if err = checkDefaultRoute(); err != nil {
-		fmt.Printf("check default route fail: %s\n", err.Error())
-		return
-	}
 	var configPath = filepath.Join(workingPath, ConfigPathName)
 	if _, err = os.Stat(configPath); os.IsNotExist(err) {
 		//create path
@@ -87,10 +122,11 @@ func generateConfigure(workingPath string) (err error) {
 	}
 
 	var configFile = filepath.Join(configPath, DomainConfigFileName)
+	var config DomainConfig
 	if _, err = os.Stat(configFile); os.IsNotExist(err) {
 		fmt.Println("No configures available, following instructions to generate a new one.")
 
-		var config = DomainConfig{
+		config = DomainConfig{
 			Timeout: defaultOperateTimeout,
 		}
 		if config.Domain, err = framework.InputString("Group Domain Name", sonar.DefaultDomain); err != nil {
@@ -102,6 +138,13 @@ func generateConfigure(workingPath string) (err error) {
 		if config.GroupPort, err = framework.InputInteger("Group MultiCast Port", sonar.DefaultMulticastPort); err != nil {
 			return
 		}
+		if config.BridgeType, err = framework.InputString("Bridge Type (linux/openvswitch)", service.BridgeTypeLinux); err != nil {
+			return
+		}
+		if !service.IsValidBridgeType(config.BridgeType) {
+			err = fmt.Errorf("invalid bridge type '%s'", config.BridgeType)
+			return
+		}
 		//write
 		var data []byte
 		data, err = json.MarshalIndent(config, "", " ")
@@ -112,6 +155,25 @@ func generateConfigure(workingPath string) (err error) {
 			return
 		}
 		fmt.Printf("default configure '%s' generated\n", configFile)
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(configFile); err != nil {
+			return
+		}
+		if err = json.Unmarshal(data, &config); err != nil {
+			return
+		}
+	}
+	if "" == config.BridgeType {
+		config.BridgeType = service.BridgeTypeLinux
+	}
+	if err = configureNetworkForCell(config.BridgeType); err != nil {
+		fmt.Printf("configure cell network fail: %s\n", err.Error())
+		return
+	}
+	if err = checkDefaultRoute(); err != nil {
+		fmt.Printf("check default route fail: %s\n", err.Error())
+		return
 	}
 	return
 }
@@ -138,6 +200,138 @@ func createDaemon(workingPath string) (daemon framework.DaemonizedService, err e
 	if config.Timeout > 0 {
 		service.GetConfigurator().SetOperateTimeout(config.Timeout)
 	}
+	if config.MetricsResolution > 0 {
+		service.GetConfigurator().SetMetricsResolution(config.MetricsResolution)
+	}
+	if config.MetricsHistory > 0 {
+		service.GetConfigurator().SetMetricsCapacity(config.MetricsHistory)
+	}
+	service.GetConfigurator().EnableMetricsRollup(config.MetricsRollup)
+	service.GetConfigurator().EnableReportDeviceIO(config.ReportDeviceIO)
+	{
+		var cpuRatio, memoryRatio, diskRatio = service.GetConfigurator().GetOvercommitRatio()
+		if config.CPUOvercommit > 0 {
+			cpuRatio = config.CPUOvercommit
+		}
+		if config.MemoryOvercommit > 0 {
+			memoryRatio = config.MemoryOvercommit
+		}
+		if config.DiskOvercommit > 0 {
+			diskRatio = config.DiskOvercommit
+		}
+		service.GetConfigurator().SetOvercommitRatio(cpuRatio, memoryRatio, diskRatio)
+		var reservedCores, reservedMemory = service.GetConfigurator().GetHostReservation()
+		var reservedMemoryInMB = uint(reservedMemory >> 20)
+		if nil != config.ReservedCores {
+			reservedCores = *config.ReservedCores
+		}
+		if nil != config.ReservedMemory {
+			reservedMemoryInMB = *config.ReservedMemory
+		}
+		service.GetConfigurator().SetHostReservation(reservedCores, reservedMemoryInMB)
+	}
+	if config.ShutdownTimeout > 0 {
+		service.GetConfigurator().SetGuestShutdownTimeout(config.ShutdownTimeout)
+	}
+	if config.ShutdownLimit > 0 {
+		service.GetConfigurator().SetGuestShutdownLimit(config.ShutdownLimit)
+	}
+	{
+		var concurrency, delay = service.GetConfigurator().GetAutoStartPolicy()
+		var delayInSeconds = int(delay.Seconds())
+		if config.AutoStartConcurrency > 0 {
+			concurrency = config.AutoStartConcurrency
+		}
+		if nil != config.AutoStartDelay && *config.AutoStartDelay >= 0 {
+			delayInSeconds = *config.AutoStartDelay
+		}
+		service.GetConfigurator().SetAutoStartPolicy(concurrency, delayInSeconds)
+	}
+	{
+		var initial, max = service.GetConfigurator().GetRestartBackoff()
+		var initialInSeconds, maxInSeconds = int(initial.Seconds()), int(max.Seconds())
+		if config.RestartBackoff > 0 {
+			initialInSeconds = config.RestartBackoff
+		}
+		if config.RestartBackoffMax > 0 {
+			maxInSeconds = config.RestartBackoffMax
+		}
+		service.GetConfigurator().SetRestartBackoff(initialInSeconds, maxInSeconds)
+		var failures, window = service.GetConfigurator().GetCrashLoopDetection()
+		var windowInSeconds = int(window.Seconds())
+		if config.CrashLoopFailures > 0 {
+			failures = config.CrashLoopFailures
+		}
+		if config.CrashLoopWindow > 0 {
+			windowInSeconds = config.CrashLoopWindow
+		}
+		service.GetConfigurator().SetCrashLoopDetection(failures, windowInSeconds)
+	}
+	{
+		var screenshots, memoryDumps = service.GetConfigurator().GetDiagnosticRetention()
+		if config.ScreenshotRetention > 0 {
+			screenshots = config.ScreenshotRetention
+		}
+		if config.MemoryDumpRetention > 0 {
+			memoryDumps = config.MemoryDumpRetention
+		}
+		service.GetConfigurator().SetDiagnosticRetention(screenshots, memoryDumps)
+	}
+	if "" != config.MonitorListen {
+		if nil == net.ParseIP(config.MonitorListen) {
+			err = fmt.Errorf("invalid monitor listen address '%s'", config.MonitorListen)
+			return
+		}
+		service.GetConfigurator().SetMonitorListen(config.MonitorListen)
+	}
+	var certPath = defaultConsoleCertPath
+	if "" != config.ConsoleCertPath {
+		certPath = config.ConsoleCertPath
+	}
+	if config.ConsoleTLS {
+		var fingerprint string
+		var active bool
+		if fingerprint, active, err = prepareConsoleTLS(certPath); err != nil {
+			err = fmt.Errorf("prepare console TLS fail: %s", err.Error())
+			return
+		}
+		service.GetConfigurator().SetConsoleTLS(active, fingerprint)
+	} else if err = disableConsoleTLS(certPath); err != nil {
+		err = fmt.Errorf("disable console TLS fail: %s", err.Error())
+		return
+	}
+	switch config.IPv6Mode {
+	case service.IPv6ModeNone:
+	case service.IPv6ModeSLAAC, service.IPv6ModeDHCPv6:
+		service.GetConfigurator().SetIPv6(config.IPv6Mode, config.IPv6Prefix)
+	default:
+		err = fmt.Errorf("invalid IPv6 mode '%s'", config.IPv6Mode)
+		return
+	}
+	if "" == config.BridgeType {
+		config.BridgeType = service.BridgeTypeLinux
+	}
+	if !service.IsValidBridgeType(config.BridgeType) {
+		err = fmt.Errorf("invalid bridge type '%s'", config.BridgeType)
+		return
+	}
+	if isOVS := service.IsOVSBridge(service.DefaultBridgeName); isOVS != (service.BridgeTypeOVS == config.BridgeType) {
+		err = fmt.Errorf("bridge '%s' not match bridge type '%s'", service.DefaultBridgeName, config.BridgeType)
+		return
+	}
+	if service.BridgeTypeOVS == config.BridgeType && service.IPv6ModeNone != config.IPv6Mode {
+		//no nwfilter on OVS port, router advertisement from guests not guarded
+		err = fmt.Errorf("IPv6 mode '%s' not supported by bridge type '%s'", config.IPv6Mode, config.BridgeType)
+		return
+	}
+	service.GetConfigurator().SetBridgeType(config.BridgeType)
+	if "" != config.PrivateNetwork {
+		if _, _, err = net.ParseCIDR(config.PrivateNetwork); err != nil {
+			err = fmt.Errorf("invalid private network '%s'", config.PrivateNetwork)
+			return
+		}
+		service.GetConfigurator().SetPrivateNetwork(config.PrivateNetwork)
+	}
 	var s = MainService{}
 	if s.cell, err = CreateCellService(config, workingPath); err != nil {
 		err = fmt.Errorf("create service fail: %s", err.Error())
@@ -173,8 +367,12 @@ func checkDefaultRoute() (err error) {
 	return nil
 }
 
-func configureNetworkForCell() (err error) {
+func configureNetworkForCell(bridgeType string) (err error) {
 	if hasDefaultBridge() {
+		if isOVS := service.IsOVSBridge(service.DefaultBridgeName); isOVS != (service.BridgeTypeOVS == bridgeType) {
+			err = fmt.Errorf("bridge %s already exists, but not match bridge type '%s'", service.DefaultBridgeName, bridgeType)
+			return
+		}
 		fmt.Printf("bridge %s is ready\n", service.DefaultBridgeName)
 		return nil
 	}
@@ -183,7 +381,7 @@ func configureNetworkForCell() (err error) {
 	if err != nil {
 		return
 	}
-	fmt.Printf("try link interface '%s' to bridge '%s', input 'yes' to confirm:", interfaceName, service.DefaultBridgeName)
+	fmt.Printf("try link interface '%s' to %s bridge '%s', input 'yes' to confirm:", interfaceName, bridgeType, service.DefaultBridgeName)
 	var input string
 	_, err = fmt.Scanln(&input)
 	if err != nil {
@@ -192,7 +390,7 @@ func configureNetworkForCell() (err error) {
 	if "yes" != input {
 		return errors.New("user interrupted")
 	}
-	if err = linkBridge(interfaceName, service.DefaultBridgeName); err != nil {
+	if err = linkBridge(interfaceName, service.DefaultBridgeName, bridgeType); err != nil {
 		return
 	}
 	var errorMessage []byte
@@ -244,7 +442,7 @@ func hasDefaultBridge() bool {
 	return false
 }
 
-func linkBridge(interfaceName, bridgeName string) (err error) {
+func linkBridge(interfaceName, bridgeName, bridgeType string) (err error) {
 	const (
 		ScriptsPath  = "/etc/sysconfig/network-scripts"
 		ScriptPrefix = "ifcfg"
@@ -255,11 +453,11 @@ func linkBridge(interfaceName, bridgeName string) (err error) {
 	if err != nil {
 		return
 	}
-	bridgeConfig, err := generateBridgeConfig(bridgeName)
+	bridgeConfig, err := generateBridgeConfig(bridgeName, bridgeType)
 	if err != nil {
 		return
 	}
-	err = migrateInterfaceConfig(bridgeName, &interfaceConfig, &bridgeConfig)
+	err = migrateInterfaceConfig(bridgeName, bridgeType, &interfaceConfig, &bridgeConfig)
 	if err != nil {
 		return
 	}
@@ -280,6 +478,9 @@ func linkBridge(interfaceName, bridgeName string) (err error) {
 	if err = netlink.LinkSetDown(link); err != nil {
 		fmt.Printf("warning:set down link fail: %s\n", err.Error())
 	}
+	if service.BridgeTypeOVS == bridgeType {
+		return linkOVSBridge(link, bridgeName)
+	}
 	var bridgeAttrs = netlink.NewLinkAttrs()
 	bridgeAttrs.Name = bridgeName
 	var bridge = &netlink.Bridge{LinkAttrs: bridgeAttrs}
@@ -302,11 +503,32 @@ func linkBridge(interfaceName, bridgeName string) (err error) {
 	return nil
 }
 
+func linkOVSBridge(link netlink.Link, bridgeName string) (err error) {
+	var interfaceName = link.Attrs().Name
+	if err = service.CreateOVSBridge(bridgeName, interfaceName); err != nil {
+		return
+	}
+	fmt.Printf("new OVS bridge %s created with port %s\n", bridgeName, interfaceName)
+	bridge, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		return
+	}
+	if err = netlink.LinkSetUp(bridge); err != nil {
+		return
+	}
+	fmt.Printf("bridge %s up\n", bridgeName)
+	if err = netlink.LinkSetUp(link); err != nil {
+		return
+	}
+	fmt.Printf("link %s up\n", interfaceName)
+	return nil
+}
+
 type InterfaceConfig struct {
 	Params map[string]string
 }
 
-func generateBridgeConfig(bridgeName string) (config InterfaceConfig, err error) {
+func generateBridgeConfig(bridgeName, bridgeType string) (config InterfaceConfig, err error) {
 	config.Params = map[string]string{
 		"NM_CONTROLLED": "no",
 		"DELAY":         "0",
@@ -314,6 +536,11 @@ func generateBridgeConfig(bridgeName string) (config InterfaceConfig, err error)
 		"ONBOOT":        "yes",
 		"ZONE":          "public",
 	}
+	if service.BridgeTypeOVS == bridgeType {
+		//network scripts of openvswitch
+		config.Params["TYPE"] = "OVSBridge"
+		config.Params["DEVICETYPE"] = "ovs"
+	}
 	config.Params["NAME"] = bridgeName
 	config.Params["DEVICE"] = bridgeName
 	return config, nil
@@ -356,11 +583,14 @@ func writeInterfaceConfig(config InterfaceConfig, filepath string) (err error) {
 	return file.Close()
 }
 
-func migrateInterfaceConfig(bridgeName string, ifcfg, brcfg *InterfaceConfig) (err error) {
+func migrateInterfaceConfig(bridgeName, bridgeType string, ifcfg, brcfg *InterfaceConfig) (err error) {
 	const (
-		NMControl = "NM_CONTROLLED"
-		BRIDGE    = "BRIDGE"
-		ONBOOT    = "ONBOOT"
+		NMControl  = "NM_CONTROLLED"
+		BRIDGE     = "BRIDGE"
+		ONBOOT     = "ONBOOT"
+		TYPE       = "TYPE"
+		DEVICETYPE = "DEVICETYPE"
+		OVSBridge  = "OVS_BRIDGE"
 	)
 	var migrateList = []string{
 		"BOOTPROTO", "PREFIX", "IPADDR", "GATEWAY", "NETMASK", "DNS1", "DNS2", "DOMAIN",
@@ -375,8 +605,14 @@ func migrateInterfaceConfig(bridgeName string, ifcfg, brcfg *InterfaceConfig) (e
 		}
 	}
 	ifcfg.Params[NMControl] = "no"
-	ifcfg.Params[BRIDGE] = bridgeName
 	ifcfg.Params[ONBOOT] = "yes"
+	if service.BridgeTypeOVS == bridgeType {
+		ifcfg.Params[TYPE] = "OVSPort"
+		ifcfg.Params[DEVICETYPE] = "ovs"
+		ifcfg.Params[OVSBridge] = bridgeName
+	} else {
+		ifcfg.Params[BRIDGE] = bridgeName
+	}
 	return nil
 }
 
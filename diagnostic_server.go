@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	goNet "net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiagnosticServer : serve screenshots and memory dumps of guests for download,
+// authenticated by token signed in path issued through Core
+type DiagnosticServer struct {
+	listenAddress string
+	//key of token, regenerated when cell restart
+	secret     []byte
+	listener   goNet.Listener
+	server     http.Server
+	insManager *service.InstanceManager
+	runner     *framework.SimpleRunner
+}
+
+const (
+	//GET /diagnostics/<guest id>/<file name>?expire=<unix time>&token=<token>
+	diagnosticsPath         = "/diagnostics/"
+	diagnosticsQueryTimeout = 5 * time.Second
+	diagnosticsExpireParam  = "expire"
+	diagnosticsTokenParam   = "token"
+	diagnosticsTokenTTL     = 5 * time.Minute
+	diagnosticsSecretSize   = 32
+)
+
+func CreateDiagnosticServer(listenAddress string, insManager *service.InstanceManager) (server *DiagnosticServer, err error) {
+	const (
+		Protocol = "tcp"
+	)
+	server = &DiagnosticServer{}
+	server.listenAddress = listenAddress
+	server.insManager = insManager
+	server.secret = make([]byte, diagnosticsSecretSize)
+	if _, err = rand.Read(server.secret); err != nil {
+		err = fmt.Errorf("generate secret of diagnostic server fail: %s", err.Error())
+		return
+	}
+	if server.listener, err = goNet.Listen(Protocol, listenAddress); err != nil {
+		err = fmt.Errorf("listen diagnostic server at '%s' fail: %s", listenAddress, err.Error())
+		return
+	}
+	var mux = http.NewServeMux()
+	mux.HandleFunc(diagnosticsPath, server.serveDiagnostic)
+	server.server.Addr = listenAddress
+	server.server.Handler = mux
+	server.runner = framework.CreateSimpleRunner(server.Routine)
+	return server, nil
+}
+
+// GetPort : actual listen port, available when listen address omit port
+func (server *DiagnosticServer) GetPort() uint {
+	if address, ok := server.listener.Addr().(*goNet.TCPAddr); ok {
+		return uint(address.Port)
+	}
+	return 0
+}
+
+// SignPath : append token to download path, expired after TTL
+func (server *DiagnosticServer) SignPath(path string) string {
+	var expire = time.Now().Add(diagnosticsTokenTTL).Unix()
+	return fmt.Sprintf("%s?%s=%d&%s=%s", path, diagnosticsExpireParam, expire,
+		diagnosticsTokenParam, server.computeToken(path, expire))
+}
+
+func (server *DiagnosticServer) computeToken(path string, expire int64) string {
+	var mac = hmac.New(sha256.New, server.secret)
+	mac.Write([]byte(fmt.Sprintf("%s\n%d", path, expire)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (server *DiagnosticServer) verifyToken(r *http.Request) error {
+	var query = r.URL.Query()
+	var token = query.Get(diagnosticsTokenParam)
+	if "" == token {
+		return fmt.Errorf("token required")
+	}
+	expire, err := strconv.ParseInt(query.Get(diagnosticsExpireParam), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expire time")
+	}
+	if time.Now().Unix() > expire {
+		return fmt.Errorf("token expired")
+	}
+	if !hmac.Equal([]byte(token), []byte(server.computeToken(r.URL.Path, expire))) {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+func (server *DiagnosticServer) Start() error {
+	return server.runner.Start()
+}
+
+func (server *DiagnosticServer) Stop() error {
+	return server.runner.Stop()
+}
+
+func (server *DiagnosticServer) Routine(c framework.RoutineController) {
+	go func() {
+		log.Printf("<diagnostic> diagnostics available at http://%s%s, token expire in %s",
+			server.listener.Addr().String(), diagnosticsPath, diagnosticsTokenTTL)
+		if err := server.server.Serve(server.listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("<diagnostic> http server finished: %s", err.Error())
+		}
+	}()
+	<-c.GetNotifyChannel()
+	c.SetStopping()
+	if err := server.server.Shutdown(context.TODO()); err != nil {
+		log.Printf("<diagnostic> shutdown http server: %s", err.Error())
+	}
+	log.Println("<diagnostic> stopped")
+	c.NotifyExit()
+}
+
+func (server *DiagnosticServer) serveDiagnostic(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method && http.MethodHead != r.Method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var parts = strings.Split(strings.TrimPrefix(r.URL.Path, diagnosticsPath), "/")
+	if 2 != len(parts) || "" == parts[0] || "" == parts[1] {
+		http.NotFound(w, r)
+		return
+	}
+	var guestID, name = parts[0], parts[1]
+	if err := server.verifyToken(r); err != nil {
+		log.Printf("<diagnostic> deny '%s' of guest '%s' requested by %s: %s", name, guestID, r.RemoteAddr, err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	var respChan = make(chan service.InstanceResult, 1)
+	server.insManager.GetDiagnostic(guestID, name, respChan)
+	var result service.InstanceResult
+	select {
+	case result = <-respChan:
+	case <-time.After(diagnosticsQueryTimeout):
+		http.Error(w, "query diagnostic timeout", http.StatusServiceUnavailable)
+		return
+	}
+	if result.Error != nil {
+		log.Printf("<diagnostic> get '%s' of guest '%s' from %s fail: %s", name, guestID, r.RemoteAddr, result.Error.Error())
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("<diagnostic> '%s' of guest '%s' requested by %s", name, guestID, r.RemoteAddr)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", result.Diagnostic.Name))
+	http.ServeFile(w, r, result.Diagnostic.Path)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/project-nano/cell/service"
+)
+
+// resend unacknowledged events when Core not acknowledge in time
+const eventAcknowledgeTimeout = 30 * time.Second
+
+type journalEvent struct {
+	Sequence  uint64                     `json:"sequence"`
+	Instance  string                     `json:"instance"`
+	Event     service.StatusChangedEvent `json:"event"`
+	Address   string                     `json:"address,omitempty"`
+	AddressV6 string                     `json:"address_v6,omitempty"`
+	Action    string                     `json:"action,omitempty"`
+	Count     uint                       `json:"count,omitempty"`
+	Path      string                     `json:"path,omitempty"`
+	//MAC of conflicting host
+	HardwareAddress string    `json:"hardware_address,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+type journalAcknowledge struct {
+	Observer string `json:"observer"`
+	Sequence uint64 `json:"sequence"`
+}
+
+// journalRecord : one line of journal file, event and acknowledge appended as they happen,
+// the whole journal rewritten as records only when compacting
+type journalRecord struct {
+	LastSequence uint64              `json:"last_sequence,omitempty"`
+	Acknowledged uint64              `json:"acknowledged,omitempty"`
+	Event        *journalEvent       `json:"event,omitempty"`
+	Acknowledge  *journalAcknowledge `json:"ack,omitempty"`
+	//saved as a single document by previous version
+	Events []journalEvent `json:"events,omitempty"`
+}
+
+// eventCursor : progress of events sent to one Core
+type eventCursor struct {
+	Sent         uint64
+	Acknowledged uint64
+	Since        time.Time
+}
+
+// eventJournal keeps outbound instance events on disk until they have been acknowledged by Core,
+// so events raised while no Core connected or lost on the way can be replayed in order.
+type eventJournal struct {
+	dataFile     string
+	capacity     int
+	lastSequence uint64
+	//events not greater than it acknowledged by all Cores or discarded when journal full
+	acknowledged uint64
+	//sequence acknowledged by each Core
+	observers map[string]uint64
+	events    []journalEvent
+	file      *os.File
+	//records appended since last compaction
+	appended int
+}
+
+func loadEventJournal(dataFile string, capacity int) (journal *eventJournal, err error) {
+	journal = &eventJournal{dataFile: dataFile, capacity: capacity, observers: map[string]uint64{}}
+	if _, err = os.Stat(dataFile); os.IsNotExist(err) {
+		log.Printf("<journal> no event journal available, new journal will be saved to '%s'", dataFile)
+		if err = journal.compact(); err != nil {
+			return
+		}
+		return journal, nil
+	}
+	var file *os.File
+	if file, err = os.Open(dataFile); err != nil {
+		err = fmt.Errorf("open event journal fail: %s", err.Error())
+		return
+	}
+	var decoder = json.NewDecoder(file)
+	var records = 0
+	for {
+		var record journalRecord
+		if err = decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			//tail may be damaged when power lost during append
+			log.Printf("<journal> warning: ignore damaged records after %d record(s): %s", records, err.Error())
+			break
+		}
+		journal.apply(record)
+		records++
+	}
+	file.Close()
+	journal.release()
+	journal.trim()
+	if err = journal.compact(); err != nil {
+		return
+	}
+	log.Printf("<journal> %d pending event(s) loaded from %d record(s), last sequence %d, acknowledged %d by %d Core(s)",
+		len(journal.events), records, journal.lastSequence, journal.acknowledged, len(journal.observers))
+	return journal, nil
+}
+
+// Close the journal file
+func (journal *eventJournal) Close() error {
+	if nil == journal.file {
+		return nil
+	}
+	var err = journal.file.Close()
+	journal.file = nil
+	return err
+}
+
+// Append assign a new sequence to the event and append it to journal
+func (journal *eventJournal) Append(event service.InstanceStatusChangedEvent) (sequence uint64, err error) {
+	journal.lastSequence++
+	sequence = journal.lastSequence
+	var timestamp = event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	var record = journalEvent{
+		Sequence:        sequence,
+		Instance:        event.ID,
+		Event:           event.Event,
+		Address:         event.Address,
+		AddressV6:       event.AddressV6,
+		Action:          event.Action,
+		Count:           event.Count,
+		Path:            event.Path,
+		HardwareAddress: event.HardwareAddress,
+		Timestamp:       timestamp,
+	}
+	journal.events = append(journal.events, record)
+	journal.trim()
+	err = journal.appendRecord(journalRecord{Event: &record})
+	return
+}
+
+// Acknowledged returns the last sequence acknowledged by the observer
+func (journal *eventJournal) Acknowledged(observer string) uint64 {
+	if sequence, exists := journal.observers[observer]; exists && sequence > journal.acknowledged {
+		return sequence
+	}
+	return journal.acknowledged
+}
+
+// Pending returns all events unacknowledged by the observer in sequence order
+func (journal *eventJournal) Pending(observer string) []journalEvent {
+	var acknowledged = journal.Acknowledged(observer)
+	for offset, event := range journal.events {
+		if event.Sequence > acknowledged {
+			return journal.events[offset:]
+		}
+	}
+	return nil
+}
+
+// Unsent returns pending events not sent to the observer yet, or all pending events
+// when the observer not acknowledge in time. caller advance cursor.Sent after delivered
+func (journal *eventJournal) Unsent(observer string, cursor *eventCursor, now time.Time) []journalEvent {
+	var acknowledged = journal.Acknowledged(observer)
+	if acknowledged != cursor.Acknowledged || cursor.Sent <= acknowledged {
+		//acknowledge progressed or nothing outstanding
+		cursor.Acknowledged = acknowledged
+		cursor.Since = now
+	}
+	if cursor.Sent < acknowledged {
+		cursor.Sent = acknowledged
+	} else if cursor.Sent > acknowledged && now.Sub(cursor.Since) > eventAcknowledgeTimeout {
+		log.Printf("<journal> %s not acknowledge event %d ~ %d in %s, resend",
+			observer, acknowledged+1, cursor.Sent, eventAcknowledgeTimeout)
+		cursor.Sent = acknowledged
+		cursor.Since = now
+	}
+	var pending = journal.Pending(observer)
+	for offset, event := range pending {
+		if event.Sequence > cursor.Sent {
+			return pending[offset:]
+		}
+	}
+	return nil
+}
+
+// Register keep events for a new observer until it acknowledged
+func (journal *eventJournal) Register(observer string) (err error) {
+	if _, exists := journal.observers[observer]; exists {
+		return nil
+	}
+	journal.observers[observer] = journal.acknowledged
+	return journal.appendRecord(journalRecord{Acknowledge: &journalAcknowledge{Observer: observer, Sequence: journal.acknowledged}})
+}
+
+// Acknowledge record all events with a sequence not greater than the specified one processed by observer,
+// events acknowledged by all observers dropped
+func (journal *eventJournal) Acknowledge(observer string, sequence uint64) (err error) {
+	if sequence > journal.lastSequence {
+		err = fmt.Errorf("acknowledge sequence %d from %s exceeds last sequence %d",
+			sequence, observer, journal.lastSequence)
+		return
+	}
+	if sequence <= journal.Acknowledged(observer) {
+		return nil
+	}
+	journal.observers[observer] = sequence
+	journal.release()
+	return journal.appendRecord(journalRecord{Acknowledge: &journalAcknowledge{Observer: observer, Sequence: sequence}})
+}
+
+func (journal *eventJournal) apply(record journalRecord) {
+	if record.LastSequence > journal.lastSequence {
+		journal.lastSequence = record.LastSequence
+	}
+	if record.Acknowledged > journal.acknowledged {
+		journal.acknowledged = record.Acknowledged
+	}
+	var events = record.Events
+	if nil != record.Event {
+		events = append(events, *record.Event)
+	}
+	for _, event := range events {
+		if event.Sequence > journal.lastSequence {
+			journal.lastSequence = event.Sequence
+		}
+		if count := len(journal.events); 0 != count && event.Sequence <= journal.events[count-1].Sequence {
+			//duplicated
+			continue
+		}
+		journal.events = append(journal.events, event)
+	}
+	if nil != record.Acknowledge && record.Acknowledge.Sequence > journal.observers[record.Acknowledge.Observer] {
+		journal.observers[record.Acknowledge.Observer] = record.Acknowledge.Sequence
+	}
+}
+
+// release drop events acknowledged by all observers
+func (journal *eventJournal) release() {
+	var floor uint64 = 0
+	var first = true
+	for _, sequence := range journal.observers {
+		if first || sequence < floor {
+			floor = sequence
+			first = false
+		}
+	}
+	if floor > journal.acknowledged {
+		journal.acknowledged = floor
+	}
+	var offset = 0
+	for ; offset < len(journal.events); offset++ {
+		if journal.events[offset].Sequence > journal.acknowledged {
+			break
+		}
+	}
+	journal.events = journal.events[offset:]
+}
+
+func (journal *eventJournal) trim() {
+	var overflow = len(journal.events) - journal.capacity
+	if overflow <= 0 {
+		return
+	}
+	var dropped = journal.events[overflow-1]
+	log.Printf("<journal> warning: journal full, %d oldest event(s) discarded (until sequence %d)",
+		overflow, dropped.Sequence)
+	journal.events = journal.events[overflow:]
+	if dropped.Sequence > journal.acknowledged {
+		journal.acknowledged = dropped.Sequence
+	}
+}
+
+func (journal *eventJournal) appendRecord(record journalRecord) (err error) {
+	if journal.appended >= journal.capacity {
+		//rewrite journal with pending events only
+		return journal.compact()
+	}
+	if nil == journal.file {
+		err = fmt.Errorf("event journal '%s' not opened", journal.dataFile)
+		return
+	}
+	var data []byte
+	if data, err = json.Marshal(record); err != nil {
+		err = fmt.Errorf("marshal journal record fail: %s", err.Error())
+		return
+	}
+	if _, err = journal.file.Write(append(data, '\n')); err != nil {
+		err = fmt.Errorf("append event journal fail: %s", err.Error())
+		return
+	}
+	journal.appended++
+	return nil
+}
+
+// compact rewrite journal with current sequence, acknowledges and pending events, then reopen for append
+func (journal *eventJournal) compact() (err error) {
+	var buffer bytes.Buffer
+	var encoder = json.NewEncoder(&buffer)
+	var records = []journalRecord{{LastSequence: journal.lastSequence, Acknowledged: journal.acknowledged}}
+	for observer, sequence := range journal.observers {
+		records = append(records, journalRecord{Acknowledge: &journalAcknowledge{Observer: observer, Sequence: sequence}})
+	}
+	for index := range journal.events {
+		records = append(records, journalRecord{Event: &journal.events[index]})
+	}
+	for _, record := range records {
+		if err = encoder.Encode(record); err != nil {
+			err = fmt.Errorf("marshal journal record fail: %s", err.Error())
+			return
+		}
+	}
+	journal.Close()
+	var tempFile = journal.dataFile + ".tmp"
+	if err = os.WriteFile(tempFile, buffer.Bytes(), service.ConfigFilePerm); err != nil {
+		err = fmt.Errorf("write event journal fail: %s", err.Error())
+		return
+	}
+	if err = os.Rename(tempFile, journal.dataFile); err != nil {
+		err = fmt.Errorf("replace event journal fail: %s", err.Error())
+		return
+	}
+	if journal.file, err = os.OpenFile(journal.dataFile, os.O_WRONLY|os.O_APPEND, service.ConfigFilePerm); err != nil {
+		err = fmt.Errorf("open event journal fail: %s", err.Error())
+		return
+	}
+	journal.appended = 0
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/project-nano/cell/service"
+)
+
+func appendTestEvents(t *testing.T, journal *eventJournal, count int) {
+	for i := 0; i < count; i++ {
+		if _, err := journal.Append(service.InstanceStatusChangedEvent{ID: "guest", Event: service.InstanceStarted}); err != nil {
+			t.Fatalf("append event fail: %s", err.Error())
+		}
+	}
+}
+
+func sequencesOf(events []journalEvent) (sequences []uint64) {
+	for _, event := range events {
+		sequences = append(sequences, event.Sequence)
+	}
+	return
+}
+
+func equalSequences(events []journalEvent, expected ...uint64) bool {
+	var sequences = sequencesOf(events)
+	if len(sequences) != len(expected) {
+		return false
+	}
+	for index := range expected {
+		if sequences[index] != expected[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEventJournal_Acknowledge(t *testing.T) {
+	journal, err := loadEventJournal(filepath.Join(t.TempDir(), "event.journal"), 16)
+	if err != nil {
+		t.Fatalf("load journal fail: %s", err.Error())
+	}
+	defer journal.Close()
+	for _, observer := range []string{"core1", "core2"} {
+		if err = journal.Register(observer); err != nil {
+			t.Fatalf("register observer fail: %s", err.Error())
+		}
+	}
+	appendTestEvents(t, journal, 5)
+	if err = journal.Acknowledge("core1", 6); err == nil {
+		t.Fatal("acknowledge beyond last sequence accepted")
+	}
+	if err = journal.Acknowledge("core1", 3); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	if !equalSequences(journal.Pending("core1"), 4, 5) {
+		t.Fatalf("unexpected pending of core1: %v", sequencesOf(journal.Pending("core1")))
+	}
+	//events kept until acknowledged by all Cores
+	if err = journal.Acknowledge("core2", 1); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	if !equalSequences(journal.Pending("core2"), 2, 3, 4, 5) {
+		t.Fatalf("unexpected pending of core2: %v", sequencesOf(journal.Pending("core2")))
+	}
+	if 4 != len(journal.events) {
+		t.Fatalf("expect 4 events kept, but %d", len(journal.events))
+	}
+	//stale acknowledge ignored
+	if err = journal.Acknowledge("core1", 2); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	if 3 != journal.Acknowledged("core1") {
+		t.Fatalf("acknowledge of core1 moved back to %d", journal.Acknowledged("core1"))
+	}
+	if err = journal.Acknowledge("core2", 5); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	if !equalSequences(journal.events, 4, 5) {
+		t.Fatalf("unexpected events kept: %v", sequencesOf(journal.events))
+	}
+	//unknown Core receives events not acknowledged by all
+	if !equalSequences(journal.Pending("core3"), 4, 5) {
+		t.Fatalf("unexpected pending of core3: %v", sequencesOf(journal.Pending("core3")))
+	}
+}
+
+func TestEventJournal_Unsent(t *testing.T) {
+	journal, err := loadEventJournal(filepath.Join(t.TempDir(), "event.journal"), 16)
+	if err != nil {
+		t.Fatalf("load journal fail: %s", err.Error())
+	}
+	defer journal.Close()
+	appendTestEvents(t, journal, 3)
+	var now = time.Now()
+	var cursor eventCursor
+	var unsent = journal.Unsent("core", &cursor, now)
+	if !equalSequences(unsent, 1, 2, 3) {
+		t.Fatalf("unexpected unsent events: %v", sequencesOf(unsent))
+	}
+	//event 1 ~ 2 delivered, 3 failed
+	cursor.Sent = 2
+	if unsent = journal.Unsent("core", &cursor, now.Add(time.Second)); !equalSequences(unsent, 3) {
+		t.Fatalf("unexpected unsent events after partial delivery: %v", sequencesOf(unsent))
+	}
+	cursor.Sent = 3
+	if unsent = journal.Unsent("core", &cursor, now.Add(2*time.Second)); 0 != len(unsent) {
+		t.Fatalf("delivered events sent again: %v", sequencesOf(unsent))
+	}
+	if err = journal.Acknowledge("core", 1); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	//acknowledge progressed, wait again
+	var acknowledged = now.Add(eventAcknowledgeTimeout)
+	if unsent = journal.Unsent("core", &cursor, acknowledged); 0 != len(unsent) {
+		t.Fatalf("events resent after acknowledge progressed: %v", sequencesOf(unsent))
+	}
+	if unsent = journal.Unsent("core", &cursor, acknowledged.Add(eventAcknowledgeTimeout+time.Second)); !equalSequences(unsent, 2, 3) {
+		t.Fatalf("unexpected resent events: %v", sequencesOf(unsent))
+	}
+	//reconnected Core receives all unacknowledged
+	var reconnected eventCursor
+	if unsent = journal.Unsent("core", &reconnected, now); !equalSequences(unsent, 2, 3) {
+		t.Fatalf("unexpected replay after reconnect: %v", sequencesOf(unsent))
+	}
+}
+
+func TestEventJournal_Replay(t *testing.T) {
+	var dataFile = filepath.Join(t.TempDir(), "event.journal")
+	journal, err := loadEventJournal(dataFile, 4)
+	if err != nil {
+		t.Fatalf("load journal fail: %s", err.Error())
+	}
+	appendTestEvents(t, journal, 3)
+	if err = journal.Acknowledge("core", 1); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	journal.Close()
+	//power lost during append
+	file, err := os.OpenFile(dataFile, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open journal fail: %s", err.Error())
+	}
+	if _, err = file.WriteString(`{"event":{"sequence":4,"inst`); err != nil {
+		t.Fatalf("damage journal fail: %s", err.Error())
+	}
+	file.Close()
+
+	if journal, err = loadEventJournal(dataFile, 4); err != nil {
+		t.Fatalf("reload journal fail: %s", err.Error())
+	}
+	if !equalSequences(journal.Pending("core"), 2, 3) {
+		t.Fatalf("unexpected pending after reload: %v", sequencesOf(journal.Pending("core")))
+	}
+	//sequence continues after reload, oldest discarded when full and compacted
+	appendTestEvents(t, journal, 6)
+	if 9 != journal.lastSequence {
+		t.Fatalf("expect last sequence 9, but %d", journal.lastSequence)
+	}
+	if err = journal.Acknowledge("core", 7); err != nil {
+		t.Fatalf("acknowledge fail: %s", err.Error())
+	}
+	journal.Close()
+	if journal, err = loadEventJournal(dataFile, 4); err != nil {
+		t.Fatalf("reload journal fail: %s", err.Error())
+	}
+	defer journal.Close()
+	if !equalSequences(journal.Pending("core"), 8, 9) {
+		t.Fatalf("unexpected pending after compaction: %v", sequencesOf(journal.Pending("core")))
+	}
+	if 9 != journal.lastSequence {
+		t.Fatalf("expect last sequence 9 after reload, but %d", journal.lastSequence)
+	}
+}
+
+func TestEventJournal_LoadPreviousVersion(t *testing.T) {
+	var dataFile = filepath.Join(t.TempDir(), "event.journal")
+	const content = `{
+ "last_sequence": 12,
+ "acknowledged": 10,
+ "events": [
+  {"sequence": 11, "instance": "guest", "event": 0, "timestamp": "2026-01-01T00:00:00Z"},
+  {"sequence": 12, "instance": "guest", "event": 1, "timestamp": "2026-01-01T00:00:01Z"}
+ ]
+}`
+	if err := os.WriteFile(dataFile, []byte(content), 0600); err != nil {
+		t.Fatalf("write journal fail: %s", err.Error())
+	}
+	journal, err := loadEventJournal(dataFile, 16)
+	if err != nil {
+		t.Fatalf("load journal fail: %s", err.Error())
+	}
+	defer journal.Close()
+	if !equalSequences(journal.Pending("core"), 11, 12) {
+		t.Fatalf("unexpected pending: %v", sequencesOf(journal.Pending("core")))
+	}
+	if sequence, _ := journal.Append(service.InstanceStatusChangedEvent{ID: "guest"}); 13 != sequence {
+		t.Fatalf("expect sequence 13, but %d", sequence)
+	}
+}
@@ -16,6 +16,7 @@ require (
 	github.com/project-nano/sonar v0.0.0-20190628085230-df7942628d6f
 	github.com/shirou/gopsutil v3.21.11+incompatible
 	github.com/vishvananda/netlink v1.1.0
+	golang.org/x/net v0.17.0
 )
 
 require (
@@ -38,6 +39,5 @@ require (
 	github.com/xtaci/kcp-go v5.4.20+incompatible // indirect
 	github.com/yusufpapurcu/wmi v1.2.3 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 )
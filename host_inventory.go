@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/libvirt/libvirt-go"
+	"github.com/project-nano/cell/service"
+	"github.com/shirou/gopsutil/cpu"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type virCapabilities struct {
+	XMLName xml.Name             `xml:"capabilities"`
+	Host    virCapabilityHost    `xml:"host"`
+	Guests  []virCapabilityGuest `xml:"guest"`
+}
+
+type virCapabilityHost struct {
+	CPU      virCapabilityCPU `xml:"cpu"`
+	Topology struct {
+		Cells []virCapabilityCell `xml:"cells>cell"`
+	} `xml:"topology"`
+}
+
+type virCapabilityCPU struct {
+	Arch     string `xml:"arch"`
+	Model    string `xml:"model"`
+	Vendor   string `xml:"vendor"`
+	Topology struct {
+		Sockets uint `xml:"sockets,attr"`
+		Cores   uint `xml:"cores,attr"`
+		Threads uint `xml:"threads,attr"`
+	} `xml:"topology"`
+	Pages []struct {
+		Unit string `xml:"unit,attr"`
+		Size uint64 `xml:"size,attr"`
+	} `xml:"pages"`
+}
+
+type virCapabilityCell struct {
+	ID     uint   `xml:"id,attr"`
+	Memory uint64 `xml:"memory"` //in KiB
+	CPUs   struct {
+		Num uint `xml:"num,attr"`
+	} `xml:"cpus"`
+}
+
+type virCapabilityGuest struct {
+	OSType string `xml:"os_type"`
+	Arch   struct {
+		Name     string   `xml:"name,attr"`
+		Machines []string `xml:"machine"`
+		Domains  []struct {
+			Type string `xml:"type,attr"`
+		} `xml:"domain"`
+	} `xml:"arch"`
+}
+
+type virDomainCapabilities struct {
+	XMLName xml.Name `xml:"domainCapabilities"`
+	OS      struct {
+		Enums  []virDomainCapabilityEnum `xml:"enum"`
+		Loader struct {
+			Supported string   `xml:"supported,attr"`
+			Values    []string `xml:"value"`
+		} `xml:"loader"`
+	} `xml:"os"`
+}
+
+type virDomainCapabilityEnum struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"value"`
+}
+
+const (
+	kvmDevicePath       = "/dev/kvm"
+	kernelReleasePath   = "/proc/sys/kernel/osrelease"
+	nestedParameterPath = "/sys/module/%s/parameters/nested"
+	defaultPageSize     = 4 //KiB
+	hvmOSType           = "hvm"
+	virtTypeKVM         = "kvm"
+	virtTypeQEMU        = "qemu"
+	firmwareEFI         = "efi"
+)
+
+// collectHostInventory : collect CPU/NUMA/hypervisor capability via libvirt and system files
+func collectHostInventory(virConnect *libvirt.Connect) (inventory service.HostInventory, err error) {
+	inventory.CollectTime = time.Now().Format(service.TimeFormatLayout)
+	//cpu
+	infos, err := cpu.Info()
+	if err != nil {
+		err = fmt.Errorf("get cpu info fail: %s", err.Error())
+		return
+	}
+	if 0 != len(infos) {
+		inventory.CPUModel = infos[0].ModelName
+		inventory.CPUVendor = infos[0].VendorID
+		inventory.CPUFlags = infos[0].Flags
+	}
+	logicalCores, err := cpu.Counts(true)
+	if err != nil {
+		err = fmt.Errorf("count logical cores fail: %s", err.Error())
+		return
+	}
+	inventory.LogicalCores = uint(logicalCores)
+	if data, err := os.ReadFile(kernelReleasePath); err != nil {
+		log.Printf("<collector> warning: read kernel version fail: %s", err.Error())
+	} else {
+		inventory.KernelVersion = strings.TrimSpace(string(data))
+	}
+	if _, err := os.Stat(kvmDevicePath); nil == err {
+		inventory.KVMAvailable = true
+	}
+	for _, module := range []string{"kvm_intel", "kvm_amd"} {
+		data, err := os.ReadFile(fmt.Sprintf(nestedParameterPath, module))
+		if err != nil {
+			continue
+		}
+		var value = strings.TrimSpace(string(data))
+		if "Y" == value || "1" == value {
+			inventory.NestedVirtualization = true
+		}
+	}
+
+	//libvirt
+	libVersion, err := virConnect.GetLibVersion()
+	if err != nil {
+		err = fmt.Errorf("get libvirt version fail: %s", err.Error())
+		return
+	}
+	inventory.LibvirtVersion = formatLibvirtVersion(libVersion)
+	hypervisorVersion, err := virConnect.GetVersion()
+	if err != nil {
+		err = fmt.Errorf("get hypervisor version fail: %s", err.Error())
+		return
+	}
+	inventory.QEMUVersion = formatLibvirtVersion(hypervisorVersion)
+
+	capabilityXML, err := virConnect.GetCapabilities()
+	if err != nil {
+		err = fmt.Errorf("get capabilities fail: %s", err.Error())
+		return
+	}
+	var capabilities virCapabilities
+	if err = xml.Unmarshal([]byte(capabilityXML), &capabilities); err != nil {
+		err = fmt.Errorf("parse capabilities fail: %s", err.Error())
+		return
+	}
+	var hostCPU = capabilities.Host.CPU
+	inventory.Sockets = hostCPU.Topology.Sockets
+	inventory.Cores = hostCPU.Topology.Cores
+	inventory.Threads = hostCPU.Topology.Threads
+	if "" == inventory.CPUModel {
+		inventory.CPUModel = hostCPU.Model
+	}
+	for _, page := range hostCPU.Pages {
+		if page.Size > defaultPageSize {
+			inventory.HugePageSizes = append(inventory.HugePageSizes, page.Size)
+		}
+	}
+	for _, cell := range capabilities.Host.Topology.Cells {
+		inventory.NUMANodes = append(inventory.NUMANodes, service.NUMANode{
+			ID:     cell.ID,
+			Memory: cell.Memory << 10,
+			CPUs:   cell.CPUs.Num,
+		})
+	}
+	var machines = map[string]bool{}
+	for _, guest := range capabilities.Guests {
+		if hvmOSType != guest.OSType || guest.Arch.Name != hostCPU.Arch {
+			continue
+		}
+		for _, machine := range guest.Arch.Machines {
+			if _, exists := machines[machine]; !exists {
+				machines[machine] = true
+				inventory.MachineTypes = append(inventory.MachineTypes, machine)
+			}
+		}
+	}
+
+	//firmware
+	var virtType = virtTypeQEMU
+	if inventory.KVMAvailable {
+		virtType = virtTypeKVM
+	}
+	domainXML, err := virConnect.GetDomainCapabilities("", hostCPU.Arch, "", virtType, 0)
+	if err != nil {
+		//not fatal for old libvirt
+		log.Printf("<collector> warning: get domain capabilities fail: %s", err.Error())
+		return inventory, nil
+	}
+	var domainCapabilities virDomainCapabilities
+	if err = xml.Unmarshal([]byte(domainXML), &domainCapabilities); err != nil {
+		err = fmt.Errorf("parse domain capabilities fail: %s", err.Error())
+		return
+	}
+	for _, value := range domainCapabilities.OS.Loader.Values {
+		inventory.Firmware = append(inventory.Firmware, filepath.Base(value))
+	}
+	if 0 != len(domainCapabilities.OS.Loader.Values) {
+		inventory.UEFIAvailable = true
+	}
+	for _, enum := range domainCapabilities.OS.Enums {
+		if "firmware" != enum.Name {
+			continue
+		}
+		for _, value := range enum.Values {
+			if firmwareEFI == value {
+				inventory.UEFIAvailable = true
+			}
+		}
+	}
+	return inventory, nil
+}
+
+func formatLibvirtVersion(version uint32) string {
+	return fmt.Sprintf("%d.%d.%d", version/1000000, (version/1000)%1000, version%1000)
+}
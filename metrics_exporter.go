@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	"log"
+	goNet "net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetricsExporter : expose host, instance and internal metrics in Prometheus text format
+type MetricsExporter struct {
+	listenAddress  string
+	listener       goNet.Listener
+	server         http.Server
+	collector      *CollectorModule
+	insManager     *service.InstanceManager
+	storageManager *service.StorageManager
+	networkManager *service.NetworkManager
+	runner         *framework.SimpleRunner
+}
+
+type metricSample struct {
+	Labels []string //name, value pairs
+	Value  float64
+}
+
+const (
+	metricsNamespace       = "nano_cell"
+	metricsPath            = "/metrics"
+	metricsContentType     = "text/plain; version=0.0.4; charset=utf-8"
+	metricsQueryTimeout    = 5 * time.Second
+	metricsShutdownTimeout = 5 * time.Second //wait for scraping in progress when stopping
+	metricTypeGauge        = "gauge"
+	metricTypeCounter      = "counter"
+	metricsLabelInstance   = "instance_id"
+)
+
+func CreateMetricsExporter(listenAddress string, collector *CollectorModule, insManager *service.InstanceManager,
+	storageManager *service.StorageManager, networkManager *service.NetworkManager) (exporter *MetricsExporter, err error) {
+	const (
+		Protocol = "tcp"
+	)
+	exporter = &MetricsExporter{}
+	exporter.listenAddress = listenAddress
+	exporter.collector = collector
+	exporter.insManager = insManager
+	exporter.storageManager = storageManager
+	exporter.networkManager = networkManager
+	if exporter.listener, err = goNet.Listen(Protocol, listenAddress); err != nil {
+		err = fmt.Errorf("listen metrics exporter at '%s' fail: %s", listenAddress, err.Error())
+		return
+	}
+	var mux = http.NewServeMux()
+	mux.HandleFunc(metricsPath, exporter.serveMetrics)
+	exporter.server.Addr = listenAddress
+	exporter.server.Handler = mux
+	exporter.runner = framework.CreateSimpleRunner(exporter.Routine)
+	return exporter, nil
+}
+
+func (exporter *MetricsExporter) Start() error {
+	return exporter.runner.Start()
+}
+
+func (exporter *MetricsExporter) Stop() error {
+	return exporter.runner.Stop()
+}
+
+func (exporter *MetricsExporter) Routine(c framework.RoutineController) {
+	go func() {
+		log.Printf("<exporter> metrics available at http://%s%s", exporter.listenAddress, metricsPath)
+		if err := exporter.server.Serve(exporter.listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("<exporter> http server finished: %s", err.Error())
+		}
+	}()
+	<-c.GetNotifyChannel()
+	c.SetStopping()
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	if err := exporter.server.Shutdown(ctx); err != nil {
+		log.Printf("<exporter> shutdown http server: %s", err.Error())
+	}
+	log.Println("<exporter> stopped")
+	c.NotifyExit()
+}
+
+func (exporter *MetricsExporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var output bytes.Buffer
+	if err := exporter.writeHostMetrics(&output); err != nil {
+		log.Printf("<exporter> collect host metrics fail: %s", err.Error())
+	}
+	if err := exporter.writeInstanceMetrics(&output); err != nil {
+		log.Printf("<exporter> collect instance metrics fail: %s", err.Error())
+	}
+	if err := exporter.writeInternalMetrics(&output); err != nil {
+		log.Printf("<exporter> collect internal metrics fail: %s", err.Error())
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(output.Bytes())
+}
+
+func (exporter *MetricsExporter) writeHostMetrics(output *bytes.Buffer) (err error) {
+	cores, err := cpu.Counts(true)
+	if err != nil {
+		return
+	}
+	writeMetric(output, "cpu_cores", "Logical CPU cores of host", metricTypeGauge,
+		metricSample{Value: float64(cores)})
+	times, err := cpu.Times(false)
+	if err != nil {
+		return
+	}
+	if 0 != len(times) {
+		var total = times[0]
+		writeMetric(output, "cpu_seconds_total", "Seconds CPUs of host spent in each mode", metricTypeCounter,
+			metricSample{[]string{"mode", "user"}, total.User},
+			metricSample{[]string{"mode", "nice"}, total.Nice},
+			metricSample{[]string{"mode", "system"}, total.System},
+			metricSample{[]string{"mode", "idle"}, total.Idle},
+			metricSample{[]string{"mode", "iowait"}, total.Iowait},
+			metricSample{[]string{"mode", "irq"}, total.Irq},
+			metricSample{[]string{"mode", "softirq"}, total.Softirq},
+			metricSample{[]string{"mode", "steal"}, total.Steal})
+	}
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return
+	}
+	writeMetric(output, "memory_total_bytes", "Total memory of host", metricTypeGauge,
+		metricSample{Value: float64(vm.Total)})
+	writeMetric(output, "memory_available_bytes", "Available memory of host", metricTypeGauge,
+		metricSample{Value: float64(vm.Available)})
+
+	if err = exporter.writeStorageMetrics(output); err != nil {
+		//other sections still available
+		log.Printf("<exporter> collect storage metrics fail: %s", err.Error())
+	}
+
+	//network interfaces
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return
+	}
+	var received, sent, receivedPackets, sentPackets []metricSample
+	for _, counter := range counters {
+		var labels = []string{"interface", counter.Name}
+		received = append(received, metricSample{labels, float64(counter.BytesRecv)})
+		sent = append(sent, metricSample{labels, float64(counter.BytesSent)})
+		receivedPackets = append(receivedPackets, metricSample{labels, float64(counter.PacketsRecv)})
+		sentPackets = append(sentPackets, metricSample{labels, float64(counter.PacketsSent)})
+	}
+	writeMetric(output, "network_receive_bytes_total", "Bytes received by host interface", metricTypeCounter, received...)
+	writeMetric(output, "network_transmit_bytes_total", "Bytes sent by host interface", metricTypeCounter, sent...)
+	writeMetric(output, "network_receive_packets_total", "Packets received by host interface", metricTypeCounter, receivedPackets...)
+	writeMetric(output, "network_transmit_packets_total", "Packets sent by host interface", metricTypeCounter, sentPackets...)
+	return nil
+}
+
+// writeStorageMetrics : usage of storage paths
+func (exporter *MetricsExporter) writeStorageMetrics(output *bytes.Buffer) (err error) {
+	var pathChan = make(chan service.StorageResult, 1)
+	exporter.storageManager.QueryStoragePaths(pathChan)
+	var pathResult service.StorageResult
+	select {
+	case pathResult = <-pathChan:
+	case <-time.After(metricsQueryTimeout):
+		return fmt.Errorf("query storage paths timeout")
+	}
+	var storagePaths = map[string]bool{}
+	for _, path := range append(pathResult.SystemPaths, pathResult.DataPaths...) {
+		storagePaths[path] = true
+	}
+	var totalSamples, freeSamples []metricSample
+	for _, path := range sortedKeys(storagePaths) {
+		usage, err := disk.Usage(path)
+		if err != nil {
+			log.Printf("<exporter> get usage of storage path '%s' fail: %s", path, err.Error())
+			continue
+		}
+		totalSamples = append(totalSamples, metricSample{[]string{"path", path}, float64(usage.Total)})
+		freeSamples = append(freeSamples, metricSample{[]string{"path", path}, float64(usage.Free)})
+	}
+	writeMetric(output, "storage_total_bytes", "Total space of storage path", metricTypeGauge, totalSamples...)
+	writeMetric(output, "storage_free_bytes", "Free space of storage path", metricTypeGauge, freeSamples...)
+	return nil
+}
+
+func (exporter *MetricsExporter) writeInstanceMetrics(output *bytes.Buffer) (err error) {
+	var respChan = make(chan []service.InstanceStatus, 1)
+	exporter.insManager.GetAllInstanceStatus(respChan)
+	var instances []service.InstanceStatus
+	select {
+	case instances = <-respChan:
+	case <-time.After(metricsQueryTimeout):
+		return fmt.Errorf("query instance status timeout")
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	var running, cores, usage, memory, availableMemory []metricSample
+	var bytesRead, bytesWritten, readRequests, writeRequests, bytesReceived, bytesSent []metricSample
+	for _, ins := range instances {
+		var labels = []string{metricsLabelInstance, ins.ID, "name", ins.Name, "group", ins.Group}
+		var value float64 = 0
+		if ins.Running {
+			value = 1
+		}
+		running = append(running, metricSample{labels, value})
+		cores = append(cores, metricSample{labels, float64(ins.Cores)})
+		memory = append(memory, metricSample{labels, float64(ins.Memory)})
+		if !ins.Running {
+			continue
+		}
+		usage = append(usage, metricSample{labels, ins.CpuUsages})
+		availableMemory = append(availableMemory, metricSample{labels, float64(ins.AvailableMemory)})
+		bytesRead = append(bytesRead, metricSample{labels, float64(ins.BytesRead)})
+		bytesWritten = append(bytesWritten, metricSample{labels, float64(ins.BytesWritten)})
+		readRequests = append(readRequests, metricSample{labels, float64(ins.ReadRequests)})
+		writeRequests = append(writeRequests, metricSample{labels, float64(ins.WriteRequests)})
+		bytesReceived = append(bytesReceived, metricSample{labels, float64(ins.BytesReceived)})
+		bytesSent = append(bytesSent, metricSample{labels, float64(ins.BytesSent)})
+	}
+	writeMetric(output, "instance_running", "Whether instance is running", metricTypeGauge, running...)
+	writeMetric(output, "instance_cpu_cores", "Virtual CPU cores of instance", metricTypeGauge, cores...)
+	writeMetric(output, "instance_cpu_usage_percent", "CPU usage of instance", metricTypeGauge, usage...)
+	writeMetric(output, "instance_memory_bytes", "Memory allocated to instance", metricTypeGauge, memory...)
+	writeMetric(output, "instance_memory_available_bytes", "Available memory reported by instance", metricTypeGauge, availableMemory...)
+	writeMetric(output, "instance_disk_read_bytes_total", "Bytes read from disks of instance", metricTypeCounter, bytesRead...)
+	writeMetric(output, "instance_disk_written_bytes_total", "Bytes written to disks of instance", metricTypeCounter, bytesWritten...)
+	writeMetric(output, "instance_disk_read_requests_total", "Read requests on disks of instance", metricTypeCounter, readRequests...)
+	writeMetric(output, "instance_disk_write_requests_total", "Write requests on disks of instance", metricTypeCounter, writeRequests...)
+	writeMetric(output, "instance_network_receive_bytes_total", "Bytes received by interfaces of instance", metricTypeCounter, bytesReceived...)
+	writeMetric(output, "instance_network_transmit_bytes_total", "Bytes sent by interfaces of instance", metricTypeCounter, bytesSent...)
+	return nil
+}
+
+func (exporter *MetricsExporter) writeInternalMetrics(output *bytes.Buffer) (err error) {
+	writeMetric(output, "command_queue_depth", "Commands waiting in module queue", metricTypeGauge,
+		metricSample{[]string{"module", "collector"}, float64(exporter.collector.GetQueueDepth())},
+		metricSample{[]string{"module", "instance"}, float64(exporter.insManager.GetQueueDepth())},
+		metricSample{[]string{"module", "storage"}, float64(exporter.storageManager.GetQueueDepth())},
+		metricSample{[]string{"module", "network"}, float64(exporter.networkManager.GetQueueDepth())})
+
+	var respChan = make(chan service.StorageResult, 1)
+	exporter.storageManager.GetSchedulerStatistic(respChan)
+	var result service.StorageResult
+	select {
+	case result = <-respChan:
+	case <-time.After(metricsQueryTimeout):
+		return fmt.Errorf("query scheduler statistic timeout")
+	}
+	var queued []metricSample
+	var pools = map[string]bool{}
+	for pool := range result.SchedulerTasks {
+		pools[pool] = true
+	}
+	for _, pool := range sortedKeys(pools) {
+		queued = append(queued, metricSample{[]string{"pool", pool}, float64(result.SchedulerTasks[pool])})
+	}
+	writeMetric(output, "scheduler_queued_tasks", "IO tasks waiting in storage scheduler", metricTypeGauge, queued...)
+	writeMetric(output, "scheduler_running_tasks", "IO tasks in progress", metricTypeGauge,
+		metricSample{Value: float64(result.RunningTasks)})
+	return nil
+}
+
+func writeMetric(output *bytes.Buffer, name, help, metricType string, samples ...metricSample) {
+	var fullName = fmt.Sprintf("%s_%s", metricsNamespace, name)
+	fmt.Fprintf(output, "# HELP %s %s\n", fullName, help)
+	fmt.Fprintf(output, "# TYPE %s %s\n", fullName, metricType)
+	for _, sample := range samples {
+		output.WriteString(fullName)
+		if 0 != len(sample.Labels) {
+			var pairs []string
+			for index := 0; index+1 < len(sample.Labels); index += 2 {
+				pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", sample.Labels[index], escapeLabelValue(sample.Labels[index+1])))
+			}
+			fmt.Fprintf(output, "{%s}", strings.Join(pairs, ","))
+		}
+		fmt.Fprintf(output, " %g\n", sample.Value)
+	}
+}
+
+func escapeLabelValue(value string) string {
+	var replacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return replacer.Replace(value)
+}
+
+func sortedKeys(values map[string]bool) []string {
+	var keys = make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
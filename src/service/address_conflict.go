@@ -0,0 +1,352 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	addressProbeTimeout  = 1 * time.Second
+	addressProbeCount    = 2
+	addressQuarantine    = 10 * time.Minute
+	addressProbePending  = 3 * addressProbeTimeout //released when result of probe lost
+	probeReceiveInterval = 100 * time.Millisecond
+	addressAnnounceCount = 2
+	announceInterval     = 1 * time.Second
+)
+
+const (
+	etherTypeARP        = 0x0806
+	etherTypeIPv4       = 0x0800
+	etherHeaderLength   = 14
+	arpPacketLength     = 28
+	arpOperationRequest = 1
+	arpHardwareLength   = 6
+	arpProtocolLength   = 4
+	//target link-layer address option of neighbor advertisement
+	ndOptionTargetLinkLayer = 2
+)
+
+var broadcastHardwareAddress = net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// addressQuarantineList : address withheld after conflict detected or declined by client, owned by single routine
+type addressQuarantineList map[string]time.Time
+
+func (list addressQuarantineList) Add(address string) (expire time.Time) {
+	expire = time.Now().Add(addressQuarantine)
+	list[address] = expire
+	return expire
+}
+
+// IsQuarantined : expired entry released
+func (list addressQuarantineList) IsQuarantined(address string) (expire time.Time, quarantined bool) {
+	if expire, quarantined = list[address]; !quarantined {
+		return
+	}
+	if time.Now().After(expire) {
+		delete(list, address)
+		return expire, false
+	}
+	return expire, true
+}
+
+// state of address in probe cache
+const (
+	probeNone = iota
+	probePending
+	probeClean
+)
+
+type addressProbeRecord struct {
+	State  int
+	Expire time.Time
+}
+
+// addressProbeCache : probe runs outside the serving routine, clean result reused in quarantine window. Owned by single routine
+type addressProbeCache map[string]addressProbeRecord
+
+// State : expired record released
+func (cache addressProbeCache) State(address string) int {
+	record, exists := cache[address]
+	if !exists {
+		return probeNone
+	}
+	if time.Now().After(record.Expire) {
+		delete(cache, address)
+		return probeNone
+	}
+	return record.State
+}
+
+func (cache addressProbeCache) SetPending(address string) {
+	cache[address] = addressProbeRecord{State: probePending, Expire: time.Now().Add(addressProbePending)}
+}
+
+func (cache addressProbeCache) SetClean(address string) {
+	cache[address] = addressProbeRecord{State: probeClean, Expire: time.Now().Add(addressQuarantine)}
+}
+
+func (cache addressProbeCache) Remove(address string) {
+	delete(cache, address)
+}
+
+// Purge : release all expired records
+func (cache addressProbeCache) Purge() {
+	var now = time.Now()
+	for address, record := range cache {
+		if now.After(record.Expire) {
+			delete(cache, address)
+		}
+	}
+}
+
+// newAddressConflictEvent : hwaddress of conflicting host is empty when unknown
+func newAddressConflictEvent(instanceID, address, hwaddress string) InstanceStatusChangedEvent {
+	return InstanceStatusChangedEvent{ID: instanceID, Event: AddressConflicted, Address: address,
+		HardwareAddress: hwaddress, Timestamp: time.Now()}
+}
+
+// ProbeIPv4Address : ARP probe with unspecified sender address (RFC 5227), any host other than owner answered is conflict
+func ProbeIPv4Address(device *net.Interface, target net.IP, owner net.HardwareAddr, timeout time.Duration) (conflicted bool, hwaddress net.HardwareAddr, err error) {
+	if target = target.To4(); nil == target {
+		err = errors.New("IPv4 address required")
+		return
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(hostToNetworkShort(etherTypeARP)))
+	if err != nil {
+		err = fmt.Errorf("open packet socket fail: %s", err.Error())
+		return
+	}
+	defer syscall.Close(fd)
+	var address = syscall.SockaddrLinklayer{Protocol: hostToNetworkShort(etherTypeARP), Ifindex: device.Index}
+	if err = syscall.Bind(fd, &address); err != nil {
+		err = fmt.Errorf("bind to '%s' fail: %s", device.Name, err.Error())
+		return
+	}
+	var interval = syscall.NsecToTimeval(probeReceiveInterval.Nanoseconds())
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &interval); err != nil {
+		err = fmt.Errorf("set receive timeout fail: %s", err.Error())
+		return
+	}
+	var probe = buildARPProbe(device.HardwareAddr, target)
+	var destination = syscall.SockaddrLinklayer{Ifindex: device.Index, Halen: arpHardwareLength}
+	copy(destination.Addr[:], broadcastHardwareAddress)
+	var buffer = make([]byte, ipv6PacketSize)
+	var deadline = time.Now().Add(timeout)
+	var nextProbe time.Time
+	var sent = 0
+	for time.Now().Before(deadline) {
+		if sent < addressProbeCount && !time.Now().Before(nextProbe) {
+			if err = syscall.Sendto(fd, probe, 0, &destination); err != nil {
+				err = fmt.Errorf("send ARP probe fail: %s", err.Error())
+				return
+			}
+			sent++
+			nextProbe = time.Now().Add(timeout / addressProbeCount)
+		}
+		length, _, err := syscall.Recvfrom(fd, buffer, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return false, nil, fmt.Errorf("receive ARP fail: %s", err.Error())
+		}
+		if sender, matched := parseARPSender(buffer[:length], target); matched &&
+			!bytes.Equal(sender, owner) && !bytes.Equal(sender, device.HardwareAddr) {
+			return true, sender, nil
+		}
+	}
+	return false, nil, nil
+}
+
+// AnnounceIPv4Address : gratuitous ARP, so that neighbors update cache after address moved to device
+func AnnounceIPv4Address(device *net.Interface, address net.IP) (err error) {
+	if address = address.To4(); nil == address {
+		err = errors.New("IPv4 address required")
+		return
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(hostToNetworkShort(etherTypeARP)))
+	if err != nil {
+		err = fmt.Errorf("open packet socket fail: %s", err.Error())
+		return
+	}
+	defer syscall.Close(fd)
+	var announcement = buildARPAnnouncement(device.HardwareAddr, address)
+	var destination = syscall.SockaddrLinklayer{Ifindex: device.Index, Halen: arpHardwareLength}
+	copy(destination.Addr[:], broadcastHardwareAddress)
+	for i := 0; i < addressAnnounceCount; i++ {
+		if 0 != i {
+			time.Sleep(announceInterval)
+		}
+		if err = syscall.Sendto(fd, announcement, 0, &destination); err != nil {
+			err = fmt.Errorf("send ARP announcement fail: %s", err.Error())
+			return
+		}
+	}
+	return nil
+}
+
+// ProbeIPv6Address : neighbor solicitation to solicited-node multicast address of target, any host other than owner advertised is conflict
+func ProbeIPv6Address(device *net.Interface, target net.IP, owner net.HardwareAddr, timeout time.Duration) (conflicted bool, hwaddress net.HardwareAddr, err error) {
+	if nil != target.To4() || nil == target.To16() {
+		err = errors.New("IPv6 address required")
+		return
+	}
+	icmpConn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		err = fmt.Errorf("listen ICMPv6 fail: %s", err.Error())
+		return
+	}
+	defer icmpConn.Close()
+	var conn = icmpConn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	if err = conn.SetICMPFilter(&filter); err != nil {
+		err = fmt.Errorf("set ICMPv6 filter fail: %s", err.Error())
+		return
+	}
+	if err = conn.SetMulticastHopLimit(ipv6HopLimit); err != nil {
+		err = fmt.Errorf("set hop limit fail: %s", err.Error())
+		return
+	}
+	if err = conn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		err = fmt.Errorf("enable control message fail: %s", err.Error())
+		return
+	}
+	var message = icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: buildNeighborSolicitation(target, device.HardwareAddr)},
+	}
+	data, err := message.Marshal(nil)
+	if err != nil {
+		err = fmt.Errorf("marshal neighbor solicitation fail: %s", err.Error())
+		return
+	}
+	var cm = &ipv6.ControlMessage{HopLimit: ipv6HopLimit, IfIndex: device.Index}
+	var destination = &net.IPAddr{IP: solicitedNodeAddress(target), Zone: device.Name}
+	var buffer = make([]byte, ipv6PacketSize)
+	for sent := 0; sent < addressProbeCount; sent++ {
+		if _, err = conn.WriteTo(data, cm, destination); err != nil {
+			err = fmt.Errorf("send neighbor solicitation fail: %s", err.Error())
+			return
+		}
+		if err = conn.SetReadDeadline(time.Now().Add(timeout / addressProbeCount)); err != nil {
+			return
+		}
+		for {
+			length, received, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				return false, nil, fmt.Errorf("receive neighbor advertisement fail: %s", err.Error())
+			}
+			if nil != received && received.IfIndex != device.Index {
+				continue
+			}
+			advertised, sender, err := parseNeighborAdvertisement(buffer[:length])
+			if err != nil || !advertised.Equal(target) || bytes.Equal(sender, owner) {
+				continue
+			}
+			return true, sender, nil
+		}
+	}
+	return false, nil, nil
+}
+
+// buildARPProbe : ethernet frame of ARP request with unspecified sender address
+func buildARPProbe(source net.HardwareAddr, target net.IP) []byte {
+	var frame = make([]byte, etherHeaderLength, etherHeaderLength+arpPacketLength)
+	copy(frame, broadcastHardwareAddress)
+	copy(frame[6:], source)
+	binary.BigEndian.PutUint16(frame[12:], etherTypeARP)
+	var packet = make([]byte, arpPacketLength)
+	binary.BigEndian.PutUint16(packet, hardwareTypeEthernet)
+	binary.BigEndian.PutUint16(packet[2:], etherTypeIPv4)
+	packet[4] = arpHardwareLength
+	packet[5] = arpProtocolLength
+	binary.BigEndian.PutUint16(packet[6:], arpOperationRequest)
+	copy(packet[8:], source)
+	//sender protocol address keeps zero
+	copy(packet[24:], target.To4())
+	return append(frame, packet...)
+}
+
+// buildARPAnnouncement : ARP request with address used as both sender and target (RFC 5227)
+func buildARPAnnouncement(source net.HardwareAddr, address net.IP) []byte {
+	var frame = buildARPProbe(source, address)
+	copy(frame[etherHeaderLength+14:], address.To4())
+	return frame
+}
+
+// parseARPSender : matched when target used as sender address of reply or announcement
+func parseARPSender(frame []byte, target net.IP) (sender net.HardwareAddr, matched bool) {
+	if len(frame) < etherHeaderLength+arpPacketLength || etherTypeARP != binary.BigEndian.Uint16(frame[12:]) {
+		return
+	}
+	var packet = frame[etherHeaderLength:]
+	if etherTypeIPv4 != binary.BigEndian.Uint16(packet[2:]) || arpHardwareLength != packet[4] || arpProtocolLength != packet[5] {
+		return
+	}
+	if !net.IP(packet[14:18]).Equal(target) {
+		return
+	}
+	sender = make(net.HardwareAddr, arpHardwareLength)
+	copy(sender, packet[8:14])
+	return sender, true
+}
+
+// buildNeighborSolicitation : body after checksum, with source link-layer address
+func buildNeighborSolicitation(target net.IP, source net.HardwareAddr) []byte {
+	var body = make([]byte, 4, 4+net.IPv6len+8)
+	body = append(body, target.To16()...)
+	body = append(body, ndOptionSourceLinkLayer, 1)
+	return append(body, source...)
+}
+
+// parseNeighborAdvertisement : data begins with ICMP header, sender is empty when no target link-layer address option
+func parseNeighborAdvertisement(data []byte) (target net.IP, sender net.HardwareAddr, err error) {
+	const (
+		HeaderLength = 4
+		BodyLength   = 4 + net.IPv6len
+	)
+	if len(data) < HeaderLength+BodyLength || byte(ipv6.ICMPTypeNeighborAdvertisement) != data[0] {
+		err = errors.New("invalid neighbor advertisement")
+		return
+	}
+	var body = data[HeaderLength:]
+	target = net.IP(append([]byte{}, body[4:BodyLength]...))
+	var options = body[BodyLength:]
+	for len(options) >= 8 {
+		var length = int(options[1]) * 8
+		if 0 == length || length > len(options) {
+			err = errors.New("invalid option length")
+			return
+		}
+		if ndOptionTargetLinkLayer == options[0] && length >= 2+arpHardwareLength {
+			sender = append(net.HardwareAddr{}, options[2:2+arpHardwareLength]...)
+		}
+		options = options[length:]
+	}
+	return target, sender, nil
+}
+
+// solicitedNodeAddress : ff02::1:ffXX:XXXX of lower 24 bits, RFC 4291
+func solicitedNodeAddress(ip net.IP) net.IP {
+	var address = net.ParseIP("ff02::1:ff00:0")
+	copy(address[13:], ip.To16()[13:])
+	return address
+}
+
+// hostToNetworkShort : htons for protocol of packet socket, cell runs on little-endian hosts only (amd64/arm64)
+func hostToNetworkShort(value uint16) uint16 {
+	return value<<8 | value>>8
+}
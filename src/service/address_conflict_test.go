@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestARPProbe(t *testing.T) {
+	var source = net.HardwareAddr{0x52, 0x54, 0, 0xAA, 0xBB, 0xCC}
+	var target = net.ParseIP("192.168.1.10")
+	var probe = buildARPProbe(source, target)
+	if etherHeaderLength+arpPacketLength != len(probe) || !bytes.Equal(broadcastHardwareAddress, probe[:6]) {
+		t.Fatalf("unexpected probe %v", probe)
+	}
+	//probe from unspecified address never matched
+	if _, matched := parseARPSender(probe, target); matched {
+		t.Fatal("probe matched unexpectedly")
+	}
+	//reply from conflicting host
+	var reply = append([]byte{}, probe...)
+	var conflict = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	copy(reply[etherHeaderLength+8:], conflict)
+	copy(reply[etherHeaderLength+14:], target.To4())
+	sender, matched := parseARPSender(reply, target)
+	if !matched || !bytes.Equal(conflict, sender) {
+		t.Fatalf("unexpected sender %s", sender)
+	}
+	if _, matched = parseARPSender(reply, net.ParseIP("192.168.1.11")); matched {
+		t.Fatal("different address matched unexpectedly")
+	}
+}
+
+func TestARPAnnouncement(t *testing.T) {
+	var source = net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	var address = net.ParseIP("192.168.1.200")
+	var announcement = buildARPAnnouncement(source, address)
+	sender, matched := parseARPSender(announcement, address)
+	if !matched || !bytes.Equal(source, sender) {
+		t.Fatalf("unexpected sender %s", sender)
+	}
+	if !net.IP(announcement[etherHeaderLength+24:]).Equal(address) {
+		t.Fatalf("unexpected target %v", announcement[etherHeaderLength+24:])
+	}
+}
+
+func TestNeighborProbe(t *testing.T) {
+	var target = net.ParseIP("2001:db8::10")
+	if !net.ParseIP("ff02::1:ff00:10").Equal(solicitedNodeAddress(target)) {
+		t.Fatalf("unexpected solicited-node address %s", solicitedNodeAddress(target))
+	}
+	var source = net.HardwareAddr{0x52, 0x54, 0, 0xAA, 0xBB, 0xCC}
+	var body = buildNeighborSolicitation(target, source)
+	if 4+16+8 != len(body) || !target.Equal(body[4:20]) || !bytes.Equal(source, body[22:]) {
+		t.Fatalf("unexpected solicitation %v", body)
+	}
+	//header and flags, then target with link-layer address
+	var advertisement = []byte{136, 0, 0, 0, 0x60, 0, 0, 0}
+	advertisement = append(advertisement, target...)
+	advertisement = append(advertisement, ndOptionTargetLinkLayer, 1, 0, 0x11, 0x22, 0x33, 0x44, 0x55)
+	advertised, sender, err := parseNeighborAdvertisement(advertisement)
+	if err != nil || !target.Equal(advertised) || "00:11:22:33:44:55" != sender.String() {
+		t.Fatalf("unexpected advertisement %s from %s: %v", advertised, sender, err)
+	}
+	if _, _, err = parseNeighborAdvertisement(advertisement[:10]); nil == err {
+		t.Fatal("truncated advertisement accepted unexpectedly")
+	}
+}
+
+func TestAddressQuarantine(t *testing.T) {
+	var list = addressQuarantineList{}
+	list.Add("192.168.1.10")
+	if _, quarantined := list.IsQuarantined("192.168.1.10"); !quarantined {
+		t.Fatal("address not quarantined")
+	}
+	if _, quarantined := list.IsQuarantined("192.168.1.11"); quarantined {
+		t.Fatal("address quarantined unexpectedly")
+	}
+}
+
+func TestAddressProbeCache(t *testing.T) {
+	var cache = addressProbeCache{}
+	const address = "192.168.1.10"
+	if probeNone != cache.State(address) {
+		t.Fatal("unknown address probed unexpectedly")
+	}
+	cache.SetPending(address)
+	if probePending != cache.State(address) {
+		t.Fatal("probe not pending")
+	}
+	cache.SetClean(address)
+	if probeClean != cache.State(address) {
+		t.Fatal("clean result not cached")
+	}
+	cache[address] = addressProbeRecord{State: probeClean, Expire: time.Now().Add(-time.Second)}
+	if probeNone != cache.State(address) {
+		t.Fatal("expired result reused")
+	}
+	if 0x0608 != hostToNetworkShort(etherTypeARP) {
+		t.Fatalf("unexpected protocol 0x%04x", hostToNetworkShort(etherTypeARP))
+	}
+}
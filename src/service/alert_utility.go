@@ -0,0 +1,56 @@
+package service
+
+import (
+	"github.com/project-nano/framework"
+)
+
+const (
+	AlertMetricMemoryAvailable = "host_memory_available"
+	AlertMetricSwapUsed        = "host_swap_used"
+	AlertMetricStorageFree     = "storage_free"
+	AlertMetricGuestCPU        = "guest_cpu"
+	AlertMetricPoolUnmounted   = "pool_unmounted"
+)
+
+// Alert : raised by a threshold rule on a target, host/storage path/instance ID/pool name
+type Alert struct {
+	Rule       string
+	Metric     string
+	Target     string
+	Value      float64 //percent, 1 for unmounted pool
+	Threshold  float64
+	RaisedTime string
+}
+
+// IsLowerBad : metric alerts when value falls below threshold
+func IsLowerBad(metric string) bool {
+	switch metric {
+	case AlertMetricMemoryAvailable, AlertMetricStorageFree:
+		return true
+	default:
+		return false
+	}
+}
+
+// MarshalAlerts : rule names, metrics, targets, raised time, value and threshold in 0.01%
+func MarshalAlerts(message framework.Message, alerts []Alert) {
+	const (
+		percentScale = 100
+	)
+	var rules, metrics, targets, raisedTime []string
+	var values, thresholds []uint64
+	for _, alert := range alerts {
+		rules = append(rules, alert.Rule)
+		metrics = append(metrics, alert.Metric)
+		targets = append(targets, alert.Target)
+		raisedTime = append(raisedTime, alert.RaisedTime)
+		values = append(values, uint64(alert.Value*percentScale))
+		thresholds = append(thresholds, uint64(alert.Threshold*percentScale))
+	}
+	message.SetStringArray(framework.ParamKeyName, rules)
+	message.SetStringArray(framework.ParamKeyType, metrics)
+	message.SetStringArray(framework.ParamKeyTarget, targets)
+	message.SetStringArray(framework.ParamKeyCreate, raisedTime)
+	message.SetUIntArray(framework.ParamKeyUsage, values)
+	message.SetUIntArray(framework.ParamKeyLimit, thresholds)
+}
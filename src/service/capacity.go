@@ -0,0 +1,141 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"syscall"
+)
+
+// ResourceCapacity : committed resource of guests admitted up to (Host - Reserved) * Ratio,
+// unlimited when ratio is zero, and Limit reported as zero
+type ResourceCapacity struct {
+	Host      uint64
+	Reserved  uint64
+	Ratio     float64
+	Limit     uint64
+	Committed uint64
+	Unlimited bool
+}
+
+func newResourceCapacity(host, reserved uint64, ratio float64, committed uint64) (capacity ResourceCapacity) {
+	capacity.Host = host
+	capacity.Reserved = reserved
+	capacity.Ratio = ratio
+	capacity.Committed = committed
+	if 0 == ratio {
+		capacity.Unlimited = true
+	} else if host > reserved {
+		capacity.Limit = uint64(float64(host-reserved) * ratio)
+	}
+	return
+}
+
+// Available : resource could be committed before reach limit
+func (capacity ResourceCapacity) Available() uint64 {
+	if capacity.Unlimited {
+		return math.MaxUint64
+	}
+	if capacity.Committed >= capacity.Limit {
+		return 0
+	}
+	return capacity.Limit - capacity.Committed
+}
+
+// Admit : check if increased resource exceeds limit
+func (capacity ResourceCapacity) Admit(resource string, increase uint64) error {
+	if increase > capacity.Available() {
+		return fmt.Errorf("insufficient %s: require %d, committed %d / %d (host %d, reserved %d, overcommit %.2f)",
+			resource, increase, capacity.Committed, capacity.Limit, capacity.Host, capacity.Reserved, capacity.Ratio)
+	}
+	return nil
+}
+
+func (manager *InstanceManager) getCoreCapacity() ResourceCapacity {
+	var ratio, _, _ = GetConfigurator().GetOvercommitRatio()
+	var reserved, _ = GetConfigurator().GetHostReservation()
+	var committed uint64 = 0
+	for _, ins := range manager.instances {
+		committed += uint64(ins.Cores)
+	}
+	return newResourceCapacity(uint64(manager.hostCores), uint64(reserved), ratio, committed)
+}
+
+func (manager *InstanceManager) getMemoryCapacity() ResourceCapacity {
+	var _, ratio, _ = GetConfigurator().GetOvercommitRatio()
+	var _, reserved = GetConfigurator().GetHostReservation()
+	var committed uint64 = 0
+	for _, ins := range manager.instances {
+		committed += uint64(ins.Memory)
+	}
+	return newResourceCapacity(manager.hostMemory, reserved, ratio, committed)
+}
+
+// admitResource : check increased cores and memory in bytes
+func (manager *InstanceManager) admitResource(cores uint, memory uint64) (err error) {
+	if 0 != cores {
+		if err = manager.getCoreCapacity().Admit("cores", uint64(cores)); err != nil {
+			return
+		}
+	}
+	if 0 != memory {
+		if err = manager.getMemoryCapacity().Admit("memory", memory); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+func (manager *InstanceManager) handleGetCapacity(respChan chan InstanceResult) (err error) {
+	respChan <- InstanceResult{Cores: manager.getCoreCapacity(), Memory: manager.getMemoryCapacity()}
+	return nil
+}
+
+// getDiskCapacity : capacity of pool, committed by provisioned size of volumes,
+// volumes of all cells counted in shared NFS pool
+func (manager *StorageManager) getDiskCapacity(poolName string) (capacity ResourceCapacity, err error) {
+	pool, exists := manager.pools[poolName]
+	if !exists {
+		err = fmt.Errorf("invalid storage pool '%s'", poolName)
+		return
+	}
+	//local and nfs pool both mounted at target
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(pool.Target, &stat); err != nil {
+		err = fmt.Errorf("get capacity of '%s' fail: %s", pool.Target, err.Error())
+		return
+	}
+	var total = stat.Blocks * uint64(stat.Bsize)
+	var _, _, ratio = GetConfigurator().GetOvercommitRatio()
+	var committed uint64 = 0
+	for _, group := range manager.groups {
+		if group.System.Pool == poolName {
+			committed += group.System.Capacity
+		}
+		for _, volume := range group.Data {
+			if volume.Pool == poolName {
+				committed += volume.Capacity
+			}
+		}
+	}
+	if StoragePoolModeNFS == pool.Mode {
+		shared, err := manager.utility.GetProvisionedCapacity(poolName)
+		if err != nil {
+			log.Printf("<storage> warning: get provisioned capacity of shared pool '%s' fail, only local volumes counted: %s",
+				poolName, err.Error())
+		} else if shared > committed {
+			committed = shared
+		}
+	}
+	return newResourceCapacity(total, 0, ratio, committed), nil
+}
+
+func (manager *StorageManager) handleGetCapacity(respChan chan StorageResult) (err error) {
+	capacity, err := manager.getDiskCapacity(manager.currentPool)
+	if err != nil {
+		respChan <- StorageResult{Error: err}
+		return
+	}
+	respChan <- StorageResult{Pool: manager.currentPool, Capacity: capacity}
+	return nil
+}
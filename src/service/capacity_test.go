@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestResourceCapacity_Admit(t *testing.T) {
+	//16 cores with 2 reserved, overcommit 4 => 56
+	var capacity = newResourceCapacity(16, 2, 4, 50)
+	if 56 != capacity.Limit {
+		t.Fatalf("unexpected limit %d", capacity.Limit)
+	}
+	if 6 != capacity.Available() {
+		t.Fatalf("unexpected available %d", capacity.Available())
+	}
+	if err := capacity.Admit("cores", 6); err != nil {
+		t.Fatalf("admit within limit fail: %s", err.Error())
+	}
+	if err := capacity.Admit("cores", 7); nil == err {
+		t.Fatal("admit exceeded limit unexpectedly")
+	}
+	//reserved more than host
+	capacity = newResourceCapacity(4, 8, 2, 0)
+	if 0 != capacity.Limit || nil == capacity.Admit("cores", 1) {
+		t.Fatalf("unexpected capacity %v", capacity)
+	}
+}
+
+func TestResourceCapacity_Unlimited(t *testing.T) {
+	//memory not limited unless ratio configured
+	var capacity = newResourceCapacity(8<<30, 0, 0, 16<<30)
+	if !capacity.Unlimited || 0 != capacity.Limit {
+		t.Fatalf("unexpected capacity %v", capacity)
+	}
+	if err := capacity.Admit("memory", 32<<30); err != nil {
+		t.Fatalf("admit unlimited resource fail: %s", err.Error())
+	}
+}
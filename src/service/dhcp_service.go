@@ -8,20 +8,75 @@ import (
 	"time"
 	"fmt"
 	"strings"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"golang.org/x/net/ipv4"
 )
 
 type clientLease struct {
-	IP      string
-	Netmask string
-	Gateway string
-	DNS     []string
-	Expire  time.Time
-	Options dhcp4.Options
+	IP       string        `json:"ip"`
+	Netmask  string        `json:"netmask"`
+	Gateway  string        `json:"gateway,omitempty"`
+	DNS      []string      `json:"dns,omitempty"`
+	Hostname string        `json:"hostname,omitempty"`
+	Instance string        `json:"instance,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Reserved bool          `json:"reserved,omitempty"`
+	VLAN     uint          `json:"vlan,omitempty"`
+	VNI      uint          `json:"vni,omitempty"`
+	Private  bool          `json:"private,omitempty"`
+	Expire   time.Time     `json:"expire"`
+	Options  dhcp4.Options `json:"-"`
+}
+
+// DHCPReservation : fixed address for MAC, options override settings of address pool when not empty
+type DHCPReservation struct {
+	HardwareAddress string   `json:"hardware_address"`
+	Address         string   `json:"address"` //CIDR
+	Gateway         string   `json:"gateway,omitempty"`
+	DNS             []string `json:"dns,omitempty"`
+	Hostname        string   `json:"hostname,omitempty"`
+	LeaseTime       uint     `json:"lease_time,omitempty"` //in seconds, default when zero
+	VLAN            uint     `json:"vlan,omitempty"`
+}
+
+// DHCPLease : lease allocated to client, address in CIDR
+type DHCPLease struct {
+	HardwareAddress string
+	Address         string
+	Gateway         string
+	DNS             []string
+	Hostname        string
+	Reserved        bool
+	VLAN            uint
+	VNI             uint
+	Private         bool
+	Expire          time.Time
 }
 
 type DHCPHandler struct {
-	operates chan dhcpOperate
-	serverIP net.IP
+	operates  chan dhcpOperate
+	serverIP  net.IP
+	privateIP net.IP //gateway of private network, nil when disabled
+	conn      *dhcpServeConn
+}
+
+// dhcpServeConn : accept requests from bridge, its VLAN sub-interfaces, overlay and private bridges only, reply on interface where request received
+type dhcpServeConn struct {
+	conn    *ipv4.PacketConn
+	bridge  string
+	ifIndex int
+	segment networkSegment
+}
+
+// networkSegment : where client attached, tagged VLAN of default bridge, overlay network or private network
+type networkSegment struct {
+	VLAN    uint
+	VNI     uint
+	Private bool
 }
 
 type operateType int
@@ -31,28 +86,55 @@ const (
 	opUpdate
 	opDeallocate
 	opChange
+	opQuery
+	opReserve
+	opUnreserve
+	opConflict
+	opProbed
 )
 
 type dhcpOperate struct {
-	Type     operateType
-	MAC      string
-	IP       string
-	Gateway  string
-	DNS      []string
-	RespChan chan operateResult
+	Type        operateType
+	MAC         string
+	IP          string
+	Segment     networkSegment
+	Gateway     string
+	DNS         []string
+	Options     DHCPOptions
+	Reservation DHCPReservation
+	Conflict    string //MAC of conflicting host
+	Probed      bool   //false when probe failed
+	RespChan    chan operateResult
+	ResultChan  chan DHCPResult
+	ErrorChan   chan error
 }
 
 type operateResult struct {
-	Error   error
-	IP      net.IP
-	Options dhcp4.Options
+	Error     error
+	IP        net.IP
+	LeaseTime time.Duration
+	Options   dhcp4.Options
+	Probe     bool //probe address before offer
+	Pending   bool //probe in progress, offer sent when finished
+}
+
+type dhcpDataConfig struct {
+	Leases       map[string]clientLease     `json:"leases,omitempty"`
+	Reservations map[string]DHCPReservation `json:"reservations,omitempty"`
 }
 
 type DHCPService struct {
 	dhcpConn      *net.UDPConn
 	handler       *DHCPHandler
 	operates      chan dhcpOperate
-	leases        map[string]clientLease //key = HW address
+	leases        map[string]clientLease     //key = HW address
+	reservations  map[string]DHCPReservation //key = HW address
+	pools         map[uint]AddressPool   //key = VLAN
+	overlayPools  map[uint]AddressPool   //key = VNI
+	quarantine    addressQuarantineList
+	probes        addressProbeCache
+	dataFile      string
+	eventChan     chan InstanceStatusChangedEvent
 	networkModule NetworkModule
 	runner        *framework.SimpleRunner
 }
@@ -61,11 +143,18 @@ const (
 	leaseTimeout    = 12 * time.Hour
 	confirmDuration = 3 * time.Minute
 	checkInterval   = 1 * time.Minute
+	dhcpClientPort  = 68
+	//default MTU of guest in overlay network, ethernet MTU minus VXLAN encapsulation
+	overlayMTU      = 1500 - vxlanOverhead
 )
 
-func CreateDHCPService(netModule *NetworkManager) (service* DHCPService, err error) {
+//server identifier in overlay network, address of overlay bridge
+var overlayServerIP = net.ParseIP(MetadataAddress).To4()
+
+func CreateDHCPService(netModule *NetworkManager, dataPath string, eventChan chan InstanceStatusChangedEvent) (service* DHCPService, err error) {
 	const (
 		DHCPAddress = ":67"
+		DHCPFilename = "dhcp.data"
 	)
 	listenAddress, err := net.ResolveUDPAddr("udp", DHCPAddress)
 	if err != nil{
@@ -83,19 +172,65 @@ func CreateDHCPService(netModule *NetworkManager) (service* DHCPService, err err
 	service.operates = make(chan dhcpOperate, 1 << 10)
 	service.networkModule = netModule
 	service.leases = map[string]clientLease{}
+	service.reservations = map[string]DHCPReservation{}
+	service.quarantine = addressQuarantineList{}
+	service.probes = addressProbeCache{}
+	service.eventChan = eventChan
+	service.pools = map[uint]AddressPool{
+		DefaultVLAN: {Gateway: netModule.DHCPGateway, DNS: netModule.DHCPDNS, Options: netModule.DHCPOptions},
+	}
+	for vlan, pool := range netModule.vlanPools{
+		service.pools[vlan] = pool
+	}
+	service.overlayPools = map[uint]AddressPool{}
+	for vni, overlay := range netModule.overlays{
+		service.overlayPools[vni] = overlay.Pool
+	}
+	service.dataFile = filepath.Join(dataPath, DHCPFilename)
+	if err = service.loadData(); err != nil{
+		err = fmt.Errorf("load DHCP data fail: %s", err.Error())
+		return
+	}
 	netModule.OnAddressUpdated = service.updateServer
-	service.handler = &DHCPHandler{service.operates, serverIP}
+	netModule.OnOverlayUpdated = service.updateOverlay
 	service.dhcpConn, err = net.ListenUDP("udp", listenAddress)
 	if err != nil{
 		err = fmt.Errorf("listen on DHCP port fail, please disable dnsmasq or other DHCP service.\nmessage: %s", err.Error())
 		return
 	}
+	var packetConn = ipv4.NewPacketConn(service.dhcpConn)
+	if err = packetConn.SetControlMessage(ipv4.FlagInterface, true); err != nil{
+		err = fmt.Errorf("enable control message fail: %s", err.Error())
+		return
+	}
+	//server identifier of bridge used for all VLANs, metadata address for overlay networks, gateway for private network
+	service.handler = &DHCPHandler{operates: service.operates, serverIP: serverIP, privateIP: netModule.privateGateway,
+		conn: &dhcpServeConn{conn: packetConn, bridge: netModule.GetBridgeName()}}
 	service.runner = framework.CreateSimpleRunner(service.Routine)
 	return
 }
 
-func (service *DHCPService) updateServer(gateway string, dns []string){
-	service.operates <- dhcpOperate{Type:opChange, Gateway:gateway, DNS:dns}
+func (service *DHCPService) updateServer(vlan uint, gateway string, dns []string, options DHCPOptions){
+	service.operates <- dhcpOperate{Type:opChange, Segment:networkSegment{VLAN:vlan}, Gateway:gateway, DNS:dns, Options:options}
+}
+
+func (service *DHCPService) updateOverlay(vni uint, gateway string, dns []string, options DHCPOptions){
+	service.operates <- dhcpOperate{Type:opChange, Segment:networkSegment{VNI:vni}, Gateway:gateway, DNS:dns, Options:options}
+}
+
+// QueryLeases : all leases and reservations, sorted by HW address
+func (service *DHCPService) QueryLeases(respChan chan DHCPResult){
+	service.operates <- dhcpOperate{Type:opQuery, ResultChan:respChan}
+}
+
+// AddReservation : replace existing reservation of same MAC, current lease released.
+// Fail when MAC belongs to guest, or address reserved, leased or assigned to guest in same VLAN
+func (service *DHCPService) AddReservation(reservation DHCPReservation, respChan chan error){
+	service.operates <- dhcpOperate{Type:opReserve, Reservation:reservation, ErrorChan:respChan}
+}
+
+func (service *DHCPService) RemoveReservation(hwaddress string, respChan chan error){
+	service.operates <- dhcpOperate{Type:opUnreserve, MAC:hwaddress, ErrorChan:respChan}
 }
 
 func (service *DHCPService) Start() error{
@@ -109,7 +244,7 @@ func (service *DHCPService) Stop() error{
 func (service *DHCPService) Routine(c framework.RoutineController)  {
 	log.Println("<dhcp> service started")
 	go func() {
-		var err = dhcp4.Serve(service.dhcpConn, service.handler)
+		var err = dhcp4.Serve(service.handler.conn, service.handler)
 		log.Printf("<dhcp> handler stopped: %s", err.Error())
 	}()
 	var checkTicker = time.NewTicker(checkInterval)
@@ -140,6 +275,303 @@ func (service *DHCPService) clearExpiredLeases(){
 		log.Printf("<dhcp> release expired lease for MAC '%s'", mac)
 		delete(service.leases, mac)
 	}
+	if 0 != len(expired){
+		service.saveData()
+	}
+	service.probes.Purge()
+}
+
+func (service *DHCPService) saveData(){
+	var config = dhcpDataConfig{Leases: service.leases, Reservations: service.reservations}
+	data, err := json.MarshalIndent(config, "", " ")
+	if err != nil{
+		log.Printf("<dhcp> marshal data fail: %s", err.Error())
+		return
+	}
+	if err = writeFileAtomically(service.dataFile, data, ConfigFilePerm); err != nil{
+		log.Printf("<dhcp> save data to '%s' fail: %s", service.dataFile, err.Error())
+	}
+}
+
+//loadData : expired or invalid leases discarded
+func (service *DHCPService) loadData() (err error){
+	if _, err = os.Stat(service.dataFile); os.IsNotExist(err){
+		log.Println("<dhcp> no data available")
+		return nil
+	}
+	data, err := os.ReadFile(service.dataFile)
+	if err != nil{
+		return
+	}
+	var config dhcpDataConfig
+	if err = json.Unmarshal(data, &config); err != nil{
+		return
+	}
+	var now = time.Now()
+	for mac, lease := range config.Leases{
+		if lease.Expire.Before(now){
+			continue
+		}
+		if err = lease.buildOptions(service.poolOf(lease.segment()).Options); err != nil{
+			log.Printf("<dhcp> warning: discard lease of MAC '%s': %s", mac, err.Error())
+			continue
+		}
+		service.leases[mac] = lease
+	}
+	for mac, reservation := range config.Reservations{
+		service.reservations[mac] = reservation
+	}
+	log.Printf("<dhcp> %d lease(s), %d reservation(s) loaded from '%s'",
+		len(service.leases), len(service.reservations), service.dataFile)
+	return nil
+}
+
+//buildOptions : settings of address pool appended
+func (lease *clientLease) buildOptions(pool DHCPOptions) (err error){
+	var netmask = net.ParseIP(lease.Netmask).To4()
+	if netmask == nil{
+		err = fmt.Errorf("invalid netmask '%s'", lease.Netmask)
+		return
+	}
+	lease.Options = dhcp4.Options{
+		dhcp4.OptionSubnetMask: []byte(netmask),
+	}
+	if "" != lease.Gateway{
+		gatewayIP, err := stringToIPv4(lease.Gateway)
+		if err != nil{
+			return fmt.Errorf("parse gateway fail: %s", err.Error())
+		}
+		lease.Options[dhcp4.OptionRouter] = gatewayIP
+	}
+	var dnsBytes []byte
+	for _, dns := range lease.DNS{
+		ip, err := stringToIPv4(dns)
+		if err != nil{
+			return fmt.Errorf("parse DNS fail: %s", err.Error())
+		}
+		dnsBytes = append(dnsBytes, ip...)
+	}
+	if 0 != len(dnsBytes){
+		lease.Options[dhcp4.OptionDomainNameServer] = dnsBytes
+	}
+	if "" != lease.Hostname{
+		lease.Options[dhcp4.OptionHostName] = []byte(lease.Hostname)
+	}
+	if "" != pool.Domain{
+		lease.Options[dhcp4.OptionDomainName] = []byte(strings.TrimSuffix(pool.Domain, "."))
+	}
+	if 0 != len(pool.Search){
+		lease.Options[dhcp4.OptionDomainSearch] = encodeDomainSearch(pool.Search)
+	}
+	var ntpBytes []byte
+	for _, server := range pool.NTP{
+		ip, err := stringToIPv4(server)
+		if err != nil{
+			return fmt.Errorf("parse NTP server fail: %s", err.Error())
+		}
+		ntpBytes = append(ntpBytes, ip...)
+	}
+	if 0 != len(ntpBytes){
+		lease.Options[dhcp4.OptionNetworkTimeProtocolServers] = ntpBytes
+	}
+	if 0 != pool.MTU{
+		lease.Options[dhcp4.OptionInterfaceMTU] = []byte{byte(pool.MTU >> 8), byte(pool.MTU)}
+	}
+	if 0 != len(pool.Routes){
+		//client ignores router option when classless routes present, RFC 3442
+		var routes = pool.Routes
+		if "" != lease.Gateway{
+			routes = append([]StaticRoute{{Destination: "0.0.0.0/0", Gateway: lease.Gateway}}, routes...)
+		}
+		if lease.Options[dhcp4.OptionClasslessRouteFormat], err = encodeClasslessRoutes(routes); err != nil{
+			return
+		}
+	}
+	return nil
+}
+
+//encodeDomainSearch : RFC 3397, without compression
+func encodeDomainSearch(domains []string) (data []byte){
+	for _, domain := range domains{
+		for _, label := range strings.Split(strings.TrimSuffix(domain, "."), "."){
+			data = append(data, byte(len(label)))
+			data = append(data, label...)
+		}
+		data = append(data, 0)
+	}
+	return data
+}
+
+//encodeClasslessRoutes : RFC 3442, significant octets of destination only
+func encodeClasslessRoutes(routes []StaticRoute) (data []byte, err error){
+	for _, route := range routes{
+		_, destination, err := net.ParseCIDR(route.Destination)
+		if err != nil{
+			return nil, fmt.Errorf("parse route destination fail: %s", err.Error())
+		}
+		var network = destination.IP.To4()
+		if network == nil{
+			return nil, fmt.Errorf("invalid IPv4 route destination '%s'", route.Destination)
+		}
+		gateway, err := stringToIPv4(route.Gateway)
+		if err != nil{
+			return nil, fmt.Errorf("parse route gateway fail: %s", err.Error())
+		}
+		var width, _ = destination.Mask.Size()
+		data = append(data, byte(width))
+		data = append(data, network[:(width + 7) / 8]...)
+		data = append(data, gateway...)
+	}
+	return data, nil
+}
+
+//newLease : address assigned by Core for guest, reservation for other hosts only
+func (service *DHCPService) newLease(macAddress string) (lease clientLease, err error){
+	var respChan = make(chan NetworkResult, 1)
+	service.networkModule.GetAddressByHWAddress(macAddress, respChan)
+	var result = <- respChan
+	reservation, reserved := service.reservations[macAddress]
+	if reserved && nil == result.Error{
+		log.Printf("<dhcp> warning: reservation of MAC '%s' ignored, address assigned to instance '%s'", macAddress, result.Instance)
+		reserved = false
+	}
+	if reserved{
+		lease.Reserved = true
+		lease.VLAN = reservation.VLAN
+		lease.Hostname = reservation.Hostname
+		lease.Duration = service.leaseDuration(&reservation, lease.segment())
+		lease.Gateway, lease.DNS = service.reservationServers(reservation)
+		if lease.IP, lease.Netmask, err = splitIPv4CIDR(reservation.Address); err != nil{
+			return
+		}
+		//guest created with same MAC or address after reserved
+		var checkChan = make(chan error, 1)
+		service.networkModule.CheckReservation(macAddress, reservation.Address, reservation.VLAN, checkChan)
+		if err = <- checkChan; err != nil{
+			err = fmt.Errorf("reservation of MAC '%s' unavailable: %s", macAddress, err.Error())
+			return
+		}
+	}else{
+		if result.Error != nil{
+			err = result.Error
+			return
+		}
+		if "" == result.Internal{
+			//IPv6 only, served by DHCPv6
+			err = fmt.Errorf("no IPv4 address assigned for MAC '%s'", macAddress)
+			return
+		}
+		lease.Instance = result.Instance
+		lease.VLAN = result.VLAN
+		lease.VNI = result.VNI
+		lease.Private = result.Private
+		lease.Gateway = result.Gateway
+		lease.DNS = result.DNS
+		lease.Duration = service.leaseDuration(nil, lease.segment())
+		if lease.IP, lease.Netmask, err = splitIPv4CIDR(result.Internal); err != nil{
+			return
+		}
+	}
+	err = lease.buildOptions(service.poolOf(lease.segment()).Options)
+	return
+}
+
+func (lease *clientLease) segment() networkSegment{
+	return networkSegment{VLAN: lease.VLAN, VNI: lease.VNI, Private: lease.Private}
+}
+
+//poolOf : MTU and route to metadata service appended for overlay, default options for private network
+func (service *DHCPService) poolOf(segment networkSegment) AddressPool{
+	if segment.Private{
+		return AddressPool{}
+	}
+	if 0 == segment.VNI{
+		return service.pools[segment.VLAN]
+	}
+	var pool = service.overlayPools[segment.VNI]
+	if 0 == pool.Options.MTU{
+		pool.Options.MTU = overlayMTU
+	}
+	//on-link
+	var metadataRoute = StaticRoute{Destination: MetadataAddress + "/32", Gateway: net.IPv4zero.String()}
+	pool.Options.Routes = append([]StaticRoute{metadataRoute}, pool.Options.Routes...)
+	return pool
+}
+
+func (segment networkSegment) String() string{
+	if segment.Private{
+		return "private network"
+	}
+	if 0 != segment.VNI{
+		return fmt.Sprintf("overlay network %d", segment.VNI)
+	}
+	return fmt.Sprintf("VLAN %d", segment.VLAN)
+}
+
+//leaseDuration : lease time of reservation > address pool of segment > default
+func (service *DHCPService) leaseDuration(reservation *DHCPReservation, segment networkSegment) time.Duration{
+	if nil != reservation && 0 != reservation.LeaseTime{
+		return time.Duration(reservation.LeaseTime) * time.Second
+	}
+	if pool := service.poolOf(segment); 0 != pool.Options.LeaseTime{
+		return time.Duration(pool.Options.LeaseTime) * time.Second
+	}
+	return leaseTimeout
+}
+
+//reservationServers : use servers of pool in same VLAN when not specified in reservation
+func (service *DHCPService) reservationServers(reservation DHCPReservation) (gateway string, dns []string){
+	var pool = service.pools[reservation.VLAN]
+	gateway = pool.Gateway
+	dns = pool.DNS
+	if "" != reservation.Gateway{
+		gateway = reservation.Gateway
+	}
+	if 0 != len(reservation.DNS){
+		dns = reservation.DNS
+	}
+	return
+}
+
+func splitIPv4CIDR(address string) (ip, netmask string, err error){
+	clientIP, clientNet, err := net.ParseCIDR(address)
+	if err != nil{
+		err = fmt.Errorf("parse address '%s' fail: %s", address, err.Error())
+		return
+	}
+	if clientIP.To4() == nil || net.IPv4len != len(clientNet.Mask){
+		err = fmt.Errorf("invalid IPv4 address '%s'", address)
+		return
+	}
+	var mask = clientNet.Mask
+	return clientIP.String(), net.IPv4(mask[0], mask[1], mask[2], mask[3]).String(), nil
+}
+
+func validateReservation(reservation *DHCPReservation) (err error){
+	hwaddress, err := net.ParseMAC(reservation.HardwareAddress)
+	if err != nil{
+		err = fmt.Errorf("invalid MAC '%s'", reservation.HardwareAddress)
+		return
+	}
+	reservation.HardwareAddress = hwaddress.String()
+	if _, _, err = splitIPv4CIDR(reservation.Address); err != nil{
+		return
+	}
+	if !IsValidVLAN(reservation.VLAN){
+		err = fmt.Errorf("invalid VLAN %d", reservation.VLAN)
+		return
+	}
+	if "" != reservation.Gateway && !isValidIPv4(reservation.Gateway){
+		err = fmt.Errorf("invalid gateway '%s'", reservation.Gateway)
+		return
+	}
+	for _, server := range reservation.DNS{
+		if !isValidIPv4(server){
+			err = fmt.Errorf("invalid DNS server '%s'", server)
+			return
+		}
+	}
+	return nil
 }
 
 func (service *DHCPService)handleOperate(op dhcpOperate){
@@ -151,54 +583,38 @@ func (service *DHCPService)handleOperate(op dhcpOperate){
 			delete(service.leases, macAddress)
 			log.Printf("<dhcp> warning: previuos lease for MAC '%s' released", macAddress)
 		}
-		var respChan = make(chan NetworkResult, 1)
-		service.networkModule.GetAddressByHWAddress(macAddress, respChan)
-		var result = <- respChan
-		if result.Error != nil{
-			err = result.Error
+		newLease, err := service.newLease(macAddress)
+		if err != nil{
 			op.RespChan <- operateResult{Error:err}
 			return
 		}
-		var newLease = clientLease{}
-		clientIP, clientNet, err := net.ParseCIDR(result.Internal)
-		if err != nil{
-			err = result.Error
+		if newLease.segment() != op.Segment{
+			err = fmt.Errorf("MAC '%s' belongs to %s, but request received from %s", macAddress,
+				newLease.segment().String(), op.Segment.String())
 			op.RespChan <- operateResult{Error:err}
 			return
 		}
-		newLease.IP = clientIP.String()
-		newLease.Gateway = result.Gateway
-		newLease.DNS = result.DNS
-		//must confirm in duration
-		newLease.Expire = time.Now().Add(confirmDuration)
-		var netMask = clientNet.Mask
-		newLease.Netmask = net.IPv4(netMask[0], netMask[1], netMask[2], netMask[3]).String()
-		gatewayIP, err := stringToIPv4(result.Gateway)
-		if err != nil{
-			err = fmt.Errorf("parse gateway fail: %s", err.Error())
+		if expire, quarantined := service.quarantine.IsQuarantined(newLease.IP); quarantined{
+			err = fmt.Errorf("address %s quarantined until '%s'", newLease.IP, expire.Format(TimeFormatLayout))
 			op.RespChan <- operateResult{Error:err}
 			return
 		}
-		var dnsBytes []byte
-		for _, dns := range result.DNS{
-			ip, err := stringToIPv4(dns)
-			if err != nil{
-				err = fmt.Errorf("parse DNS fail: %s", err.Error())
-				op.RespChan <- operateResult{Error:err}
-				return
-			}
-			dnsBytes = append(dnsBytes, ip...)
-		}
-		newLease.Options = dhcp4.Options{
-			dhcp4.OptionSubnetMask: netMask,
-			dhcp4.OptionRouter:     gatewayIP,
-			dhcp4.OptionDomainNameServer: dnsBytes,
-		}
+		//must confirm in duration
+		newLease.Expire = time.Now().Add(confirmDuration)
 		service.leases[macAddress] = newLease
-		log.Printf("<dhcp> allocate new lease for MAC '%s', address %s/%s, gateway %s, dns %s, expire '%s'",
-			macAddress, newLease.IP, newLease.Netmask, newLease.Gateway, strings.Join(newLease.DNS, "/"),
-			newLease.Expire.Format(TimeFormatLayout))
-		op.RespChan <- operateResult{IP: clientIP, Options: newLease.Options}
+		log.Printf("<dhcp> allocate new lease for MAC '%s' in %s, address %s/%s, gateway %s, dns %s, reserved %t, expire '%s'",
+			macAddress, newLease.segment().String(), newLease.IP, newLease.Netmask, newLease.Gateway, strings.Join(newLease.DNS, "/"),
+			newLease.Reserved, newLease.Expire.Format(TimeFormatLayout))
+		var result = operateResult{IP: net.ParseIP(newLease.IP).To4(), LeaseTime: newLease.Duration, Options: newLease.Options}
+		switch service.probes.State(newLease.IP) {
+		case probeNone:
+			service.probes.SetPending(newLease.IP)
+			result.Probe = true
+		case probePending:
+			//retransmitted by client
+			result.Pending = true
+		}
+		op.RespChan <- result
 		return
 
 	case opUpdate:
@@ -206,6 +622,12 @@ func (service *DHCPService)handleOperate(op dhcpOperate){
 		var macAddress = op.MAC
 		var requestIP = op.IP
 		if lease, exists := service.leases[macAddress]; exists{
+			if op.Segment != lease.segment(){
+				err = fmt.Errorf("request of MAC '%s' received from %s, but leased in %s",
+					macAddress, op.Segment.String(), lease.segment().String())
+				op.RespChan <- operateResult{Error:err}
+				return
+			}
 			if requestIP != lease.IP{
 				err = fmt.Errorf("request IP '%s' diffrent from allocated '%s' for MAC '%s'",
 					requestIP, lease.IP, macAddress)
@@ -219,10 +641,11 @@ func (service *DHCPService)handleOperate(op dhcpOperate){
 				return
 			}
 			//update
-			lease.Expire = now.Add(leaseTimeout)
+			lease.Expire = now.Add(lease.Duration)
 			service.leases[macAddress] = lease
 			log.Printf("<dhcp> lease of MAC '%s' updated for request", macAddress)
-			op.RespChan <- operateResult{Options: lease.Options}
+			op.RespChan <- operateResult{LeaseTime: lease.Duration, Options: lease.Options}
+			service.saveData()
 			return
 		}else{
 			err = fmt.Errorf("no lease for MAC '%s'", macAddress)
@@ -234,36 +657,155 @@ func (service *DHCPService)handleOperate(op dhcpOperate){
 		if _, exists := service.leases[macAddress]; exists{
 			log.Printf("<dhcp> lease of MAC '%s' released", macAddress)
 			delete(service.leases, macAddress)
+			service.saveData()
 		}else{
 			log.Printf("<dhcp> warning: no lease for MAC '%s' could release", macAddress)
 		}
 		return
 	case opChange:
-		gatewayIP, err := stringToIPv4(op.Gateway)
-		if err != nil{
-			log.Printf("<dhcp> parse gateway fail when update servers: %s", err.Error())
+		if 0 != op.Segment.VNI{
+			service.overlayPools[op.Segment.VNI] = AddressPool{Gateway: op.Gateway, DNS: op.DNS, Options: op.Options}
+		}else{
+			service.pools[op.Segment.VLAN] = AddressPool{Gateway: op.Gateway, DNS: op.DNS, Options: op.Options}
+		}
+		for mac, lease := range service.leases{
+			if lease.segment() != op.Segment{
+				continue
+			}
+			if reservation, reserved := service.reservations[mac]; reserved && lease.Reserved{
+				lease.Gateway, lease.DNS = service.reservationServers(reservation)
+				lease.Duration = service.leaseDuration(&reservation, op.Segment)
+			}else{
+				lease.Gateway, lease.DNS = op.Gateway, op.DNS
+				lease.Duration = service.leaseDuration(nil, op.Segment)
+			}
+			if err = lease.buildOptions(service.poolOf(op.Segment).Options); err != nil{
+				log.Printf("<dhcp> update servers of lease for '%s' fail: %s", mac, err.Error())
+				continue
+			}
+			service.leases[mac] = lease
+			log.Printf("<dhcp> servers of lease for '%s' changed", mac)
+		}
+		service.saveData()
+	case opQuery:
+		var result DHCPResult
+		for mac, lease := range service.leases{
+			var ones, _ = net.IPMask(net.ParseIP(lease.Netmask).To4()).Size()
+			result.Leases = append(result.Leases, DHCPLease{
+				HardwareAddress: mac,
+				Address:         fmt.Sprintf("%s/%d", lease.IP, ones),
+				Gateway:         lease.Gateway,
+				DNS:             lease.DNS,
+				Hostname:        lease.Hostname,
+				Reserved:        lease.Reserved,
+				VLAN:            lease.VLAN,
+				VNI:             lease.VNI,
+				Private:         lease.Private,
+				Expire:          lease.Expire,
+			})
+		}
+		for _, reservation := range service.reservations{
+			result.Reservations = append(result.Reservations, reservation)
+		}
+		sort.Slice(result.Leases, func(i, j int) bool {
+			return result.Leases[i].HardwareAddress < result.Leases[j].HardwareAddress
+		})
+		sort.Slice(result.Reservations, func(i, j int) bool {
+			return result.Reservations[i].HardwareAddress < result.Reservations[j].HardwareAddress
+		})
+		op.ResultChan <- result
+	case opReserve:
+		var reservation = op.Reservation
+		if err = validateReservation(&reservation); err != nil{
+			op.ErrorChan <- err
 			return
 		}
-		var dnsBytes []byte
-		for _, dns := range op.DNS{
-			ip, err := stringToIPv4(dns)
-			if err != nil{
-				log.Printf("<dhcp> parse DNS fail when update servers: %s", err.Error())
+		var reservedIP, _, _ = net.ParseCIDR(reservation.Address)
+		for mac, current := range service.reservations{
+			if currentIP, _, _ := net.ParseCIDR(current.Address); mac != reservation.HardwareAddress && currentIP.Equal(reservedIP){
+				err = fmt.Errorf("address '%s' already reserved for MAC '%s'", reservedIP.String(), mac)
+				op.ErrorChan <- err
 				return
 			}
-			dnsBytes = append(dnsBytes, ip...)
 		}
+		var segment = networkSegment{VLAN: reservation.VLAN}
 		for mac, lease := range service.leases{
-			lease.Options[dhcp4.OptionRouter] = gatewayIP
-			lease.Options[dhcp4.OptionDomainNameServer] = dnsBytes
-			service.leases[mac] = lease
-			log.Printf("<dhcp> servers of lease for '%s' changed", mac)
+			if mac != reservation.HardwareAddress && lease.segment() == segment && reservedIP.Equal(net.ParseIP(lease.IP)){
+				err = fmt.Errorf("address '%s' leased to MAC '%s'", reservedIP.String(), mac)
+				op.ErrorChan <- err
+				return
+			}
+		}
+		//guest addresses allocated by Core
+		var checkChan = make(chan error, 1)
+		service.networkModule.CheckReservation(reservation.HardwareAddress, reservation.Address, reservation.VLAN, checkChan)
+		if err = <- checkChan; err != nil{
+			op.ErrorChan <- fmt.Errorf("reservation conflict: %s", err.Error())
+			return
+		}
+		service.reservations[reservation.HardwareAddress] = reservation
+		if _, exists := service.leases[reservation.HardwareAddress]; exists{
+			//renew with reserved address
+			delete(service.leases, reservation.HardwareAddress)
+		}
+		log.Printf("<dhcp> address %s reserved for MAC '%s'", reservation.Address, reservation.HardwareAddress)
+		op.ErrorChan <- nil
+		service.saveData()
+	case opUnreserve:
+		var macAddress = op.MAC
+		if hwaddress, err := net.ParseMAC(macAddress); err == nil{
+			macAddress = hwaddress.String()
+		}
+		if _, exists := service.reservations[macAddress]; !exists{
+			err = fmt.Errorf("no reservation for MAC '%s'", macAddress)
+			op.ErrorChan <- err
+			return
+		}
+		delete(service.reservations, macAddress)
+		if lease, exists := service.leases[macAddress]; exists && lease.Reserved{
+			delete(service.leases, macAddress)
+		}
+		log.Printf("<dhcp> reservation of MAC '%s' removed", macAddress)
+		op.ErrorChan <- nil
+		service.saveData()
+	case opConflict:
+		service.handleConflict(op.MAC, op.IP, op.Conflict)
+	case opProbed:
+		if !op.Probed{
+			//probe again when client retransmits
+			service.probes.Remove(op.IP)
+		}else if "" != op.Conflict{
+			service.handleConflict(op.MAC, op.IP, op.Conflict)
+		}else{
+			service.probes.SetClean(op.IP)
 		}
 	default:
 		log.Printf("<dhcp> ignore invalid op type %d", op.Type)
 	}
 }
 
+//handleConflict : lease released and address quarantined, address of current lease used when not specified
+func (service *DHCPService) handleConflict(macAddress, address, conflict string){
+	var instanceID string
+	if lease, exists := service.leases[macAddress]; exists{
+		instanceID = lease.Instance
+		if "" == address{
+			address = lease.IP
+		}
+		delete(service.leases, macAddress)
+		service.saveData()
+	}
+	if "" == address{
+		log.Printf("<dhcp> warning: ignore conflict without address from MAC '%s'", macAddress)
+		return
+	}
+	service.probes.Remove(address)
+	var expire = service.quarantine.Add(address)
+	log.Printf("<dhcp> address %s of MAC '%s' conflict with host '%s', quarantined until '%s'",
+		address, macAddress, conflict, expire.Format(TimeFormatLayout))
+	service.eventChan <- newAddressConflictEvent(instanceID, address, conflict)
+}
+
 func (handler *DHCPHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) (packet dhcp4.Packet){
 
 	var err error
@@ -271,21 +813,30 @@ func (handler *DHCPHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageTyp
 	case dhcp4.Discover:
 		var macAddress = req.CHAddr().String()
 		var respChan = make(chan operateResult, 1)
-		handler.operates <- dhcpOperate{Type:opAllocate, MAC:macAddress, RespChan:respChan}
+		handler.operates <- dhcpOperate{Type:opAllocate, MAC:macAddress, Segment:handler.conn.segment, RespChan:respChan}
 		var result = <- respChan
 		if result.Error != nil{
 			err = result.Error
 			log.Printf("<dhcp> allocate lease for MAC '%s' fail: %s", macAddress, err.Error())
 			return
 		}
-		return dhcp4.ReplyPacket(req, dhcp4.Offer, handler.serverIP, result.IP, leaseTimeout,
+		if result.Pending{
+			return
+		}
+		var offer = dhcp4.ReplyPacket(req, dhcp4.Offer, handler.currentServer(), result.IP, result.LeaseTime,
 			result.Options.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList]))
+		if !result.Probe{
+			return offer
+		}
+		//probe outside serve loop, offer after probe finished
+		go handler.probeBeforeOffer(offer, result.IP, req.CHAddr(), handler.conn.ifIndex)
+		return
 
 	case dhcp4.Request:
 		if requestServer, exists := options[dhcp4.OptionServerIdentifier]; exists{
 			//check request server
 			var serverIP = net.IP(requestServer)
-			if !serverIP.Equal(handler.serverIP){
+			if !serverIP.Equal(handler.currentServer()){
 				//log.Printf("<dhcp> ignore request for different server '%s'", serverIP.String())
 				return
 			}
@@ -296,25 +847,142 @@ func (handler *DHCPHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageTyp
 		}
 		var macAddress = req.CHAddr().String()
 		var respChan = make(chan operateResult, 1)
-		handler.operates <- dhcpOperate{Type:opUpdate, MAC:macAddress, IP: requestIP.String(), RespChan:respChan}
+		handler.operates <- dhcpOperate{Type:opUpdate, MAC:macAddress, IP: requestIP.String(), Segment:handler.conn.segment,
+			RespChan:respChan}
 		var result = <- respChan
 		if result.Error != nil{
 			err = result.Error
 			log.Printf("<dhcp> update lease for MAC '%s' fail: %s", macAddress, err.Error())
-			return dhcp4.ReplyPacket(req, dhcp4.NAK, handler.serverIP, nil, 0, nil)
+			return dhcp4.ReplyPacket(req, dhcp4.NAK, handler.currentServer(), nil, 0, nil)
 		}
-		return dhcp4.ReplyPacket(req, dhcp4.ACK, handler.serverIP, requestIP, leaseTimeout,
+		return dhcp4.ReplyPacket(req, dhcp4.ACK, handler.currentServer(), requestIP, result.LeaseTime,
 			result.Options.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList]))
-	case dhcp4.Release, dhcp4.Decline:
+	case dhcp4.Release:
 		var macAddress = req.CHAddr().String()
 		handler.operates <- dhcpOperate{Type:opDeallocate, MAC:macAddress}
 		return
+	case dhcp4.Decline:
+		//address in use detected by client
+		var macAddress = req.CHAddr().String()
+		var declined = net.IP(options[dhcp4.OptionRequestedIPAddress]).To4()
+		var op = dhcpOperate{Type:opConflict, MAC:macAddress}
+		if nil == declined{
+			handler.operates <- op
+			return
+		}
+		op.IP = declined.String()
+		//identify conflicting host outside serve loop
+		go func(ifIndex int, owner net.HardwareAddr) {
+			if conflicted, hwaddress, err := handler.probeAddress(ifIndex, declined, owner); err != nil{
+				log.Printf("<dhcp> warning: probe declined address %s fail: %s", op.IP, err.Error())
+			}else if conflicted{
+				op.Conflict = hwaddress.String()
+			}
+			handler.operates <- op
+		}(handler.conn.ifIndex, req.CHAddr())
+		return
 	default:
 		//log.Printf("<dhcp> ignore message type %d from %s", msgType, req.CHAddr().String())
 	}
 	return
 }
 
+//currentServer : server identifier of network where current request received
+func (handler *DHCPHandler) currentServer() net.IP{
+	if handler.conn.segment.Private{
+		return handler.privateIP
+	}
+	if 0 != handler.conn.segment.VNI{
+		return overlayServerIP
+	}
+	return handler.serverIP
+}
+
+//probeAddress : probe on interface where request received
+func (handler *DHCPHandler) probeAddress(ifIndex int, target net.IP, owner net.HardwareAddr) (conflicted bool, hwaddress net.HardwareAddr, err error){
+	device, err := net.InterfaceByIndex(ifIndex)
+	if err != nil{
+		err = fmt.Errorf("get interface %d fail: %s", ifIndex, err.Error())
+		return
+	}
+	return ProbeIPv4Address(device, target, owner, addressProbeTimeout)
+}
+
+//probeBeforeOffer : offer broadcast when no conflict found, or probe failed
+func (handler *DHCPHandler) probeBeforeOffer(offer dhcp4.Packet, target net.IP, owner net.HardwareAddr, ifIndex int){
+	var op = dhcpOperate{Type:opProbed, MAC:owner.String(), IP:target.String(), Probed:true}
+	conflicted, hwaddress, err := handler.probeAddress(ifIndex, target, owner)
+	if err != nil{
+		log.Printf("<dhcp> warning: probe address %s for MAC '%s' fail: %s", op.IP, op.MAC, err.Error())
+		op.Probed = false
+	}else if conflicted{
+		op.Conflict = hwaddress.String()
+	}
+	handler.operates <- op
+	if "" != op.Conflict{
+		return
+	}
+	if err = handler.conn.broadcast(offer, ifIndex); err != nil{
+		log.Printf("<dhcp> send offer to MAC '%s' fail: %s", op.MAC, err.Error())
+	}
+}
+
+func (c *dhcpServeConn) ReadFrom(b []byte) (n int, addr net.Addr, err error){
+	for {
+		var cm *ipv4.ControlMessage
+		if n, cm, addr, err = c.conn.ReadFrom(b); err != nil{
+			return
+		}
+		if nil == cm{
+			continue
+		}
+		if segment, matched := c.interfaceSegment(cm.IfIndex); matched{
+			c.ifIndex = cm.IfIndex
+			c.segment = segment
+			return
+		}
+	}
+}
+
+func (c *dhcpServeConn) WriteTo(b []byte, addr net.Addr) (n int, err error){
+	return c.conn.WriteTo(b, &ipv4.ControlMessage{IfIndex: c.ifIndex}, addr)
+}
+
+//broadcast : reply to client without address, outside serve loop
+func (c *dhcpServeConn) broadcast(b []byte, ifIndex int) (err error){
+	_, err = c.conn.WriteTo(b, &ipv4.ControlMessage{IfIndex: ifIndex}, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpClientPort})
+	return
+}
+
+//interfaceSegment : VLAN of sub-interface named as "<bridge>.<vlan>", untagged for bridge itself, VNI of overlay bridge
+func (c *dhcpServeConn) interfaceSegment(index int) (segment networkSegment, matched bool){
+	device, err := net.InterfaceByIndex(index)
+	if err != nil{
+		return
+	}
+	if c.bridge == device.Name{
+		return networkSegment{VLAN: DefaultVLAN}, true
+	}
+	if PrivateBridgeName == device.Name{
+		return networkSegment{Private: true}, true
+	}
+	if strings.HasPrefix(device.Name, overlayBridgeTag){
+		value, err := strconv.ParseUint(strings.TrimPrefix(device.Name, overlayBridgeTag), 10, 32)
+		if err != nil || !IsValidVNI(uint(value)){
+			return
+		}
+		return networkSegment{VNI: uint(value)}, true
+	}
+	var prefix = c.bridge + "."
+	if !strings.HasPrefix(device.Name, prefix){
+		return
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(device.Name, prefix), 10, 16)
+	if err != nil || !IsValidVLAN(uint(value)){
+		return
+	}
+	return networkSegment{VLAN: uint(value)}, true
+}
 
 func stringToIPv4(value string) (ip net.IP, err error){
 	ip = net.ParseIP(value)
@@ -330,4 +998,3 @@ func stringToIPv4(value string) (ip net.IP, err error){
 	return ip, nil
 }
 
-
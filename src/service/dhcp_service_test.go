@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestValidateReservation(t *testing.T) {
+	var reservation = DHCPReservation{HardwareAddress: "52-54-00-12-34-56", Address: "192.168.1.10/24",
+		Gateway: "192.168.1.1", DNS: []string{"8.8.8.8"}}
+	if err := validateReservation(&reservation); err != nil {
+		t.Fatalf("validate reservation fail: %s", err.Error())
+	}
+	if "52:54:00:12:34:56" != reservation.HardwareAddress {
+		t.Fatalf("unexpected MAC '%s'", reservation.HardwareAddress)
+	}
+	var invalid = []DHCPReservation{
+		{HardwareAddress: "invalid", Address: "192.168.1.10/24"},
+		{HardwareAddress: "52:54:00:12:34:56", Address: "192.168.1.10"},
+		{HardwareAddress: "52:54:00:12:34:56", Address: "2001:db8::10/64"},
+		{HardwareAddress: "52:54:00:12:34:56", Address: "192.168.1.10/24", Gateway: "2001:db8::1"},
+		{HardwareAddress: "52:54:00:12:34:56", Address: "192.168.1.10/24", DNS: []string{"dns"}},
+	}
+	for _, current := range invalid {
+		if err := validateReservation(&current); nil == err {
+			t.Fatalf("invalid reservation %v accepted unexpectedly", current)
+		}
+	}
+}
+
+func TestLeaseOptions(t *testing.T) {
+	ip, netmask, err := splitIPv4CIDR("10.0.0.5/16")
+	if err != nil || "10.0.0.5" != ip || "255.255.0.0" != netmask {
+		t.Fatalf("unexpected address %s/%s: %v", ip, netmask, err)
+	}
+	var lease = clientLease{IP: ip, Netmask: netmask, Gateway: "10.0.0.1",
+		DNS: []string{"10.0.0.2", "10.0.0.3"}, Hostname: "guest"}
+	var pool = DHCPOptions{Domain: "example.com", Search: []string{"example.com", "lab"}, MTU: 1450,
+		Routes: []StaticRoute{{Destination: "172.16.0.0/12", Gateway: "10.0.0.254"}}}
+	if err = lease.buildOptions(pool); err != nil {
+		t.Fatalf("build options fail: %s", err.Error())
+	}
+	if !bytes.Equal(net.IP{255, 255, 0, 0}, lease.Options[dhcp4.OptionSubnetMask]) {
+		t.Fatalf("unexpected netmask %v", lease.Options[dhcp4.OptionSubnetMask])
+	}
+	if !bytes.Equal(net.IP{10, 0, 0, 2, 10, 0, 0, 3}, lease.Options[dhcp4.OptionDomainNameServer]) {
+		t.Fatalf("unexpected DNS %v", lease.Options[dhcp4.OptionDomainNameServer])
+	}
+	if "guest" != string(lease.Options[dhcp4.OptionHostName]) {
+		t.Fatalf("unexpected hostname %s", lease.Options[dhcp4.OptionHostName])
+	}
+	if !bytes.Equal([]byte{0x05, 0xAA}, lease.Options[dhcp4.OptionInterfaceMTU]) {
+		t.Fatalf("unexpected MTU %v", lease.Options[dhcp4.OptionInterfaceMTU])
+	}
+	var search = []byte("\x07example\x03com\x00\x03lab\x00")
+	if !bytes.Equal(search, lease.Options[dhcp4.OptionDomainSearch]) {
+		t.Fatalf("unexpected search list %v", lease.Options[dhcp4.OptionDomainSearch])
+	}
+	//default route via gateway first
+	var routes = []byte{0, 10, 0, 0, 1, 12, 172, 16, 10, 0, 0, 254}
+	if !bytes.Equal(routes, lease.Options[dhcp4.OptionClasslessRouteFormat]) {
+		t.Fatalf("unexpected routes %v", lease.Options[dhcp4.OptionClasslessRouteFormat])
+	}
+	lease.Gateway = "invalid"
+	if err = lease.buildOptions(DHCPOptions{}); nil == err {
+		t.Fatal("invalid gateway accepted unexpectedly")
+	}
+}
+
+func TestOverlayPool(t *testing.T) {
+	var service = DHCPService{
+		pools:        map[uint]AddressPool{DefaultVLAN: {Gateway: "10.0.0.1"}},
+		overlayPools: map[uint]AddressPool{100: {Gateway: "172.16.0.1", Options: DHCPOptions{LeaseTime: 600}}},
+	}
+	if pool := service.poolOf(networkSegment{VLAN: DefaultVLAN}); 0 != pool.Options.MTU || 0 != len(pool.Options.Routes) {
+		t.Fatalf("unexpected options of untagged pool %v", pool.Options)
+	}
+	var pool = service.poolOf(networkSegment{VNI: 100})
+	if "172.16.0.1" != pool.Gateway || overlayMTU != pool.Options.MTU {
+		t.Fatalf("unexpected overlay pool %v", pool)
+	}
+	var lease = clientLease{IP: "172.16.0.5", Netmask: "255.255.255.0", Gateway: pool.Gateway}
+	if err := lease.buildOptions(pool.Options); err != nil {
+		t.Fatalf("build options fail: %s", err.Error())
+	}
+	//default route, then metadata service on-link
+	var routes = []byte{0, 172, 16, 0, 1, 32, 169, 254, 169, 254, 0, 0, 0, 0}
+	if !bytes.Equal(routes, lease.Options[dhcp4.OptionClasslessRouteFormat]) {
+		t.Fatalf("unexpected routes %v", lease.Options[dhcp4.OptionClasslessRouteFormat])
+	}
+	if duration := service.leaseDuration(nil, networkSegment{VNI: 100}); 600*time.Second != duration {
+		t.Fatalf("unexpected lease duration %s", duration)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"net/textproto"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -21,8 +22,10 @@ type GuestInitiator struct {
 	listener            net.Listener
 	listenAddress       string
 	listenDevice        string
+	overlayListeners    map[uint]net.Listener //key = VNI
 	server              http.Server
 	eventChan           chan InstanceStatusChangedEvent
+	overlayChan         chan overlayAttachedEvent
 	insManager          *InstanceManager
 	networkModule       NetworkModule
 	supportedInterfaces []string
@@ -30,12 +33,30 @@ type GuestInitiator struct {
 	runner              *framework.SimpleRunner
 }
 
+type overlayAttachedEvent struct {
+	VNI      uint
+	Attached bool
+}
+
+// overlayListener : connections tagged with VNI of overlay network where accepted
+type overlayListener struct {
+	net.Listener
+	vni uint
+}
+
+type overlayConn struct {
+	net.Conn
+	vni uint
+}
+
+type overlayContextKey struct{}
+
 const (
 	InitiatorMagicPort = 25469
 	ListenerName       = "initiator"
 )
 
-func CreateInitiator(networkModule NetworkModule, instanceManager *InstanceManager) (initiator *GuestInitiator, err error) {
+func CreateInitiator(networkModule *NetworkManager, instanceManager *InstanceManager) (initiator *GuestInitiator, err error) {
 	const (
 		DefaultQueueSize = 1 << 10
 	)
@@ -54,7 +75,16 @@ func CreateInitiator(networkModule NetworkModule, instanceManager *InstanceManag
 	if err = initiator.prepareServer();err != nil{
 		return
 	}
+	initiator.overlayListeners = map[uint]net.Listener{}
+	for vni := range networkModule.overlays{
+		if err = initiator.listenOverlay(vni); err != nil{
+			log.Printf("<initiator> warning: %s", err.Error())
+		}
+	}
+	err = nil
 	initiator.eventChan = make(chan InstanceStatusChangedEvent, DefaultQueueSize)
+	initiator.overlayChan = make(chan overlayAttachedEvent, DefaultQueueSize)
+	networkModule.OnOverlayAttached = initiator.updateOverlay
 	initiator.insManager = instanceManager
 	initiator.networkModule = networkModule
 	initiator.runner = framework.CreateSimpleRunner(initiator.Routine)
@@ -73,6 +103,78 @@ func (initiator *GuestInitiator) listenMagicAddress() (err error) {
 	return nil
 }
 
+//listenOverlay : metadata address shared by all overlay bridges, so bind to bridge device
+func (initiator *GuestInitiator) listenOverlay(vni uint) (err error) {
+	const(
+		Protocol = "tcp"
+	)
+	var device = OverlayBridgeName(vni)
+	var config = net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var bindError error
+			if err := conn.Control(func(fd uintptr) {
+				bindError = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+			}); err != nil{
+				return err
+			}
+			return bindError
+		},
+	}
+	var address = fmt.Sprintf("%s:%d", MetadataAddress, InitiatorMagicPort)
+	listener, err := config.Listen(context.TODO(), Protocol, address)
+	if err != nil{
+		err = fmt.Errorf("listen at %s of '%s' fail: %s", address, device, err.Error())
+		return
+	}
+	initiator.overlayListeners[vni] = &overlayListener{Listener: listener, vni: vni}
+	log.Printf("<initiator> listen at %s of '%s' success", address, device)
+	return nil
+}
+
+func (initiator *GuestInitiator) updateOverlay(vni uint, attached bool){
+	initiator.overlayChan <- overlayAttachedEvent{VNI: vni, Attached: attached}
+}
+
+func (initiator *GuestInitiator) handleOverlayEvent(event overlayAttachedEvent){
+	listener, exists := initiator.overlayListeners[event.VNI]
+	if event.Attached{
+		if exists{
+			return
+		}
+		if err := initiator.listenOverlay(event.VNI); err != nil{
+			log.Printf("<initiator> serve overlay network %d fail: %s", event.VNI, err.Error())
+			return
+		}
+		go initiator.serveCloudInit(initiator.overlayListeners[event.VNI])
+	}else if exists{
+		if err := listener.Close(); err != nil{
+			log.Printf("<initiator> warning: close listener of overlay network %d fail: %s", event.VNI, err.Error())
+		}
+		delete(initiator.overlayListeners, event.VNI)
+	}
+}
+
+func (l *overlayListener) Accept() (conn net.Conn, err error) {
+	if conn, err = l.Listener.Accept(); err != nil{
+		return
+	}
+	return &overlayConn{Conn: conn, vni: l.vni}, nil
+}
+
+//requestVNI : zero when received from default bridge
+func requestVNI(r *http.Request) uint{
+	vni, _ := r.Context().Value(overlayContextKey{}).(uint)
+	return vni
+}
+
+//verifyGuestNetwork : guest in overlay network accessible from its own network only, isolate tenants
+func verifyGuestNetwork(r *http.Request, guest GuestConfig) error{
+	if vni := requestVNI(r); vni != guest.NetworkVNI{
+		return fmt.Errorf("guest '%s' not available from %s", guest.Name, r.RemoteAddr)
+	}
+	return nil
+}
+
 func (initiator *GuestInitiator) prepareServer() (err error) {
 	var router = httprouter.New()
 	var noHandler = NotFoundHandler{}
@@ -83,6 +185,12 @@ func (initiator *GuestInitiator) prepareServer() (err error) {
 
 	initiator.server.Addr = initiator.listenAddress
 	initiator.server.Handler = router
+	initiator.server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if conn, isOverlay := c.(*overlayConn); isOverlay{
+			return context.WithValue(ctx, overlayContextKey{}, conn.vni)
+		}
+		return ctx
+	}
 
 	initiator.supportedInterfaces = []string{"hostname", "instance-id", "local-hostname", "local-ipv4", "public-ipv4"}
 	return nil
@@ -98,7 +206,10 @@ func (initiator *GuestInitiator) Stop() error{
 
 func (initiator *GuestInitiator) Routine(c framework.RoutineController) {
 	initiator.insManager.AddEventListener(ListenerName, initiator.eventChan)
-	go initiator.serveCloudInit()
+	go initiator.serveCloudInit(initiator.listener)
+	for _, listener := range initiator.overlayListeners{
+		go initiator.serveCloudInit(listener)
+	}
 	for !c.IsStopping(){
 		select {
 		case <- c.GetNotifyChannel():
@@ -110,6 +221,8 @@ func (initiator *GuestInitiator) Routine(c framework.RoutineController) {
 			}
 		case event := <- initiator.eventChan:
 			initiator.handleGuestEvent(event)
+		case event := <- initiator.overlayChan:
+			initiator.handleOverlayEvent(event)
 		}
 	}
 	initiator.insManager.RemoveEventListener(ListenerName)
@@ -117,11 +230,11 @@ func (initiator *GuestInitiator) Routine(c framework.RoutineController) {
 	c.NotifyExit()
 }
 
-func (initiator *GuestInitiator) serveCloudInit(){
-	log.Println("<initiator> http server started")
-	var err = initiator.server.Serve(initiator.listener)
+func (initiator *GuestInitiator) serveCloudInit(listener net.Listener){
+	log.Printf("<initiator> http server started at %s", listener.Addr().String())
+	var err = initiator.server.Serve(listener)
 	if err != nil{
-		log.Printf("<initiator> http server finished: %s", err.Error())
+		log.Printf("<initiator> http server at %s finished: %s", listener.Addr().String(), err.Error())
 	}
 }
 
@@ -147,28 +260,32 @@ func (initiator *GuestInitiator) getMetaData(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
 	var ins = result.Instance
+	if err = verifyGuestNetwork(r, ins.GuestConfig); err != nil{
+		log.Printf("<initiator> reject meta data query: %s", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "instance-id: %s\n", ins.ID)
 	var hostname = strings.TrimPrefix(ins.Name, fmt.Sprintf("%s.", ins.Group))
 	fmt.Fprintf(w, "hostname: %s\n", hostname)
 	if AddressAllocationCloudInit == ins.AddressAllocation{
 		//allocate using Cloud-Init
+		//for internal interface only
+		if "" == ins.InternalAddress && "" == ins.InternalAddressV6{
+			log.Printf("<initiator> no internal address allocated for guest '%s'", ins.Name)
+			err = fmt.Errorf(" no internal address allocated for guest '%s'", ins.Name)
+			return
+		}
+		//servers of address pool in VLAN of guest
 		var respChan = make(chan NetworkResult, 1)
-		initiator.networkModule.GetCurrentConfig(respChan)
+		initiator.networkModule.GetAddressByHWAddress(ins.HardwareAddress, respChan)
 		var result = <- respChan
 		if nil != result.Error{
-			log.Printf("<initiator> get current network config fail: %s", result.Error.Error())
+			log.Printf("<initiator> get network config of guest '%s' fail: %s", ins.Name, result.Error.Error())
 			err = result.Error
 			return
 		}
-
-		//for internal interface only
-		if "" == ins.InternalAddress{
-			log.Printf("<initiator> no internal address allocated for guest '%s'", ins.Name)
-			err = fmt.Errorf(" no internal address allocated for guest '%s'", ins.Name)
-			return
-		}
 		var gatewayIP = result.Gateway
 		//var internalIP net.IP
 		//var internalMask *net.IPNet
@@ -209,6 +326,9 @@ func (initiator *GuestInitiator) getMetaData(w http.ResponseWriter, r *http.Requ
 		//		 - type: static
 		//		   address: 192.168.23.14/24
 		//		   gateway: 192.168.23.1
+		//		 - type: static6
+		//		   address: 2001:db8::14/64
+		//		   gateway: 2001:db8::1
 		//  - type: nameserver
 		//	  address:
 		//		- 192.168.23.2
@@ -218,17 +338,85 @@ func (initiator *GuestInitiator) getMetaData(w http.ResponseWriter, r *http.Requ
 		fmt.Fprint(w, "    name: eth0\n")
 		fmt.Fprintf(w, "    mac_address: '%s'\n", ins.HardwareAddress)
 		fmt.Fprint(w, "    subnets:\n")
-		fmt.Fprint(w, "      - type: static\n")
-		fmt.Fprintf(w, "        address: %s\n", ins.InternalAddress)
-		fmt.Fprintf(w, "        gateway: %s\n", gatewayIP)
+		var device = initiator.guestDevice(result)
+		var internalV4, internalV6 = initiator.probeStaticAddress(ins.ID, ins.HardwareAddress, ins.InternalAddress, device),
+			initiator.probeStaticAddress(ins.ID, ins.HardwareAddress, ins.InternalAddressV6, device)
+		if internalV4{
+			fmt.Fprint(w, "      - type: static\n")
+			fmt.Fprintf(w, "        address: %s\n", ins.InternalAddress)
+			fmt.Fprintf(w, "        gateway: %s\n", gatewayIP)
+		}
+		if internalV6{
+			fmt.Fprint(w, "      - type: static6\n")
+			fmt.Fprintf(w, "        address: %s\n", ins.InternalAddressV6)
+			if "" != result.GatewayV6{
+				fmt.Fprintf(w, "        gateway: %s\n", result.GatewayV6)
+			}
+		}else if "" == ins.InternalAddressV6 && initiator.listenDevice == device{
+			//address from router advertisement of cell, untagged network only
+			switch mode, _ := GetConfigurator().GetIPv6(); mode {
+			case IPv6ModeSLAAC:
+				fmt.Fprint(w, "      - type: ipv6_slaac\n")
+			case IPv6ModeDHCPv6:
+				fmt.Fprint(w, "      - type: dhcp6\n")
+			}
+		}
 		fmt.Fprint(w, "  - type: nameserver\n")
 		fmt.Fprint(w, "    address:\n")
-		for _, dns := range result.DNS{
+		var servers = append([]string{}, result.DNS...)
+		for _, dns := range append(servers, result.DNSV6...){
 			fmt.Fprintf(w, "      - %s\n", dns)
 		}
 	}
 }
 
+//guestDevice : interface of host where guest attached
+func (initiator *GuestInitiator) guestDevice(result NetworkResult) string{
+	if result.Private{
+		return PrivateBridgeName
+	}else if 0 != result.VNI{
+		return OverlayBridgeName(result.VNI)
+	}else if DefaultVLAN != result.VLAN{
+		return VLANInterfaceName(initiator.listenDevice, result.VLAN)
+	}
+	return initiator.listenDevice
+}
+
+//probeStaticAddress : false when address empty or already used by other host, conflict event raised
+func (initiator *GuestInitiator) probeStaticAddress(instanceID, hwaddress, address, deviceName string) bool{
+	if "" == address{
+		return false
+	}
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil{
+		return false
+	}
+	device, err := net.InterfaceByName(deviceName)
+	if err != nil{
+		log.Printf("<initiator> warning: skip probing address %s: %s", address, err.Error())
+		return true
+	}
+	owner, _ := net.ParseMAC(hwaddress)
+	var conflicted bool
+	var conflict net.HardwareAddr
+	if nil != ip.To4(){
+		conflicted, conflict, err = ProbeIPv4Address(device, ip, owner, addressProbeTimeout)
+	}else{
+		conflicted, conflict, err = ProbeIPv6Address(device, ip, owner, addressProbeTimeout)
+	}
+	if err != nil{
+		log.Printf("<initiator> warning: probe address %s fail: %s", address, err.Error())
+		return true
+	}
+	if !conflicted{
+		return true
+	}
+	log.Printf("<initiator> address %s of instance '%s' conflict with host '%s', withheld from cloud-init",
+		address, instanceID, conflict.String())
+	initiator.insManager.GetEventChannel() <- newAddressConflictEvent(instanceID, ip.String(), conflict.String())
+	return false
+}
+
 func ipv4MaskToString(mask net.IPMask) (s string, err error){
 	if net.IPv4len != len(mask){
 		err = fmt.Errorf("invalid mask length %d", len(mask))
@@ -254,6 +442,12 @@ func (initiator *GuestInitiator) getUserData(w http.ResponseWriter, r *http.Requ
 	}
 	//todo: modified flag (password/disks)
 	var guest = result.Instance
+	if err := verifyGuestNetwork(r, guest.GuestConfig); err != nil{
+		log.Printf("<initiator> reject user data query: %s", err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if !guest.Initialized{
 		data, err := initiator.buildInitialConfig(guest.GuestConfig)
 		if err != nil{
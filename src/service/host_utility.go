@@ -0,0 +1,128 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/project-nano/framework"
+	"os"
+	"path/filepath"
+)
+
+type HostDeviceType uint
+
+const (
+	HostDeviceDisk = HostDeviceType(iota)
+	HostDeviceInterface
+)
+
+// HostDeviceIO : IO of a physical disk or network interface, read/write for disk, receive/send for interface
+type HostDeviceIO struct {
+	Name          string
+	Type          HostDeviceType
+	BytesRead     uint64
+	BytesWritten  uint64
+	BytesReceived uint64
+	BytesSent     uint64
+	ReadSpeed     uint64
+	WriteSpeed    uint64
+	ReceiveSpeed  uint64
+	SendSpeed     uint64
+}
+
+const (
+	sysBlockPath = "/sys/block"
+	sysNetPath   = "/sys/class/net"
+	sysDevice    = "device"
+)
+
+// IsPhysicalDisk : whole disk backed by real device, loop/ram/dm/md devices and partitions excluded
+func IsPhysicalDisk(name string) bool {
+	return pathExists(filepath.Join(sysBlockPath, name, sysDevice))
+}
+
+// IsPhysicalInterface : interface backed by real device, loopback/bridge/tap/vlan excluded
+func IsPhysicalInterface(name string) bool {
+	return pathExists(filepath.Join(sysNetPath, name, sysDevice))
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return nil == err
+}
+
+// writeFileAtomically : write to temporary file in same directory then rename, so that a crash never leaves partial content
+func writeFileAtomically(filename string, data []byte, perm os.FileMode) (err error) {
+	var tempFile = filename + ".tmp"
+	file, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tempFile)
+	}
+	return
+}
+
+// MarshalHostDeviceIO : device names, types and counters in groups of [read/receive bytes, write/send bytes, read/receive speed, write/send speed]
+func MarshalHostDeviceIO(message framework.Message, devices []HostDeviceIO) {
+	var names []string
+	var types, counters []uint64
+	for _, device := range devices {
+		names = append(names, device.Name)
+		types = append(types, uint64(device.Type))
+		if HostDeviceDisk == device.Type {
+			counters = append(counters, device.BytesRead, device.BytesWritten, device.ReadSpeed, device.WriteSpeed)
+		} else {
+			counters = append(counters, device.BytesReceived, device.BytesSent, device.ReceiveSpeed, device.SendSpeed)
+		}
+	}
+	message.SetStringArray(framework.ParamKeyTarget, names)
+	message.SetUIntArray(framework.ParamKeyType, types)
+	message.SetUIntArray(framework.ParamKeyData, counters)
+}
+
+type NUMANode struct {
+	ID     uint   `json:"id"`
+	Memory uint64 `json:"memory"`
+	CPUs   uint   `json:"cpus"`
+}
+
+// HostInventory : hardware and virtualization capability of host, for placement of guests
+type HostInventory struct {
+	CPUModel             string     `json:"cpu_model"`
+	CPUVendor            string     `json:"cpu_vendor,omitempty"`
+	CPUFlags             []string   `json:"cpu_flags,omitempty"`
+	Sockets              uint       `json:"sockets"`
+	Cores                uint       `json:"cores"`
+	Threads              uint       `json:"threads"`
+	LogicalCores         uint       `json:"logical_cores"`
+	NUMANodes            []NUMANode `json:"numa_nodes,omitempty"`
+	HugePageSizes        []uint64   `json:"huge_page_sizes,omitempty"` //in KiB
+	KernelVersion        string     `json:"kernel_version"`
+	LibvirtVersion       string     `json:"libvirt_version"`
+	QEMUVersion          string     `json:"qemu_version"`
+	KVMAvailable         bool       `json:"kvm_available"`
+	NestedVirtualization bool       `json:"nested_virtualization"`
+	MachineTypes         []string   `json:"machine_types,omitempty"`
+	Firmware             []string   `json:"firmware,omitempty"`
+	UEFIAvailable        bool       `json:"uefi_available"`
+	CollectTime          string     `json:"collect_time"`
+}
+
+// Marshal : inventory encoded as JSON in data
+func (inventory *HostInventory) Marshal(message framework.Message) error {
+	data, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+	message.SetString(framework.ParamKeyData, string(data))
+	return nil
+}
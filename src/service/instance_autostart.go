@@ -0,0 +1,241 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+)
+
+type guestShutdownState struct {
+	//guest id in reverse order of start, grouped by start order
+	stages [][]string
+	//deadline of guests shutting down
+	pending map[string]time.Time
+	timeout time.Duration
+	//all remaining guests force stopped after deadline
+	deadline time.Time
+	respChan chan error
+}
+
+func readBootID() (string, error) {
+	data, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// prepareAutoStart : queue auto start guests when host rebooted, and guests shut down by cell in last stop.
+// boot id not saved by previous version, which left auto start to libvirt, so regarded as rebooted
+func (manager *InstanceManager) prepareAutoStart(savedBootID string, shutdownGuests []string) {
+	currentBootID, err := readBootID()
+	if err != nil {
+		log.Printf("<instance> warning: read boot id fail: %s", err.Error())
+		return
+	}
+	manager.bootID = currentBootID
+	var hostRebooted = savedBootID != currentBootID
+	if !hostRebooted && 0 == len(shutdownGuests) {
+		return
+	}
+	var restoring = map[string]bool{}
+	for _, id := range shutdownGuests {
+		restoring[id] = true
+	}
+	var guests []GuestConfig
+	for id, ins := range manager.instances {
+		if ins.Running {
+			continue
+		}
+		if restoring[id] || (hostRebooted && ins.AutoStart) {
+			guests = append(guests, ins.GuestConfig)
+		}
+	}
+	if 0 == len(guests) {
+		return
+	}
+	sortByStartOrder(guests)
+	for _, guest := range guests {
+		manager.autoStartQueue = append(manager.autoStartQueue, guest.ID)
+	}
+	var concurrency, delay = GetConfigurator().GetAutoStartPolicy()
+	if hostRebooted {
+		log.Printf("<instance> host rebooted, %d guest(s) queued for auto start, %d at a time with %s delay",
+			len(guests), concurrency, delay)
+	} else {
+		log.Printf("<instance> %d guest(s) shut down in last stop queued for auto start, %d at a time with %s delay",
+			len(guests), concurrency, delay)
+	}
+}
+
+// sortByStartOrder : by start order, then cpu priority
+func sortByStartOrder(guests []GuestConfig) {
+	sort.Slice(guests, func(i, j int) bool {
+		if guests[i].StartOrder != guests[j].StartOrder {
+			return guests[i].StartOrder < guests[j].StartOrder
+		}
+		if guests[i].CPUPriority != guests[j].CPUPriority {
+			return guests[i].CPUPriority < guests[j].CPUPriority
+		}
+		return guests[i].Name < guests[j].Name
+	})
+}
+
+// advanceAutoStart : start queued guests in order, each one occupies a slot of concurrency until delay passed
+func (manager *InstanceManager) advanceAutoStart(now time.Time) {
+	if 0 == len(manager.autoStartQueue) && 0 == len(manager.autoStartBooting) {
+		return
+	}
+	var concurrency, delay = GetConfigurator().GetAutoStartPolicy()
+	for id, startTime := range manager.autoStartBooting {
+		if now.Sub(startTime) >= delay {
+			delete(manager.autoStartBooting, id)
+		}
+	}
+	if err := manager.checkMaintenance(); err != nil {
+		if 0 != len(manager.autoStartQueue) {
+			log.Printf("<instance> %d auto start guest(s) cancelled: %s", len(manager.autoStartQueue), err.Error())
+			manager.autoStartQueue = nil
+		}
+		return
+	}
+	for len(manager.autoStartBooting) < concurrency && 0 != len(manager.autoStartQueue) {
+		var id = manager.autoStartQueue[0]
+		manager.autoStartQueue = manager.autoStartQueue[1:]
+		ins, exists := manager.instances[id]
+		if !exists || ins.Running {
+			continue
+		}
+		if err := manager.util.StartInstance(id, ins.NetworkVLAN); err != nil {
+			log.Printf("<instance> auto start guest '%s' fail: %s", ins.Name, err.Error())
+			continue
+		}
+		//left for sync
+		manager.autoStartBooting[id] = now
+		log.Printf("<instance> guest '%s' auto started, %d remains", ins.Name, len(manager.autoStartQueue))
+	}
+}
+
+// handleShutdownAllGuests : shutdown running guests in reverse order of auto start, stage by stage of start order,
+// advanced by advanceShutdown and respond when all guests stopped, or remaining guests force stopped after limit
+func (manager *InstanceManager) handleShutdownAllGuests(timeout, limit time.Duration, respChan chan error) (err error) {
+	if nil != manager.shutdown {
+		err = errors.New("guests are already shutting down")
+		respChan <- err
+		return err
+	}
+	var guests []GuestConfig
+	for _, ins := range manager.instances {
+		if ins.Running {
+			guests = append(guests, ins.GuestConfig)
+		}
+	}
+	//no more guests started by cell
+	manager.autoStartQueue = nil
+	manager.shutdownGuests = nil
+	if 0 == len(guests) {
+		err = manager.saveConfig()
+		respChan <- err
+		return err
+	}
+	sortByStartOrder(guests)
+	var now = time.Now()
+	var state = &guestShutdownState{timeout: timeout, deadline: now.Add(limit), pending: map[string]time.Time{}, respChan: respChan}
+	for index := len(guests) - 1; index >= 0; index-- {
+		var guest = guests[index]
+		var stageCount = len(state.stages)
+		if 0 == stageCount || guests[index+1].StartOrder != guest.StartOrder {
+			state.stages = append(state.stages, []string{guest.ID})
+		} else {
+			state.stages[stageCount-1] = append(state.stages[stageCount-1], guest.ID)
+		}
+		//restore in next start
+		manager.shutdownGuests = append(manager.shutdownGuests, guest.ID)
+	}
+	manager.shutdown = state
+	log.Printf("<instance> shutting down %d guest(s) in %d stage(s), timeout %s for each guest, %s in total",
+		len(guests), len(state.stages), timeout, limit)
+	manager.advanceShutdown(now)
+	return nil
+}
+
+// advanceShutdown : force stop guests not stopped in timeout, start next stage when current stage finished
+func (manager *InstanceManager) advanceShutdown(now time.Time) {
+	var state = manager.shutdown
+	if nil == state {
+		return
+	}
+	if !now.Before(state.deadline) && 0 != len(state.stages) {
+		log.Printf("<instance> shutdown not finished before deadline, force stop remaining guests")
+		for _, stage := range state.stages {
+			for _, id := range stage {
+				ins, exists := manager.instances[id]
+				if !exists || !ins.Running {
+					continue
+				}
+				if err := manager.util.StopInstance(id, false, true); err != nil {
+					log.Printf("<instance> warning: force stop guest '%s' fail: %s", ins.Name, err.Error())
+				} else {
+					manager.markStopRequested(id)
+				}
+			}
+		}
+		state.stages = nil
+	}
+	for id, deadline := range state.pending {
+		ins, exists := manager.instances[id]
+		if !exists {
+			delete(state.pending, id)
+			continue
+		}
+		if running, err := manager.util.IsInstanceRunning(id); nil == err && !running {
+			delete(state.pending, id)
+			log.Printf("<instance> guest '%s' shut down", ins.Name)
+			continue
+		}
+		if now.Before(deadline) && now.Before(state.deadline) {
+			continue
+		}
+		delete(state.pending, id)
+		if err := manager.util.StopInstance(id, false, true); err != nil {
+			log.Printf("<instance> warning: force stop guest '%s' fail: %s", ins.Name, err.Error())
+		} else {
+			log.Printf("<instance> guest '%s' force stopped", ins.Name)
+		}
+	}
+	for 0 == len(state.pending) && 0 != len(state.stages) {
+		var stage = state.stages[0]
+		state.stages = state.stages[1:]
+		for _, id := range stage {
+			ins, exists := manager.instances[id]
+			if !exists || !ins.Running {
+				continue
+			}
+			if err := manager.util.StopInstance(id, false, false); err != nil {
+				log.Printf("<instance> warning: shutdown guest '%s' fail: %s", ins.Name, err.Error())
+				if err = manager.util.StopInstance(id, false, true); err != nil {
+					log.Printf("<instance> warning: force stop guest '%s' fail: %s", ins.Name, err.Error())
+				} else {
+					manager.markStopRequested(id)
+				}
+				continue
+			}
+			manager.markStopRequested(id)
+			state.pending[id] = now.Add(state.timeout)
+		}
+	}
+	if 0 != len(state.pending) {
+		return
+	}
+	manager.shutdown = nil
+	var err = manager.saveConfig()
+	log.Printf("<instance> %d guest(s) shut down", len(manager.shutdownGuests))
+	state.respChan <- err
+}
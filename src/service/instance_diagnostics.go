@@ -0,0 +1,365 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kinds of diagnostic artifact, also prefix of file name
+const (
+	DiagnosticScreenshot = "screenshot"
+	DiagnosticMemory     = "memory"
+	DiagnosticCrash      = "crash"
+)
+
+// GuestDiagnostic : screenshot or memory dump of guest saved in storage pool
+type GuestDiagnostic struct {
+	Name       string
+	Kind       string
+	Size       uint64
+	CreateTime string
+	Path       string
+}
+
+const (
+	diagnosticTimeFormat = "20060102150405"
+	diagnosticSuffixSize = 8
+)
+
+func diagnosticExtension(kind string) string {
+	if DiagnosticScreenshot == kind {
+		return "png"
+	}
+	return "kdump"
+}
+
+// parseDiagnosticKind : name formatted as <kind>_<time>_<random suffix>.<extension>
+func parseDiagnosticKind(name string) (kind string, valid bool) {
+	if filepath.Base(name) != name {
+		return "", false
+	}
+	var parts = strings.SplitN(name, "_", 2)
+	if 2 != len(parts) {
+		return "", false
+	}
+	switch parts[0] {
+	case DiagnosticScreenshot, DiagnosticMemory, DiagnosticCrash:
+		return parts[0], true
+	default:
+		return "", false
+	}
+}
+
+// newDiagnosticFile : random suffix appended, so file can't be guessed when downloading
+func (manager *InstanceManager) newDiagnosticFile(guestID, poolName, kind string) (filename string, err error) {
+	var poolPath string
+	if poolPath, err = manager.util.GetPoolPath(poolName); err != nil {
+		return
+	}
+	var guestPath = filepath.Join(poolPath, DiagnosticPath, guestID)
+	if err = os.MkdirAll(guestPath, DefaultPathPerm); err != nil {
+		err = fmt.Errorf("create diagnostic path '%s' fail: %s", guestPath, err.Error())
+		return
+	}
+	var suffix = make([]byte, diagnosticSuffixSize)
+	if _, err = rand.Read(suffix); err != nil {
+		err = fmt.Errorf("generate file suffix fail: %s", err.Error())
+		return
+	}
+	var name = fmt.Sprintf("%s_%s_%s.%s", kind, time.Now().Format(diagnosticTimeFormat),
+		hex.EncodeToString(suffix), diagnosticExtension(kind))
+	return filepath.Join(guestPath, name), nil
+}
+
+func (manager *InstanceManager) getDiagnosticPath(guestID string) (guestPath string, err error) {
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		return
+	}
+	poolPath, err := manager.util.GetPoolPath(ins.StoragePool)
+	if err != nil {
+		return
+	}
+	return filepath.Join(poolPath, DiagnosticPath, guestID), nil
+}
+
+func loadDiagnostic(filename string) (diagnostic GuestDiagnostic, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(filename); err != nil {
+		return
+	}
+	var name = filepath.Base(filename)
+	kind, valid := parseDiagnosticKind(name)
+	if !valid {
+		err = fmt.Errorf("invalid diagnostic '%s'", name)
+		return
+	}
+	diagnostic.Name = name
+	diagnostic.Kind = kind
+	diagnostic.Size = uint64(info.Size())
+	diagnostic.CreateTime = info.ModTime().Format(TimeFormatLayout)
+	diagnostic.Path = filename
+	return diagnostic, nil
+}
+
+// listDiagnostics : sorted by name, oldest first for each kind
+func listDiagnostics(guestPath string) (diagnostics []GuestDiagnostic, err error) {
+	entries, err := os.ReadDir(guestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, valid := parseDiagnosticKind(entry.Name()); !valid {
+			continue
+		}
+		diagnostic, err := loadDiagnostic(filepath.Join(guestPath, entry.Name()))
+		if err != nil {
+			log.Printf("<instance> warning: load diagnostic '%s' fail: %s", entry.Name(), err.Error())
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	sort.Slice(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Name < diagnostics[j].Name
+	})
+	return diagnostics, nil
+}
+
+// pruneDiagnostics : remove oldest artifacts of kind when exceed retention
+func pruneDiagnostics(guestPath, kind string, retention int) (removed int, err error) {
+	diagnostics, err := listDiagnostics(guestPath)
+	if err != nil {
+		return
+	}
+	var matched []GuestDiagnostic
+	for _, diagnostic := range diagnostics {
+		if kind == diagnostic.Kind {
+			matched = append(matched, diagnostic)
+		}
+	}
+	for index := 0; index < len(matched)-retention; index++ {
+		if err = os.Remove(matched[index].Path); err != nil {
+			return
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func diagnosticRetention(kind string) int {
+	var screenshots, dumps = GetConfigurator().GetDiagnosticRetention()
+	if DiagnosticScreenshot == kind {
+		return screenshots
+	}
+	return dumps
+}
+
+// saveDiagnostic : apply retention of kind after new artifact saved
+func saveDiagnostic(filename, kind string) (diagnostic GuestDiagnostic, err error) {
+	if diagnostic, err = loadDiagnostic(filename); err != nil {
+		return
+	}
+	removed, err := pruneDiagnostics(filepath.Dir(filename), kind, diagnosticRetention(kind))
+	if err != nil {
+		log.Printf("<instance> warning: prune %s of '%s' fail: %s", kind, filepath.Dir(filename), err.Error())
+		err = nil
+	} else if 0 != removed {
+		log.Printf("<instance> %d expired %s(s) removed", removed, kind)
+	}
+	return diagnostic, nil
+}
+
+func (manager *InstanceManager) handleCaptureScreenshot(guestID string, respChan chan InstanceResult) (err error) {
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	if !ins.Running {
+		err = fmt.Errorf("guest '%s' not running", ins.Name)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	var imageFile string
+	if imageFile, err = manager.newDiagnosticFile(guestID, ins.StoragePool, DiagnosticScreenshot); err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	if err = manager.util.CaptureScreenshot(guestID, imageFile); err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	diagnostic, err := saveDiagnostic(imageFile, DiagnosticScreenshot)
+	if err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	log.Printf("<instance> screenshot of guest '%s' saved to '%s'", ins.Name, imageFile)
+	respChan <- InstanceResult{Diagnostic: diagnostic}
+	return nil
+}
+
+// handleDumpMemory : response after dump finished in background
+func (manager *InstanceManager) handleDumpMemory(guestID string, respChan chan InstanceResult) (err error) {
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	if !ins.Running {
+		err = fmt.Errorf("guest '%s' not running", ins.Name)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	if _, dumping := manager.dumpingGuests[guestID]; dumping {
+		err = fmt.Errorf("dump of guest '%s' in progress", ins.Name)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	var dumpFile string
+	if dumpFile, err = manager.newDiagnosticFile(guestID, ins.StoragePool, DiagnosticMemory); err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	manager.dumpingGuests[guestID] = true
+	log.Printf("<instance> dumping memory of guest '%s'", ins.Name)
+	go func() {
+		var err = manager.util.DumpGuestMemory(guestID, dumpFile)
+		manager.dumpResults <- guestDumpResult{GuestID: guestID, Trigger: deviceTriggerRequest, Path: dumpFile,
+			Error: err, RespChan: respChan}
+	}()
+	return nil
+}
+
+func (manager *InstanceManager) handleQueryDiagnostics(guestID string, respChan chan InstanceResult) (err error) {
+	var guestPath string
+	if guestPath, err = manager.getDiagnosticPath(guestID); err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	diagnostics, err := listDiagnostics(guestPath)
+	if err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	respChan <- InstanceResult{Diagnostics: diagnostics}
+	return nil
+}
+
+func (manager *InstanceManager) handleGetDiagnostic(guestID, name string, respChan chan InstanceResult) (err error) {
+	if _, valid := parseDiagnosticKind(name); !valid {
+		err = fmt.Errorf("invalid diagnostic '%s'", name)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	var guestPath string
+	if guestPath, err = manager.getDiagnosticPath(guestID); err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	diagnostic, err := loadDiagnostic(filepath.Join(guestPath, name))
+	if err != nil {
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	respChan <- InstanceResult{Diagnostic: diagnostic}
+	return nil
+}
+
+// decodePPM : decode binary portable pixmap returned by screenshot of QEMU
+func decodePPM(data []byte) (img image.Image, err error) {
+	const (
+		magicBinary = "P6"
+	)
+	var reader = bufio.NewReader(bytes.NewReader(data))
+	var header []int
+	var magic string
+	for len(header) < 3 {
+		var token string
+		if token, err = readPPMToken(reader); err != nil {
+			err = fmt.Errorf("read header fail: %s", err.Error())
+			return
+		}
+		if "" == magic {
+			if magicBinary != token {
+				err = fmt.Errorf("unsupported format '%s'", token)
+				return
+			}
+			magic = token
+			continue
+		}
+		var value int
+		if _, err = fmt.Sscanf(token, "%d", &value); err != nil || value <= 0 {
+			err = fmt.Errorf("invalid header value '%s'", token)
+			return
+		}
+		header = append(header, value)
+	}
+	var width, height, maxValue = header[0], header[1], header[2]
+	if maxValue > 0xFF {
+		err = fmt.Errorf("unsupported max value %d", maxValue)
+		return
+	}
+	var pixels = make([]byte, width*height*3)
+	if _, err = io.ReadFull(reader, pixels); err != nil {
+		err = fmt.Errorf("read pixels fail: %s", err.Error())
+		return
+	}
+	var rgba = image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var offset = (y*width + x) * 3
+			rgba.SetRGBA(x, y, color.RGBA{
+				R: uint8(int(pixels[offset]) * 0xFF / maxValue),
+				G: uint8(int(pixels[offset+1]) * 0xFF / maxValue),
+				B: uint8(int(pixels[offset+2]) * 0xFF / maxValue),
+				A: 0xFF,
+			})
+		}
+	}
+	return rgba, nil
+}
+
+// readPPMToken : skip whitespace and comments, consume single whitespace after token
+func readPPMToken(reader *bufio.Reader) (token string, err error) {
+	var builder strings.Builder
+	for {
+		var c byte
+		if c, err = reader.ReadByte(); err != nil {
+			return
+		}
+		switch {
+		case '#' == c && 0 == builder.Len():
+			if _, err = reader.ReadString('\n'); err != nil {
+				return
+			}
+		case ' ' == c || '\t' == c || '\n' == c || '\r' == c:
+			if 0 != builder.Len() {
+				return builder.String(), nil
+			}
+		default:
+			builder.WriteByte(c)
+		}
+	}
+}
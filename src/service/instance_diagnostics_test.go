@@ -0,0 +1,70 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodePPM(t *testing.T) {
+	//2x1 pixmap with comment, red and blue pixels
+	var data = append([]byte("P6\n# screenshot\n2 1\n255\n"), 0xFF, 0, 0, 0, 0, 0xFF)
+	img, err := decodePPM(data)
+	if err != nil {
+		t.Fatalf("decode fail: %s", err.Error())
+	}
+	if 2 != img.Bounds().Dx() || 1 != img.Bounds().Dy() {
+		t.Fatalf("unexpected bounds %v", img.Bounds())
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); 0xFFFF != r || 0 != g || 0 != b {
+		t.Fatalf("unexpected first pixel %d, %d, %d", r, g, b)
+	}
+	if r, g, b, _ := img.At(1, 0).RGBA(); 0 != r || 0 != g || 0xFFFF != b {
+		t.Fatalf("unexpected second pixel %d, %d, %d", r, g, b)
+	}
+	if _, err = decodePPM([]byte("P3\n1 1\n255\n0 0 0\n")); nil == err {
+		t.Fatal("ascii pixmap decoded unexpectedly")
+	}
+	if _, err = decodePPM([]byte("P6\n2 2\n255\n\x00\x00\x00")); nil == err {
+		t.Fatal("truncated pixmap decoded unexpectedly")
+	}
+}
+
+func TestPruneDiagnostics(t *testing.T) {
+	var guestPath = t.TempDir()
+	var names = []string{
+		"screenshot_20240101000000_01.png",
+		"screenshot_20240102000000_02.png",
+		"screenshot_20240103000000_03.png",
+		"memory_20240101000000_04.kdump",
+		"unknown_20240101000000_05.png",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(guestPath, name), []byte("data"), 0640); err != nil {
+			t.Fatalf("write '%s' fail: %s", name, err.Error())
+		}
+	}
+	removed, err := pruneDiagnostics(guestPath, DiagnosticScreenshot, 1)
+	if err != nil {
+		t.Fatalf("prune fail: %s", err.Error())
+	}
+	if 2 != removed {
+		t.Fatalf("unexpected removed %d", removed)
+	}
+	diagnostics, err := listDiagnostics(guestPath)
+	if err != nil {
+		t.Fatalf("list fail: %s", err.Error())
+	}
+	if 2 != len(diagnostics) {
+		t.Fatalf("unexpected diagnostics %v", diagnostics)
+	}
+	if names[3] != diagnostics[0].Name || DiagnosticMemory != diagnostics[0].Kind {
+		t.Fatalf("unexpected memory dump %v", diagnostics[0])
+	}
+	if names[2] != diagnostics[1].Name || 4 != diagnostics[1].Size {
+		t.Fatalf("unexpected screenshot %v", diagnostics[1])
+	}
+	if _, valid := parseDiagnosticKind("../" + names[2]); valid {
+		t.Fatal("name with path accepted unexpectedly")
+	}
+}
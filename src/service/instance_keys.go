@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type KeyCodeSet uint
+
+const (
+	KeyCodeSetLinux = KeyCodeSet(iota)
+	KeyCodeSetXT
+	KeyCodeSetInvalid
+)
+
+const (
+	//limit of libvirt
+	maxSendKeys = 16
+)
+
+// linux input event codes of key names
+var linuxKeyCodes = map[string]uint{
+	"esc": 1, "1": 2, "2": 3, "3": 4, "4": 5, "5": 6, "6": 7, "7": 8, "8": 9, "9": 10, "0": 11,
+	"minus": 12, "equal": 13, "backspace": 14, "tab": 15,
+	"q": 16, "w": 17, "e": 18, "r": 19, "t": 20, "y": 21, "u": 22, "i": 23, "o": 24, "p": 25,
+	"leftbrace": 26, "rightbrace": 27, "enter": 28, "ctrl": 29,
+	"a": 30, "s": 31, "d": 32, "f": 33, "g": 34, "h": 35, "j": 36, "k": 37, "l": 38,
+	"semicolon": 39, "apostrophe": 40, "grave": 41, "shift": 42, "backslash": 43,
+	"z": 44, "x": 45, "c": 46, "v": 47, "b": 48, "n": 49, "m": 50,
+	"comma": 51, "dot": 52, "slash": 53, "rightshift": 54, "alt": 56, "space": 57, "capslock": 58,
+	"f1": 59, "f2": 60, "f3": 61, "f4": 62, "f5": 63, "f6": 64, "f7": 65, "f8": 66, "f9": 67, "f10": 68,
+	"f11": 87, "f12": 88, "rightctrl": 97, "sysrq": 99, "rightalt": 100,
+	"home": 102, "up": 103, "pageup": 104, "left": 105, "right": 106, "end": 107, "down": 108,
+	"pagedown": 109, "insert": 110, "delete": 111, "pause": 119, "meta": 125, "rightmeta": 126,
+}
+
+// key aliases
+var keyNameAliases = map[string]string{
+	"control": "ctrl", "del": "delete", "ins": "insert", "escape": "esc", "return": "enter",
+	"win": "meta", "super": "meta", "print": "sysrq", "pgup": "pageup", "pgdn": "pagedown",
+}
+
+func (codeSet KeyCodeSet) ToString() string {
+	switch codeSet {
+	case KeyCodeSetLinux:
+		return "linux"
+	case KeyCodeSetXT:
+		return "xt"
+	default:
+		return "invalid"
+	}
+}
+
+// parseKeyCodes : key names only available in linux code set, raw codes in decimal or hex with prefix '0x'
+func parseKeyCodes(codeSet KeyCodeSet, keys []string) (codes []uint, err error) {
+	if codeSet >= KeyCodeSetInvalid {
+		err = fmt.Errorf("invalid key code set %d", codeSet)
+		return
+	}
+	if 0 == len(keys) {
+		err = fmt.Errorf("no key specified")
+		return
+	}
+	if len(keys) > maxSendKeys {
+		err = fmt.Errorf("too many keys, %d > %d", len(keys), maxSendKeys)
+		return
+	}
+	for _, key := range keys {
+		var name = strings.ToLower(strings.TrimSpace(key))
+		if code, err := strconv.ParseUint(name, 0, 16); nil == err && (len(name) > 1 || KeyCodeSetLinux != codeSet) {
+			codes = append(codes, uint(code))
+			continue
+		}
+		if alias, exists := keyNameAliases[name]; exists {
+			name = alias
+		}
+		code, exists := linuxKeyCodes[name]
+		if !exists {
+			err = fmt.Errorf("invalid key '%s'", key)
+			return
+		}
+		if KeyCodeSetLinux != codeSet {
+			err = fmt.Errorf("key name '%s' not available in %s code set", key, codeSet.ToString())
+			return
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (manager *InstanceManager) handleSendKeys(guestID string, codeSet KeyCodeSet, keys []string,
+	respChan chan error) (err error) {
+	defer func() {
+		respChan <- err
+	}()
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		return
+	}
+	if !ins.Running {
+		err = fmt.Errorf("guest '%s' not running", ins.Name)
+		return
+	}
+	var codes []uint
+	if codes, err = parseKeyCodes(codeSet, keys); err != nil {
+		return
+	}
+	if err = manager.util.SendKeys(guestID, codeSet, codes); err != nil {
+		return
+	}
+	log.Printf("<instance> keys '%s' sent to guest '%s'", strings.Join(keys, "+"), ins.Name)
+	return nil
+}
+
+func (manager *InstanceManager) handleInjectNMI(guestID string, respChan chan error) (err error) {
+	defer func() {
+		respChan <- err
+	}()
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		return
+	}
+	if !ins.Running {
+		err = fmt.Errorf("guest '%s' not running", ins.Name)
+		return
+	}
+	if err = manager.util.InjectNMI(guestID); err != nil {
+		return
+	}
+	log.Printf("<instance> NMI injected to guest '%s'", ins.Name)
+	return nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestParseKeyCodes(t *testing.T) {
+	codes, err := parseKeyCodes(KeyCodeSetLinux, []string{"Ctrl", "alt", "Del"})
+	if err != nil {
+		t.Fatalf("parse key names fail: %s", err.Error())
+	}
+	if 3 != len(codes) || 29 != codes[0] || 56 != codes[1] || 111 != codes[2] {
+		t.Fatalf("unexpected codes %v", codes)
+	}
+	//single digit is key name, others are raw codes
+	if codes, err = parseKeyCodes(KeyCodeSetLinux, []string{"1", "0x1d", "56"}); err != nil {
+		t.Fatalf("parse raw codes fail: %s", err.Error())
+	}
+	if 2 != codes[0] || 29 != codes[1] || 56 != codes[2] {
+		t.Fatalf("unexpected codes %v", codes)
+	}
+	if codes, err = parseKeyCodes(KeyCodeSetXT, []string{"0x1d", "1"}); err != nil || 1 != codes[1] {
+		t.Fatalf("parse xt codes fail: %v, %v", codes, err)
+	}
+	if _, err = parseKeyCodes(KeyCodeSetXT, []string{"ctrl"}); nil == err {
+		t.Fatal("key name accepted in xt code set unexpectedly")
+	}
+	if _, err = parseKeyCodes(KeyCodeSetLinux, []string{"hyper"}); nil == err {
+		t.Fatal("invalid key accepted unexpectedly")
+	}
+	if _, err = parseKeyCodes(KeyCodeSetLinux, nil); nil == err {
+		t.Fatal("empty keys accepted unexpectedly")
+	}
+	if _, err = parseKeyCodes(KeyCodeSetLinux, make([]string, maxSendKeys+1)); nil == err {
+		t.Fatal("too many keys accepted unexpectedly")
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+type DrainStatus uint
+
+const (
+	DrainPending = DrainStatus(iota)
+	DrainStopping
+	DrainForceStopping
+	DrainStopped
+	DrainFailed
+)
+
+// DrainGuest : progress of shutting down a guest when draining
+type DrainGuest struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Priority    PriorityEnum `json:"priority"`
+	Status      DrainStatus  `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	RequestTime time.Time    `json:"request_time,omitempty"`
+}
+
+// MaintenanceStatus : new creates, starts and image writes rejected when enabled
+type MaintenanceStatus struct {
+	Enabled    bool         `json:"enabled"`
+	Since      string       `json:"since,omitempty"`
+	Draining   bool         `json:"draining,omitempty"`
+	DrainBegin string       `json:"drain_begin,omitempty"`
+	DrainEnd   string       `json:"drain_end,omitempty"`
+	Guests     []DrainGuest `json:"guests,omitempty"`
+}
+
+const (
+	drainConcurrency = 4
+	drainStopTimeout = 3 * time.Minute
+)
+
+func (manager *InstanceManager) checkMaintenance() error {
+	if manager.maintenance.Enabled {
+		return fmt.Errorf("cell in maintenance mode since %s", manager.maintenance.Since)
+	}
+	return nil
+}
+
+func (manager *InstanceManager) handleSetMaintenance(enable, drain bool, respChan chan error) (err error) {
+	defer func() {
+		respChan <- err
+	}()
+	var now = time.Now()
+	if !enable {
+		if !manager.maintenance.Enabled {
+			err = fmt.Errorf("not in maintenance mode")
+			return
+		}
+		if manager.maintenance.Draining {
+			log.Printf("<instance> drain cancelled")
+		}
+		manager.maintenance = MaintenanceStatus{}
+		log.Println("<instance> maintenance mode disabled")
+		return manager.saveConfig()
+	}
+	if manager.maintenance.Enabled && !drain {
+		err = fmt.Errorf("already in maintenance mode since %s", manager.maintenance.Since)
+		return
+	}
+	if manager.maintenance.Draining {
+		err = fmt.Errorf("drain in progress since %s", manager.maintenance.DrainBegin)
+		return
+	}
+	if !manager.maintenance.Enabled {
+		manager.maintenance.Enabled = true
+		manager.maintenance.Since = now.Format(TimeFormatLayout)
+		log.Println("<instance> maintenance mode enabled")
+	}
+	if drain {
+		var guests []DrainGuest
+		for id, ins := range manager.instances {
+			if !ins.Running {
+				continue
+			}
+			guests = append(guests, DrainGuest{ID: id, Name: ins.Name, Priority: ins.CPUPriority, Status: DrainPending})
+		}
+		//low priority guests shut down first
+		sort.Slice(guests, func(i, j int) bool {
+			if guests[i].Priority != guests[j].Priority {
+				return guests[i].Priority > guests[j].Priority
+			}
+			return guests[i].Name < guests[j].Name
+		})
+		manager.maintenance.Guests = guests
+		manager.maintenance.DrainBegin = now.Format(TimeFormatLayout)
+		manager.maintenance.DrainEnd = ""
+		if 0 == len(guests) {
+			manager.maintenance.DrainEnd = manager.maintenance.DrainBegin
+			log.Println("<instance> no running guest to drain")
+		} else {
+			manager.maintenance.Draining = true
+			log.Printf("<instance> start draining %d guest(s)", len(guests))
+		}
+	}
+	return manager.saveConfig()
+}
+
+func (manager *InstanceManager) handleGetMaintenance(respChan chan InstanceResult) (err error) {
+	var status = manager.maintenance
+	status.Guests = make([]DrainGuest, len(manager.maintenance.Guests))
+	copy(status.Guests, manager.maintenance.Guests)
+	respChan <- InstanceResult{Maintenance: status}
+	return nil
+}
+
+// advanceDrain : gracefully stop guests in order with limited concurrency, force stop when timeout
+func (manager *InstanceManager) advanceDrain(now time.Time) {
+	if !manager.maintenance.Draining {
+		return
+	}
+	var changed = false
+	var stopping = 0
+	var guests = manager.maintenance.Guests
+	for index := range guests {
+		var guest = &guests[index]
+		if DrainStopping != guest.Status && DrainForceStopping != guest.Status {
+			continue
+		}
+		ins, exists := manager.instances[guest.ID]
+		if !exists || !ins.Running {
+			guest.Status = DrainStopped
+			changed = true
+			log.Printf("<instance> guest '%s' drained", guest.Name)
+			continue
+		}
+		if now.Sub(guest.RequestTime) < drainStopTimeout {
+			stopping++
+			continue
+		}
+		changed = true
+		if DrainForceStopping == guest.Status {
+			guest.Status = DrainFailed
+			guest.Error = "still running after force stop"
+			log.Printf("<instance> drain guest '%s' fail: %s", guest.Name, guest.Error)
+			continue
+		}
+		if err := manager.util.StopInstance(guest.ID, false, true); err != nil {
+			guest.Status = DrainFailed
+			guest.Error = err.Error()
+			log.Printf("<instance> force stop guest '%s' fail: %s", guest.Name, err.Error())
+			continue
+		}
+		guest.Status = DrainForceStopping
+		guest.RequestTime = now
+		stopping++
+		log.Printf("<instance> guest '%s' not stopped in %s, force stopping", guest.Name, drainStopTimeout)
+	}
+	for index := range guests {
+		if stopping >= drainConcurrency {
+			break
+		}
+		var guest = &guests[index]
+		if DrainPending != guest.Status {
+			continue
+		}
+		changed = true
+		ins, exists := manager.instances[guest.ID]
+		if !exists || !ins.Running {
+			guest.Status = DrainStopped
+			continue
+		}
+		if err := manager.util.StopInstance(guest.ID, false, false); err != nil {
+			guest.Status = DrainFailed
+			guest.Error = err.Error()
+			log.Printf("<instance> shutdown guest '%s' fail: %s", guest.Name, err.Error())
+			continue
+		}
+		manager.markStopRequested(guest.ID)
+		guest.Status = DrainStopping
+		guest.RequestTime = now
+		stopping++
+		log.Printf("<instance> shutting down guest '%s' for drain", guest.Name)
+	}
+	if !changed {
+		return
+	}
+	var finished = true
+	var failed = 0
+	for _, guest := range guests {
+		switch guest.Status {
+		case DrainStopped:
+		case DrainFailed:
+			failed++
+		default:
+			finished = false
+		}
+	}
+	if finished {
+		manager.maintenance.Draining = false
+		manager.maintenance.DrainEnd = now.Format(TimeFormatLayout)
+		log.Printf("<instance> drain finished, %d / %d guest(s) stopped", len(guests)-failed, len(guests))
+	}
+	if err := manager.saveConfig(); err != nil {
+		log.Printf("<instance> warning: save drain progress fail: %s", err.Error())
+	}
+}
@@ -189,6 +189,34 @@ func (value TemplateTabletModel) ToString() string {
 	}
 }
 
+type TemplateDeviceAction int
+
+const (
+	TemplateDeviceActionNone = iota
+	TemplateDeviceActionReset
+	TemplateDeviceActionPoweroff
+	TemplateDeviceActionPause
+	TemplateDeviceActionDump
+	TemplateDeviceActionInvalid
+)
+
+func (value TemplateDeviceAction) ToString() string {
+	switch value {
+	case TemplateDeviceActionNone:
+		return DeviceActionNone
+	case TemplateDeviceActionReset:
+		return DeviceActionReset
+	case TemplateDeviceActionPoweroff:
+		return DeviceActionPoweroff
+	case TemplateDeviceActionPause:
+		return DeviceActionPause
+	case TemplateDeviceActionDump:
+		return DeviceActionDump
+	default:
+		return "invalid"
+	}
+}
+
 type HardwareTemplate struct {
 	OperatingSystem string `json:"operating_system"`
 	Disk            string `json:"disk"`
@@ -197,6 +225,9 @@ type HardwareTemplate struct {
 	Control         string `json:"control"`
 	USB             string `json:"usb,omitempty"`
 	Tablet          string `json:"tablet,omitempty"`
+	//action when triggered, device disabled when empty
+	Watchdog string `json:"watchdog,omitempty"`
+	Panic    string `json:"panic,omitempty"`
 }
 
 type PolicyRuleProtocol string
@@ -219,18 +250,99 @@ const (
 	PolicyRuleActionReject
 )
 
+// direction of security rule, incoming when empty
+const (
+	PolicyRuleDirectionIn  = "in"
+	PolicyRuleDirectionOut = "out"
+)
+
 type SecurityPolicyRule struct {
 	Accept        bool               `json:"accept"`
+	Direction     string             `json:"direction,omitempty"`
 	Protocol      PolicyRuleProtocol `json:"protocol"`
 	SourceAddress string             `json:"source_address,omitempty"`
-	TargetAddress string             `json:"target_address,omitempty"`
+	TargetAddress string             `json:"target_address,omitempty"` //address or CIDR
 	TargetPort    uint               `json:"target_port"`
+	TargetPortEnd uint               `json:"target_port_end,omitempty"` //single port when zero
 	Interface     uint               `json:"interface,omitempty"`
 }
 
+// SecurityPolicy : Accept for incoming traffic, outgoing traffic accepted unless RejectEgress
 type SecurityPolicy struct {
-	Accept bool                 `json:"accept"`
-	Rules  []SecurityPolicyRule `json:"rules,omitempty"`
+	Accept       bool                 `json:"accept"`
+	RejectEgress bool                 `json:"reject_egress,omitempty"`
+	Rules        []SecurityPolicyRule `json:"rules,omitempty"`
+}
+
+func IsValidRuleDirection(direction string) bool {
+	return "" == direction || PolicyRuleDirectionIn == direction || PolicyRuleDirectionOut == direction
+}
+
+func (rule SecurityPolicyRule) IsEgress() bool {
+	return PolicyRuleDirectionOut == rule.Direction
+}
+
+// portRange : end omitted for single port
+func (rule SecurityPolicyRule) portRange() (start, end uint64) {
+	start = uint64(rule.TargetPort)
+	if rule.TargetPortEnd > rule.TargetPort {
+		end = uint64(rule.TargetPortEnd)
+	}
+	return
+}
+
+// sameMatch : match same traffic, action ignored
+func (rule SecurityPolicyRule) sameMatch(other SecurityPolicyRule) bool {
+	return rule.IsEgress() == other.IsEgress() &&
+		rule.TargetPort == other.TargetPort &&
+		rule.TargetPortEnd == other.TargetPortEnd &&
+		rule.Protocol == other.Protocol &&
+		rule.SourceAddress == other.SourceAddress &&
+		rule.TargetAddress == other.TargetAddress
+}
+
+// matchable : source and target in same address family
+func (rule SecurityPolicyRule) matchable() bool {
+	var source, _ = splitRuleAddress(rule.SourceAddress)
+	var target, _ = splitRuleAddress(rule.TargetAddress)
+	return (isRuleAddressIPv4(source) && isRuleAddressIPv4(target)) ||
+		(isRuleAddressIPv6(source) && isRuleAddressIPv6(target))
+}
+
+// String : such as "out tcp:10.0.0.5->0.0.0.0/0:25"
+func (rule SecurityPolicyRule) String() string {
+	var direction = PolicyRuleDirectionIn
+	if rule.IsEgress() {
+		direction = PolicyRuleDirectionOut
+	}
+	if start, end := rule.portRange(); 0 != end {
+		return fmt.Sprintf("%s %s:%s->%s:%d-%d", direction, rule.Protocol, rule.SourceAddress, rule.TargetAddress, start, end)
+	}
+	return fmt.Sprintf("%s %s:%s->%s:%d", direction, rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort)
+}
+
+// adjacentRule : index of nearest rule with same direction before (step -1) or after (step 1), -1 when not found
+func (policy SecurityPolicy) adjacentRule(index, step int) int {
+	var egress = policy.Rules[index].IsEgress()
+	for current := index + step; current >= 0 && current < len(policy.Rules); current += step {
+		if egress == policy.Rules[current].IsEgress() {
+			return current
+		}
+	}
+	return -1
+}
+
+// acceptAll : no filtering required
+func (policy SecurityPolicy) acceptAll() bool {
+	return policy.Accept && !policy.RejectEgress && 0 == len(policy.Rules)
+}
+
+// checkSecurityPolicy : nwfilter not available on port of Open vSwitch bridge
+func (config GuestConfig) checkSecurityPolicy() error {
+	if NetworkModePlain == config.NetworkMode && BridgeTypeOVS == GetConfigurator().GetBridgeType() {
+		return fmt.Errorf("security policy not available for instance '%s' on Open vSwitch bridge", config.Name)
+	}
+	return nil
 }
 
 type GuestConfig struct {
@@ -254,7 +366,10 @@ type GuestConfig struct {
 	StorageVolumes     []string            `json:"storage_volumes"`
 	NetworkMode        InstanceNetworkMode `json:"network_mode"`
 	NetworkSource      string              `json:"network_source"`
+	NetworkVLAN        uint                `json:"network_vlan,omitempty"`
+	NetworkVNI         uint                `json:"network_vni,omitempty"`
 	NetworkAddress     string              `json:"-"`
+	NetworkAddressV6   string              `json:"-"`
 	HardwareAddress    string              `json:"hardware_address"`
 	Ports              []uint              `json:"ports,omitempty"`
 	AuthUser           string              `json:"auth_user,omitempty"`
@@ -270,7 +385,13 @@ type GuestConfig struct {
 	AddressAllocation  string              `json:"address_allocation,omitempty"`
 	InternalAddress    string              `json:"internal_address,omitemtpy"`
 	ExternalAddress    string              `json:"external_address,omitemtpy"`
+	InternalAddressV6  string              `json:"internal_address_v6,omitempty"`
+	ExternalAddressV6  string              `json:"external_address_v6,omitempty"`
 	CPUPriority        PriorityEnum        `json:"cpu_priority,omitempty"`
+	StartOrder         int                 `json:"start_order,omitempty"`
+	RestartPolicy      RestartPolicy       `json:"restart_policy,omitempty"`
+	RestartRetries     uint                `json:"restart_retries,omitempty"` //unlimited when zero
+	CrashLooping       bool                `json:"-"`
 	WriteSpeed         uint64              `json:"write_speed,omitempty"`
 	WriteIOPS          uint64              `json:"write_iops,omitempty"`
 	ReadSpeed          uint64              `json:"read_speed,omitempty"`
@@ -292,13 +413,16 @@ type InstanceStatus struct {
 	BytesWritten    uint64
 	BytesReceived   uint64
 	BytesSent       uint64
+	ReadRequests    uint64
+	WriteRequests   uint64
 	InstanceSnapshot
 }
 
 type InstanceSnapshot struct {
-	lastCPUTimes uint64
-	lastCPUCheck time.Time
-	startTime    time.Time
+	lastCPUTimes    uint64
+	lastCPUCheck    time.Time
+	startTime       time.Time
+	lastStatsUpdate time.Time //IO counters refreshed from libvirt
 }
 
 const (
@@ -330,6 +454,7 @@ type instanceCommand struct {
 	Size            uint64
 	Name            string
 	Allocation      string
+	Address         string
 	Media           InstanceMediaConfig
 	Priority        PriorityEnum
 	ReadSpeed       uint64
@@ -343,9 +468,21 @@ type instanceCommand struct {
 	Accept          bool
 	Rule            SecurityPolicyRule
 	Enable          bool
+	Drain           bool
+	RestartPolicy   RestartPolicy
+	Retries         uint
+	Order           int
+	Timeout         time.Duration
+	Limit           time.Duration
+	Begin           time.Time
+	End             time.Time
+	Granularity     time.Duration
+	KeyCodeSet      KeyCodeSet
+	Keys            []string
 	ResultChan      chan InstanceResult
 	ErrorChan       chan error
 	AllConfigChan   chan []GuestConfig
+	AllStatusChan   chan []InstanceStatus
 	BoolChan        chan bool
 	EventChan       chan InstanceStatusChangedEvent
 }
@@ -395,6 +532,21 @@ const (
 	InsCmdPullUpSecurityPolicyRule
 	InsCmdPushDownSecurityPolicyRule
 	InsCmdSetAutoStart
+	InsCmdQueryMetrics
+	InsCmdGetAllStatus
+	InsCmdSetMaintenance
+	InsCmdGetMaintenance
+	InsCmdGetCapacity
+	InsCmdShutdownAll
+	InsCmdModifyRestartPolicy
+	InsCmdCaptureScreenshot
+	InsCmdDumpMemory
+	InsCmdQueryDiagnostics
+	InsCmdGetDiagnostic
+	InsCmdSendKeys
+	InsCmdInjectNMI
+	InsCmdModifyExternalAddress
+	InsCmdChangeEgressSecurityPolicyAction
 	InsCmdInvalid
 )
 
@@ -441,6 +593,21 @@ var instanceCommandNames = []string{
 	"PullUpSecurityPolicyRule",
 	"PushDownSecurityPolicyRule",
 	"SetAutoStart",
+	"QueryMetrics",
+	"GetAllStatus",
+	"SetMaintenance",
+	"GetMaintenance",
+	"GetCapacity",
+	"ShutdownAll",
+	"ModifyRestartPolicy",
+	"CaptureScreenshot",
+	"DumpMemory",
+	"QueryDiagnostics",
+	"GetDiagnostic",
+	"SendKeys",
+	"InjectNMI",
+	"ModifyExternalAddress",
+	"ChangeEgressSecurityPolicyAction",
 }
 
 func (c InstanceCommandType) toString() string {
@@ -454,7 +621,13 @@ type InstanceStatusChangedEvent struct {
 	ID        string
 	Event     StatusChangedEvent
 	Address   string
-	Timestamp time.Time
+	AddressV6 string
+	Action    string //action of restart policy, watchdog or panic
+	Count     uint   //retries of restart policy
+	Path      string //memory dump file
+	//MAC of conflicting host, empty when unknown
+	HardwareAddress string
+	Timestamp       time.Time
 }
 
 type StatusChangedEvent int
@@ -465,6 +638,10 @@ const (
 	AddressChanged
 	GuestCreated
 	GuestDeleted
+	GuestRestartPolicy
+	GuestWatchdogTriggered
+	GuestPanicked
+	AddressConflicted
 )
 
 const (
@@ -494,6 +671,22 @@ type InstanceManager struct {
 	randomGenerator *rand.Rand
 	runner          *framework.SimpleRunner
 	maxGuest        int
+	hostCores       uint
+	hostMemory      uint64
+	bootID          string
+	//guests shut down by cell, restored in next start
+	shutdownGuests []string
+	shutdown       *guestShutdownState
+	//guest id in order of start
+	autoStartQueue   []string
+	autoStartBooting map[string]time.Time
+	restarts         map[string]*guestRestartState
+	deviceEvents     chan guestDeviceEvent
+	dumpResults      chan guestDumpResult
+	dumpingGuests    map[string]bool
+	metricsPath      string
+	metrics          map[string]*instanceMetricsHistory
+	maintenance      MaintenanceStatus
 }
 
 func CreateInstanceManager(dataPath string, connect *libvirt.Connect) (manager *InstanceManager, err error) {
@@ -513,6 +706,13 @@ func CreateInstanceManager(dataPath string, connect *libvirt.Connect) (manager *
 	manager.dataFile = filepath.Join(dataPath, InstanceFilename)
 	manager.instances = map[string]InstanceStatus{}
 	manager.eventListeners = map[string]chan InstanceStatusChangedEvent{}
+	manager.metricsPath = filepath.Join(dataPath, metricsPathName)
+	manager.metrics = map[string]*instanceMetricsHistory{}
+	manager.autoStartBooting = map[string]time.Time{}
+	manager.restarts = map[string]*guestRestartState{}
+	manager.deviceEvents = make(chan guestDeviceEvent, DefaultQueueSize)
+	manager.dumpResults = make(chan guestDumpResult, DefaultQueueSize)
+	manager.dumpingGuests = map[string]bool{}
 	manager.defaultTemplate = HardwareTemplate{
 		OperatingSystem: TemplateOperatingSystem(TemplateOperatingSystemLinux).ToString(),
 		Disk:            TemplateDiskDriver(TemplateDiskDriverSCSI).ToString(),
@@ -526,25 +726,41 @@ func CreateInstanceManager(dataPath string, connect *libvirt.Connect) (manager *
 	if manager.util, err = CreateInstanceUtility(connect); err != nil {
 		return nil, err
 	}
+	if manager.hostCores, manager.hostMemory, err = manager.util.GetHostResource(); err != nil {
+		err = fmt.Errorf("get host resource fail: %s", err.Error())
+		return nil, err
+	}
 	if err = manager.loadConfig(); err != nil {
 		return nil, err
 	}
+	var cores, memory = manager.getCoreCapacity(), manager.getMemoryCapacity()
+	log.Printf("<instance> %d / %d cores, %d / %d MiB memory committed",
+		cores.Committed, cores.Limit, memory.Committed>>20, memory.Limit>>20)
 	return manager, nil
 }
 
 func (manager *InstanceManager) GetInstanceNetworkResources() (result map[string]InstanceNetworkResource) {
 	result = map[string]InstanceNetworkResource{}
 	for instanceID, instance := range manager.instances {
-		result[instanceID] = InstanceNetworkResource{
-			MonitorPort:     int(instance.MonitorPort),
-			HardwareAddress: instance.HardwareAddress,
-			InternalAddress: instance.InternalAddress,
-			ExternalAddress: instance.ExternalAddress,
-		}
+		result[instanceID] = instance.GuestConfig.networkResource()
 	}
 	return result
 }
 
+func (config *GuestConfig) networkResource() InstanceNetworkResource {
+	return InstanceNetworkResource{
+		MonitorPort:       int(config.MonitorPort),
+		HardwareAddress:   config.HardwareAddress,
+		InternalAddress:   config.InternalAddress,
+		ExternalAddress:   config.ExternalAddress,
+		InternalAddressV6: config.InternalAddressV6,
+		ExternalAddressV6: config.ExternalAddressV6,
+		VLAN:              config.NetworkVLAN,
+		VNI:               config.NetworkVNI,
+		Private:           NetworkModePrivate == config.NetworkMode,
+	}
+}
+
 func (manager *InstanceManager) GetInstanceVolumeResources() (result map[string][]string) {
 	result = map[string][]string{}
 	for instanceID, instance := range manager.instances {
@@ -568,6 +784,7 @@ func (manager *InstanceManager) Stop() error {
 func (manager *InstanceManager) Routine(c framework.RoutineController) {
 	log.Println("<instance> started")
 	var syncTicker = time.NewTicker(SyncInterval)
+	var callbacks = manager.registerDeviceEvents()
 	for !c.IsStopping() {
 		select {
 		case <-c.GetNotifyChannel():
@@ -575,10 +792,19 @@ func (manager *InstanceManager) Routine(c framework.RoutineController) {
 			break
 		case cmd := <-manager.commands:
 			manager.handleCommand(cmd)
+		case event := <-manager.deviceEvents:
+			manager.handleDeviceEvent(event)
+		case result := <-manager.dumpResults:
+			manager.finishGuestDump(result)
 		case <-syncTicker.C:
 			manager.syncInstanceStatus()
+			manager.advanceDrain(time.Now())
+			manager.advanceShutdown(time.Now())
+			manager.advanceAutoStart(time.Now())
+			manager.advanceRestart(time.Now())
 		}
 	}
+	manager.deregisterDeviceEvents(callbacks)
 	c.NotifyExit()
 	log.Println("<instance> stopped")
 }
@@ -603,15 +829,22 @@ func (manager *InstanceManager) syncInstanceStatus() {
 
 				log.Printf("<instance> sync instance '%s' status => running", id)
 				manager.StartCPUMonitor(&status)
+				manager.onGuestStarted(id, now)
 				manager.events <- InstanceStatusChangedEvent{ID: id, Event: InstanceStarted, Timestamp: time.Now()}
 			} else {
 				//running => stopped
 				log.Printf("<instance> sync instance '%s' status => stopped", id)
 				status.CpuUsages = 0.0
+				if history, exists := manager.metrics[id]; exists {
+					history.Reset()
+				}
 				manager.events <- InstanceStatusChangedEvent{ID: id, Event: InstanceStopped, Timestamp: time.Now()}
 			}
 			status.Running = isRunning
 			manager.instances[id] = status
+			if !isRunning {
+				manager.onGuestStopped(id, now)
+			}
 		} else if isRunning {
 			//check usage
 			if status.lastCPUCheck.Add(MinimalCPUGap).After(now) {
@@ -643,35 +876,39 @@ func (manager *InstanceManager) syncInstanceStatus() {
 			status.lastCPUTimes = times
 			status.lastCPUCheck = now
 
-			if status.NetworkAddress == "" {
+			if status.NetworkAddress == "" && status.NetworkAddressV6 == "" {
 				//check network interface
 				var elapsed = int(now.Sub(status.startTime) / SyncInterval)
 				if (NetworkCheckDivider - 1) == elapsed%NetworkCheckDivider {
 					//get ip address
-					ip, err := manager.util.GetIPv4Address(id, status.HardwareAddress)
-					if (err == nil) && (ip != "") {
+					ip, ipv6, err := manager.util.GetIPAddresses(id, status.HardwareAddress)
+					if (err == nil) && (ip != "" || ipv6 != "") {
 						status.NetworkAddress = ip
-						log.Printf("<instance> ip '%s' detected for instance '%s'", ip, status.Name)
-						manager.events <- InstanceStatusChangedEvent{ID: id, Event: AddressChanged, Address: ip, Timestamp: time.Now()}
+						status.NetworkAddressV6 = ipv6
+						log.Printf("<instance> ip '%s'/'%s' detected for instance '%s'", ip, ipv6, status.Name)
+						manager.events <- InstanceStatusChangedEvent{ID: id, Event: AddressChanged, Address: ip, AddressV6: ipv6, Timestamp: time.Now()}
 					}
 				}
 			} else {
 				//detect interval change to 2 min after established some IP
-				var currentIP = status.NetworkAddress
+				var currentIP, currentIPv6 = status.NetworkAddress, status.NetworkAddressV6
 				var elapsed = int(now.Sub(status.startTime) / SyncInterval)
 				var prolongedDivider = NetworkCheckDivider * 4 // 30s => 2min
 				if (prolongedDivider - 1) == elapsed%prolongedDivider {
 					//get ip address
-					ip, err := manager.util.GetIPv4Address(id, status.HardwareAddress)
-					if (err == nil) && (ip != currentIP) {
+					ip, ipv6, err := manager.util.GetIPAddresses(id, status.HardwareAddress)
+					if (err == nil) && (ip != currentIP || ipv6 != currentIPv6) {
 						status.NetworkAddress = ip
-						log.Printf("<instance> IP of instance '%s' changed from '%s' to '%s'", status.Name, currentIP, ip)
-						manager.events <- InstanceStatusChangedEvent{ID: id, Event: AddressChanged, Address: ip, Timestamp: time.Now()}
+						status.NetworkAddressV6 = ipv6
+						log.Printf("<instance> IP of instance '%s' changed from '%s'/'%s' to '%s'/'%s'", status.Name,
+							currentIP, currentIPv6, ip, ipv6)
+						manager.events <- InstanceStatusChangedEvent{ID: id, Event: AddressChanged, Address: ip, AddressV6: ipv6, Timestamp: time.Now()}
 					}
 				}
 			}
 
 			manager.instances[id] = status
+			manager.recordInstanceMetrics(now, status)
 		}
 	}
 }
@@ -726,6 +963,76 @@ func (manager *InstanceManager) GetAllInstance(resp chan []GuestConfig) {
 	manager.commands <- cmd
 }
 
+func (manager *InstanceManager) GetAllInstanceStatus(resp chan []InstanceStatus) {
+	manager.commands <- instanceCommand{Type: InsCmdGetAllStatus, AllStatusChan: resp}
+}
+
+// SetMaintenance : enter or leave maintenance mode, drain running guests when required
+func (manager *InstanceManager) SetMaintenance(enable, drain bool, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdSetMaintenance, Enable: enable, Drain: drain, ErrorChan: respChan}
+}
+
+func (manager *InstanceManager) GetMaintenance(respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdGetMaintenance, ResultChan: respChan}
+}
+
+// ModifyRestartPolicy : restart retries unlimited when zero
+func (manager *InstanceManager) ModifyRestartPolicy(guestID string, policy RestartPolicy, retries uint, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdModifyRestartPolicy, Instance: guestID, RestartPolicy: policy,
+		Retries: retries, ErrorChan: respChan}
+}
+
+// CaptureScreenshot : save PNG of current console to storage pool
+func (manager *InstanceManager) CaptureScreenshot(guestID string, respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdCaptureScreenshot, Instance: guestID, ResultChan: respChan}
+}
+
+// DumpMemory : save compressed memory core to storage pool, response after dump finished
+func (manager *InstanceManager) DumpMemory(guestID string, respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdDumpMemory, Instance: guestID, ResultChan: respChan}
+}
+
+// QueryDiagnostics : screenshots and memory dumps of guest
+func (manager *InstanceManager) QueryDiagnostics(guestID string, respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdQueryDiagnostics, Instance: guestID, ResultChan: respChan}
+}
+
+// GetDiagnostic : get diagnostic file of guest by name
+func (manager *InstanceManager) GetDiagnostic(guestID, name string, respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdGetDiagnostic, Instance: guestID, Name: name, ResultChan: respChan}
+}
+
+// SendKeys : send key combination to running guest, such as ctrl+alt+delete
+func (manager *InstanceManager) SendKeys(guestID string, codeSet KeyCodeSet, keys []string, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdSendKeys, Instance: guestID, KeyCodeSet: codeSet, Keys: keys,
+		ErrorChan: respChan}
+}
+
+// InjectNMI : inject non-maskable interrupt to running guest
+func (manager *InstanceManager) InjectNMI(guestID string, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdInjectNMI, Instance: guestID, ErrorChan: respChan}
+}
+
+// ModifyExternalAddress : record floating address attached by network module, empty address for detached
+func (manager *InstanceManager) ModifyExternalAddress(guestID, address string, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdModifyExternalAddress, Instance: guestID, Address: address, ErrorChan: respChan}
+}
+
+// ShutdownAllGuests : shutdown running guests before cell stop, force stop when not finished in timeout of each guest, or limit of all
+func (manager *InstanceManager) ShutdownAllGuests(timeout, limit time.Duration, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdShutdownAll, Timeout: timeout, Limit: limit, ErrorChan: respChan}
+}
+
+// GetCapacity : committed and limit of cores and memory
+func (manager *InstanceManager) GetCapacity(respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdGetCapacity, ResultChan: respChan}
+}
+
+// GetQueueDepth : count of commands waiting for handle
+func (manager *InstanceManager) GetQueueDepth() int {
+	return len(manager.commands)
+}
+
 func (manager *InstanceManager) IsInstanceRunning(id string, resp chan bool) {
 	cmd := instanceCommand{Type: InsCmdIsRunning, Instance: id, BoolChan: resp}
 	manager.commands <- cmd
@@ -743,8 +1050,9 @@ func (manager *InstanceManager) ModifyGuestMemory(id string, memory uint, resp c
 	manager.commands <- instanceCommand{Type: InsCmdModifyMemory, Instance: id, Memory: memory, ErrorChan: resp}
 }
 
-func (manager *InstanceManager) ModifyAutoStart(guestID string, enable bool, respChan chan error) {
-	manager.commands <- instanceCommand{Type: InsCmdSetAutoStart, Instance: guestID, Enable: enable, ErrorChan: respChan}
+// ModifyAutoStart : order of auto start unchanged when negative
+func (manager *InstanceManager) ModifyAutoStart(guestID string, enable bool, order int, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdSetAutoStart, Instance: guestID, Enable: enable, Order: order, ErrorChan: respChan}
 }
 
 func (manager *InstanceManager) ModifyCPUPriority(guestID string, priority PriorityEnum, resp chan error) {
@@ -840,6 +1148,11 @@ func (manager *InstanceManager) ChangeDefaultSecurityPolicyAction(instanceID str
 	manager.commands <- instanceCommand{Type: InsCmdChangeDefaultSecurityPolicyAction, Instance: instanceID, Accept: accept, ErrorChan: respChan}
 }
 
+// ChangeEgressSecurityPolicyAction : default action of outgoing traffic not matched by any rule
+func (manager *InstanceManager) ChangeEgressSecurityPolicyAction(instanceID string, accept bool, respChan chan error) {
+	manager.commands <- instanceCommand{Type: InsCmdChangeEgressSecurityPolicyAction, Instance: instanceID, Accept: accept, ErrorChan: respChan}
+}
+
 func (manager *InstanceManager) PullUpSecurityPolicyRule(instanceID string, index int, respChan chan error) {
 	manager.commands <- instanceCommand{Type: InsCmdPullUpSecurityPolicyRule, Instance: instanceID, Index: index, ErrorChan: respChan}
 }
@@ -848,11 +1161,19 @@ func (manager *InstanceManager) PushDownSecurityPolicyRule(instanceID string, in
 	manager.commands <- instanceCommand{Type: InsCmdPushDownSecurityPolicyRule, Instance: instanceID, Index: index, ErrorChan: respChan}
 }
 
+func (manager *InstanceManager) QueryInstanceMetrics(instanceID string, begin, end time.Time, granularity time.Duration, respChan chan InstanceResult) {
+	manager.commands <- instanceCommand{Type: InsCmdQueryMetrics, Instance: instanceID, Begin: begin, End: end, Granularity: granularity, ResultChan: respChan}
+}
+
 type instanceDataConfig struct {
-	Instances   []GuestConfig `json:"instances"`
-	StoragePool string        `json:"storage_pool,omitempty"`
-	StorageURL  string        `json:"storage_url,omitempty"`
-	MaxGuest    int           `json:"max_guest,omitempty"`
+	Instances   []GuestConfig      `json:"instances"`
+	StoragePool string             `json:"storage_pool,omitempty"`
+	StorageURL  string             `json:"storage_url,omitempty"`
+	MaxGuest    int                `json:"max_guest,omitempty"`
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+	//detect host reboot for auto start
+	BootID         string   `json:"boot_id,omitempty"`
+	ShutdownGuests []string `json:"shutdown_guests,omitempty"`
 }
 
 func (manager *InstanceManager) saveInstanceConfig(instanceID string) (err error) {
@@ -902,6 +1223,11 @@ func (manager *InstanceManager) saveConfig() error {
 	config.StoragePool = manager.storagePool
 	config.StorageURL = manager.storageURL
 	config.MaxGuest = manager.maxGuest
+	if manager.maintenance.Enabled {
+		config.Maintenance = &manager.maintenance
+	}
+	config.BootID = manager.bootID
+	config.ShutdownGuests = manager.shutdownGuests
 	data, err := json.MarshalIndent(config, "", " ")
 	if err != nil {
 		return err
@@ -922,6 +1248,7 @@ func (manager *InstanceManager) loadConfig() error {
 	if _, err := os.Stat(manager.dataFile); os.IsNotExist(err) {
 		log.Printf("<instance> no instance configured, default max guest %d, using local storage pool %s",
 			manager.maxGuest, manager.storagePool)
+		manager.prepareAutoStart("", nil)
 		return nil
 	}
 	data, err := os.ReadFile(manager.dataFile)
@@ -936,6 +1263,14 @@ func (manager *InstanceManager) loadConfig() error {
 	if config.MaxGuest > 0 {
 		manager.maxGuest = config.MaxGuest
 	}
+	if nil != config.Maintenance {
+		manager.maintenance = *config.Maintenance
+		if manager.maintenance.Draining {
+			log.Printf("<instance> in maintenance mode since %s, resume draining", manager.maintenance.Since)
+		} else {
+			log.Printf("<instance> in maintenance mode since %s", manager.maintenance.Since)
+		}
+	}
 	for _, ins := range config.Instances {
 		var realStatus InstanceStatus
 		realStatus, err = manager.util.GetInstanceStatus(ins.ID)
@@ -969,6 +1304,36 @@ func (manager *InstanceManager) loadConfig() error {
 	} else {
 		log.Printf("<instance> using local storage pool '%s'", manager.storagePool)
 	}
+	//auto start managed by cell instead of libvirt
+	for id, ins := range manager.instances {
+		if changed, err := manager.util.DisableLibvirtAutoStart(id); err != nil {
+			log.Printf("<instance> warning: disable libvirt auto start of guest '%s' fail: %s", ins.Name, err.Error())
+		} else if changed {
+			log.Printf("<instance> libvirt auto start of guest '%s' disabled", ins.Name)
+		}
+		var monitorProtocol = RemoteControlVNC
+		if nil != ins.Template {
+			monitorProtocol = ins.Template.Control
+		}
+		if changed, err := manager.util.UpdateRemoteControl(id, monitorProtocol, ins.MonitorPort, ins.MonitorSecret); err != nil {
+			log.Printf("<instance> warning: update remote control of guest '%s' fail: %s", ins.Name, err.Error())
+		} else if changed {
+			log.Printf("<instance> remote control of guest '%s' updated, take effect in next start", ins.Name)
+		}
+		if nil != ins.Template && DeviceActionReset == ins.Template.Panic {
+			//reset by libvirt when panic
+			continue
+		}
+		if changed, err := manager.util.DisableLibvirtCrashRestart(id); err != nil {
+			log.Printf("<instance> warning: disable libvirt crash restart of guest '%s' fail: %s", ins.Name, err.Error())
+		} else if changed {
+			log.Printf("<instance> crash of guest '%s' handled by restart policy", ins.Name)
+		}
+	}
+	manager.prepareAutoStart(config.BootID, config.ShutdownGuests)
+	if config.BootID != manager.bootID || 0 != len(config.ShutdownGuests) {
+		return manager.saveConfig()
+	}
 	return nil
 }
 
@@ -1056,7 +1421,37 @@ func (manager *InstanceManager) handleCommand(cmd instanceCommand) {
 	case InsCmdPushDownSecurityPolicyRule:
 		err = manager.handlePushDownSecurityPolicyRule(cmd.Instance, cmd.Index, cmd.ErrorChan)
 	case InsCmdSetAutoStart:
-		err = manager.handleModifyAutoStart(cmd.Instance, cmd.Enable, cmd.ErrorChan)
+		err = manager.handleModifyAutoStart(cmd.Instance, cmd.Enable, cmd.Order, cmd.ErrorChan)
+	case InsCmdQueryMetrics:
+		err = manager.handleQueryInstanceMetrics(cmd.Instance, cmd.Begin, cmd.End, cmd.Granularity, cmd.ResultChan)
+	case InsCmdGetAllStatus:
+		err = manager.handleGetAllStatus(cmd.AllStatusChan)
+	case InsCmdSetMaintenance:
+		err = manager.handleSetMaintenance(cmd.Enable, cmd.Drain, cmd.ErrorChan)
+	case InsCmdGetMaintenance:
+		err = manager.handleGetMaintenance(cmd.ResultChan)
+	case InsCmdGetCapacity:
+		err = manager.handleGetCapacity(cmd.ResultChan)
+	case InsCmdShutdownAll:
+		err = manager.handleShutdownAllGuests(cmd.Timeout, cmd.Limit, cmd.ErrorChan)
+	case InsCmdModifyRestartPolicy:
+		err = manager.handleModifyRestartPolicy(cmd.Instance, cmd.RestartPolicy, cmd.Retries, cmd.ErrorChan)
+	case InsCmdCaptureScreenshot:
+		err = manager.handleCaptureScreenshot(cmd.Instance, cmd.ResultChan)
+	case InsCmdDumpMemory:
+		err = manager.handleDumpMemory(cmd.Instance, cmd.ResultChan)
+	case InsCmdQueryDiagnostics:
+		err = manager.handleQueryDiagnostics(cmd.Instance, cmd.ResultChan)
+	case InsCmdGetDiagnostic:
+		err = manager.handleGetDiagnostic(cmd.Instance, cmd.Name, cmd.ResultChan)
+	case InsCmdSendKeys:
+		err = manager.handleSendKeys(cmd.Instance, cmd.KeyCodeSet, cmd.Keys, cmd.ErrorChan)
+	case InsCmdInjectNMI:
+		err = manager.handleInjectNMI(cmd.Instance, cmd.ErrorChan)
+	case InsCmdModifyExternalAddress:
+		err = manager.handleModifyExternalAddress(cmd.Instance, cmd.Address, cmd.ErrorChan)
+	case InsCmdChangeEgressSecurityPolicyAction:
+		err = manager.handleChangeEgressSecurityPolicyAction(cmd.Instance, cmd.Accept, cmd.ErrorChan)
 	default:
 		log.Printf("<instance> unsupported command type %d", cmd.Type)
 	}
@@ -1066,6 +1461,10 @@ func (manager *InstanceManager) handleCommand(cmd instanceCommand) {
 }
 
 func (manager *InstanceManager) handleCreateInstance(config GuestConfig, resp chan error) error {
+	if err := manager.checkMaintenance(); err != nil {
+		resp <- err
+		return err
+	}
 	if config.ID == "" {
 		err := errors.New("instance id required")
 		resp <- err
@@ -1076,6 +1475,16 @@ func (manager *InstanceManager) handleCreateInstance(config GuestConfig, resp ch
 		resp <- err
 		return err
 	}
+	if err := manager.admitResource(config.Cores, uint64(config.Memory)); err != nil {
+		resp <- err
+		return err
+	}
+	if nil != config.Security && !config.Security.acceptAll() {
+		if err := config.checkSecurityPolicy(); err != nil {
+			resp <- err
+			return err
+		}
+	}
 	if nil == config.Template {
 		config.Template = &manager.defaultTemplate
 		log.Printf("<instance> using default template for instance '%s'", config.Name)
@@ -1101,6 +1510,9 @@ func (manager *InstanceManager) handleDeleteInstance(id string, resp chan error)
 	delete(manager.instances, id)
 	log.Printf("<instance> instance '%s' deleted", id)
 	resp <- nil
+	if err = manager.removeMetricsHistory(id); err != nil {
+		log.Printf("<instance> warning: remove metrics of instance '%s' fail: %s", id, err.Error())
+	}
 	return manager.removeInstanceConfig(id)
 }
 
@@ -1138,19 +1550,20 @@ func (manager *InstanceManager) handleGetInstanceStatus(id string, resp chan Ins
 
 			log.Printf("<instance> detected instance started when get status of '%s'", ins.Name)
 			manager.events <- InstanceStatusChangedEvent{ID: id, Event: InstanceStarted, Timestamp: time.Now()}
+			manager.onGuestStarted(id, time.Now())
 		} else {
 			//running => stopped
 			log.Printf("<instance> detected instance stopped when get status of '%s'", ins.Name)
 			manager.events <- InstanceStatusChangedEvent{ID: id, Event: InstanceStopped, Timestamp: time.Now()}
 		}
 		ins.Running = status.Running
+		if !status.Running {
+			manager.instances[id] = ins
+			manager.onGuestStopped(id, time.Now())
+			ins.CrashLooping = manager.instances[id].CrashLooping
+		}
 	}
-	ins.AvailableMemory = status.AvailableMemory
-	ins.AvailableDisk = status.AvailableDisk
-	ins.BytesRead = status.BytesRead
-	ins.BytesWritten = status.BytesWritten
-	ins.BytesReceived = status.BytesReceived
-	ins.BytesSent = status.BytesSent
+	ins.updateCounters(status, time.Now())
 	//update
 	manager.instances[id] = ins
 	resp <- InstanceResult{Instance: ins}
@@ -1168,6 +1581,10 @@ func (manager *InstanceManager) handleIsInstanceRunning(id string, respChan chan
 }
 
 func (manager *InstanceManager) handleStartInstance(id string, resp chan error) error {
+	if err := manager.checkMaintenance(); err != nil {
+		resp <- err
+		return err
+	}
 	ins, exist := manager.instances[id]
 	if !exist {
 		err := fmt.Errorf("invalid instance '%s'", id)
@@ -1179,7 +1596,7 @@ func (manager *InstanceManager) handleStartInstance(id string, resp chan error)
 		resp <- err
 		return err
 	}
-	if err := manager.util.StartInstance(id); err != nil {
+	if err := manager.util.StartInstance(id, ins.NetworkVLAN); err != nil {
 		resp <- err
 		return err
 	}
@@ -1187,11 +1604,16 @@ func (manager *InstanceManager) handleStartInstance(id string, resp chan error)
 	ins.Running = true
 	_ = manager.StartCPUMonitor(&ins)
 	manager.instances[id] = ins
+	manager.resetRestart(id, time.Now())
 	resp <- nil
 	return nil
 }
 
 func (manager *InstanceManager) handleStartInstanceWithMedia(id string, media InstanceMediaConfig, resp chan error) error {
+	if err := manager.checkMaintenance(); err != nil {
+		resp <- err
+		return err
+	}
 	ins, exist := manager.instances[id]
 	if !exist {
 		err := fmt.Errorf("invalid instance '%s'", id)
@@ -1204,7 +1626,7 @@ func (manager *InstanceManager) handleStartInstanceWithMedia(id string, media In
 		return err
 	}
 	var resourceURI = manager.apiPath(fmt.Sprintf("/%s/%s/file/", MediaImagePath, media.ID))
-	if err := manager.util.StartInstanceWithMedia(id, media.Host, resourceURI, media.Port); err != nil {
+	if err := manager.util.StartInstanceWithMedia(id, media.Host, resourceURI, media.Port, ins.NetworkVLAN); err != nil {
 		resp <- err
 		return err
 	}
@@ -1216,6 +1638,7 @@ func (manager *InstanceManager) handleStartInstanceWithMedia(id string, media In
 	ins.MediaSource = media.ID
 	manager.StartCPUMonitor(&ins)
 	manager.instances[id] = ins
+	manager.resetRestart(id, time.Now())
 	resp <- nil
 	return nil
 }
@@ -1236,6 +1659,9 @@ func (manager *InstanceManager) handleStopInstance(id string, reboot, force bool
 		resp <- err
 		return err
 	}
+	if !reboot {
+		manager.markStopRequested(id)
+	}
 	if !reboot {
 		running, err := manager.util.IsInstanceRunning(id)
 		if err != nil {
@@ -1268,6 +1694,15 @@ func (manager *InstanceManager) handleGetAllConfig(respChan chan []GuestConfig)
 	return nil
 }
 
+func (manager *InstanceManager) handleGetAllStatus(respChan chan []InstanceStatus) error {
+	var result = make([]InstanceStatus, 0, len(manager.instances))
+	for _, ins := range manager.instances {
+		result = append(result, ins)
+	}
+	respChan <- result
+	return nil
+}
+
 func (manager *InstanceManager) handleModifyGuestName(id, name string, resp chan error) (err error) {
 	ins, exists := manager.instances[id]
 	if !exists {
@@ -1299,6 +1734,12 @@ func (manager *InstanceManager) handleModifyGuestCore(id string, core uint, resp
 		resp <- err
 		return err
 	}
+	if core > current.Cores {
+		if err = manager.admitResource(core-current.Cores, 0); err != nil {
+			resp <- err
+			return err
+		}
+	}
 
 	if core > current.Cores {
 		//update topology
@@ -1331,6 +1772,12 @@ func (manager *InstanceManager) handleModifyGuestMemory(id string, memory uint,
 		resp <- err
 		return err
 	}
+	if memory > current.Memory {
+		if err = manager.admitResource(0, uint64(memory-current.Memory)); err != nil {
+			resp <- err
+			return err
+		}
+	}
 	if err = manager.util.ModifyMemory(id, memory, false); err != nil {
 		resp <- err
 		return err
@@ -1342,26 +1789,26 @@ func (manager *InstanceManager) handleModifyGuestMemory(id string, memory uint,
 	return manager.saveInstanceConfig(id)
 }
 
-func (manager *InstanceManager) handleModifyAutoStart(guestID string, enable bool, respChan chan error) (err error) {
+func (manager *InstanceManager) handleModifyAutoStart(guestID string, enable bool, order int, respChan chan error) (err error) {
 	current, exists := manager.instances[guestID]
 	if !exists {
 		err = fmt.Errorf("invalid guest '%s'", guestID)
 		respChan <- err
 		return
 	}
-	if current.AutoStart == enable {
-		err = errors.New("no need to change")
-		respChan <- err
-		return
+	if order < 0 {
+		order = current.StartOrder
 	}
-	if err = manager.util.ModifyAutoStart(guestID, enable); err != nil {
+	if current.AutoStart == enable && current.StartOrder == order {
+		err = errors.New("no need to change")
 		respChan <- err
 		return
 	}
 	current.AutoStart = enable
+	current.StartOrder = order
 	manager.instances[guestID] = current
 	if enable {
-		log.Printf("<instance> guest '%s' enable auto start", current.Name)
+		log.Printf("<instance> guest '%s' enable auto start, order %d", current.Name, order)
 	} else {
 		log.Printf("<instance> guest '%s' disable auto start", current.Name)
 	}
@@ -1370,6 +1817,29 @@ func (manager *InstanceManager) handleModifyAutoStart(guestID string, enable boo
 	return manager.saveInstanceConfig(guestID)
 }
 
+func (manager *InstanceManager) handleModifyExternalAddress(guestID, address string, respChan chan error) (err error) {
+	current, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		respChan <- err
+		return
+	}
+	if current.ExternalAddress == address {
+		err = errors.New("no need to change")
+		respChan <- err
+		return
+	}
+	current.ExternalAddress = address
+	manager.instances[guestID] = current
+	if "" == address {
+		log.Printf("<instance> external address of guest '%s' removed", current.Name)
+	} else {
+		log.Printf("<instance> external address of guest '%s' changed to %s", current.Name, address)
+	}
+	respChan <- nil
+	return manager.saveInstanceConfig(guestID)
+}
+
 func (manager *InstanceManager) handleModifyCPUPriority(guestID string, priority PriorityEnum, resp chan error) (err error) {
 	currentGuest, exists := manager.instances[guestID]
 	if !exists {
@@ -1632,7 +2102,7 @@ func (manager *InstanceManager) handleGetNetworkResources(instances []string, re
 	var sharedStorageEnabled = DefaultLocalPoolName != manager.storagePool
 	for _, instanceID := range instances {
 		if status, exists := manager.instances[instanceID]; exists {
-			result[instanceID] = InstanceNetworkResource{int(status.MonitorPort), status.HardwareAddress, status.InternalAddress, status.ExternalAddress}
+			result[instanceID] = status.GuestConfig.networkResource()
 		} else if !sharedStorageEnabled {
 			err = fmt.Errorf("shared storage required for load meta data for instance '%s'", instanceID)
 			respChan <- InstanceResult{Error: err}
@@ -1656,7 +2126,7 @@ func (manager *InstanceManager) handleGetNetworkResources(instances []string, re
 				respChan <- InstanceResult{Error: err}
 				return err
 			}
-			result[instanceID] = InstanceNetworkResource{int(ins.MonitorPort), ins.HardwareAddress, ins.InternalAddress, ins.ExternalAddress}
+			result[instanceID] = ins.GuestConfig.networkResource()
 		}
 	}
 	respChan <- InstanceResult{NetworkResources: result}
@@ -1771,7 +2241,7 @@ func (manager *InstanceManager) handleMigrateInstances(instances []string, respC
 		}
 		//start autostart instance in share storage
 		if ins.AutoStart && !ins.Running {
-			if err = manager.util.StartInstance(instanceID); err != nil {
+			if err = manager.util.StartInstance(instanceID, ins.NetworkVLAN); err != nil {
 				log.Printf("<instance> start migrated instance '%s'('%s') fail: %s", ins.Name, instanceID, err.Error())
 				respChan <- err
 				return err
@@ -1888,18 +2358,24 @@ func (manager *InstanceManager) handleAddSecurityPolicyRule(instanceID string, r
 		respChan <- err
 		return
 	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
 	if nil == instance.Security {
 		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
 		respChan <- err
 		return
 	}
+	if !rule.matchable() {
+		err = fmt.Errorf("address family of source and target not match: %s", rule.String())
+		respChan <- err
+		return
+	}
 	for currentIndex, currentRule := range instance.Security.Rules {
-		if currentRule.TargetPort == rule.TargetPort &&
-			currentRule.Protocol == rule.Protocol &&
-			currentRule.SourceAddress == rule.SourceAddress &&
-			currentRule.TargetAddress == rule.TargetAddress {
-			err = fmt.Errorf("%s:%s->%s:%d already defined on %dth rule of instance '%s'",
-				rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort, currentIndex, instance.Name)
+		if currentRule.sameMatch(rule) {
+			err = fmt.Errorf("%s already defined on %dth rule of instance '%s'",
+				rule.String(), currentIndex, instance.Name)
 			respChan <- err
 			return
 		}
@@ -1912,11 +2388,9 @@ func (manager *InstanceManager) handleAddSecurityPolicyRule(instanceID string, r
 	}
 	manager.instances[instanceID] = instance
 	if rule.Accept {
-		log.Printf("<instance> %s:%s->%s:%d enabled on instance '%s'",
-			rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort, instance.Name)
+		log.Printf("<instance> %s enabled on instance '%s'", rule.String(), instance.Name)
 	} else {
-		log.Printf("<instance> %s:%s->%s:%d disabled on instance '%s'",
-			rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort, instance.Name)
+		log.Printf("<instance> %s disabled on instance '%s'", rule.String(), instance.Name)
 	}
 
 	respChan <- nil
@@ -1931,21 +2405,27 @@ func (manager *InstanceManager) handleModifySecurityPolicyRule(instanceID string
 		respChan <- err
 		return
 	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
 	if nil == instance.Security {
 		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
 		respChan <- err
 		return
 	}
+	if !rule.matchable() {
+		err = fmt.Errorf("address family of source and target not match: %s", rule.String())
+		respChan <- err
+		return
+	}
 	if index >= len(instance.Security.Rules) {
 		err = fmt.Errorf("invalid rule index %d for instance %s", index, instance.Name)
 		respChan <- err
 		return
 	}
 	for currentIndex, currentRule := range instance.Security.Rules {
-		if currentRule.TargetPort == rule.TargetPort &&
-			currentRule.Protocol == rule.Protocol &&
-			currentRule.SourceAddress == rule.SourceAddress &&
-			currentRule.TargetAddress == rule.TargetAddress {
+		if currentRule.sameMatch(rule) {
 			if index == currentIndex {
 				if rule.Accept == currentRule.Accept {
 					err = errors.New("no need to change")
@@ -1953,8 +2433,8 @@ func (manager *InstanceManager) handleModifySecurityPolicyRule(instanceID string
 					return
 				}
 			} else {
-				err = fmt.Errorf("%s:%s->%s:%d already defined on %dth rule of instance '%s'",
-					rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort, currentIndex, instance.Name)
+				err = fmt.Errorf("%s already defined on %dth rule of instance '%s'",
+					rule.String(), currentIndex, instance.Name)
 				respChan <- err
 				return
 			}
@@ -1969,11 +2449,9 @@ func (manager *InstanceManager) handleModifySecurityPolicyRule(instanceID string
 	}
 	manager.instances[instanceID] = instance
 	if rule.Accept {
-		log.Printf("<instance> %s:%s->%s:%d enabled on instance '%s'",
-			rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort, instance.Name)
+		log.Printf("<instance> %s enabled on instance '%s'", rule.String(), instance.Name)
 	} else {
-		log.Printf("<instance> %s:%s->%s:%d disabled on instance '%s'",
-			rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort, instance.Name)
+		log.Printf("<instance> %s disabled on instance '%s'", rule.String(), instance.Name)
 	}
 
 	respChan <- nil
@@ -1988,6 +2466,10 @@ func (manager *InstanceManager) handleRemoveSecurityPolicyRule(instanceID string
 		respChan <- err
 		return
 	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
 	if nil == instance.Security {
 		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
 		respChan <- err
@@ -2024,6 +2506,10 @@ func (manager *InstanceManager) handleChangeDefaultSecurityPolicyAction(instance
 		respChan <- err
 		return
 	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
 	if nil == instance.Security {
 		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
 		respChan <- err
@@ -2051,6 +2537,45 @@ func (manager *InstanceManager) handleChangeDefaultSecurityPolicyAction(instance
 	return manager.saveConfig()
 }
 
+func (manager *InstanceManager) handleChangeEgressSecurityPolicyAction(instanceID string, accept bool, respChan chan error) (err error) {
+	var instance InstanceStatus
+	var exists bool
+	if instance, exists = manager.instances[instanceID]; !exists {
+		err = fmt.Errorf("invalid instance '%s'", instanceID)
+		respChan <- err
+		return
+	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
+	if nil == instance.Security {
+		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
+		respChan <- err
+		return
+	} else if instance.Security.RejectEgress == !accept {
+		err = errors.New("no need to change")
+		respChan <- err
+		return
+	} else {
+		instance.Security.RejectEgress = !accept
+	}
+	if err = manager.util.SyncDomainNwfilter(instanceID, instance.Security); err != nil {
+		err = fmt.Errorf("sync nwfilter of instance '%s' fail: %s", instance.Name, err.Error())
+		respChan <- err
+		return
+	}
+	manager.instances[instanceID] = instance
+	if accept {
+		log.Printf("<instance> instance '%s' accept outgoing connections by default", instance.Name)
+	} else {
+		log.Printf("<instance> instance '%s' reject outgoing connections by default", instance.Name)
+	}
+
+	respChan <- nil
+	return manager.saveConfig()
+}
+
 func (manager *InstanceManager) handlePullUpSecurityPolicyRule(instanceID string, index int, respChan chan error) (err error) {
 	var instance InstanceStatus
 	var exists bool
@@ -2059,6 +2584,10 @@ func (manager *InstanceManager) handlePullUpSecurityPolicyRule(instanceID string
 		respChan <- err
 		return
 	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
 	if nil == instance.Security {
 		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
 		respChan <- err
@@ -2069,14 +2598,17 @@ func (manager *InstanceManager) handlePullUpSecurityPolicyRule(instanceID string
 		err = fmt.Errorf("invalid rule index %d for instance '%s'", index, instance.Name)
 		respChan <- err
 		return
-	} else if 0 == index {
+	}
+	//rules of other direction not affected by order
+	var previousIndex = instance.Security.adjacentRule(index, -1)
+	if previousIndex < 0 {
 		err = errors.New("already on top")
 		respChan <- err
 		return
 	}
 	//swap
-	var previous = instance.Security.Rules[index-1]
-	instance.Security.Rules[index-1] = instance.Security.Rules[index]
+	var previous = instance.Security.Rules[previousIndex]
+	instance.Security.Rules[previousIndex] = instance.Security.Rules[index]
 	instance.Security.Rules[index] = previous
 	if err = manager.util.SyncDomainNwfilter(instanceID, instance.Security); err != nil {
 		err = fmt.Errorf("sync nwfilter of instance '%s' fail: %s", instance.Name, err.Error())
@@ -2097,6 +2629,10 @@ func (manager *InstanceManager) handlePushDownSecurityPolicyRule(instanceID stri
 		respChan <- err
 		return
 	}
+	if err = instance.checkSecurityPolicy(); err != nil {
+		respChan <- err
+		return
+	}
 	if nil == instance.Security {
 		err = fmt.Errorf("no security policy available for instance '%s'", instance.Name)
 		respChan <- err
@@ -2108,14 +2644,15 @@ func (manager *InstanceManager) handlePushDownSecurityPolicyRule(instanceID stri
 		respChan <- err
 		return
 	}
-	if ruleCount-1 == index {
+	var nextIndex = instance.Security.adjacentRule(index, 1)
+	if nextIndex < 0 {
 		err = errors.New("already on bottom")
 		respChan <- err
 		return
 	}
 	//swap
-	var next = instance.Security.Rules[index+1]
-	instance.Security.Rules[index+1] = instance.Security.Rules[index]
+	var next = instance.Security.Rules[nextIndex]
+	instance.Security.Rules[nextIndex] = instance.Security.Rules[index]
 	instance.Security.Rules[index] = next
 	if err = manager.util.SyncDomainNwfilter(instanceID, instance.Security); err != nil {
 		err = fmt.Errorf("sync nwfilter of instance '%s' fail: %s", instance.Name, err.Error())
@@ -2128,6 +2665,27 @@ func (manager *InstanceManager) handlePushDownSecurityPolicyRule(instanceID stri
 	return manager.saveConfig()
 }
 
+func (manager *InstanceManager) handleQueryInstanceMetrics(instanceID string, begin, end time.Time, granularity time.Duration,
+	respChan chan InstanceResult) (err error) {
+	if _, exists := manager.instances[instanceID]; !exists {
+		err = fmt.Errorf("invalid instance '%s'", instanceID)
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	if !begin.Before(end) {
+		err = fmt.Errorf("invalid time range %s ~ %s", begin.Format(TimeFormatLayout), end.Format(TimeFormatLayout))
+		respChan <- InstanceResult{Error: err}
+		return
+	}
+	var resolution = GetConfigurator().GetMetricsResolution()
+	if granularity < resolution {
+		granularity = resolution
+	}
+	var history = manager.getMetricsHistory(instanceID)
+	respChan <- InstanceResult{Metrics: history.Query(begin, end, granularity)}
+	return nil
+}
+
 func (config *GuestConfig) Marshal(message framework.Message) error {
 	message.SetString(framework.ParamKeyName, config.Name)
 	message.SetString(framework.ParamKeyInstance, config.ID)
@@ -2156,13 +2714,31 @@ func (config *GuestConfig) Marshal(message framework.Message) error {
 
 	message.SetUInt(framework.ParamKeyMonitor, config.MonitorPort)
 	message.SetString(framework.ParamKeySecret, config.MonitorSecret)
+	//protocol, security and fingerprint of certificate
+	var monitorProtocol = RemoteControlVNC
+	if nil != config.Template {
+		monitorProtocol = config.Template.Control
+	}
+	if enabled, fingerprint := GetConfigurator().GetConsoleTLS(); enabled && RemoteControlSPICE == monitorProtocol {
+		message.SetStringArray(framework.ParamKeyProtocol, []string{monitorProtocol, MonitorSecurityTLS, fingerprint})
+	} else {
+		message.SetStringArray(framework.ParamKeyProtocol, []string{monitorProtocol, MonitorSecurityNone, ""})
+	}
 	message.SetUInt(framework.ParamKeyMemory, config.Memory)
 	message.SetUIntArray(framework.ParamKeyDisk, config.Disks)
 	message.SetString(framework.ParamKeyAddress, config.NetworkAddress)
+	//detected and assigned IPv6 address, not mixed with IPv4 address in ParamKeyAddress
+	message.SetStringArray(framework.ParamKeyNetwork, []string{config.NetworkAddressV6, config.InternalAddressV6, config.ExternalAddressV6})
 	message.SetString(framework.ParamKeyCreate, config.CreateTime)
 	message.SetString(framework.ParamKeyHardware, config.HardwareAddress)
 	//QoS
 	message.SetUInt(framework.ParamKeyPriority, uint(config.CPUPriority))
+	//restart policy, max retries, crash looping
+	var crashLooping uint64 = 0
+	if config.CrashLooping {
+		crashLooping = 1
+	}
+	message.SetUIntArray(framework.ParamKeyAction, []uint64{uint64(config.RestartPolicy), uint64(config.RestartRetries), crashLooping})
 	message.SetUIntArray(framework.ParamKeyLimit, []uint64{config.ReadSpeed, config.WriteSpeed, config.ReadIOPS,
 		config.WriteIOPS, config.ReceiveSpeed, config.SendSpeed})
 	return nil
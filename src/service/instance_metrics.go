@@ -0,0 +1,341 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InstanceMetrics : resource usage of an instance during one sample period
+type InstanceMetrics struct {
+	Timestamp       time.Time `json:"timestamp"`
+	CpuUsage        float64   `json:"cpu_usage"`
+	AvailableMemory uint64    `json:"available_memory"`
+	BytesRead       uint64    `json:"bytes_read"`
+	BytesWritten    uint64    `json:"bytes_written"`
+	ReadIOPS        uint64    `json:"read_iops"`
+	WriteIOPS       uint64    `json:"write_iops"`
+	BytesReceived   uint64    `json:"bytes_received"`
+	BytesSent       uint64    `json:"bytes_sent"`
+}
+
+const (
+	MetricsRollupInterval = 5 * time.Minute
+	MetricsRollupCapacity = 7 * 24 * int(time.Hour/MetricsRollupInterval) //keep rollups of 7 days
+	metricsPathName       = "metrics"
+	metricsRollupSuffix   = "rollup"
+)
+
+// metricsRing : fixed size buffer, oldest sample overwritten when full
+type metricsRing struct {
+	samples []InstanceMetrics
+	next    int
+	count   int
+}
+
+func newMetricsRing(capacity int) *metricsRing {
+	return &metricsRing{samples: make([]InstanceMetrics, capacity)}
+}
+
+func (ring *metricsRing) Push(sample InstanceMetrics) {
+	var capacity = len(ring.samples)
+	if 0 == capacity {
+		return
+	}
+	ring.samples[ring.next] = sample
+	ring.next = (ring.next + 1) % capacity
+	if ring.count < capacity {
+		ring.count++
+	}
+}
+
+// Oldest : timestamp of oldest sample, zero when empty
+func (ring *metricsRing) Oldest() time.Time {
+	if 0 == ring.count {
+		return time.Time{}
+	}
+	return ring.samples[ring.offset(0)].Timestamp
+}
+
+// Query : samples with timestamp in [begin, end), in chronological order
+func (ring *metricsRing) Query(begin, end time.Time) (result []InstanceMetrics) {
+	for index := 0; index < ring.count; index++ {
+		var sample = ring.samples[ring.offset(index)]
+		if sample.Timestamp.Before(begin) || !sample.Timestamp.Before(end) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+func (ring *metricsRing) All() []InstanceMetrics {
+	var result = make([]InstanceMetrics, 0, ring.count)
+	for index := 0; index < ring.count; index++ {
+		result = append(result, ring.samples[ring.offset(index)])
+	}
+	return result
+}
+
+func (ring *metricsRing) offset(index int) int {
+	var capacity = len(ring.samples)
+	return (ring.next - ring.count + index + capacity) % capacity
+}
+
+type instanceIOCounters struct {
+	BytesRead     uint64
+	BytesWritten  uint64
+	ReadRequests  uint64
+	WriteRequests uint64
+	BytesReceived uint64
+	BytesSent     uint64
+}
+
+type instanceMetricsHistory struct {
+	samples       *metricsRing
+	rollups       *metricsRing
+	lastRecord    time.Time
+	lastCounters  instanceIOCounters
+	countersReady bool
+	rollupBucket  time.Time
+}
+
+func newInstanceMetricsHistory(capacity int, rollupEnabled bool) *instanceMetricsHistory {
+	var history = instanceMetricsHistory{samples: newMetricsRing(capacity)}
+	if rollupEnabled {
+		history.rollups = newMetricsRing(MetricsRollupCapacity)
+	}
+	return &history
+}
+
+// Reset : forget IO counters, invoked when instance stopped
+func (history *instanceMetricsHistory) Reset() {
+	history.countersReady = false
+}
+
+// Record : compute sample from current status, returns true when a new rollup generated
+func (history *instanceMetricsHistory) Record(now time.Time, status InstanceStatus) (rollup bool) {
+	var current = instanceIOCounters{
+		BytesRead:     status.BytesRead,
+		BytesWritten:  status.BytesWritten,
+		ReadRequests:  status.ReadRequests,
+		WriteRequests: status.WriteRequests,
+		BytesReceived: status.BytesReceived,
+		BytesSent:     status.BytesSent,
+	}
+	var sample = InstanceMetrics{
+		Timestamp:       now,
+		CpuUsage:        status.CpuUsages,
+		AvailableMemory: status.AvailableMemory,
+	}
+	if history.countersReady {
+		var previous = history.lastCounters
+		var seconds = uint64(now.Sub(history.lastRecord) / time.Second)
+		if 0 == seconds {
+			seconds = 1
+		}
+		sample.BytesRead = CounterDelta(previous.BytesRead, current.BytesRead)
+		sample.BytesWritten = CounterDelta(previous.BytesWritten, current.BytesWritten)
+		sample.ReadIOPS = CounterDelta(previous.ReadRequests, current.ReadRequests) / seconds
+		sample.WriteIOPS = CounterDelta(previous.WriteRequests, current.WriteRequests) / seconds
+		sample.BytesReceived = CounterDelta(previous.BytesReceived, current.BytesReceived)
+		sample.BytesSent = CounterDelta(previous.BytesSent, current.BytesSent)
+	}
+	history.lastCounters = current
+	history.countersReady = true
+	history.lastRecord = now
+
+	if nil != history.rollups {
+		var bucket = now.Truncate(MetricsRollupInterval)
+		if !history.rollupBucket.IsZero() && bucket.After(history.rollupBucket) {
+			var pending = history.samples.Query(history.rollupBucket, bucket)
+			//one rollup for each bucket elapsed
+			for _, aggregated := range aggregateMetrics(pending, MetricsRollupInterval) {
+				history.rollups.Push(aggregated)
+				rollup = true
+			}
+		}
+		history.rollupBucket = bucket
+	}
+	history.samples.Push(sample)
+	return rollup
+}
+
+// Query : aggregate samples in [begin, end) by granularity, rollups used when range exceeds memory buffer
+func (history *instanceMetricsHistory) Query(begin, end time.Time, granularity time.Duration) []InstanceMetrics {
+	var samples []InstanceMetrics
+	var oldest = history.samples.Oldest()
+	if nil != history.rollups && (oldest.IsZero() || begin.Before(oldest)) {
+		var rollupEnd = end
+		if !oldest.IsZero() && oldest.Truncate(MetricsRollupInterval).Before(rollupEnd) {
+			rollupEnd = oldest.Truncate(MetricsRollupInterval)
+		}
+		samples = history.rollups.Query(begin, rollupEnd)
+	}
+	samples = append(samples, history.samples.Query(begin, end)...)
+	return aggregateMetrics(samples, granularity)
+}
+
+func (history *instanceMetricsHistory) loadRollups(filename string) (err error) {
+	if nil == history.rollups {
+		return nil
+	}
+	if _, err = os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+	var data []byte
+	if data, err = os.ReadFile(filename); err != nil {
+		return
+	}
+	var rollups []InstanceMetrics
+	if err = json.Unmarshal(data, &rollups); err != nil {
+		return
+	}
+	for _, rollup := range rollups {
+		history.rollups.Push(rollup)
+	}
+	return nil
+}
+
+func (history *instanceMetricsHistory) saveRollups(filename string) (err error) {
+	if nil == history.rollups {
+		return nil
+	}
+	var data []byte
+	if data, err = json.Marshal(history.rollups.All()); err != nil {
+		return
+	}
+	return writeFileAtomically(filename, data, ConfigFilePerm)
+}
+
+// aggregateMetrics : merge chronological samples into buckets, average usage and IOPS, sum bytes
+func aggregateMetrics(samples []InstanceMetrics, granularity time.Duration) (result []InstanceMetrics) {
+	var current InstanceMetrics
+	var count = 0
+	var cpuUsage float64
+	var memory, readIOPS, writeIOPS uint64
+	var flush = func() {
+		if 0 == count {
+			return
+		}
+		current.CpuUsage = cpuUsage / float64(count)
+		current.AvailableMemory = memory / uint64(count)
+		current.ReadIOPS = readIOPS / uint64(count)
+		current.WriteIOPS = writeIOPS / uint64(count)
+		result = append(result, current)
+	}
+	for _, sample := range samples {
+		var bucket = sample.Timestamp.Truncate(granularity)
+		if 0 == count || !bucket.Equal(current.Timestamp) {
+			flush()
+			current = InstanceMetrics{Timestamp: bucket}
+			count = 0
+			cpuUsage = 0
+			memory, readIOPS, writeIOPS = 0, 0, 0
+		}
+		count++
+		cpuUsage += sample.CpuUsage
+		memory += sample.AvailableMemory
+		readIOPS += sample.ReadIOPS
+		writeIOPS += sample.WriteIOPS
+		current.BytesRead += sample.BytesRead
+		current.BytesWritten += sample.BytesWritten
+		current.BytesReceived += sample.BytesReceived
+		current.BytesSent += sample.BytesSent
+	}
+	flush()
+	return result
+}
+
+// CounterDelta : increment of a cumulative counter, current value used when counter reset
+func CounterDelta(previous, current uint64) uint64 {
+	if current < previous {
+		//counter reset
+		return current
+	}
+	return current - previous
+}
+
+func (manager *InstanceManager) metricsRollupFile(instanceID string) string {
+	return filepath.Join(manager.metricsPath, fmt.Sprintf("%s.%s", instanceID, metricsRollupSuffix))
+}
+
+func (manager *InstanceManager) getMetricsHistory(instanceID string) *instanceMetricsHistory {
+	if history, exists := manager.metrics[instanceID]; exists {
+		return history
+	}
+	var configurator = GetConfigurator()
+	var history = newInstanceMetricsHistory(configurator.GetMetricsCapacity(), configurator.IsMetricsRollupEnabled())
+	if err := history.loadRollups(manager.metricsRollupFile(instanceID)); err != nil {
+		log.Printf("<instance> warning: load metrics rollup of instance '%s' fail: %s", instanceID, err.Error())
+	}
+	manager.metrics[instanceID] = history
+	return history
+}
+
+// recordInstanceMetrics : invoked in sync routine when instance running.
+// Counters refreshed by status query in current period reused, libvirt queried only when stale
+func (manager *InstanceManager) recordInstanceMetrics(now time.Time, status InstanceStatus) {
+	var history = manager.getMetricsHistory(status.ID)
+	var resolution = GetConfigurator().GetMetricsResolution()
+	if now.Sub(history.lastRecord) < resolution {
+		return
+	}
+	var current = status
+	var sampled = status.lastStatsUpdate
+	if !sampled.After(history.lastRecord) || now.Sub(sampled) >= resolution {
+		var err error
+		if current, err = manager.util.GetInstanceStatus(status.ID); err != nil {
+			log.Printf("<instance> warning: collect metrics of instance '%s' fail: %s", status.Name, err.Error())
+			history.lastRecord = now
+			return
+		}
+		current.CpuUsages = status.CpuUsages
+		sampled = now
+		//keep latest counters for status query
+		if ins, exists := manager.instances[status.ID]; exists {
+			ins.updateCounters(current, now)
+			manager.instances[status.ID] = ins
+		}
+	}
+	if history.Record(sampled, current) {
+		if err := manager.saveMetricsRollups(status.ID, history); err != nil {
+			log.Printf("<instance> warning: save metrics rollup of instance '%s' fail: %s", status.Name, err.Error())
+		}
+	}
+}
+
+// updateCounters : usage and IO counters of running instance sampled at timestamp
+func (status *InstanceStatus) updateCounters(current InstanceStatus, timestamp time.Time) {
+	status.AvailableMemory = current.AvailableMemory
+	status.AvailableDisk = current.AvailableDisk
+	status.BytesRead = current.BytesRead
+	status.BytesWritten = current.BytesWritten
+	status.ReadRequests = current.ReadRequests
+	status.WriteRequests = current.WriteRequests
+	status.BytesReceived = current.BytesReceived
+	status.BytesSent = current.BytesSent
+	status.lastStatsUpdate = timestamp
+}
+
+func (manager *InstanceManager) saveMetricsRollups(instanceID string, history *instanceMetricsHistory) (err error) {
+	if _, err = os.Stat(manager.metricsPath); os.IsNotExist(err) {
+		if err = os.MkdirAll(manager.metricsPath, DefaultPathPerm); err != nil {
+			return
+		}
+		log.Printf("<instance> metrics path '%s' created", manager.metricsPath)
+	}
+	return history.saveRollups(manager.metricsRollupFile(instanceID))
+}
+
+func (manager *InstanceManager) removeMetricsHistory(instanceID string) (err error) {
+	delete(manager.metrics, instanceID)
+	var filename = manager.metricsRollupFile(instanceID)
+	if _, err = os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Remove(filename)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetricsRing_Query(t *testing.T) {
+	const (
+		capacity = 4
+		total    = 6
+	)
+	var ring = newMetricsRing(capacity)
+	var begin = time.Unix(1000, 0)
+	for index := 0; index < total; index++ {
+		ring.Push(InstanceMetrics{Timestamp: begin.Add(time.Duration(index) * time.Second), AvailableMemory: uint64(index)})
+	}
+	var all = ring.All()
+	if capacity != len(all) {
+		t.Fatalf("unexpected sample count %d", len(all))
+	}
+	for index, sample := range all {
+		if uint64(total-capacity+index) != sample.AvailableMemory {
+			t.Fatalf("unexpected sample %d at %d", sample.AvailableMemory, index)
+		}
+	}
+	if !ring.Oldest().Equal(begin.Add(2 * time.Second)) {
+		t.Fatalf("unexpected oldest timestamp %s", ring.Oldest())
+	}
+	var selected = ring.Query(begin.Add(3*time.Second), begin.Add(5*time.Second))
+	if 2 != len(selected) || 3 != selected[0].AvailableMemory || 4 != selected[1].AvailableMemory {
+		t.Fatalf("unexpected query result %v", selected)
+	}
+}
+
+func TestAggregateMetrics(t *testing.T) {
+	var begin = time.Unix(6000, 0)
+	var samples = []InstanceMetrics{
+		{Timestamp: begin, CpuUsage: 10, AvailableMemory: 100, BytesRead: 1, ReadIOPS: 2},
+		{Timestamp: begin.Add(30 * time.Second), CpuUsage: 30, AvailableMemory: 300, BytesRead: 2, ReadIOPS: 4},
+		{Timestamp: begin.Add(time.Minute), CpuUsage: 50, AvailableMemory: 500, BytesRead: 4, ReadIOPS: 6},
+	}
+	var result = aggregateMetrics(samples, time.Minute)
+	if 2 != len(result) {
+		t.Fatalf("unexpected bucket count %d", len(result))
+	}
+	var first = result[0]
+	if 20 != first.CpuUsage || 200 != first.AvailableMemory || 3 != first.BytesRead || 3 != first.ReadIOPS {
+		t.Fatalf("unexpected first bucket %v", first)
+	}
+	if !result[1].Timestamp.Equal(begin.Add(time.Minute)) || 4 != result[1].BytesRead {
+		t.Fatalf("unexpected second bucket %v", result[1])
+	}
+}
+
+func TestMetricsHistory_Rollup(t *testing.T) {
+	var history = newInstanceMetricsHistory(100, true)
+	var begin = time.Unix(6000, 0).Truncate(MetricsRollupInterval)
+	var status = InstanceStatus{CpuUsages: 10, BytesRead: 100}
+	if history.Record(begin, status) {
+		t.Fatal("rollup generated for first sample")
+	}
+	status.BytesRead = 160
+	if history.Record(begin.Add(time.Minute), status) {
+		t.Fatal("rollup generated in same bucket")
+	}
+	//gap of several buckets, when instance paused
+	status.BytesRead = 200
+	if !history.Record(begin.Add(3*MetricsRollupInterval), status) {
+		t.Fatal("no rollup generated for elapsed bucket")
+	}
+	var rollups = history.rollups.All()
+	if 1 != len(rollups) || !rollups[0].Timestamp.Equal(begin) || 60 != rollups[0].BytesRead {
+		t.Fatalf("unexpected rollups %v", rollups)
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+type RestartPolicy uint
+
+const (
+	//default, restart when guest crashed
+	RestartOnCrash = RestartPolicy(iota)
+	RestartNever
+	//restart whenever guest stopped without request of cell
+	RestartAlways
+	RestartPolicyInvalid
+)
+
+// actions reported in GuestRestartPolicy event
+const (
+	RestartActionScheduled = "scheduled"
+	RestartActionRestarted = "restarted"
+	RestartActionFailed    = "failed"
+	RestartActionGiveUp    = "give_up"
+	RestartActionCrashLoop = "crash_loop"
+	RestartActionRecovered = "recovered"
+	RestartActionCancelled = "cancelled"
+)
+
+type guestRestartState struct {
+	stopRequested bool
+	//crashed guest stopped by cell after memory dumped
+	crashDumped bool
+	//stop reason of scheduled restart, checked again when policy changed
+	crashed   bool
+	startTime time.Time
+	//stop time of recent failures, within crash loop window
+	failures    []time.Time
+	retries     uint
+	nextRestart time.Time
+}
+
+func (policy RestartPolicy) ToString() string {
+	switch policy {
+	case RestartOnCrash:
+		return "on-crash"
+	case RestartNever:
+		return "never"
+	case RestartAlways:
+		return "always"
+	default:
+		return "invalid"
+	}
+}
+
+// restartRequired : restart guest stopped without request of cell
+func (policy RestartPolicy) restartRequired(crashed bool) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnCrash:
+		return crashed
+	default:
+		return false
+	}
+}
+
+func (manager *InstanceManager) getRestartState(guestID string) *guestRestartState {
+	state, exists := manager.restarts[guestID]
+	if !exists {
+		state = &guestRestartState{}
+		manager.restarts[guestID] = state
+	}
+	return state
+}
+
+// markStopRequested : guest stopped by cell, not affected by restart policy
+func (manager *InstanceManager) markStopRequested(guestID string) {
+	var state = manager.getRestartState(guestID)
+	state.stopRequested = true
+	state.nextRestart = time.Time{}
+}
+
+func (manager *InstanceManager) notifyRestartAction(guestID, action string, retries uint, now time.Time) {
+	manager.events <- InstanceStatusChangedEvent{ID: guestID, Event: GuestRestartPolicy, Action: action,
+		Count: retries, Timestamp: now}
+}
+
+// onGuestStarted : invoke when guest detected running
+func (manager *InstanceManager) onGuestStarted(guestID string, now time.Time) {
+	var state = manager.getRestartState(guestID)
+	state.stopRequested = false
+	state.startTime = now
+	state.nextRestart = time.Time{}
+}
+
+// resetRestart : guest started by request, retries and failures cleared
+func (manager *InstanceManager) resetRestart(guestID string, now time.Time) {
+	var state = manager.getRestartState(guestID)
+	state.retries = 0
+	state.failures = nil
+	manager.onGuestStarted(guestID, now)
+}
+
+// onGuestStopped : invoke when guest detected stopped, schedule restart by policy
+func (manager *InstanceManager) onGuestStopped(guestID string, now time.Time) {
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		return
+	}
+	var state = manager.getRestartState(guestID)
+	state.startTime = time.Time{}
+	if state.stopRequested {
+		state.stopRequested = false
+		return
+	}
+	crashed, err := manager.util.IsInstanceCrashed(guestID)
+	if err != nil {
+		log.Printf("<instance> warning: check stop reason of guest '%s' fail: %s", ins.Name, err.Error())
+	}
+	if state.crashDumped {
+		state.crashDumped = false
+		crashed = true
+	}
+	if crashed {
+		log.Printf("<instance> guest '%s' crashed", ins.Name)
+	}
+	manager.scheduleRestart(guestID, crashed, now)
+}
+
+// scheduleRestart : count failure of stopped guest, restart after backoff when required by policy
+func (manager *InstanceManager) scheduleRestart(guestID string, crashed bool, now time.Time) {
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		return
+	}
+	var state = manager.getRestartState(guestID)
+	if !ins.RestartPolicy.restartRequired(crashed) {
+		return
+	}
+	state.crashed = crashed
+	var failureThreshold, window = GetConfigurator().GetCrashLoopDetection()
+	var recent = state.failures[:0]
+	for _, stopTime := range state.failures {
+		if now.Sub(stopTime) < window {
+			recent = append(recent, stopTime)
+		}
+	}
+	state.failures = append(recent, now)
+	if !ins.CrashLooping && len(state.failures) >= failureThreshold {
+		ins.CrashLooping = true
+		manager.instances[guestID] = ins
+		log.Printf("<instance> guest '%s' crash looping, %d failure(s) in %s", ins.Name, len(state.failures), window)
+		manager.notifyRestartAction(guestID, RestartActionCrashLoop, state.retries, now)
+	}
+	if 0 != ins.RestartRetries && state.retries >= ins.RestartRetries {
+		log.Printf("<instance> give up restarting guest '%s' after %d retries", ins.Name, state.retries)
+		manager.notifyRestartAction(guestID, RestartActionGiveUp, state.retries, now)
+		return
+	}
+	var delay = restartBackoff(state.retries)
+	state.nextRestart = now.Add(delay)
+	log.Printf("<instance> guest '%s' will restart in %s by policy %s", ins.Name, delay, ins.RestartPolicy.ToString())
+	manager.notifyRestartAction(guestID, RestartActionScheduled, state.retries, now)
+}
+
+// restartBackoff : initial delay doubled by each consecutive retry, limited to max delay
+func restartBackoff(retries uint) time.Duration {
+	var initial, max = GetConfigurator().GetRestartBackoff()
+	var delay = initial
+	for i := uint(0); i < retries && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// advanceRestart : restart guests when backoff passed, reset failures of guests running stably
+func (manager *InstanceManager) advanceRestart(now time.Time) {
+	if nil != manager.shutdown {
+		//no restart when cell stopping
+		return
+	}
+	var _, window = GetConfigurator().GetCrashLoopDetection()
+	for guestID, state := range manager.restarts {
+		ins, exists := manager.instances[guestID]
+		if !exists {
+			delete(manager.restarts, guestID)
+			continue
+		}
+		if ins.Running {
+			if !state.startTime.IsZero() && now.Sub(state.startTime) >= window &&
+				(0 != state.retries || 0 != len(state.failures) || ins.CrashLooping) {
+				state.retries = 0
+				state.failures = nil
+				if ins.CrashLooping {
+					ins.CrashLooping = false
+					manager.instances[guestID] = ins
+					log.Printf("<instance> guest '%s' recovered from crash loop", ins.Name)
+					manager.notifyRestartAction(guestID, RestartActionRecovered, 0, now)
+				}
+			}
+			continue
+		}
+		if state.nextRestart.IsZero() || now.Before(state.nextRestart) {
+			continue
+		}
+		state.nextRestart = time.Time{}
+		if !ins.RestartPolicy.restartRequired(state.crashed) {
+			log.Printf("<instance> restart guest '%s' cancelled by policy %s", ins.Name, ins.RestartPolicy.ToString())
+			manager.notifyRestartAction(guestID, RestartActionCancelled, state.retries, now)
+			continue
+		}
+		if 0 != ins.RestartRetries && state.retries >= ins.RestartRetries {
+			log.Printf("<instance> give up restarting guest '%s' after %d retries", ins.Name, state.retries)
+			manager.notifyRestartAction(guestID, RestartActionGiveUp, state.retries, now)
+			continue
+		}
+		if err := manager.checkMaintenance(); err != nil {
+			log.Printf("<instance> restart guest '%s' cancelled: %s", ins.Name, err.Error())
+			manager.notifyRestartAction(guestID, RestartActionCancelled, state.retries, now)
+			continue
+		}
+		state.retries++
+		if err := manager.util.StartInstance(guestID, ins.NetworkVLAN); err != nil {
+			log.Printf("<instance> restart guest '%s' fail: %s", ins.Name, err.Error())
+			manager.notifyRestartAction(guestID, RestartActionFailed, state.retries, now)
+			if 0 != ins.RestartRetries && state.retries >= ins.RestartRetries {
+				log.Printf("<instance> give up restarting guest '%s' after %d retries", ins.Name, state.retries)
+				manager.notifyRestartAction(guestID, RestartActionGiveUp, state.retries, now)
+				continue
+			}
+			state.nextRestart = now.Add(restartBackoff(state.retries))
+			continue
+		}
+		//left for sync
+		log.Printf("<instance> guest '%s' restarted by policy, retry %d", ins.Name, state.retries)
+		manager.notifyRestartAction(guestID, RestartActionRestarted, state.retries, now)
+	}
+}
+
+func (manager *InstanceManager) handleModifyRestartPolicy(guestID string, policy RestartPolicy, retries uint,
+	respChan chan error) (err error) {
+	defer func() {
+		respChan <- err
+	}()
+	if policy >= RestartPolicyInvalid {
+		err = fmt.Errorf("invalid restart policy %d", policy)
+		return
+	}
+	ins, exists := manager.instances[guestID]
+	if !exists {
+		err = fmt.Errorf("invalid guest '%s'", guestID)
+		return
+	}
+	if ins.RestartPolicy == policy && ins.RestartRetries == retries {
+		err = fmt.Errorf("no need to change")
+		return
+	}
+	ins.RestartPolicy = policy
+	ins.RestartRetries = retries
+	manager.instances[guestID] = ins
+	if state, exists := manager.restarts[guestID]; exists && !state.nextRestart.IsZero() && !policy.restartRequired(state.crashed) {
+		state.nextRestart = time.Time{}
+		log.Printf("<instance> pending restart of guest '%s' cancelled", ins.Name)
+	}
+	log.Printf("<instance> restart policy of guest '%s' changed to %s, max retries %d",
+		ins.Name, policy.ToString(), retries)
+	return manager.saveConfig()
+}
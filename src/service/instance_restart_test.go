@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func newRestartTestManager(policy RestartPolicy) *InstanceManager {
+	var manager = &InstanceManager{
+		instances: map[string]InstanceStatus{},
+		restarts:  map[string]*guestRestartState{},
+		events:    make(chan InstanceStatusChangedEvent, 1<<10),
+	}
+	var ins InstanceStatus
+	ins.ID = "guest"
+	ins.Name = "test"
+	ins.RestartPolicy = policy
+	manager.instances[ins.ID] = ins
+	return manager
+}
+
+func TestRestartBackoff(t *testing.T) {
+	var initial, max = GetConfigurator().GetRestartBackoff()
+	if initial != restartBackoff(0) {
+		t.Fatalf("unexpected initial backoff %s", restartBackoff(0))
+	}
+	if 4*initial != restartBackoff(2) {
+		t.Fatalf("unexpected backoff %s of 2 retries", restartBackoff(2))
+	}
+	if max != restartBackoff(20) || max != restartBackoff(100) {
+		t.Fatalf("backoff %s not limited to %s", restartBackoff(100), max)
+	}
+}
+
+func TestCrashLoopDetection(t *testing.T) {
+	const guestID = "guest"
+	var manager = newRestartTestManager(RestartOnCrash)
+	var failures, window = GetConfigurator().GetCrashLoopDetection()
+	var now = time.Now()
+	//stopped normally
+	manager.scheduleRestart(guestID, false, now)
+	if state := manager.getRestartState(guestID); !state.nextRestart.IsZero() || 0 != len(state.failures) {
+		t.Fatalf("restart scheduled for normal stop %v", state)
+	}
+	for i := 0; i < failures; i++ {
+		now = now.Add(time.Second)
+		manager.scheduleRestart(guestID, true, now)
+	}
+	if !manager.instances[guestID].CrashLooping {
+		t.Fatalf("not crash looping after %d failures", failures)
+	}
+	if state := manager.getRestartState(guestID); state.nextRestart.IsZero() {
+		t.Fatal("restart not scheduled")
+	}
+	//recovered after running stably
+	var ins = manager.instances[guestID]
+	ins.Running = true
+	manager.instances[guestID] = ins
+	manager.onGuestStarted(guestID, now)
+	manager.advanceRestart(now.Add(window))
+	if manager.instances[guestID].CrashLooping || 0 != len(manager.getRestartState(guestID).failures) {
+		t.Fatal("crash loop not recovered")
+	}
+	//failures out of window not counted
+	ins = manager.instances[guestID]
+	ins.Running = false
+	manager.instances[guestID] = ins
+	for i := 0; i < failures; i++ {
+		now = now.Add(window)
+		manager.scheduleRestart(guestID, true, now)
+	}
+	if manager.instances[guestID].CrashLooping {
+		t.Fatal("crash looping with failures out of window")
+	}
+}
+
+func TestRestartCancelledByPolicy(t *testing.T) {
+	const guestID = "guest"
+	var manager = newRestartTestManager(RestartAlways)
+	var now = time.Now()
+	manager.scheduleRestart(guestID, false, now)
+	var state = manager.getRestartState(guestID)
+	if state.nextRestart.IsZero() {
+		t.Fatal("restart not scheduled")
+	}
+	//changed to restart on crash only
+	var ins = manager.instances[guestID]
+	ins.RestartPolicy = RestartOnCrash
+	manager.instances[guestID] = ins
+	manager.advanceRestart(state.nextRestart)
+	if !state.nextRestart.IsZero() || 0 != state.retries {
+		t.Fatalf("restart not cancelled %v", state)
+	}
+}
+
+func TestPanicPoweroffNotRestarted(t *testing.T) {
+	const guestID = "guest"
+	var manager = newRestartTestManager(RestartAlways)
+	var ins = manager.instances[guestID]
+	ins.Template = &HardwareTemplate{Panic: DeviceActionPoweroff}
+	manager.instances[guestID] = ins
+	manager.handleDeviceEvent(guestDeviceEvent{GuestID: guestID, Trigger: deviceTriggerPanic})
+	//shut off as crashed
+	manager.onGuestStopped(guestID, time.Now())
+	if state := manager.getRestartState(guestID); !state.nextRestart.IsZero() || 0 != len(state.failures) {
+		t.Fatalf("restart scheduled for guest powered off by panic %v", state)
+	}
+}
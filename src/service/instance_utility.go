@@ -1,12 +1,19 @@
 package service
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"github.com/libvirt/libvirt-go"
+	"github.com/vishvananda/netlink"
+	"image"
+	"image/png"
 	"log"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 )
 
@@ -54,15 +61,25 @@ type virDomainInterfaceBandwidth struct {
 	Outbound *virDomainInterfaceLimit `xml:"outbound,omitempty"`
 }
 
+type virDomainInterfaceParameters struct {
+	InterfaceID string `xml:"interfaceid,attr,omitempty"`
+}
+
+type virDomainInterfaceVirtualPort struct {
+	Type       string                        `xml:"type,attr"`
+	Parameters *virDomainInterfaceParameters `xml:"parameters,omitempty"`
+}
+
 type virDomainInterfaceElement struct {
-	XMLName   xml.Name                     `xml:"interface"`
-	Type      string                       `xml:"type,attr"`
-	Source    virDomainInterfaceSource     `xml:"source,omitempty"`
-	MAC       *virDomainInterfaceMAC       `xml:"mac,omitempty"`
-	Model     *virDomainInterfaceModel     `xml:"model,omitempty"`
-	Target    *virDomainInterfaceTarget    `xml:"target,omitempty"`
-	Bandwidth *virDomainInterfaceBandwidth `xml:"bandwidth,omitempty"`
-	Filter    *virNwfilterRef
+	XMLName     xml.Name                       `xml:"interface"`
+	Type        string                         `xml:"type,attr"`
+	Source      virDomainInterfaceSource       `xml:"source,omitempty"`
+	MAC         *virDomainInterfaceMAC         `xml:"mac,omitempty"`
+	Model       *virDomainInterfaceModel       `xml:"model,omitempty"`
+	Target      *virDomainInterfaceTarget      `xml:"target,omitempty"`
+	VirtualPort *virDomainInterfaceVirtualPort `xml:"virtualport,omitempty"`
+	Bandwidth   *virDomainInterfaceBandwidth   `xml:"bandwidth,omitempty"`
+	Filter      *virNwfilterRef
 }
 
 type virDomainGraphicsListen struct {
@@ -71,11 +88,13 @@ type virDomainGraphicsListen struct {
 }
 
 type virDomainGraphicsElement struct {
-	XMLName  xml.Name                 `xml:"graphics"`
-	Type     string                   `xml:"type,attr"`
-	Port     uint                     `xml:"port,attr"`
-	Password string                   `xml:"passwd,attr"`
-	Listen   *virDomainGraphicsListen `xml:"listen,omitempty"`
+	XMLName     xml.Name                 `xml:"graphics"`
+	Type        string                   `xml:"type,attr"`
+	Port        uint                     `xml:"port,attr,omitempty"`
+	TLSPort     uint                     `xml:"tlsPort,attr,omitempty"` //spice only, plain port omitted when TLS enabled
+	DefaultMode string                   `xml:"defaultMode,attr,omitempty"`
+	Password    string                   `xml:"passwd,attr"`
+	Listen      *virDomainGraphicsListen `xml:"listen,omitempty"`
 }
 
 type virDomainControllerElement struct {
@@ -108,6 +127,15 @@ type virDomainInput struct {
 	Bus  string `xml:"bus,attr"`
 }
 
+type virDomainWatchdog struct {
+	Model  string `xml:"model,attr"`
+	Action string `xml:"action,attr"`
+}
+
+type virDomainPanic struct {
+	Model string `xml:"model,attr"`
+}
+
 type virVideoModel struct {
 	Type string `xml:"type,attr"`
 }
@@ -117,7 +145,7 @@ type virVideoDriver struct {
 }
 
 type virVideoElement struct {
-	Model  virVideoModel  `xml:"model"`
+	Model  virVideoModel   `xml:"model"`
 	Driver *virVideoDriver `xml:"driver,omitempty"`
 }
 
@@ -131,6 +159,8 @@ type virDomainDevicesElement struct {
 	MemoryBalloon virDomainMemoryBalloon       `xml:"memballoon"`
 	Channel       virDomainChannel             `xml:"channel"`
 	Video         virVideoElement              `xml:"video"`
+	Watchdog      *virDomainWatchdog           `xml:"watchdog,omitempty"`
+	Panic         *virDomainPanic              `xml:"panic,omitempty"`
 }
 
 type virDomainCpuElement struct {
@@ -262,20 +292,35 @@ type virDomainDefine struct {
 
 //nwfilter
 
-type virNwfilerRuleIP struct {
-	XMLName         xml.Name `xml:"ip"`
-	Protocol        string   `xml:"protocol,attr"`
-	SourceAddress   string   `xml:"srcipaddr,attr,omitempty"`
-	TargetPortStart uint64   `xml:"dstportstart,attr,omitempty"`
-	TargetPortEnd   uint64   `xml:"dstportend,attr,omitempty"`
+// virNwfilerRuleMAC : ethernet frames of protocol, matched in ebtables layer
+type virNwfilerRuleMAC struct {
+	XMLName    xml.Name `xml:"mac"`
+	ProtocolID string   `xml:"protocolid,attr"`
+}
+
+// virNwfilerRuleProtocol : element named by protocol such as "tcp" or "udp-ipv6", matched in iptables layer with connection state
+type virNwfilerRuleProtocol struct {
+	XMLName         xml.Name
+	SourceAddress   string `xml:"srcipaddr,attr,omitempty"`
+	SourceMask      string `xml:"srcipmask,attr,omitempty"`
+	TargetAddress   string `xml:"dstipaddr,attr,omitempty"`
+	TargetMask      string `xml:"dstipmask,attr,omitempty"`
+	SourcePortStart uint64 `xml:"srcportstart,attr,omitempty"`
+	SourcePortEnd   uint64 `xml:"srcportend,attr,omitempty"`
+	TargetPortStart uint64 `xml:"dstportstart,attr,omitempty"`
+	TargetPortEnd   uint64 `xml:"dstportend,attr,omitempty"`
+	Type            uint   `xml:"type,attr,omitempty"`
+	State           string `xml:"state,attr,omitempty"`
 }
 
 type virNwfilterRule struct {
-	XMLName   xml.Name `xml:"rule"`
-	Action    string   `xml:"action,attr"`
-	Direction string   `xml:"direction,attr"`
-	Priority  int      `xml:"priority,attr,omitempty"`
-	IPRule    *virNwfilerRuleIP
+	XMLName      xml.Name `xml:"rule"`
+	Action       string   `xml:"action,attr"`
+	Direction    string   `xml:"direction,attr"`
+	Priority     int      `xml:"priority,attr,omitempty"`
+	StateMatch   string   `xml:"statematch,attr,omitempty"`
+	MACRule      *virNwfilerRuleMAC
+	ProtocolRule *virNwfilerRuleProtocol
 }
 
 type virNwfilterRef struct {
@@ -324,6 +369,7 @@ const (
 	DisplayDriverNone      = "none"
 	RemoteControlVNC       = "vnc"
 	RemoteControlSPICE     = "spice"
+	GraphicsModeSecure     = "secure"
 	USBModelXHCI           = "nec-xhci"
 	USBModelNone           = ""
 	TabletBusNone          = ""
@@ -345,6 +391,36 @@ const (
 	NwfilterDirectionInOut = "inout"
 	NwfilterPrefix         = "nano-nwfilter-"
 	InterfaceTypeBridge    = "bridge"
+	WatchdogModelI6300ESB  = "i6300esb"
+	PanicModelISA          = "isa"
+	DeviceActionNone       = ""
+	DeviceActionReset      = "reset"
+	DeviceActionPoweroff   = "poweroff"
+	DeviceActionPause      = "pause"
+	DeviceActionDump       = "dump"
+	DiagnosticPath         = "diagnostics"
+)
+
+const (
+	NwfilterProtocolICMPv6   = "icmpv6"
+	NwfilterProtocolAll      = "all"
+	nwfilterIPv6Suffix       = "-ipv6"
+	NwfilterMACProtocolARP   = "arp"
+	NwfilterMACProtocolIPv4  = "ipv4"
+	NwfilterMACProtocolIPv6  = "ipv6"
+	NwfilterStateEstablished = "ESTABLISHED,RELATED"
+	//rule applied to packets in any connection state
+	NwfilterStateMatchDisabled = "false"
+	//messages of neighbor discovery
+	ICMPv6RouterSolicitation    = 133
+	ICMPv6RouterAdvertisement   = 134
+	ICMPv6NeighborSolicitation  = 135
+	ICMPv6NeighborAdvertisement = 136
+	ICMPv6Redirect              = 137
+	DHCPv6ClientPort            = 546
+	DHCPv6ServerPort            = 547
+	DHCPServerPort              = 67
+	DHCPClientPort              = 68
 )
 
 type InstanceUtility struct {
@@ -370,7 +446,7 @@ func (util *InstanceUtility) CreateInstance(config GuestConfig) (guest GuestConf
 			}
 		}
 	}()
-	var nwfilterDefine = policyToFilter(generateNwfilterName(config.ID), config.ID, config.Security)
+	var nwfilterDefine = policyToFilter(generateNwfilterName(config.ID), config.ID, config.Security, ipv6Routers())
 	var xmlData []byte
 	if xmlData, err = xml.MarshalIndent(nwfilterDefine, "", " "); err != nil {
 		err = fmt.Errorf("generate nwfilter define for instance '%s' fail: %s", config.Name, err.Error())
@@ -396,12 +472,6 @@ func (util *InstanceUtility) CreateInstance(config GuestConfig) (guest GuestConf
 		err = fmt.Errorf("create domain for instance '%s' fail: %s", config.Name, err.Error())
 		return
 	}
-	if config.AutoStart {
-		if err = virDomain.SetAutostart(true); err != nil {
-			err = fmt.Errorf("enable auto start for instance '%s' fail: %s", config.Name, err.Error())
-			return
-		}
-	}
 	config.Created = true
 	//todo: complete return guest
 	return config, nil
@@ -438,6 +508,15 @@ func (util *InstanceUtility) Exists(id string) bool {
 	return true
 }
 
+// GetHostResource : logical cores and memory in bytes of host
+func (util *InstanceUtility) GetHostResource() (cores uint, memory uint64, err error) {
+	info, err := util.virConnect.GetNodeInfo()
+	if err != nil {
+		return
+	}
+	return info.Cpus, info.Memory << 10, nil
+}
+
 func (util *InstanceUtility) GetCPUTimes(id string) (usedNanoseconds uint64, cores uint, err error) {
 	virDomain, err := util.virConnect.LookupDomainByUUIDString(id)
 	if err != nil {
@@ -450,7 +529,8 @@ func (util *InstanceUtility) GetCPUTimes(id string) (usedNanoseconds uint64, cor
 	return info.CpuTime, info.NrVirtCpu, nil
 }
 
-func (util *InstanceUtility) GetIPv4Address(id, mac string) (ip string, err error) {
+// GetIPAddresses : first IPv4 and global IPv6 address of interface reported by guest agent
+func (util *InstanceUtility) GetIPAddresses(id, mac string) (ipv4, ipv6 string, err error) {
 	virDomain, err := util.virConnect.LookupDomainByUUIDString(id)
 	if err != nil {
 		return
@@ -471,18 +551,26 @@ func (util *InstanceUtility) GetIPv4Address(id, mac string) (ip string, err erro
 		if guestInterface.Hwaddr == mac {
 			if 0 == len(guestInterface.Addrs) {
 				err = fmt.Errorf("no address found in interface '%s'", guestInterface.Name)
-				return "", err
+				return "", "", err
 			}
 			for _, addr := range guestInterface.Addrs {
-				if libvirt.IP_ADDR_TYPE_IPV4 == libvirt.IPAddrType(addr.Type) {
-					ip = addr.Addr
-					return ip, nil
+				switch libvirt.IPAddrType(addr.Type) {
+				case libvirt.IP_ADDR_TYPE_IPV4:
+					if "" == ipv4 {
+						ipv4 = addr.Addr
+					}
+				case libvirt.IP_ADDR_TYPE_IPV6:
+					//link local address ignored
+					if ip := net.ParseIP(addr.Addr); "" == ipv6 && nil != ip && ip.IsGlobalUnicast() {
+						ipv6 = addr.Addr
+					}
 				}
 			}
+			return ipv4, ipv6, nil
 		}
 	}
 	//no hwaddress matched
-	return "", nil
+	return "", "", nil
 }
 
 func (util *InstanceUtility) GetInstanceStatus(id string) (ins InstanceStatus, err error) {
@@ -568,6 +656,8 @@ func (util *InstanceUtility) GetInstanceStatus(id string) (ins InstanceStatus, e
 			}
 			ins.BytesRead += uint64(stats.RdBytes)
 			ins.BytesWritten += uint64(stats.WrBytes)
+			ins.ReadRequests += uint64(stats.RdReq)
+			ins.WriteRequests += uint64(stats.WrReq)
 		}
 	}
 	{
@@ -598,7 +688,23 @@ func (util *InstanceUtility) IsInstanceRunning(id string) (bool, error) {
 	return virDomain.IsActive()
 }
 
-func (util *InstanceUtility) StartInstance(id string) error {
+// IsInstanceCrashed : check if stopped instance shut off by crash
+func (util *InstanceUtility) IsInstanceCrashed(id string) (bool, error) {
+	virDomain, err := util.virConnect.LookupDomainByUUIDString(id)
+	if err != nil {
+		return false, err
+	}
+	state, reason, err := virDomain.GetState()
+	if err != nil {
+		return false, err
+	}
+	if libvirt.DOMAIN_SHUTOFF != state {
+		return false, nil
+	}
+	return libvirt.DOMAIN_SHUTOFF_CRASHED == libvirt.DomainShutoffReason(reason), nil
+}
+
+func (util *InstanceUtility) StartInstance(id string, vlan uint) error {
 	virDomain, err := util.virConnect.LookupDomainByUUIDString(id)
 	if err != nil {
 		return err
@@ -610,10 +716,53 @@ func (util *InstanceUtility) StartInstance(id string) error {
 	if isRunning {
 		return fmt.Errorf("instance '%s' already started", id)
 	}
-	return virDomain.Create()
+	return util.createDomain(virDomain, vlan)
 }
 
-func (util *InstanceUtility) StartInstanceWithMedia(id, host, url string, port uint) error {
+// createDomain : start paused when VLAN required, resume after tap devices tagged, so guest never runs on untagged network
+func (util *InstanceUtility) createDomain(virDomain *libvirt.Domain, vlan uint) (err error) {
+	if DefaultVLAN == vlan {
+		return virDomain.Create()
+	}
+	if err = virDomain.CreateWithFlags(libvirt.DOMAIN_START_PAUSED); err != nil {
+		return err
+	}
+	if err = util.tagDomainVLAN(virDomain, vlan); err != nil {
+		return err
+	}
+	if err = virDomain.Resume(); err != nil {
+		virDomain.Destroy()
+		return fmt.Errorf("resume after VLAN %d tagged fail: %s", vlan, err.Error())
+	}
+	return nil
+}
+
+// tagDomainVLAN : tag tap devices of paused domain, domain destroyed when fail to keep it off untagged network
+func (util *InstanceUtility) tagDomainVLAN(virDomain *libvirt.Domain, vlan uint) (err error) {
+	var define virDomainDefine
+	xmlDesc, err := virDomain.GetXMLDesc(0)
+	if err == nil {
+		err = xml.Unmarshal([]byte(xmlDesc), &define)
+	}
+	if err == nil {
+		for index, inf := range define.Devices.Interface {
+			if inf.Target == nil {
+				err = fmt.Errorf("no target available for interface %d", index)
+				break
+			}
+			if err = TagInterfaceVLAN(inf.Target.Device, vlan); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		virDomain.Destroy()
+		return fmt.Errorf("tag VLAN %d fail: %s", vlan, err.Error())
+	}
+	return nil
+}
+
+func (util *InstanceUtility) StartInstanceWithMedia(id, host, url string, port, vlan uint) error {
 	virDomain, err := util.virConnect.LookupDomainByUUIDString(id)
 	if err != nil {
 		return err
@@ -656,7 +805,7 @@ func (util *InstanceUtility) StartInstanceWithMedia(id, host, url string, port u
 	if err = virDomain.UpdateDeviceFlags(deviceWithMedia, libvirt.DOMAIN_DEVICE_MODIFY_CONFIG); err != nil {
 		return err
 	}
-	if err = virDomain.Create(); err != nil {
+	if err = util.createDomain(virDomain, vlan); err != nil {
 		return err
 	}
 	//change live config only
@@ -836,7 +985,8 @@ func (util *InstanceUtility) ModifyPassword(id, user, password string) (err erro
 	return err
 }
 
-func (util *InstanceUtility) ModifyAutoStart(guestID string, enable bool) (err error) {
+// DisableLibvirtAutoStart : guests auto started by cell in order, instead of all at once by libvirt
+func (util *InstanceUtility) DisableLibvirtAutoStart(guestID string) (changed bool, err error) {
 	var virDomain *libvirt.Domain
 	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
 		err = fmt.Errorf("get guest fail: %s", err.Error())
@@ -847,19 +997,178 @@ func (util *InstanceUtility) ModifyAutoStart(guestID string, enable bool) (err e
 		err = fmt.Errorf("check auto start status fail: %s", err.Error())
 		return
 	}
-	if current == enable {
-		if current {
-			err = fmt.Errorf("auto start of guest '%s' already enabled", guestID)
-		} else {
-			err = fmt.Errorf("auto start of guest '%s' already disabled", guestID)
+	if !current {
+		return false, nil
+	}
+	if err = virDomain.SetAutostart(false); err != nil {
+		err = fmt.Errorf("set auto start fail: %s", err.Error())
+		return
+	}
+	return true, nil
+}
+
+// GetPoolPath : target path of storage pool
+func (util *InstanceUtility) GetPoolPath(poolName string) (path string, err error) {
+	var virPool *libvirt.StoragePool
+	if virPool, err = util.virConnect.LookupStoragePoolByName(poolName); err != nil {
+		err = fmt.Errorf("get storage pool '%s' fail: %s", poolName, err.Error())
+		return
+	}
+	var description string
+	if description, err = virPool.GetXMLDesc(0); err != nil {
+		err = fmt.Errorf("get define of pool '%s' fail: %s", poolName, err.Error())
+		return
+	}
+	var poolDefine virStoragePoolDefine
+	if err = xml.Unmarshal([]byte(description), &poolDefine); err != nil {
+		err = fmt.Errorf("parse define of pool '%s' fail: %s", poolName, err.Error())
+		return
+	}
+	if nil == poolDefine.Target {
+		err = fmt.Errorf("no target path of pool '%s'", poolName)
+		return
+	}
+	return poolDefine.Target.Path, nil
+}
+
+// DumpGuestMemory : write compressed memory core of guest to file
+func (util *InstanceUtility) DumpGuestMemory(guestID, dumpFile string) (err error) {
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
+		err = fmt.Errorf("get guest fail: %s", err.Error())
+		return
+	}
+	if err = virDomain.CoreDumpWithFormat(dumpFile, libvirt.DOMAIN_CORE_DUMP_FORMAT_KDUMP_ZLIB,
+		libvirt.DUMP_MEMORY_ONLY); err != nil {
+		err = fmt.Errorf("dump memory fail: %s", err.Error())
+		return
+	}
+	return nil
+}
+
+// CaptureScreenshot : save current console of guest as PNG
+func (util *InstanceUtility) CaptureScreenshot(guestID, imageFile string) (err error) {
+	const (
+		mimePPM = "image/x-portable-pixmap"
+		mimePNG = "image/png"
+	)
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
+		err = fmt.Errorf("get guest fail: %s", err.Error())
+		return
+	}
+	var stream *libvirt.Stream
+	if stream, err = util.virConnect.NewStream(0); err != nil {
+		err = fmt.Errorf("create stream fail: %s", err.Error())
+		return
+	}
+	defer stream.Free()
+	var mimeType string
+	if mimeType, err = virDomain.Screenshot(stream, 0, 0); err != nil {
+		err = fmt.Errorf("take screenshot fail: %s", err.Error())
+		return
+	}
+	var buffer bytes.Buffer
+	if err = stream.RecvAll(func(s *libvirt.Stream, data []byte) (int, error) {
+		return buffer.Write(data)
+	}); err != nil {
+		_ = stream.Abort()
+		err = fmt.Errorf("receive screenshot fail: %s", err.Error())
+		return
+	}
+	if err = stream.Finish(); err != nil {
+		err = fmt.Errorf("finish stream fail: %s", err.Error())
+		return
+	}
+	var data []byte
+	switch mimeType {
+	case mimePNG:
+		data = buffer.Bytes()
+	case mimePPM:
+		var img image.Image
+		if img, err = decodePPM(buffer.Bytes()); err != nil {
+			err = fmt.Errorf("decode screenshot fail: %s", err.Error())
+			return
+		}
+		var output bytes.Buffer
+		if err = png.Encode(&output, img); err != nil {
+			err = fmt.Errorf("encode screenshot fail: %s", err.Error())
+			return
 		}
+		data = output.Bytes()
+	default:
+		err = fmt.Errorf("unsupported screenshot format '%s'", mimeType)
 		return
 	}
-	if err = virDomain.SetAutostart(enable); err != nil {
-		err = fmt.Errorf("set auto start fail: %s", err.Error())
+	return os.WriteFile(imageFile, data, ConfigFilePerm)
+}
+
+// ResetInstance : reset and resume guest paused by watchdog
+func (util *InstanceUtility) ResetInstance(guestID string) (err error) {
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
 		return
 	}
-	return
+	if err = virDomain.Reset(0); err != nil {
+		return
+	}
+	state, _, err := virDomain.GetState()
+	if err != nil {
+		return
+	}
+	if libvirt.DOMAIN_PAUSED == state {
+		return virDomain.Resume()
+	}
+	return nil
+}
+
+// SendKeys : press keys together, released after default hold time of libvirt
+func (util *InstanceUtility) SendKeys(guestID string, codeSet KeyCodeSet, codes []uint) (err error) {
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
+		return
+	}
+	var virCodeSet = libvirt.KEYCODE_SET_LINUX
+	if KeyCodeSetXT == codeSet {
+		virCodeSet = libvirt.KEYCODE_SET_XT
+	}
+	return virDomain.SendKey(uint(virCodeSet), 0, codes, 0)
+}
+
+func (util *InstanceUtility) InjectNMI(guestID string) (err error) {
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
+		return
+	}
+	return virDomain.InjectNMI(0)
+}
+
+// DisableLibvirtCrashRestart : crashed guests restarted by policy of cell, instead of immediately by libvirt
+func (util *InstanceUtility) DisableLibvirtCrashRestart(guestID string) (changed bool, err error) {
+	const (
+		restartOnCrash = "<on_crash>restart</on_crash>"
+		destroyOnCrash = "<on_crash>destroy</on_crash>"
+	)
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
+		err = fmt.Errorf("get guest fail: %s", err.Error())
+		return
+	}
+	var xmlDesc string
+	if xmlDesc, err = virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE); err != nil {
+		err = fmt.Errorf("get define fail: %s", err.Error())
+		return
+	}
+	if !strings.Contains(xmlDesc, restartOnCrash) {
+		return false, nil
+	}
+	//take effect after next start when running
+	var modifiedData = strings.Replace(xmlDesc, restartOnCrash, destroyOnCrash, 1)
+	if _, err = util.virConnect.DomainDefineXML(modifiedData); err != nil {
+		err = fmt.Errorf("redefine fail: %s", err.Error())
+		return
+	}
+	return true, nil
 }
 
 func (util *InstanceUtility) SetCPUThreshold(guestID string, priority PriorityEnum) (err error) {
@@ -1100,12 +1409,12 @@ func (util *InstanceUtility) ResetMonitorSecret(uuid string, display string, por
 	if isRunning {
 		updateFlag |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
 	}
-	var graphics = virDomainGraphicsElement{
-		Type:     display,
-		Port:     port,
-		Password: secret,
-		Listen:   &virDomainGraphicsListen{ListenTypeAddress, ListenAllAddress},
-	}
+	return updateGraphics(virDomain, display, port, secret, updateFlag)
+}
+
+func updateGraphics(virDomain *libvirt.Domain, display string, port uint, secret string,
+	updateFlag libvirt.DomainDeviceModifyFlags) (err error) {
+	var graphics = newGraphicsElement(display, port, secret)
 	var payload []byte
 	if payload, err = xml.Marshal(graphics); err != nil {
 		err = fmt.Errorf("generate graphic element fail: %s", err.Error())
@@ -1118,11 +1427,56 @@ func (util *InstanceUtility) ResetMonitorSecret(uuid string, display string, por
 	return nil
 }
 
+// newGraphicsElement : SPICE requires secure mode on all channels when TLS enabled in qemu.conf, VNC keeps plain
+func newGraphicsElement(display string, port uint, secret string) virDomainGraphicsElement {
+	var graphics = virDomainGraphicsElement{
+		Type:     display,
+		Port:     port,
+		Password: secret,
+		Listen:   &virDomainGraphicsListen{ListenTypeAddress, GetConfigurator().GetMonitorListen()},
+	}
+	if enabled, _ := GetConfigurator().GetConsoleTLS(); enabled && RemoteControlSPICE == display {
+		graphics.Port = 0
+		graphics.TLSPort = port
+		graphics.DefaultMode = GraphicsModeSecure
+	}
+	return graphics
+}
+
+// UpdateRemoteControl : apply configured listen address and TLS of monitor, take effect in next start
+func (util *InstanceUtility) UpdateRemoteControl(guestID, display string, port uint, secret string) (changed bool, err error) {
+	var virDomain *libvirt.Domain
+	if virDomain, err = util.virConnect.LookupDomainByUUIDString(guestID); err != nil {
+		err = fmt.Errorf("get guest fail: %s", err.Error())
+		return
+	}
+	var xmlDesc string
+	if xmlDesc, err = virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE); err != nil {
+		err = fmt.Errorf("get define of guest fail: %s", err.Error())
+		return
+	}
+	var define virDomainDefine
+	if err = xml.Unmarshal([]byte(xmlDesc), &define); err != nil {
+		err = fmt.Errorf("parse define of guest fail: %s", err.Error())
+		return
+	}
+	var current = define.Devices.Graphics
+	var expected = newGraphicsElement(display, port, secret)
+	if nil != current.Listen && expected.Listen.Address == current.Listen.Address &&
+		expected.Port == current.Port && expected.TLSPort == current.TLSPort {
+		return false, nil
+	}
+	if err = updateGraphics(virDomain, display, port, secret, libvirt.DOMAIN_DEVICE_MODIFY_CONFIG); err != nil {
+		return
+	}
+	return true, nil
+}
+
 func (util *InstanceUtility) InitialDomainNwfilter(instanceID string, policy SecurityPolicy) (err error) {
 	var filterName = generateNwfilterName(instanceID)
 	var xmlData []byte
 	var xmlString string
-	var filterDefine = policyToFilter(filterName, instanceID, &policy)
+	var filterDefine = policyToFilter(filterName, instanceID, &policy, ipv6Routers())
 	if xmlData, err = xml.MarshalIndent(filterDefine, "", " "); err != nil {
 		err = fmt.Errorf("generate nwfilter xml for instance '%s' fail: %s", instanceID, err.Error())
 		return
@@ -1157,7 +1511,7 @@ func (util *InstanceUtility) InitialDomainNwfilter(instanceID string, policy Sec
 			updateFlag |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
 		}
 		for _, interfaceDefine := range domainDefine.Devices.Interface {
-			if InterfaceTypeBridge == interfaceDefine.Type {
+			if InterfaceTypeBridge == interfaceDefine.Type && nil == interfaceDefine.VirtualPort {
 				if nil == interfaceDefine.Filter {
 					var deviceWithFilter = interfaceDefine
 					deviceWithFilter.Filter = &virNwfilterRef{
@@ -1181,7 +1535,7 @@ func (util *InstanceUtility) InitialDomainNwfilter(instanceID string, policy Sec
 
 func (util *InstanceUtility) SyncDomainNwfilter(id string, policy *SecurityPolicy) (err error) {
 	var filterName = generateNwfilterName(id)
-	var filterDefine = policyToFilter(filterName, id, policy)
+	var filterDefine = policyToFilter(filterName, id, policy, ipv6Routers())
 	var xmlData []byte
 	if xmlData, err = xml.MarshalIndent(filterDefine, "", " "); err != nil {
 		err = fmt.Errorf("generate nwfilter xml for instance '%s' fail: %s", id, err.Error())
@@ -1228,7 +1582,7 @@ func (util *InstanceUtility) createDefine(config GuestConfig) (define virDomainD
 	//<feature name='monitor' policy='disable'/>
 	//<topology sockets='1' cores='1' threads='1'/>
 	//</cpu>
-	
+
 	// define.CPU.Mode = cpuModeCustom
 	// define.CPU.Match = cpuMatchMinimum
 	// define.CPU.Check = cpuCheckPartial
@@ -1329,6 +1683,22 @@ func (util *InstanceUtility) createDefine(config GuestConfig) (define virDomainD
 			err = fmt.Errorf("set plain network fail: %s", err.Error())
 			return
 		}
+		if BridgeTypeOVS == GetConfigurator().GetBridgeType() {
+			define.SetOVSVirtualPort(config.ID)
+		}
+	case NetworkModeVPC:
+		//tap attached to bridge of overlay network
+		if err = define.SetPlainNetwork(config.Template.Network, OverlayBridgeName(config.NetworkVNI), config.HardwareAddress, nwfilterName,
+			config.ReceiveSpeed, config.SendSpeed); err != nil {
+			err = fmt.Errorf("set overlay network fail: %s", err.Error())
+			return
+		}
+	case NetworkModePrivate:
+		if err = define.SetPlainNetwork(config.Template.Network, PrivateBridgeName, config.HardwareAddress, nwfilterName,
+			config.ReceiveSpeed, config.SendSpeed); err != nil {
+			err = fmt.Errorf("set private network fail: %s", err.Error())
+			return
+		}
 	default:
 		err = fmt.Errorf("unsupported network mode :%d", config.NetworkMode)
 		return
@@ -1344,9 +1714,57 @@ func (util *InstanceUtility) createDefine(config GuestConfig) (define virDomainD
 	if config.Template.USB != USBModelNone {
 		define.Devices.Controller = append(define.Devices.Controller, virDomainControllerElement{USBController, DefaultControllerIndex, config.Template.USB})
 	}
+	if err = define.SetWatchdog(config.Template.Watchdog); err != nil {
+		err = fmt.Errorf("set watchdog fail: %s", err.Error())
+		return
+	}
+	if err = define.SetPanic(config.Template.Panic); err != nil {
+		err = fmt.Errorf("set panic device fail: %s", err.Error())
+		return
+	}
 	return
 }
 
+// SetWatchdog : memory dumped by cell, so guest paused by libvirt when dump
+func (define *virDomainDefine) SetWatchdog(action string) error {
+	var libvirtAction string
+	switch action {
+	case DeviceActionNone:
+		return nil
+	case DeviceActionReset, DeviceActionPoweroff, DeviceActionPause:
+		libvirtAction = action
+	case DeviceActionDump:
+		libvirtAction = DeviceActionPause
+	default:
+		return fmt.Errorf("invalid watchdog action '%s'", action)
+	}
+	define.Devices.Watchdog = &virDomainWatchdog{Model: WatchdogModelI6300ESB, Action: libvirtAction}
+	return nil
+}
+
+// SetPanic : action of pvpanic taken by on_crash, crashed guest preserved for cell when dump
+func (define *virDomainDefine) SetPanic(action string) error {
+	const (
+		crashRestart  = "restart"
+		crashDestroy  = "destroy"
+		crashPreserve = "preserve"
+	)
+	switch action {
+	case DeviceActionNone:
+		return nil
+	case DeviceActionReset:
+		define.OnCrash = crashRestart
+	case DeviceActionPoweroff:
+		define.OnCrash = crashDestroy
+	case DeviceActionPause, DeviceActionDump:
+		define.OnCrash = crashPreserve
+	default:
+		return fmt.Errorf("invalid panic action '%s'", action)
+	}
+	define.Devices.Panic = &virDomainPanic{Model: PanicModelISA}
+	return nil
+}
+
 func (topology *virDomainCpuTopology) SetCpuTopology(totalThreads uint) error {
 	const (
 		//SplitThreshold = 4
@@ -1477,6 +1895,19 @@ func (define *virDomainDefine) SetPlainNetwork(netBus, bridge, mac, filterName s
 	return nil
 }
 
+// SetOVSVirtualPort : tap plugged to OVS bridge by libvirt, with ID derived from instance in external-ids:iface-id of port.
+// nwfilter not available on OVS port, so security policy rejected for instance on OVS bridge, and IPv6 disabled without guard of router advertisement
+func (define *virDomainDefine) SetOVSVirtualPort(instanceID string) {
+	for index := range define.Devices.Interface {
+		var inf = &define.Devices.Interface[index]
+		inf.VirtualPort = &virDomainInterfaceVirtualPort{
+			Type:       VirtualPortTypeOVS,
+			Parameters: &virDomainInterfaceParameters{InterfaceID: ovsInterfaceID(instanceID, index)},
+		}
+		inf.Filter = nil
+	}
+}
+
 func (define *virDomainDefine) generateMacAddress() (string, error) {
 	const (
 		BufferSize = 3
@@ -1491,10 +1922,7 @@ func (define *virDomainDefine) generateMacAddress() (string, error) {
 }
 
 func (define *virDomainDefine) SetRemoteControl(display string, port uint, secret string) error {
-	define.Devices.Graphics.Port = port
-	define.Devices.Graphics.Type = display
-	define.Devices.Graphics.Password = secret
-	define.Devices.Graphics.Listen = &virDomainGraphicsListen{ListenTypeAddress, ListenAllAddress}
+	define.Devices.Graphics = newGraphicsElement(display, port, secret)
 	return nil
 }
 
@@ -1527,7 +1955,8 @@ func (define *virDomainDefine) Initial() {
 
 	define.OnPowerOff = DestroyInstance
 	define.OnReboot = RestartInstance
-	define.OnCrash = RestartInstance
+	//restart by policy of cell
+	define.OnCrash = DestroyInstance
 
 	define.PowerManage.Disk.Enabled = DefaultPowerEnabled
 	define.PowerManage.Mem.Enabled = DefaultPowerEnabled
@@ -1550,7 +1979,10 @@ func (define *virDomainDefine) Initial() {
 
 }
 
-func policyToFilter(name, uuid string, policy *SecurityPolicy) (nwfilter virNwfilterDefine) {
+// policyToFilter : rules of policy matched in iptables layer with connection state, only replies of accepted connections pass in reverse direction.
+// established connections, address resolution, neighbor discovery and DHCP accepted first, then rules of policy in order, default actions at last.
+// router advertisement and redirect only accepted from link-local address of routers
+func policyToFilter(name, uuid string, policy *SecurityPolicy, routers []string) (nwfilter virNwfilterDefine) {
 	const LowestPriority = 1000
 	if nil == policy {
 		//accept by default
@@ -1559,44 +1991,250 @@ func policyToFilter(name, uuid string, policy *SecurityPolicy) (nwfilter virNwfi
 	nwfilter.Name = name
 	nwfilter.UUID = uuid
 	var priority = LowestPriority - len(policy.Rules) - 1
-	var outRule = virNwfilterRule{
-		Direction: NwfilterDirectionOut,
-		Priority:  priority,
-		Action:    NwfilterActionAccept,
+	if !policy.Accept {
+		nwfilter.Rules = append(nwfilter.Rules, establishedRules(NwfilterDirectionIn, priority)...)
+		//neighbor discovery required by IPv6 of guest
+		nwfilter.Rules = append(nwfilter.Rules, neighborDiscoveryRules(NwfilterDirectionIn, priority)...)
+		for _, router := range routers {
+			nwfilter.Rules = append(nwfilter.Rules,
+				icmpv6Rule(NwfilterDirectionIn, priority, router, ICMPv6RouterAdvertisement),
+				icmpv6Rule(NwfilterDirectionIn, priority, router, ICMPv6Redirect))
+		}
+		nwfilter.Rules = append(nwfilter.Rules, dhcpRules(NwfilterDirectionIn, priority,
+			DHCPServerPort, DHCPClientPort, DHCPv6ServerPort, DHCPv6ClientPort)...)
+	}
+	if policy.RejectEgress {
+		nwfilter.Rules = append(nwfilter.Rules, establishedRules(NwfilterDirectionOut, priority)...)
+		//neighbor discovery and DHCP required by network of guest
+		nwfilter.Rules = append(nwfilter.Rules, neighborDiscoveryRules(NwfilterDirectionOut, priority)...)
+		nwfilter.Rules = append(nwfilter.Rules, dhcpRules(NwfilterDirectionOut, priority,
+			DHCPClientPort, DHCPServerPort, DHCPv6ClientPort, DHCPv6ServerPort)...)
 	}
-	nwfilter.Rules = append(nwfilter.Rules, outRule)
 	priority++
 	for _, rule := range policy.Rules {
-		var virRule = virNwfilterRule{
-			Direction: NwfilterDirectionIn,
-			Priority:  priority,
-		}
+		var action = NwfilterActionDrop
 		if rule.Accept {
-			virRule.Action = NwfilterActionAccept
-		} else {
-			virRule.Action = NwfilterActionDrop
+			action = NwfilterActionAccept
 		}
-		virRule.IPRule = &virNwfilerRuleIP{
-			Protocol:        string(rule.Protocol),
-			TargetPortStart: uint64(rule.TargetPort),
-			SourceAddress:   rule.SourceAddress,
+		var direction = NwfilterDirectionIn
+		if rule.IsEgress() {
+			direction = NwfilterDirectionOut
 		}
-		nwfilter.Rules = append(nwfilter.Rules, virRule)
+		var start, end = rule.portRange()
+		nwfilter.Rules = appendFilterRules(nwfilter.Rules, virNwfilterRule{
+			Direction: direction,
+			Priority:  priority,
+			Action:    action,
+		}, string(rule.Protocol), rule.SourceAddress, rule.TargetAddress, 0, 0, start, end)
 		priority++
 	}
-	var defaultRule = virNwfilterRule{
-		Direction: NwfilterDirectionIn,
+	nwfilter.Rules = append(nwfilter.Rules, defaultRules(NwfilterDirectionIn, priority, policy.Accept)...)
+	nwfilter.Rules = append(nwfilter.Rules, defaultRules(NwfilterDirectionOut, priority, !policy.RejectEgress)...)
+	return
+}
+
+// protocolElement : element of protocol in iptables layer, such as "tcp", "tcp-ipv6" or "icmpv6"
+func protocolElement(protocol string, ipv6 bool) xml.Name {
+	if !ipv6 {
+		return xml.Name{Local: protocol}
+	}
+	if PolicyRuleProtocolICMP == protocol {
+		return xml.Name{Local: NwfilterProtocolICMPv6}
+	}
+	return xml.Name{Local: protocol + nwfilterIPv6Suffix}
+}
+
+// appendFilterRules : append IPv4 and IPv6 rule matching family of addresses, both when addresses omitted
+func appendFilterRules(rules []virNwfilterRule, base virNwfilterRule, protocol string, sourceAddress, targetAddress string,
+	sourceStart, sourceEnd, targetStart, targetEnd uint64) []virNwfilterRule {
+	var source, sourceMask = splitRuleAddress(sourceAddress)
+	var target, targetMask = splitRuleAddress(targetAddress)
+	for _, ipv6 := range []bool{false, true} {
+		if ipv6 && !(isRuleAddressIPv6(source) && isRuleAddressIPv6(target)) {
+			continue
+		} else if !ipv6 && !(isRuleAddressIPv4(source) && isRuleAddressIPv4(target)) {
+			continue
+		}
+		var rule = base
+		rule.ProtocolRule = &virNwfilerRuleProtocol{
+			XMLName:         protocolElement(protocol, ipv6),
+			SourcePortStart: sourceStart,
+			SourcePortEnd:   sourceEnd,
+			TargetPortStart: targetStart,
+			TargetPortEnd:   targetEnd,
+			SourceAddress:   source,
+			SourceMask:      sourceMask,
+			TargetAddress:   target,
+			TargetMask:      targetMask,
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// establishedRules : ARP and IP frames pass ebtables layer, replies and related packets of tracked connections accepted in iptables layer
+func establishedRules(direction string, priority int) (rules []virNwfilterRule) {
+	for _, protocol := range []string{NwfilterMACProtocolARP, NwfilterMACProtocolIPv4, NwfilterMACProtocolIPv6} {
+		rules = append(rules, virNwfilterRule{
+			Direction: direction,
+			Priority:  priority,
+			Action:    NwfilterActionAccept,
+			MACRule:   &virNwfilerRuleMAC{ProtocolID: protocol},
+		})
+	}
+	for _, ipv6 := range []bool{false, true} {
+		rules = append(rules, virNwfilterRule{
+			Direction:  direction,
+			Priority:   priority,
+			Action:     NwfilterActionAccept,
+			StateMatch: NwfilterStateMatchDisabled,
+			ProtocolRule: &virNwfilerRuleProtocol{
+				XMLName: protocolElement(NwfilterProtocolAll, ipv6),
+				State:   NwfilterStateEstablished,
+			},
+		})
+	}
+	return
+}
+
+// dhcpRules : DHCP and DHCPv6 between ports, not tracked when request sent to broadcast or multicast address
+func dhcpRules(direction string, priority int, sourcePort, targetPort, sourcePortV6, targetPortV6 uint64) []virNwfilterRule {
+	var rules []virNwfilterRule
+	for _, ipv6 := range []bool{false, true} {
+		var rule = virNwfilterRule{
+			Direction:  direction,
+			Priority:   priority,
+			Action:     NwfilterActionAccept,
+			StateMatch: NwfilterStateMatchDisabled,
+			ProtocolRule: &virNwfilerRuleProtocol{
+				XMLName:         protocolElement(PolicyRuleProtocolUDP, ipv6),
+				SourcePortStart: sourcePort,
+				TargetPortStart: targetPort,
+			},
+		}
+		if ipv6 {
+			rule.ProtocolRule.SourcePortStart = sourcePortV6
+			rule.ProtocolRule.TargetPortStart = targetPortV6
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// defaultRules : other ethernet frames in ebtables layer, and IP packets in any state in iptables layer
+func defaultRules(direction string, priority int, accept bool) []virNwfilterRule {
+	var action = NwfilterActionDrop
+	if accept {
+		action = NwfilterActionAccept
+	}
+	var rules = []virNwfilterRule{{
+		Direction: direction,
 		Priority:  priority,
+		Action:    action,
+	}}
+	for _, ipv6 := range []bool{false, true} {
+		rules = append(rules, virNwfilterRule{
+			Direction:    direction,
+			Priority:     priority,
+			Action:       action,
+			StateMatch:   NwfilterStateMatchDisabled,
+			ProtocolRule: &virNwfilerRuleProtocol{XMLName: protocolElement(NwfilterProtocolAll, ipv6)},
+		})
+	}
+	return rules
+}
+
+// icmpv6Rule : message of neighbor discovery from source, not tracked by connection
+func icmpv6Rule(direction string, priority int, source string, messageType uint) virNwfilterRule {
+	return virNwfilterRule{
+		Direction:  direction,
+		Priority:   priority,
+		Action:     NwfilterActionAccept,
+		StateMatch: NwfilterStateMatchDisabled,
+		ProtocolRule: &virNwfilerRuleProtocol{
+			XMLName:       protocolElement(PolicyRuleProtocolICMP, true),
+			SourceAddress: source,
+			Type:          messageType,
+		},
+	}
+}
+
+// neighborDiscoveryRules : router solicitation, neighbor solicitation and advertisement, but no advertisement or redirect of router
+func neighborDiscoveryRules(direction string, priority int) []virNwfilterRule {
+	return []virNwfilterRule{
+		icmpv6Rule(direction, priority, "", ICMPv6RouterSolicitation),
+		icmpv6Rule(direction, priority, "", ICMPv6NeighborSolicitation),
+		icmpv6Rule(direction, priority, "", ICMPv6NeighborAdvertisement),
+	}
+}
+
+// ipv6Routers : link-local address of default bridge advertised by cell, and default gateway on bridge
+func ipv6Routers() (routers []string) {
+	link, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		log.Printf("<instance> warning: get bridge '%s' fail: %s", DefaultBridgeName, err.Error())
+		return
 	}
-	if policy.Accept {
-		defaultRule.Action = NwfilterActionAccept
-	} else {
-		defaultRule.Action = NwfilterActionDrop
+	if addresses, err := netlink.AddrList(link, netlink.FAMILY_V6); nil == err {
+		for _, address := range addresses {
+			if address.IP.IsLinkLocalUnicast() {
+				routers = append(routers, address.IP.String())
+			}
+		}
+	}
+	if routes, err := netlink.RouteList(link, netlink.FAMILY_V6); nil == err {
+		for _, route := range routes {
+			if nil == route.Dst && nil != route.Gw && route.Gw.IsLinkLocalUnicast() {
+				routers = append(routers, route.Gw.String())
+			}
+		}
 	}
-	nwfilter.Rules = append(nwfilter.Rules, defaultRule)
 	return
 }
 
+// IsValidRuleAddress : IPv4/IPv6 address, or network in CIDR
+func IsValidRuleAddress(address string) bool {
+	if nil != net.ParseIP(address) {
+		return true
+	}
+	_, _, err := net.ParseCIDR(address)
+	return nil == err
+}
+
+// splitRuleAddress : split CIDR to network address and prefix length,
+// unspecified address such as "0.0.0.0" from integer of request matches any
+func splitRuleAddress(address string) (ip, mask string) {
+	if !strings.Contains(address, "/") {
+		if current := net.ParseIP(address); nil != current && current.IsUnspecified() {
+			return "", ""
+		}
+		return address, ""
+	}
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return address, ""
+	}
+	var ones, _ = network.Mask.Size()
+	return network.IP.String(), strconv.Itoa(ones)
+}
+
+// isRuleAddressIPv4 : empty address matches both IPv4 and IPv6
+func isRuleAddressIPv4(address string) bool {
+	if "" == address {
+		return true
+	}
+	var ip = net.ParseIP(address)
+	return nil != ip && nil != ip.To4()
+}
+
+func isRuleAddressIPv6(address string) bool {
+	if "" == address {
+		return true
+	}
+	var ip = net.ParseIP(address)
+	return nil != ip && nil == ip.To4()
+}
+
 func generateNwfilterName(id string) string {
 	return NwfilterPrefix + id
 }
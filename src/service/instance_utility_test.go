@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestPolicyToFilter(t *testing.T) {
+	var policy = SecurityPolicy{
+		Accept:       false,
+		RejectEgress: true,
+		Rules: []SecurityPolicyRule{
+			{Accept: true, Protocol: PolicyRuleProtocolTCP, SourceAddress: "0.0.0.0", TargetAddress: "0.0.0.0", TargetPort: 22},
+			{Accept: false, Direction: PolicyRuleDirectionOut, Protocol: PolicyRuleProtocolTCP, TargetAddress: "10.0.0.0/8",
+				TargetPort: 1, TargetPortEnd: 65535},
+			{Accept: true, Direction: PolicyRuleDirectionOut, Protocol: PolicyRuleProtocolUDP, TargetAddress: "2001:db8::/32", TargetPort: 53},
+		},
+	}
+	var filter = policyToFilter("test", "", &policy, []string{"fe80::1"})
+	var inbound, outbound, resolver *virNwfilterRule
+	for index, rule := range filter.Rules {
+		if nil == rule.ProtocolRule {
+			continue
+		}
+		switch {
+		case NwfilterDirectionIn == rule.Direction && 22 == rule.ProtocolRule.TargetPortStart && nil == inbound:
+			inbound = &filter.Rules[index]
+		case NwfilterDirectionOut == rule.Direction && "10.0.0.0" == rule.ProtocolRule.TargetAddress:
+			outbound = &filter.Rules[index]
+		case 53 == rule.ProtocolRule.TargetPortStart:
+			resolver = &filter.Rules[index]
+		case 0 != rule.ProtocolRule.SourcePortStart && DHCPClientPort != rule.ProtocolRule.SourcePortStart &&
+			DHCPServerPort != rule.ProtocolRule.SourcePortStart && DHCPv6ClientPort != rule.ProtocolRule.SourcePortStart &&
+			DHCPv6ServerPort != rule.ProtocolRule.SourcePortStart:
+			t.Fatalf("stateless rule of replies %v", rule.ProtocolRule)
+		}
+	}
+	//unspecified address matches any, replies tracked by connection state
+	if nil == inbound || "tcp" != inbound.ProtocolRule.XMLName.Local || "" != inbound.ProtocolRule.SourceAddress ||
+		"" != inbound.ProtocolRule.TargetAddress || "" != inbound.StateMatch {
+		t.Fatalf("unexpected inbound rule %v", inbound)
+	}
+	if nil == outbound || NwfilterActionDrop != outbound.Action || "8" != outbound.ProtocolRule.TargetMask ||
+		1 != outbound.ProtocolRule.TargetPortStart || 65535 != outbound.ProtocolRule.TargetPortEnd {
+		t.Fatalf("unexpected outbound rule %v", outbound)
+	}
+	if outbound.Priority <= inbound.Priority {
+		t.Fatalf("unexpected priority %d of outbound rule", outbound.Priority)
+	}
+	//IPv6 destination only in IPv6 rule
+	if nil == resolver || "udp-ipv6" != resolver.ProtocolRule.XMLName.Local || NwfilterDirectionOut != resolver.Direction {
+		t.Fatalf("unexpected rule of IPv6 destination %v", resolver)
+	}
+	//router advertisement only from router
+	var advertisements = 0
+	for _, rule := range filter.Rules {
+		if nil == rule.ProtocolRule || NwfilterProtocolICMPv6 != rule.ProtocolRule.XMLName.Local || NwfilterActionAccept != rule.Action {
+			continue
+		}
+		var icmp = rule.ProtocolRule
+		if ICMPv6RouterAdvertisement == icmp.Type || ICMPv6Redirect == icmp.Type {
+			if NwfilterDirectionIn != rule.Direction || "fe80::1" != icmp.SourceAddress {
+				t.Fatalf("unexpected rule of router message %v", icmp)
+			}
+			advertisements++
+		}
+	}
+	if 2 != advertisements {
+		t.Fatalf("unexpected %d rules of router message", advertisements)
+	}
+	//default actions on ethernet frames, IPv4 and IPv6 of both directions
+	var defaults = filter.Rules[len(filter.Rules)-6:]
+	for index, rule := range defaults {
+		var direction = NwfilterDirectionIn
+		if index >= 3 {
+			direction = NwfilterDirectionOut
+		}
+		if NwfilterActionDrop != rule.Action || direction != rule.Direction || outbound.Priority >= rule.Priority {
+			t.Fatalf("unexpected default rule %v", rule)
+		}
+	}
+	if !policy.Rules[1].matchable() || (SecurityPolicyRule{SourceAddress: "192.168.1.1", TargetAddress: "2001:db8::1"}).matchable() {
+		t.Fatal("unexpected address family check")
+	}
+	if 2 != policy.adjacentRule(1, 1) || -1 != policy.adjacentRule(1, -1) || -1 != policy.adjacentRule(0, 1) {
+		t.Fatal("unexpected adjacent rule")
+	}
+}
+
+func TestPolicyToFilter_Established(t *testing.T) {
+	var policy = SecurityPolicy{
+		Accept:       false,
+		RejectEgress: true,
+		Rules: []SecurityPolicyRule{
+			{Accept: true, Protocol: PolicyRuleProtocolTCP, TargetPort: 80},
+			{Accept: false, Direction: PolicyRuleDirectionOut, Protocol: PolicyRuleProtocolTCP, TargetPort: 25},
+		},
+	}
+	var filter = policyToFilter("test", "", &policy, nil)
+	var established = map[string]bool{}
+	var ruleOfPort = map[uint64]virNwfilterRule{}
+	for _, rule := range filter.Rules {
+		if nil == rule.ProtocolRule {
+			continue
+		}
+		if NwfilterStateEstablished == rule.ProtocolRule.State && NwfilterActionAccept == rule.Action {
+			established[rule.Direction+rule.ProtocolRule.XMLName.Local] = true
+		}
+		if 0 != rule.ProtocolRule.TargetPortStart {
+			ruleOfPort[rule.ProtocolRule.TargetPortStart] = rule
+		}
+	}
+	for _, direction := range []string{NwfilterDirectionIn, NwfilterDirectionOut} {
+		for _, element := range []string{"all", "all-ipv6"} {
+			if !established[direction+element] {
+				t.Fatalf("established %s traffic of direction %s not accepted", element, direction)
+			}
+		}
+	}
+	//tcp sport 80 -> dport 25 matches no accept rule of a new connection, dropped before default
+	var service, smtp = ruleOfPort[80], ruleOfPort[25]
+	if NwfilterActionAccept != service.Action || NwfilterDirectionIn != service.Direction || NwfilterStateMatchDisabled == service.StateMatch {
+		t.Fatalf("unexpected rule of service %v", service)
+	}
+	if NwfilterActionDrop != smtp.Action || NwfilterDirectionOut != smtp.Direction {
+		t.Fatalf("unexpected egress rule %v", smtp)
+	}
+	for _, rule := range filter.Rules {
+		if nil == rule.ProtocolRule || NwfilterActionAccept != rule.Action || NwfilterDirectionOut != rule.Direction {
+			continue
+		}
+		if NwfilterStateEstablished == rule.ProtocolRule.State {
+			continue
+		}
+		var matched = rule.ProtocolRule
+		if (0 == matched.SourcePortStart || 80 == matched.SourcePortStart) && (0 == matched.TargetPortStart || 25 == matched.TargetPortStart) &&
+			!strings.HasPrefix(matched.XMLName.Local, "udp") && NwfilterProtocolICMPv6 != matched.XMLName.Local {
+			t.Fatalf("new connection from port 80 to 25 accepted by %v", matched)
+		}
+	}
+	data, err := xml.Marshal(filter)
+	if err != nil {
+		t.Fatalf("marshal filter fail: %s", err.Error())
+	}
+	if !strings.Contains(string(data), `<tcp dstportstart="80"></tcp>`) {
+		t.Fatalf("unexpected filter %s", data)
+	}
+}
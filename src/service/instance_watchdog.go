@@ -0,0 +1,171 @@
+package service
+
+import (
+	"github.com/libvirt/libvirt-go"
+	"log"
+	"time"
+)
+
+const (
+	deviceTriggerWatchdog = iota
+	deviceTriggerPanic
+	//dump requested by user
+	deviceTriggerRequest
+)
+
+type guestDeviceEvent struct {
+	GuestID string
+	Trigger int
+}
+
+type guestDumpResult struct {
+	GuestID  string
+	Trigger  int
+	Path     string
+	Error    error
+	RespChan chan InstanceResult
+}
+
+// registerDeviceEvents : callbacks invoked in event loop of libvirt, only forward to routine
+func (manager *InstanceManager) registerDeviceEvents() (callbacks []int) {
+	var forward = func(domain *libvirt.Domain, trigger int) {
+		guestID, err := domain.GetUUIDString()
+		if err != nil {
+			log.Printf("<instance> warning: get id of triggered guest fail: %s", err.Error())
+			return
+		}
+		select {
+		case manager.deviceEvents <- guestDeviceEvent{GuestID: guestID, Trigger: trigger}:
+		default:
+			log.Printf("<instance> warning: device event of guest '%s' dropped", guestID)
+		}
+	}
+	watchdogID, err := manager.util.virConnect.DomainEventWatchdogRegister(nil,
+		func(c *libvirt.Connect, d *libvirt.Domain, event *libvirt.DomainEventWatchdog) {
+			forward(d, deviceTriggerWatchdog)
+		})
+	if err != nil {
+		log.Printf("<instance> warning: register watchdog event fail: %s", err.Error())
+	} else {
+		callbacks = append(callbacks, watchdogID)
+	}
+	lifecycleID, err := manager.util.virConnect.DomainEventLifecycleRegister(nil,
+		func(c *libvirt.Connect, d *libvirt.Domain, event *libvirt.DomainEventLifecycle) {
+			if libvirt.DOMAIN_EVENT_CRASHED == event.Event &&
+				libvirt.DOMAIN_EVENT_CRASHED_PANICKED == libvirt.DomainEventCrashedDetailType(event.Detail) {
+				forward(d, deviceTriggerPanic)
+			}
+		})
+	if err != nil {
+		log.Printf("<instance> warning: register lifecycle event fail: %s", err.Error())
+	} else {
+		callbacks = append(callbacks, lifecycleID)
+	}
+	return
+}
+
+func (manager *InstanceManager) deregisterDeviceEvents(callbacks []int) {
+	for _, callbackID := range callbacks {
+		if err := manager.util.virConnect.DomainEventDeregister(callbackID); err != nil {
+			log.Printf("<instance> warning: deregister event %d fail: %s", callbackID, err.Error())
+		}
+	}
+}
+
+func (manager *InstanceManager) handleDeviceEvent(event guestDeviceEvent) {
+	ins, exists := manager.instances[event.GuestID]
+	if !exists {
+		return
+	}
+	var action = DeviceActionNone
+	var eventType StatusChangedEvent = GuestWatchdogTriggered
+	var trigger = "watchdog"
+	if nil != ins.Template {
+		action = ins.Template.Watchdog
+	}
+	if deviceTriggerPanic == event.Trigger {
+		eventType = GuestPanicked
+		trigger = "panic"
+		action = DeviceActionNone
+		if nil != ins.Template {
+			action = ins.Template.Panic
+		}
+	}
+	if DeviceActionDump != action {
+		if DeviceActionPoweroff == action {
+			//shut off as crashed by libvirt, but powered off as configured, so ignored by restart policy
+			manager.markStopRequested(event.GuestID)
+		}
+		log.Printf("<instance> %s of guest '%s' triggered, action '%s'", trigger, ins.Name, action)
+		manager.events <- InstanceStatusChangedEvent{ID: event.GuestID, Event: eventType, Action: action,
+			Timestamp: time.Now()}
+		return
+	}
+	if _, dumping := manager.dumpingGuests[event.GuestID]; dumping {
+		log.Printf("<instance> %s of guest '%s' ignored, dump in progress", trigger, ins.Name)
+		return
+	}
+	dumpFile, err := manager.newDiagnosticFile(event.GuestID, ins.StoragePool, DiagnosticCrash)
+	if err != nil {
+		log.Printf("<instance> %s of guest '%s' triggered, but prepare dump fail: %s", trigger, ins.Name, err.Error())
+		manager.finishGuestDump(guestDumpResult{GuestID: event.GuestID, Trigger: event.Trigger, Error: err})
+		return
+	}
+	manager.dumpingGuests[event.GuestID] = true
+	log.Printf("<instance> %s of guest '%s' triggered, dumping memory", trigger, ins.Name)
+	//dump may take minutes, so not block routine
+	go func(guestID string, trigger int) {
+		var err = manager.util.DumpGuestMemory(guestID, dumpFile)
+		manager.dumpResults <- guestDumpResult{GuestID: guestID, Trigger: trigger, Path: dumpFile, Error: err}
+	}(event.GuestID, event.Trigger)
+}
+
+// finishGuestDump : guest reset after watchdog dump, stopped after panic dump
+func (manager *InstanceManager) finishGuestDump(result guestDumpResult) {
+	delete(manager.dumpingGuests, result.GuestID)
+	var diagnostic GuestDiagnostic
+	if nil == result.Error {
+		var kind = DiagnosticCrash
+		if deviceTriggerRequest == result.Trigger {
+			kind = DiagnosticMemory
+		}
+		diagnostic, result.Error = saveDiagnostic(result.Path, kind)
+	}
+	if deviceTriggerRequest == result.Trigger {
+		if result.Error != nil {
+			log.Printf("<instance> dump memory of guest '%s' fail: %s", result.GuestID, result.Error.Error())
+			result.RespChan <- InstanceResult{Error: result.Error}
+		} else {
+			log.Printf("<instance> memory of guest '%s' dumped to '%s'", result.GuestID, result.Path)
+			result.RespChan <- InstanceResult{Diagnostic: diagnostic}
+		}
+		return
+	}
+	ins, exists := manager.instances[result.GuestID]
+	if !exists {
+		return
+	}
+	var eventType StatusChangedEvent = GuestWatchdogTriggered
+	if deviceTriggerPanic == result.Trigger {
+		eventType = GuestPanicked
+	}
+	if result.Error != nil {
+		log.Printf("<instance> dump memory of guest '%s' fail: %s", ins.Name, result.Error.Error())
+		result.Path = ""
+	} else {
+		log.Printf("<instance> memory of guest '%s' dumped to '%s'", ins.Name, result.Path)
+	}
+	manager.events <- InstanceStatusChangedEvent{ID: result.GuestID, Event: eventType, Action: DeviceActionDump,
+		Path: result.Path, Timestamp: time.Now()}
+	if deviceTriggerWatchdog == result.Trigger {
+		if err := manager.util.ResetInstance(result.GuestID); err != nil {
+			log.Printf("<instance> reset guest '%s' after dump fail: %s", ins.Name, err.Error())
+		}
+		return
+	}
+	//stopped as crashed for restart policy
+	manager.getRestartState(result.GuestID).crashDumped = true
+	if err := manager.util.StopInstance(result.GuestID, false, true); err != nil {
+		log.Printf("<instance> stop guest '%s' after dump fail: %s", ins.Name, err.Error())
+	}
+}
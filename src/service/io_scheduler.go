@@ -120,6 +120,11 @@ func (scheduler *IOScheduler) Stop() error {
 	return scheduler.runner.Stop()
 }
 
+// GetPendingTasks : count of tasks waiting in queue
+func (scheduler *IOScheduler) GetPendingTasks() int {
+	return len(scheduler.taskChan)
+}
+
 func (scheduler *IOScheduler) Routine(c framework.RoutineController) {
 	log.Printf("<scheduler-%s> started", scheduler.name)
 	for !c.IsStopping() {
@@ -0,0 +1,704 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/project-nano/framework"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"log"
+	"net"
+	"time"
+)
+
+// mode of IPv6 address configuration advertised to guests
+const (
+	IPv6ModeNone   = ""
+	IPv6ModeSLAAC  = "slaac"
+	IPv6ModeDHCPv6 = "dhcpv6"
+)
+
+const (
+	dhcpv6ServerPort        = 547
+	routerAdvertiseInterval = 3 * time.Minute
+	ipv6QueryTimeout        = 3 * time.Second
+	ipv6HopLimit            = 255
+	ipv6PacketSize          = 1500
+	ipv6PrefixLength        = 64
+	//lifetime in seconds
+	prefixValidLifetime     = 86400
+	prefixPreferredLifetime = 14400
+	dnsServerLifetime       = 600
+)
+
+// DHCPv6 message types, RFC 8415
+const (
+	dhcpv6Solicit            = 1
+	dhcpv6Advertise          = 2
+	dhcpv6Request            = 3
+	dhcpv6Confirm            = 4
+	dhcpv6Renew              = 5
+	dhcpv6Rebind             = 6
+	dhcpv6Reply              = 7
+	dhcpv6Release            = 8
+	dhcpv6Decline            = 9
+	dhcpv6InformationRequest = 11
+)
+
+// DHCPv6 options
+const (
+	dhcpv6OptionClientID        = 1
+	dhcpv6OptionServerID        = 2
+	dhcpv6OptionIANA            = 3
+	dhcpv6OptionIAAddress       = 5
+	dhcpv6OptionStatusCode      = 13
+	dhcpv6OptionRapidCommit     = 14
+	dhcpv6OptionDNSServers      = 23
+	dhcpv6OptionClientLinkLayer = 79
+)
+
+const (
+	dhcpv6StatusSuccess   = 0
+	dhcpv6StatusNotOnLink = 4
+)
+
+const (
+	duidTypeLLT          = 1
+	duidTypeLL           = 3
+	hardwareTypeEthernet = 1
+)
+
+// options of neighbor discovery, RFC 4861 & RFC 8106
+const (
+	ndOptionSourceLinkLayer   = 1
+	ndOptionPrefixInformation = 3
+	ndOptionDNSServers        = 25
+)
+
+var (
+	allNodesAddress      = net.ParseIP("ff02::1")
+	allRoutersAddress    = net.ParseIP("ff02::2")
+	allDHCPv6ServAddress = net.ParseIP("ff02::1:2")
+)
+
+type dhcpv6Option struct {
+	Code uint16
+	Data []byte
+}
+
+type dhcpv6Message struct {
+	Type          byte
+	TransactionID [3]byte
+	Options       []dhcpv6Option
+}
+
+type dhcpv6Packet struct {
+	Message dhcpv6Message
+	Source  *net.UDPAddr
+}
+
+// ipv6ProbeResult : conflict is empty when no other host found
+type ipv6ProbeResult struct {
+	Request  dhcpv6Packet
+	MAC      string
+	Address  net.IP
+	Result   NetworkResult
+	Conflict string
+	Error    error
+}
+
+// IPv6Service : router advertisement and DHCPv6 for guests on default bridge.
+// Router lifetime is zero, the default route comes from the upstream router or the gateway in cloud-init
+type IPv6Service struct {
+	mode          string
+	prefix        *net.IPNet
+	bridge        *net.Interface
+	serverDUID    []byte
+	raConn        *ipv6.PacketConn
+	dhcpConn      *ipv6.PacketConn
+	solicitations chan bool
+	requests      chan dhcpv6Packet
+	quarantine    addressQuarantineList
+	probes        addressProbeCache
+	probeResults  chan ipv6ProbeResult
+	eventChan     chan InstanceStatusChangedEvent
+	networkModule NetworkModule
+	runner        *framework.SimpleRunner
+}
+
+func CreateIPv6Service(netModule NetworkModule, eventChan chan InstanceStatusChangedEvent) (service *IPv6Service, err error) {
+	const (
+		DefaultQueueSize = 1 << 10
+	)
+	mode, prefix := GetConfigurator().GetIPv6()
+	if IPv6ModeSLAAC != mode && IPv6ModeDHCPv6 != mode {
+		err = fmt.Errorf("invalid IPv6 mode '%s'", mode)
+		return
+	}
+	service = &IPv6Service{}
+	service.mode = mode
+	if "" != prefix {
+		var ip net.IP
+		if ip, service.prefix, err = net.ParseCIDR(prefix); err != nil || nil != ip.To4() {
+			err = fmt.Errorf("invalid IPv6 prefix '%s'", prefix)
+			return
+		}
+		if ones, _ := service.prefix.Mask.Size(); IPv6ModeSLAAC == mode && ipv6PrefixLength != ones {
+			err = fmt.Errorf("prefix length %d not supported by SLAAC", ones)
+			return
+		}
+	} else if IPv6ModeSLAAC == mode {
+		err = errors.New("prefix required by SLAAC")
+		return
+	}
+	if service.bridge, err = net.InterfaceByName(DefaultBridgeName); err != nil {
+		err = fmt.Errorf("get interface of bridge '%s' fail: %s", DefaultBridgeName, err.Error())
+		return
+	}
+	if len(service.bridge.HardwareAddr) != 6 {
+		err = fmt.Errorf("invalid hardware address '%s' of bridge '%s'", service.bridge.HardwareAddr, DefaultBridgeName)
+		return
+	}
+	service.serverDUID = make([]byte, 4, 10)
+	binary.BigEndian.PutUint16(service.serverDUID, duidTypeLL)
+	binary.BigEndian.PutUint16(service.serverDUID[2:], hardwareTypeEthernet)
+	service.serverDUID = append(service.serverDUID, service.bridge.HardwareAddr...)
+	service.networkModule = netModule
+	service.eventChan = eventChan
+	service.quarantine = addressQuarantineList{}
+	service.probes = addressProbeCache{}
+	service.probeResults = make(chan ipv6ProbeResult, DefaultQueueSize)
+	service.solicitations = make(chan bool, DefaultQueueSize)
+	service.requests = make(chan dhcpv6Packet, DefaultQueueSize)
+	if err = service.listenRouterSolicitation(); err != nil {
+		return
+	}
+	if err = service.listenDHCPv6(); err != nil {
+		_ = service.raConn.Close()
+		return
+	}
+	service.runner = framework.CreateSimpleRunner(service.Routine)
+	return service, nil
+}
+
+func (service *IPv6Service) listenRouterSolicitation() (err error) {
+	icmpConn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		err = fmt.Errorf("listen ICMPv6 fail: %s", err.Error())
+		return
+	}
+	service.raConn = icmpConn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterSolicitation)
+	if err = service.raConn.SetICMPFilter(&filter); err != nil {
+		err = fmt.Errorf("set ICMPv6 filter fail: %s", err.Error())
+	} else if err = service.raConn.SetMulticastHopLimit(ipv6HopLimit); err != nil {
+		err = fmt.Errorf("set hop limit fail: %s", err.Error())
+	} else if err = service.raConn.SetMulticastInterface(service.bridge); err != nil {
+		err = fmt.Errorf("set multicast interface fail: %s", err.Error())
+	} else if err = service.raConn.JoinGroup(service.bridge, &net.IPAddr{IP: allRoutersAddress}); err != nil {
+		err = fmt.Errorf("join all routers group fail: %s", err.Error())
+	} else if err = service.raConn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		err = fmt.Errorf("enable control message fail: %s", err.Error())
+	}
+	if err != nil {
+		_ = service.raConn.Close()
+	}
+	return
+}
+
+func (service *IPv6Service) listenDHCPv6() (err error) {
+	udpConn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6ServerPort})
+	if err != nil {
+		err = fmt.Errorf("listen on DHCPv6 port fail, please disable dnsmasq or other DHCPv6 service.\nmessage: %s", err.Error())
+		return
+	}
+	service.dhcpConn = ipv6.NewPacketConn(udpConn)
+	if err = service.dhcpConn.JoinGroup(service.bridge, &net.UDPAddr{IP: allDHCPv6ServAddress}); err != nil {
+		err = fmt.Errorf("join DHCPv6 servers group fail: %s", err.Error())
+	} else if err = service.dhcpConn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		err = fmt.Errorf("enable control message fail: %s", err.Error())
+	}
+	if err != nil {
+		_ = service.dhcpConn.Close()
+	}
+	return
+}
+
+func (service *IPv6Service) Start() error {
+	return service.runner.Start()
+}
+
+func (service *IPv6Service) Stop() error {
+	return service.runner.Stop()
+}
+
+func (service *IPv6Service) Routine(c framework.RoutineController) {
+	log.Printf("<ipv6> service started in mode '%s' on %s", service.mode, service.bridge.Name)
+	go service.receiveSolicitations()
+	go service.receiveDHCPv6()
+	service.advertise()
+	var advertiseTicker = time.NewTicker(routerAdvertiseInterval)
+	for !c.IsStopping() {
+		select {
+		case <-c.GetNotifyChannel():
+			c.SetStopping()
+			_ = service.raConn.Close()
+			_ = service.dhcpConn.Close()
+		case <-service.solicitations:
+			service.advertise()
+		case request := <-service.requests:
+			service.handleDHCPv6(request)
+		case result := <-service.probeResults:
+			service.handleProbeResult(result)
+		case <-advertiseTicker.C:
+			service.advertise()
+		}
+	}
+	advertiseTicker.Stop()
+	c.NotifyExit()
+	log.Println("<ipv6> service stopped")
+}
+
+func (service *IPv6Service) receiveSolicitations() {
+	var buffer = make([]byte, ipv6PacketSize)
+	for {
+		_, cm, _, err := service.raConn.ReadFrom(buffer)
+		if err != nil {
+			log.Printf("<ipv6> receive router solicitation stopped: %s", err.Error())
+			return
+		}
+		if nil != cm && cm.IfIndex != service.bridge.Index {
+			continue
+		}
+		service.solicitations <- true
+	}
+}
+
+func (service *IPv6Service) receiveDHCPv6() {
+	var buffer = make([]byte, ipv6PacketSize)
+	for {
+		length, cm, source, err := service.dhcpConn.ReadFrom(buffer)
+		if err != nil {
+			log.Printf("<ipv6> receive DHCPv6 stopped: %s", err.Error())
+			return
+		}
+		if nil != cm && cm.IfIndex != service.bridge.Index {
+			continue
+		}
+		address, ok := source.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		message, err := parseDHCPv6Message(buffer[:length])
+		if err != nil {
+			log.Printf("<ipv6> ignore invalid DHCPv6 message from %s: %s", address.String(), err.Error())
+			continue
+		}
+		service.requests <- dhcpv6Packet{Message: message, Source: address}
+	}
+}
+
+func (service *IPv6Service) queryCurrentConfig() (result NetworkResult, err error) {
+	var respChan = make(chan NetworkResult, 1)
+	service.networkModule.GetCurrentConfig(respChan)
+	select {
+	case result = <-respChan:
+		return result, result.Error
+	case <-time.After(ipv6QueryTimeout):
+		err = errors.New("query network config timeout")
+		return
+	}
+}
+
+// advertise : multicast to all nodes, also as response to router solicitation
+func (service *IPv6Service) advertise() {
+	var servers []net.IP
+	if config, err := service.queryCurrentConfig(); err != nil {
+		log.Printf("<ipv6> warning: advertise without DNS servers: %s", err.Error())
+	} else {
+		for _, server := range config.DNSV6 {
+			if ip := net.ParseIP(server); nil != ip {
+				servers = append(servers, ip)
+			}
+		}
+	}
+	var message = icmp.Message{
+		Type: ipv6.ICMPTypeRouterAdvertisement,
+		Body: &icmp.RawBody{Data: buildRouterAdvertisement(service.mode, service.prefix, servers, service.bridge.HardwareAddr)},
+	}
+	//checksum calculated by kernel
+	data, err := message.Marshal(nil)
+	if err != nil {
+		log.Printf("<ipv6> marshal router advertisement fail: %s", err.Error())
+		return
+	}
+	var cm = &ipv6.ControlMessage{HopLimit: ipv6HopLimit, IfIndex: service.bridge.Index}
+	if _, err = service.raConn.WriteTo(data, cm, &net.IPAddr{IP: allNodesAddress, Zone: service.bridge.Name}); err != nil {
+		log.Printf("<ipv6> send router advertisement fail: %s", err.Error())
+	}
+}
+
+func (service *IPv6Service) handleDHCPv6(request dhcpv6Packet) {
+	var message = request.Message
+	switch message.Type {
+	case dhcpv6Solicit, dhcpv6Request, dhcpv6Confirm, dhcpv6Renew, dhcpv6Rebind, dhcpv6Release,
+		dhcpv6Decline, dhcpv6InformationRequest:
+	default:
+		return
+	}
+	if serverID, exists := message.getOption(dhcpv6OptionServerID); exists && !bytes.Equal(serverID, service.serverDUID) {
+		//for other server
+		return
+	}
+	var result NetworkResult
+	var mac string
+	var err error
+	if dhcpv6InformationRequest == message.Type {
+		//stateless, DNS only
+		if result, err = service.queryCurrentConfig(); err != nil {
+			log.Printf("<ipv6> query config for %s fail: %s", request.Source.IP, err.Error())
+			return
+		}
+	} else {
+		if mac, err = dhcpv6ClientMAC(message, request.Source.IP); err != nil {
+			log.Printf("<ipv6> ignore DHCPv6 message from %s: %s", request.Source.IP, err.Error())
+			return
+		}
+		var respChan = make(chan NetworkResult, 1)
+		service.networkModule.GetAddressByHWAddress(mac, respChan)
+		select {
+		case result = <-respChan:
+			if result.Error != nil {
+				//not guest of current cell
+				return
+			}
+		case <-time.After(ipv6QueryTimeout):
+			log.Printf("<ipv6> query address of %s timeout", mac)
+			return
+		}
+		if IPv6ModeDHCPv6 == service.mode && "" != result.InternalV6 && !service.checkConflict(request, mac, result) {
+			return
+		}
+	}
+	service.sendReply(request, mac, result)
+}
+
+func (service *IPv6Service) sendReply(request dhcpv6Packet, mac string, result NetworkResult) {
+	var message = request.Message
+	reply, err := buildDHCPv6Reply(message, service.serverDUID, result, IPv6ModeDHCPv6 == service.mode)
+	if err != nil {
+		log.Printf("<ipv6> build reply for %s fail: %s", request.Source.IP, err.Error())
+		return
+	}
+	var target = &net.UDPAddr{IP: request.Source.IP, Port: request.Source.Port, Zone: service.bridge.Name}
+	var cm = &ipv6.ControlMessage{IfIndex: service.bridge.Index}
+	if _, err = service.dhcpConn.WriteTo(reply.marshal(), cm, target); err != nil {
+		log.Printf("<ipv6> send DHCPv6 reply to %s fail: %s", target.String(), err.Error())
+		return
+	}
+	if dhcpv6Request == message.Type || (dhcpv6Reply == reply.Type && dhcpv6Solicit == message.Type) {
+		log.Printf("<ipv6> address '%s' assigned to MAC '%s'", result.InternalV6, mac)
+	}
+}
+
+// checkConflict : false when address of client should not be assigned now.
+// Probe runs outside the service routine, reply of solicit sent when probe finished
+func (service *IPv6Service) checkConflict(request dhcpv6Packet, mac string, result NetworkResult) bool {
+	address, _, err := net.ParseCIDR(result.InternalV6)
+	if err != nil {
+		return true
+	}
+	var ip = address.String()
+	switch request.Message.Type {
+	case dhcpv6Decline:
+		//address in use detected by client, reply anyway and quarantine when conflicting host identified
+		service.startProbe(request, mac, address, result)
+		return true
+	case dhcpv6Solicit, dhcpv6Request, dhcpv6Renew, dhcpv6Rebind:
+		if expire, quarantined := service.quarantine.IsQuarantined(ip); quarantined {
+			log.Printf("<ipv6> address %s of MAC '%s' quarantined until '%s'", ip, mac, expire.Format(TimeFormatLayout))
+			return false
+		}
+		if dhcpv6Solicit != request.Message.Type {
+			return true
+		}
+		switch service.probes.State(ip) {
+		case probeClean:
+			return true
+		case probePending:
+			//retransmitted by client
+			return false
+		default:
+			service.startProbe(request, mac, address, result)
+			return false
+		}
+	}
+	return true
+}
+
+func (service *IPv6Service) startProbe(request dhcpv6Packet, mac string, address net.IP, result NetworkResult) {
+	service.probes.SetPending(address.String())
+	go func() {
+		var probe = ipv6ProbeResult{Request: request, MAC: mac, Address: address, Result: result}
+		owner, err := net.ParseMAC(mac)
+		if err != nil {
+			probe.Error = err
+		} else if conflicted, hwaddress, err := ProbeIPv6Address(service.bridge, address, owner, addressProbeTimeout); err != nil {
+			probe.Error = err
+		} else if conflicted {
+			probe.Conflict = hwaddress.String()
+		}
+		service.probeResults <- probe
+	}()
+}
+
+// handleProbeResult : reply of solicit sent when no conflict found, or probe failed
+func (service *IPv6Service) handleProbeResult(probe ipv6ProbeResult) {
+	var ip = probe.Address.String()
+	if dhcpv6Decline == probe.Request.Message.Type {
+		service.probes.Remove(ip)
+		service.quarantineAddress(probe.Result.Instance, ip, probe.MAC, probe.Conflict)
+		return
+	}
+	if nil != probe.Error {
+		log.Printf("<ipv6> warning: probe address %s for MAC '%s' fail: %s", ip, probe.MAC, probe.Error.Error())
+		//probe again when client retransmits
+		service.probes.Remove(ip)
+	} else if "" != probe.Conflict {
+		service.probes.Remove(ip)
+		service.quarantineAddress(probe.Result.Instance, ip, probe.MAC, probe.Conflict)
+		return
+	} else {
+		service.probes.SetClean(ip)
+	}
+	service.sendReply(probe.Request, probe.MAC, probe.Result)
+}
+
+func (service *IPv6Service) quarantineAddress(instanceID, address, mac, conflict string) {
+	var expire = service.quarantine.Add(address)
+	log.Printf("<ipv6> address %s of MAC '%s' conflict with host '%s', quarantined until '%s'",
+		address, mac, conflict, expire.Format(TimeFormatLayout))
+	service.eventChan <- newAddressConflictEvent(instanceID, address, conflict)
+}
+
+// buildRouterAdvertisement : body of RA after checksum. Other configuration flag always set for DNS servers
+func buildRouterAdvertisement(mode string, prefix *net.IPNet, servers []net.IP, hwaddress net.HardwareAddr) []byte {
+	const (
+		curHopLimit    = 64
+		flagManaged    = 0x80
+		flagOther      = 0x40
+		flagOnLink     = 0x80
+		flagAutonomous = 0x40
+	)
+	var flags byte = flagOther
+	if IPv6ModeDHCPv6 == mode {
+		flags |= flagManaged
+	}
+	//router lifetime, reachable time and retransmit timer are zero
+	var body = []byte{curHopLimit, flags, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	body = append(body, ndOptionSourceLinkLayer, 1)
+	body = append(body, hwaddress...)
+	if nil != prefix {
+		var option = make([]byte, 32)
+		var ones, _ = prefix.Mask.Size()
+		option[0] = ndOptionPrefixInformation
+		option[1] = 4
+		option[2] = byte(ones)
+		option[3] = flagOnLink
+		if IPv6ModeSLAAC == mode {
+			option[3] |= flagAutonomous
+		}
+		binary.BigEndian.PutUint32(option[4:], prefixValidLifetime)
+		binary.BigEndian.PutUint32(option[8:], prefixPreferredLifetime)
+		copy(option[16:], prefix.IP.To16())
+		body = append(body, option...)
+	}
+	if 0 != len(servers) {
+		var option = make([]byte, 8, 8+net.IPv6len*len(servers))
+		option[0] = ndOptionDNSServers
+		option[1] = byte(1 + 2*len(servers))
+		binary.BigEndian.PutUint32(option[4:], dnsServerLifetime)
+		for _, server := range servers {
+			option = append(option, server.To16()...)
+		}
+		body = append(body, option...)
+	}
+	return body
+}
+
+// buildDHCPv6Reply : addresses assigned by Core, so lease only used for renew
+func buildDHCPv6Reply(request dhcpv6Message, serverDUID []byte, result NetworkResult, stateful bool) (reply dhcpv6Message, err error) {
+	clientID, hasClientID := request.getOption(dhcpv6OptionClientID)
+	if !hasClientID && dhcpv6InformationRequest != request.Type {
+		err = errors.New("client identifier required")
+		return
+	}
+	reply.Type = dhcpv6Reply
+	reply.TransactionID = request.TransactionID
+	reply.addOption(dhcpv6OptionServerID, serverDUID)
+	if hasClientID {
+		reply.addOption(dhcpv6OptionClientID, clientID)
+	}
+	if dhcpv6Solicit == request.Type {
+		if _, rapidCommit := request.getOption(dhcpv6OptionRapidCommit); rapidCommit {
+			reply.addOption(dhcpv6OptionRapidCommit, nil)
+		} else {
+			reply.Type = dhcpv6Advertise
+		}
+	}
+	var servers []byte
+	for _, server := range result.DNSV6 {
+		if ip := net.ParseIP(server); nil != ip {
+			servers = append(servers, ip.To16()...)
+		}
+	}
+	if 0 != len(servers) {
+		reply.addOption(dhcpv6OptionDNSServers, servers)
+	}
+	switch request.Type {
+	case dhcpv6InformationRequest:
+		return reply, nil
+	case dhcpv6Release, dhcpv6Decline:
+		reply.addOption(dhcpv6OptionStatusCode, dhcpv6Status(dhcpv6StatusSuccess, "released"))
+		return reply, nil
+	case dhcpv6Confirm:
+		if stateful && "" != result.InternalV6 {
+			reply.addOption(dhcpv6OptionStatusCode, dhcpv6Status(dhcpv6StatusSuccess, "confirmed"))
+		} else {
+			reply.addOption(dhcpv6OptionStatusCode, dhcpv6Status(dhcpv6StatusNotOnLink, "not assigned"))
+		}
+		return reply, nil
+	}
+	if !stateful {
+		err = errors.New("stateful address not available in SLAAC mode")
+		return
+	}
+	association, exists := request.getOption(dhcpv6OptionIANA)
+	if !exists || len(association) < 12 {
+		err = errors.New("IA_NA required")
+		return
+	}
+	if "" == result.InternalV6 {
+		err = errors.New("no IPv6 address assigned")
+		return
+	}
+	address, _, err := net.ParseCIDR(result.InternalV6)
+	if err != nil {
+		err = fmt.Errorf("invalid IPv6 address '%s'", result.InternalV6)
+		return
+	}
+	var lifetime = uint32(leaseTimeout / time.Second)
+	var addressOption = make([]byte, 24)
+	copy(addressOption, address.To16())
+	binary.BigEndian.PutUint32(addressOption[16:], lifetime)
+	binary.BigEndian.PutUint32(addressOption[20:], lifetime)
+	//IAID, T1, T2
+	var iana = make([]byte, 12)
+	copy(iana, association[:4])
+	binary.BigEndian.PutUint32(iana[4:], lifetime/2)
+	binary.BigEndian.PutUint32(iana[8:], lifetime*4/5)
+	iana = appendDHCPv6Option(iana, dhcpv6OptionIAAddress, addressOption)
+	reply.addOption(dhcpv6OptionIANA, iana)
+	return reply, nil
+}
+
+// dhcpv6ClientMAC : from EUI-64 of link local source, or DUID/link-layer option with ethernet address
+func dhcpv6ClientMAC(message dhcpv6Message, source net.IP) (mac string, err error) {
+	const (
+		duidLLTSize   = 14
+		duidLLSize    = 10
+		linkLayerSize = 8
+	)
+	if ip := source.To16(); nil != ip && source.IsLinkLocalUnicast() && 0xFF == ip[11] && 0xFE == ip[12] {
+		return net.HardwareAddr{ip[8] ^ 0x02, ip[9], ip[10], ip[13], ip[14], ip[15]}.String(), nil
+	}
+	if duid, exists := message.getOption(dhcpv6OptionClientID); exists && len(duid) >= 4 &&
+		hardwareTypeEthernet == binary.BigEndian.Uint16(duid[2:]) {
+		switch binary.BigEndian.Uint16(duid) {
+		case duidTypeLLT:
+			if duidLLTSize == len(duid) {
+				return net.HardwareAddr(duid[8:]).String(), nil
+			}
+		case duidTypeLL:
+			if duidLLSize == len(duid) {
+				return net.HardwareAddr(duid[4:]).String(), nil
+			}
+		}
+	}
+	if linkLayer, exists := message.getOption(dhcpv6OptionClientLinkLayer); exists && linkLayerSize == len(linkLayer) &&
+		hardwareTypeEthernet == binary.BigEndian.Uint16(linkLayer) {
+		return net.HardwareAddr(linkLayer[2:]).String(), nil
+	}
+	return "", errors.New("no hardware address available")
+}
+
+func dhcpv6Status(code uint16, message string) []byte {
+	var data = make([]byte, 2, 2+len(message))
+	binary.BigEndian.PutUint16(data, code)
+	return append(data, message...)
+}
+
+func appendDHCPv6Option(data []byte, code uint16, value []byte) []byte {
+	var header = make([]byte, 4)
+	binary.BigEndian.PutUint16(header, code)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(value)))
+	data = append(data, header...)
+	return append(data, value...)
+}
+
+func parseDHCPv6Message(data []byte) (message dhcpv6Message, err error) {
+	const (
+		headerSize       = 4
+		optionHeaderSize = 4
+	)
+	if len(data) < headerSize {
+		err = fmt.Errorf("message too short (%d bytes)", len(data))
+		return
+	}
+	//buffer reused by receiver
+	data = append([]byte(nil), data...)
+	message.Type = data[0]
+	copy(message.TransactionID[:], data[1:headerSize])
+	var offset = headerSize
+	for offset < len(data) {
+		if offset+optionHeaderSize > len(data) {
+			err = fmt.Errorf("truncated option at offset %d", offset)
+			return
+		}
+		var code = binary.BigEndian.Uint16(data[offset:])
+		var length = int(binary.BigEndian.Uint16(data[offset+2:]))
+		offset += optionHeaderSize
+		if offset+length > len(data) {
+			err = fmt.Errorf("invalid length %d of option %d", length, code)
+			return
+		}
+		message.Options = append(message.Options, dhcpv6Option{Code: code, Data: data[offset : offset+length]})
+		offset += length
+	}
+	return message, nil
+}
+
+func (message *dhcpv6Message) getOption(code uint16) (data []byte, exists bool) {
+	for _, option := range message.Options {
+		if code == option.Code {
+			return option.Data, true
+		}
+	}
+	return nil, false
+}
+
+func (message *dhcpv6Message) addOption(code uint16, data []byte) {
+	message.Options = append(message.Options, dhcpv6Option{Code: code, Data: data})
+}
+
+func (message *dhcpv6Message) marshal() []byte {
+	var data = []byte{message.Type, message.TransactionID[0], message.TransactionID[1], message.TransactionID[2]}
+	for _, option := range message.Options {
+		data = appendDHCPv6Option(data, option.Code, option.Data)
+	}
+	return data
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestDHCPv6Message(t *testing.T) {
+	var request = dhcpv6Message{Type: dhcpv6Solicit, TransactionID: [3]byte{1, 2, 3}}
+	request.addOption(dhcpv6OptionClientID, []byte{0, duidTypeLL, 0, hardwareTypeEthernet, 0x52, 0x54, 0, 0x12, 0x34, 0x56})
+	request.addOption(dhcpv6OptionRapidCommit, nil)
+	parsed, err := parseDHCPv6Message(request.marshal())
+	if err != nil {
+		t.Fatalf("parse message fail: %s", err.Error())
+	}
+	if dhcpv6Solicit != parsed.Type || request.TransactionID != parsed.TransactionID || 2 != len(parsed.Options) {
+		t.Fatalf("unexpected message %v", parsed)
+	}
+	if _, err = parseDHCPv6Message([]byte{dhcpv6Solicit, 1, 2, 3, 0, 1, 0, 8, 0}); nil == err {
+		t.Fatal("truncated option accepted unexpectedly")
+	}
+	//DUID-LL
+	mac, err := dhcpv6ClientMAC(parsed, net.ParseIP("fe80::1"))
+	if err != nil || "52:54:00:12:34:56" != mac {
+		t.Fatalf("unexpected MAC '%s' from DUID: %v", mac, err)
+	}
+	//EUI-64 of link local address preferred
+	if mac, err = dhcpv6ClientMAC(parsed, net.ParseIP("fe80::5054:ff:fe65:4321")); err != nil || "52:54:00:65:43:21" != mac {
+		t.Fatalf("unexpected MAC '%s' from link local address: %v", mac, err)
+	}
+	if _, err = dhcpv6ClientMAC(dhcpv6Message{}, net.ParseIP("fe80::1")); nil == err {
+		t.Fatal("MAC resolved without identifier unexpectedly")
+	}
+}
+
+func TestBuildDHCPv6Reply(t *testing.T) {
+	var serverDUID = []byte{0, duidTypeLL, 0, hardwareTypeEthernet, 0x52, 0x54, 0, 0xAA, 0xBB, 0xCC}
+	var request = dhcpv6Message{Type: dhcpv6Request, TransactionID: [3]byte{4, 5, 6}}
+	request.addOption(dhcpv6OptionClientID, []byte{0, duidTypeLL, 0, hardwareTypeEthernet, 0x52, 0x54, 0, 0x12, 0x34, 0x56})
+	request.addOption(dhcpv6OptionServerID, serverDUID)
+	request.addOption(dhcpv6OptionIANA, []byte{0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0})
+	var result = NetworkResult{InternalV6: "2001:db8::10/64", DNSV6: []string{"2001:db8::53"}}
+	reply, err := buildDHCPv6Reply(request, serverDUID, result, true)
+	if err != nil {
+		t.Fatalf("build reply fail: %s", err.Error())
+	}
+	if dhcpv6Reply != reply.Type || request.TransactionID != reply.TransactionID {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	if servers, exists := reply.getOption(dhcpv6OptionDNSServers); !exists || !net.ParseIP("2001:db8::53").Equal(servers) {
+		t.Fatalf("unexpected DNS servers %v", servers)
+	}
+	iana, exists := reply.getOption(dhcpv6OptionIANA)
+	if !exists || len(iana) != 12+4+24 || 9 != binary.BigEndian.Uint32(iana) {
+		t.Fatalf("unexpected IA_NA %v", iana)
+	}
+	if !net.ParseIP("2001:db8::10").Equal(iana[16:32]) {
+		t.Fatalf("unexpected address %v", net.IP(iana[16:32]))
+	}
+	if _, err = buildDHCPv6Reply(request, serverDUID, result, false); nil == err {
+		t.Fatal("address assigned in SLAAC mode unexpectedly")
+	}
+	request.Type = dhcpv6Solicit
+	if reply, err = buildDHCPv6Reply(request, serverDUID, result, true); err != nil || dhcpv6Advertise != reply.Type {
+		t.Fatalf("unexpected reply for solicit: %v, %v", reply, err)
+	}
+	request.addOption(dhcpv6OptionRapidCommit, nil)
+	if reply, err = buildDHCPv6Reply(request, serverDUID, result, true); err != nil || dhcpv6Reply != reply.Type {
+		t.Fatalf("unexpected reply for rapid commit: %v, %v", reply, err)
+	}
+}
+
+func TestBuildRouterAdvertisement(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8::/64")
+	var hwaddress = net.HardwareAddr{0x52, 0x54, 0, 0xAA, 0xBB, 0xCC}
+	var body = buildRouterAdvertisement(IPv6ModeSLAAC, prefix, []net.IP{net.ParseIP("2001:db8::53")}, hwaddress)
+	//header, link-layer address, prefix, DNS
+	if 12+8+32+24 != len(body) {
+		t.Fatalf("unexpected length %d", len(body))
+	}
+	if 0x40 != body[1] || 0 != binary.BigEndian.Uint16(body[2:]) {
+		t.Fatalf("unexpected flags %02X and lifetime of SLAAC", body[1])
+	}
+	if !bytes.Equal(hwaddress, body[14:20]) {
+		t.Fatalf("unexpected link-layer address %v", body[14:20])
+	}
+	var option = body[20:52]
+	if ndOptionPrefixInformation != option[0] || 64 != option[2] || 0xC0 != option[3] || !prefix.IP.Equal(option[16:32]) {
+		t.Fatalf("unexpected prefix option %v", option)
+	}
+	body = buildRouterAdvertisement(IPv6ModeDHCPv6, nil, nil, hwaddress)
+	if 12+8 != len(body) || 0xC0 != body[1] {
+		t.Fatalf("unexpected advertisement of DHCPv6 %v", body)
+	}
+}
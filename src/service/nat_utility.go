@@ -0,0 +1,301 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	//bridge of cell-local private network, guests behind NAT
+	PrivateBridgeName  = "nvnat0"
+	ForwardProtocolTCP = "tcp"
+	ForwardProtocolUDP = "udp"
+	natTableName       = "nano_nat"
+	natCommand         = "nft"
+	ipv4ForwardSwitch  = "/proc/sys/net/ipv4/ip_forward"
+	procNetPath        = "/proc/net"
+	tcpStateListen     = "0A"
+	//network, gateway, at least one guest and broadcast
+	maxPrivatePrefix = 30
+)
+
+// PortForward : DNAT from port of host to port of guest in private network
+type PortForward struct {
+	Protocol  string `json:"protocol"`
+	HostPort  uint   `json:"host_port"`
+	Instance  string `json:"instance"`
+	GuestPort uint   `json:"guest_port"`
+}
+
+// natMapping : 1:1 NAT between external address on uplink and internal address of guest
+type natMapping struct {
+	Internal string
+	External string
+}
+
+// natForward : port forward with guest address resolved
+type natForward struct {
+	Protocol  string
+	HostPort  uint
+	Address   string
+	GuestPort uint
+}
+
+type natRuleset struct {
+	Bridge   string
+	Subnet   string
+	Mappings []natMapping
+	Forwards []natForward
+}
+
+func IsValidForwardProtocol(protocol string) bool {
+	return ForwardProtocolTCP == protocol || ForwardProtocolUDP == protocol
+}
+
+func portForwardKey(protocol string, hostPort uint) string {
+	return fmt.Sprintf("%s/%d", protocol, hostPort)
+}
+
+// parsePrivateNetwork : gateway of private network in CIDR, such as "172.30.0.1/24"
+func parsePrivateNetwork(address string) (gateway net.IP, subnet *net.IPNet, err error) {
+	gateway, subnet, err = net.ParseCIDR(address)
+	if err != nil {
+		err = fmt.Errorf("invalid private network '%s': %s", address, err.Error())
+		return
+	}
+	if gateway = gateway.To4(); nil == gateway {
+		err = fmt.Errorf("private network '%s' must be IPv4", address)
+		return
+	}
+	if ones, _ := subnet.Mask.Size(); ones > maxPrivatePrefix {
+		err = fmt.Errorf("private network '%s' too small", address)
+		return
+	}
+	if gateway.Equal(subnet.IP) || gateway.Equal(broadcastOf(subnet)) {
+		err = fmt.Errorf("invalid gateway of private network '%s'", address)
+		return
+	}
+	return gateway, subnet, nil
+}
+
+func broadcastOf(subnet *net.IPNet) net.IP {
+	var network = subnet.IP.To4()
+	var broadcast = make(net.IP, net.IPv4len)
+	for i := range network {
+		broadcast[i] = network[i] | ^subnet.Mask[i]
+	}
+	return broadcast
+}
+
+// allocatePrivateAddress : first available address in CIDR, network, broadcast and gateway skipped
+func allocatePrivateAddress(gateway net.IP, subnet *net.IPNet, allocated map[string]bool) (address string, err error) {
+	var ones, _ = subnet.Mask.Size()
+	var broadcast = broadcastOf(subnet)
+	var current = make(net.IP, net.IPv4len)
+	copy(current, subnet.IP.To4())
+	for {
+		for i := net.IPv4len - 1; i >= 0; i-- {
+			current[i]++
+			if 0 != current[i] {
+				break
+			}
+		}
+		if current.Equal(broadcast) {
+			err = fmt.Errorf("no address available in private network %s", subnet.String())
+			return
+		}
+		if current.Equal(gateway) || allocated[current.String()] {
+			continue
+		}
+		return fmt.Sprintf("%s/%d", current.String(), ones), nil
+	}
+}
+
+// CreatePrivateBridge : bridge with gateway address, existing bridge reused. Forwarding enabled after NAT rules applied
+func CreatePrivateBridge(gateway net.IP, subnet *net.IPNet) (err error) {
+	link, err := netlink.LinkByName(PrivateBridgeName)
+	if err != nil {
+		var attrs = netlink.NewLinkAttrs()
+		attrs.Name = PrivateBridgeName
+		link = &netlink.Bridge{LinkAttrs: attrs}
+		if err = netlink.LinkAdd(link); err != nil {
+			err = fmt.Errorf("create bridge '%s' fail: %s", PrivateBridgeName, err.Error())
+			return
+		}
+	}
+	var address = &netlink.Addr{IPNet: &net.IPNet{IP: gateway, Mask: subnet.Mask}}
+	if err = netlink.AddrReplace(link, address); err != nil {
+		err = fmt.Errorf("assign gateway to '%s' fail: %s", PrivateBridgeName, err.Error())
+		return
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		err = fmt.Errorf("set up interface '%s' fail: %s", PrivateBridgeName, err.Error())
+		return
+	}
+	return nil
+}
+
+// EnableIPv4Forwarding : only after forward chain of NAT table applied, so that private network never exposed
+func EnableIPv4Forwarding() (err error) {
+	if err = os.WriteFile(ipv4ForwardSwitch, []byte("1"), 0644); err != nil {
+		err = fmt.Errorf("enable IPv4 forwarding fail: %s", err.Error())
+		return
+	}
+	return nil
+}
+
+// IsHostPortInUse : listening TCP socket or bound UDP socket of host on port, any address
+func IsHostPortInUse(protocol string, port uint) (inUse bool, err error) {
+	for _, name := range []string{protocol, protocol + "6"} {
+		data, err := os.ReadFile(filepath.Join(procNetPath, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				//IPv6 disabled
+				continue
+			}
+			return false, fmt.Errorf("read sockets of %s fail: %s", name, err.Error())
+		}
+		if parseSocketPorts(string(data), ForwardProtocolTCP == protocol)[port] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseSocketPorts : local ports in table of /proc/net/{tcp,udp}, header skipped
+func parseSocketPorts(table string, listenOnly bool) (ports map[uint]bool) {
+	const (
+		LocalAddressField = 1
+		StateField        = 3
+	)
+	ports = map[uint]bool{}
+	var lines = strings.Split(table, "\n")
+	for _, line := range lines[1:] {
+		var fields = strings.Fields(line)
+		if len(fields) <= StateField {
+			continue
+		}
+		if listenOnly && tcpStateListen != fields[StateField] {
+			continue
+		}
+		var address = fields[LocalAddressField]
+		port, err := strconv.ParseUint(address[strings.LastIndex(address, ":")+1:], 16, 16)
+		if err != nil {
+			continue
+		}
+		ports[uint(port)] = true
+	}
+	return ports
+}
+
+// AssignExternalAddress : host answers for external address of 1:1 NAT on uplink
+func AssignExternalAddress(device, address string) (err error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		err = fmt.Errorf("get interface '%s' fail: %s", device, err.Error())
+		return
+	}
+	ip, err := externalIP(address)
+	if err != nil {
+		return
+	}
+	var external = &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}}
+	if err = netlink.AddrReplace(link, external); err != nil {
+		err = fmt.Errorf("assign %s to '%s' fail: %s", ip.String(), device, err.Error())
+		return
+	}
+	return nil
+}
+
+// ReleaseExternalAddress : absent address ignored
+func ReleaseExternalAddress(device, address string) (err error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		err = fmt.Errorf("get interface '%s' fail: %s", device, err.Error())
+		return
+	}
+	ip, err := externalIP(address)
+	if err != nil {
+		return
+	}
+	var external = &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}}
+	if err = netlink.AddrDel(link, external); err != nil && !os.IsNotExist(err) && !strings.Contains(err.Error(), "cannot assign") {
+		err = fmt.Errorf("release %s from '%s' fail: %s", ip.String(), device, err.Error())
+		return
+	}
+	return nil
+}
+
+// externalIP : external address in CIDR or plain IPv4
+func externalIP(address string) (ip net.IP, err error) {
+	if ip, _, err = net.ParseCIDR(address); err != nil {
+		ip = net.ParseIP(address)
+	}
+	if nil == ip || nil == ip.To4() {
+		err = fmt.Errorf("invalid external address '%s'", address)
+		return
+	}
+	return ip.To4(), nil
+}
+
+// applyNATRuleset : whole table replaced atomically
+func applyNATRuleset(ruleset natRuleset) (err error) {
+	var cmd = exec.Command(natCommand, "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset.build())
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("apply NAT rules fail: %s, %s", err.Error(), strings.TrimSpace(output.String()))
+		return
+	}
+	return nil
+}
+
+// build : nft script, mappings and forwards sorted for stable output
+func (ruleset natRuleset) build() string {
+	var mappings = append([]natMapping{}, ruleset.Mappings...)
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Internal < mappings[j].Internal
+	})
+	var forwards = append([]natForward{}, ruleset.Forwards...)
+	sort.Slice(forwards, func(i, j int) bool {
+		if forwards[i].Protocol != forwards[j].Protocol {
+			return forwards[i].Protocol < forwards[j].Protocol
+		}
+		return forwards[i].HostPort < forwards[j].HostPort
+	})
+	var builder strings.Builder
+	//create before delete, so that script works when table absent
+	fmt.Fprintf(&builder, "table ip %s\ndelete table ip %s\n", natTableName, natTableName)
+	fmt.Fprintf(&builder, "table ip %s {\n", natTableName)
+	builder.WriteString("\tchain prerouting {\n\t\ttype nat hook prerouting priority -100; policy accept;\n")
+	for _, mapping := range mappings {
+		fmt.Fprintf(&builder, "\t\tip daddr %s dnat to %s\n", mapping.External, mapping.Internal)
+	}
+	for _, forward := range forwards {
+		fmt.Fprintf(&builder, "\t\tiifname != \"%s\" fib daddr type local %s dport %d dnat to %s:%d\n",
+			ruleset.Bridge, forward.Protocol, forward.HostPort, forward.Address, forward.GuestPort)
+	}
+	builder.WriteString("\t}\n")
+	builder.WriteString("\tchain postrouting {\n\t\ttype nat hook postrouting priority 100; policy accept;\n")
+	for _, mapping := range mappings {
+		fmt.Fprintf(&builder, "\t\toifname != \"%s\" ip saddr %s snat to %s\n", ruleset.Bridge, mapping.Internal, mapping.External)
+	}
+	fmt.Fprintf(&builder, "\t\toifname != \"%s\" ip saddr %s masquerade\n", ruleset.Bridge, ruleset.Subnet)
+	builder.WriteString("\t}\n")
+	//new connection into private network allowed only when mapped or forwarded
+	builder.WriteString("\tchain forward {\n\t\ttype filter hook forward priority 0; policy accept;\n")
+	fmt.Fprintf(&builder, "\t\toifname \"%s\" ct state established,related accept\n", ruleset.Bridge)
+	fmt.Fprintf(&builder, "\t\toifname \"%s\" ct status dnat accept\n", ruleset.Bridge)
+	fmt.Fprintf(&builder, "\t\toifname \"%s\" ip daddr %s drop\n", ruleset.Bridge, ruleset.Subnet)
+	builder.WriteString("\t}\n}\n")
+	return builder.String()
+}
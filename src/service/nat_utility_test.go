@@ -0,0 +1,77 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrivateNetwork(t *testing.T) {
+	gateway, subnet, err := parsePrivateNetwork("172.30.0.1/29")
+	if err != nil {
+		t.Fatalf("parse private network fail: %s", err.Error())
+	}
+	if "172.30.0.0/29" != subnet.String() || "172.30.0.7" != broadcastOf(subnet).String() {
+		t.Fatalf("unexpected subnet %s", subnet.String())
+	}
+	for _, invalid := range []string{"172.30.0.1", "fd00::1/64", "172.30.0.1/31", "172.30.0.0/24", "172.30.0.255/24"} {
+		if _, _, err = parsePrivateNetwork(invalid); nil == err {
+			t.Fatalf("invalid private network '%s' accepted", invalid)
+		}
+	}
+	var allocated = map[string]bool{"172.30.0.2": true}
+	address, err := allocatePrivateAddress(gateway, subnet, allocated)
+	if err != nil || "172.30.0.3/29" != address {
+		t.Fatalf("unexpected address '%s': %v", address, err)
+	}
+	for _, ip := range []string{"172.30.0.3", "172.30.0.4", "172.30.0.5", "172.30.0.6"} {
+		allocated[ip] = true
+	}
+	if _, err = allocatePrivateAddress(gateway, subnet, allocated); nil == err {
+		t.Fatal("address allocated from exhausted network")
+	}
+}
+
+func TestNATRuleset(t *testing.T) {
+	var ruleset = natRuleset{
+		Bridge:   PrivateBridgeName,
+		Subnet:   "172.30.0.0/24",
+		Mappings: []natMapping{{Internal: "172.30.0.5", External: "192.168.1.200"}},
+		Forwards: []natForward{
+			{Protocol: ForwardProtocolUDP, HostPort: 53, Address: "172.30.0.6", GuestPort: 5353},
+			{Protocol: ForwardProtocolTCP, HostPort: 8080, Address: "172.30.0.5", GuestPort: 80},
+		},
+	}
+	var script = ruleset.build()
+	var expected = []string{
+		"table ip nano_nat\ndelete table ip nano_nat\n",
+		"ip daddr 192.168.1.200 dnat to 172.30.0.5\n",
+		"iifname != \"nvnat0\" fib daddr type local tcp dport 8080 dnat to 172.30.0.5:80\n" +
+			"\t\tiifname != \"nvnat0\" fib daddr type local udp dport 53 dnat to 172.30.0.6:5353\n",
+		"oifname != \"nvnat0\" ip saddr 172.30.0.5 snat to 192.168.1.200\n" +
+			"\t\toifname != \"nvnat0\" ip saddr 172.30.0.0/24 masquerade\n",
+		"oifname \"nvnat0\" ct status dnat accept\n" +
+			"\t\toifname \"nvnat0\" ip daddr 172.30.0.0/24 drop\n",
+	}
+	for _, rule := range expected {
+		if !strings.Contains(script, rule) {
+			t.Fatalf("rule '%s' not found in script:\n%s", rule, script)
+		}
+	}
+	//1:1 mapping takes precedence over port forward
+	if strings.Index(script, "dnat to 172.30.0.5\n") > strings.Index(script, "dport 8080") {
+		t.Fatalf("unexpected rule order:\n%s", script)
+	}
+}
+
+func TestSocketPorts(t *testing.T) {
+	const table = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+		"   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0\n" +
+		"   1: 0100007F:1F90 0100007F:A2C4 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0\n"
+	var listening = parseSocketPorts(table, true)
+	if !listening[22] || listening[8080] {
+		t.Fatalf("unexpected listening ports %v", listening)
+	}
+	if bound := parseSocketPorts(table, false); !bound[22] || !bound[8080] {
+		t.Fatalf("unexpected bound ports %v", bound)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -20,6 +21,47 @@ type InstanceNetworkResource struct {
 	HardwareAddress string `json:"hardware_address,omitmepty"`
 	InternalAddress string `json:"internal_address,omitmepty"`
 	ExternalAddress string `json:"external_address,omitmepty"`
+	//IPv6 address in CIDR, optional
+	InternalAddressV6 string `json:"internal_address_v6,omitempty"`
+	ExternalAddressV6 string `json:"external_address_v6,omitempty"`
+	//tagged VLAN on default bridge, untagged when zero
+	VLAN uint `json:"vlan,omitempty"`
+	//VXLAN overlay network, attached to default bridge when zero
+	VNI uint `json:"vni,omitempty"`
+	//cell-local private network behind NAT, external address mapped 1:1 when available
+	Private bool `json:"private,omitempty"`
+}
+
+// AddressPool : address pool of tagged VLAN or overlay network, pool of untagged network kept in DHCP* fields of manager
+type AddressPool struct {
+	Gateway   string      `json:"gateway"`
+	DNS       []string    `json:"dns,omitempty"`
+	GatewayV6 string      `json:"gateway_v6,omitempty"`
+	DNSV6     []string    `json:"dns_v6,omitempty"`
+	Options   DHCPOptions `json:"dhcp_options"`
+}
+
+// DHCPOptions : optional settings of address pool, lease time in seconds, default when zero
+type DHCPOptions struct {
+	Domain    string        `json:"domain,omitempty"`
+	Search    []string      `json:"search,omitempty"`
+	NTP       []string      `json:"ntp,omitempty"`
+	MTU       uint          `json:"mtu,omitempty"`
+	Routes    []StaticRoute `json:"routes,omitempty"`
+	LeaseTime uint          `json:"lease_time,omitempty"`
+}
+
+// OverlayNetwork : VXLAN overlay network, peers are VTEP address of remote cells
+type OverlayNetwork struct {
+	VNI   uint        `json:"vni"`
+	Peers []string    `json:"peers,omitempty"`
+	Pool  AddressPool `json:"pool"`
+}
+
+// StaticRoute : classless static route, destination in CIDR
+type StaticRoute struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
 }
 
 type networkCommand struct {
@@ -31,8 +73,17 @@ type networkCommand struct {
 	HWAddress    string
 	Internal     string
 	External     string
+	InternalV6   string
+	ExternalV6   string
+	VLAN         uint
+	VNI          uint
+	Private      bool
+	Forward      PortForward
 	Gateway      string
+	GatewayV6    string
 	DNS          []string
+	Options      DHCPOptions
+	Peers        []string
 	Allocation   string
 	Resources    map[string]InstanceNetworkResource
 }
@@ -44,12 +95,24 @@ type NetworkManager struct {
 	hwaddressMap      map[string]string //MAC => instance ID
 	DHCPGateway       string
 	DHCPDNS           []string
+	DHCPGatewayV6     string
+	DHCPDNSV6         []string
+	DHCPOptions       DHCPOptions
+	vlanPools         map[uint]AddressPool
+	overlays          map[uint]OverlayNetwork
+	privateGateway    net.IP
+	privateSubnet     *net.IPNet             //nil when private network disabled
+	portForwards      map[string]PortForward //key = protocol/host port
+	reservedPorts     map[uint]bool          //host ports used by cell
+	migrating         map[string]bool        //attached instances, external address held until migrated
 	allocationMode    string
 	commands          chan networkCommand
 	dataFile          string
 	generator         *rand.Rand
 	util              *NetworkUtility
-	OnAddressUpdated  func(string, []string)
+	OnAddressUpdated  func(uint, string, []string, DHCPOptions)
+	OnOverlayUpdated  func(uint, string, []string, DHCPOptions)
+	OnOverlayAttached func(uint, bool)
 	runner            *framework.SimpleRunner
 	maxMonitorPort    int
 	monitorPortEnd    int
@@ -66,6 +129,15 @@ const (
 	networkCommandDetachInstance
 	networkCommandUpdateAllocation
 	networkCommandGetAddress
+	networkCommandUpdateOverlay
+	networkCommandRemoveOverlay
+	networkCommandAddPortForward
+	networkCommandRemovePortForward
+	networkCommandQueryPortForward
+	networkCommandAttachExternal
+	networkCommandDetachExternal
+	networkCommandCheckReservation
+	networkCommandActivateInstance
 )
 
 const (
@@ -90,8 +162,13 @@ func CreateNetworkManager(dataPath string, connect *libvirt.Connect, maxMonitorP
 	manager.runner = framework.CreateSimpleRunner(manager.Routine)
 
 	manager.instanceResources = map[string]InstanceNetworkResource{}
+	manager.vlanPools = map[uint]AddressPool{}
+	manager.overlays = map[uint]OverlayNetwork{}
+	manager.portForwards = map[string]PortForward{}
 	manager.hwaddressMap = map[string]string{}
 	manager.monitorPorts = map[int]bool{}
+	manager.reservedPorts = map[uint]bool{InitiatorMagicPort: true}
+	manager.migrating = map[string]bool{}
 	manager.generator = rand.New(rand.NewSource(time.Now().UnixNano()))
 	manager.util = &NetworkUtility{connect}
 	var changed = false
@@ -112,12 +189,27 @@ func CreateNetworkManager(dataPath string, connect *libvirt.Connect, maxMonitorP
 	log.Printf("<network> monitor port range %d ~ %d (%d in total)",
 		manager.monitorPortStart, manager.monitorPortEnd, maxMonitorPort)
 
+	if privateNetwork := GetConfigurator().GetPrivateNetwork(); "" != privateNetwork {
+		if manager.privateGateway, manager.privateSubnet, err = parsePrivateNetwork(privateNetwork); err != nil {
+			return nil, err
+		}
+	}
 	if err = manager.loadConfig(); err != nil {
 		return nil, err
 	}
+	manager.attachVLANs()
+	manager.attachOverlays()
+	manager.attachPrivateNetwork()
 	return &manager, nil
 }
 
+// ReserveHostPorts : ports of services in cell, never forwarded to guest even not listening yet. Call before start
+func (manager *NetworkManager) ReserveHostPorts(ports []uint) {
+	for _, port := range ports {
+		manager.reservedPorts[port] = true
+	}
+}
+
 func (manager *NetworkManager) SyncInstanceResources(resources map[string]InstanceNetworkResource) (err error) {
 	var modified = false
 	// verify by manager.resources
@@ -200,8 +292,10 @@ func (manager *NetworkManager) GetCurrentConfig(resp chan NetworkResult) {
 	manager.commands <- cmd
 }
 
-func (manager *NetworkManager) AllocateInstanceResource(instance, hwaddress, internal, external string, resp chan NetworkResult) {
-	cmd := networkCommand{Type: networkCommandAllocateInstanceResource, Instance: instance, HWAddress: hwaddress, Internal: internal, External: external, ResultChan: resp}
+// AllocateInstanceResource : internal address allocated from private network when empty, returned in result
+func (manager *NetworkManager) AllocateInstanceResource(instance, hwaddress, internal, external, internalV6, externalV6 string, vlan, vni uint, private bool, resp chan NetworkResult) {
+	cmd := networkCommand{Type: networkCommandAllocateInstanceResource, Instance: instance, HWAddress: hwaddress, Internal: internal, External: external,
+		InternalV6: internalV6, ExternalV6: externalV6, VLAN: vlan, VNI: vni, Private: private, ResultChan: resp}
 	manager.commands <- cmd
 }
 func (manager *NetworkManager) DeallocateAllResource(instance string, resp chan error) {
@@ -217,14 +311,64 @@ func (manager *NetworkManager) DetachInstances(instances []string, resp chan err
 	manager.commands <- networkCommand{Type: networkCommandDetachInstance, InstanceList: instances, ErrorChan: resp}
 }
 
-func (manager *NetworkManager) UpdateAddressAllocation(gateway string, dns []string, mode string, resp chan error) {
-	manager.commands <- networkCommand{Type: networkCommandUpdateAllocation, Gateway: gateway, DNS: dns, Allocation: mode, ErrorChan: resp}
+// ActivateInstances : assign and announce external addresses of attached instances after migrated, when released by source cell
+func (manager *NetworkManager) ActivateInstances(instances []string, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandActivateInstance, InstanceList: instances, ErrorChan: resp}
+}
+
+// UpdateAddressAllocation : IPv6 gateway is optional, DNS servers could be IPv4 or IPv6
+// UpdateAddressAllocation : update address pool of VLAN, allocation mode shared by all VLANs
+func (manager *NetworkManager) UpdateAddressAllocation(vlan uint, gateway, gatewayV6 string, dns []string, options DHCPOptions, mode string, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandUpdateAllocation, VLAN: vlan, Gateway: gateway, GatewayV6: gatewayV6, DNS: dns, Options: options, Allocation: mode, ErrorChan: resp}
 }
 
 func (manager *NetworkManager) GetAddressByHWAddress(hwaddress string, resp chan NetworkResult) {
 	manager.commands <- networkCommand{Type: networkCommandGetAddress, HWAddress: hwaddress, ResultChan: resp}
 }
 
+// CheckReservation : fail when MAC belongs to guest, or address assigned to guest in same VLAN
+func (manager *NetworkManager) CheckReservation(hwaddress, address string, vlan uint, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandCheckReservation, HWAddress: hwaddress, Internal: address, VLAN: vlan, ErrorChan: resp}
+}
+
+// UpdateOverlayNetwork : create overlay network when not exists, address pool and remote peers replaced
+func (manager *NetworkManager) UpdateOverlayNetwork(vni uint, gateway string, dns []string, options DHCPOptions, peers []string, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandUpdateOverlay, VNI: vni, Gateway: gateway, DNS: dns, Options: options, Peers: peers, ErrorChan: resp}
+}
+
+// RemoveOverlayNetwork : fail when any instance attached
+func (manager *NetworkManager) RemoveOverlayNetwork(vni uint, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandRemoveOverlay, VNI: vni, ErrorChan: resp}
+}
+
+// AddPortForward : forward from host port to guest in private network, replace existing forward of same host port
+func (manager *NetworkManager) AddPortForward(forward PortForward, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandAddPortForward, Forward: forward, ErrorChan: resp}
+}
+
+func (manager *NetworkManager) RemovePortForward(protocol string, hostPort uint, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandRemovePortForward, Forward: PortForward{Protocol: protocol, HostPort: hostPort}, ErrorChan: resp}
+}
+
+// QueryPortForwards : sorted by protocol and host port
+func (manager *NetworkManager) QueryPortForwards(resp chan NetworkResult) {
+	manager.commands <- networkCommand{Type: networkCommandQueryPortForward, ResultChan: resp}
+}
+
+// AttachExternalAddress : bind floating address to instance in private network, replace previous address
+func (manager *NetworkManager) AttachExternalAddress(instance, address string, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandAttachExternal, Instance: instance, External: address, ErrorChan: resp}
+}
+
+func (manager *NetworkManager) DetachExternalAddress(instance string, resp chan error) {
+	manager.commands <- networkCommand{Type: networkCommandDetachExternal, Instance: instance, ErrorChan: resp}
+}
+
+// GetQueueDepth : count of commands waiting for handle
+func (manager *NetworkManager) GetQueueDepth() int {
+	return len(manager.commands)
+}
+
 func (manager *NetworkManager) Start() error {
 	return manager.runner.Start()
 }
@@ -254,7 +398,7 @@ func (manager *NetworkManager) handleCommand(cmd networkCommand) {
 	case networkCommandGetCurrentConfig:
 		err = manager.handleGetCurrentConfig(cmd.ResultChan)
 	case networkCommandAllocateInstanceResource:
-		err = manager.handleAllocateInstanceResource(cmd.Instance, cmd.HWAddress, cmd.Internal, cmd.External, cmd.ResultChan)
+		err = manager.handleAllocateInstanceResource(cmd.Instance, cmd.HWAddress, cmd.Internal, cmd.External, cmd.InternalV6, cmd.ExternalV6, cmd.VLAN, cmd.VNI, cmd.Private, cmd.ResultChan)
 	case networkCommandDeallocateAllResource:
 		err = manager.handleDeallocateAllResource(cmd.Instance, cmd.ErrorChan)
 	case networkCommandAttachInstance:
@@ -262,9 +406,27 @@ func (manager *NetworkManager) handleCommand(cmd networkCommand) {
 	case networkCommandDetachInstance:
 		err = manager.handleDetachInstances(cmd.InstanceList, cmd.ErrorChan)
 	case networkCommandUpdateAllocation:
-		err = manager.handleUpdateAddressAllocation(cmd.Gateway, cmd.DNS, cmd.Allocation, cmd.ErrorChan)
+		err = manager.handleUpdateAddressAllocation(cmd.VLAN, cmd.Gateway, cmd.GatewayV6, cmd.DNS, cmd.Options, cmd.Allocation, cmd.ErrorChan)
 	case networkCommandGetAddress:
 		err = manager.handleGetAddressByHWAddress(cmd.HWAddress, cmd.ResultChan)
+	case networkCommandUpdateOverlay:
+		err = manager.handleUpdateOverlayNetwork(cmd.VNI, cmd.Gateway, cmd.DNS, cmd.Options, cmd.Peers, cmd.ErrorChan)
+	case networkCommandRemoveOverlay:
+		err = manager.handleRemoveOverlayNetwork(cmd.VNI, cmd.ErrorChan)
+	case networkCommandAddPortForward:
+		err = manager.handleAddPortForward(cmd.Forward, cmd.ErrorChan)
+	case networkCommandRemovePortForward:
+		err = manager.handleRemovePortForward(cmd.Forward.Protocol, cmd.Forward.HostPort, cmd.ErrorChan)
+	case networkCommandQueryPortForward:
+		err = manager.handleQueryPortForwards(cmd.ResultChan)
+	case networkCommandAttachExternal:
+		err = manager.handleAttachExternalAddress(cmd.Instance, cmd.External, cmd.ErrorChan)
+	case networkCommandDetachExternal:
+		err = manager.handleDetachExternalAddress(cmd.Instance, cmd.ErrorChan)
+	case networkCommandCheckReservation:
+		err = manager.handleCheckReservation(cmd.HWAddress, cmd.Internal, cmd.VLAN, cmd.ErrorChan)
+	case networkCommandActivateInstance:
+		err = manager.handleActivateInstances(cmd.InstanceList, cmd.ErrorChan)
 	default:
 		log.Printf("<network> unsupported netword command %d", cmd.Type)
 	}
@@ -281,6 +443,8 @@ func (manager *NetworkManager) handleGetCurrentConfig(respChan chan NetworkResul
 	result.Name = manager.defaultBridge
 	result.Gateway = manager.DHCPGateway
 	result.DNS = manager.DHCPDNS
+	result.GatewayV6 = manager.DHCPGatewayV6
+	result.DNSV6 = manager.DHCPDNSV6
 	result.Allocation = manager.allocationMode
 	respChan <- result
 	return nil
@@ -291,6 +455,12 @@ type networkDataConfig struct {
 	Resources      map[string]InstanceNetworkResource `json:"resources,omitempty"`
 	DNS            []string                           `json:"dns,omitempty"`
 	Gateway        string                             `json:"gateway,omitempty"`
+	DNSV6          []string                           `json:"dns_v6,omitempty"`
+	GatewayV6      string                             `json:"gateway_v6,omitempty"`
+	Options        DHCPOptions                        `json:"dhcp_options"`
+	VLANPools      map[uint]AddressPool               `json:"vlan_pools,omitempty"`
+	Overlays       map[uint]OverlayNetwork            `json:"overlay_networks,omitempty"`
+	PortForwards   map[string]PortForward             `json:"port_forwards,omitempty"`
 	AllocationMode string                             `json:"allocation_mode,omitempty"`
 }
 
@@ -300,6 +470,12 @@ func (manager *NetworkManager) saveConfig() error {
 	config.Resources = manager.instanceResources
 	config.DNS = manager.DHCPDNS
 	config.Gateway = manager.DHCPGateway
+	config.DNSV6 = manager.DHCPDNSV6
+	config.GatewayV6 = manager.DHCPGatewayV6
+	config.Options = manager.DHCPOptions
+	config.VLANPools = manager.vlanPools
+	config.Overlays = manager.overlays
+	config.PortForwards = manager.portForwards
 	config.AllocationMode = manager.allocationMode
 	data, err := json.MarshalIndent(config, "", " ")
 	if err != nil {
@@ -331,16 +507,28 @@ func (manager *NetworkManager) loadConfig() error {
 	manager.defaultBridge = config.DefaultBridge
 	manager.DHCPGateway = config.Gateway
 	manager.DHCPDNS = config.DNS
+	manager.DHCPGatewayV6 = config.GatewayV6
+	manager.DHCPOptions = config.Options
+	manager.DHCPDNSV6 = config.DNSV6
 	manager.allocationMode = config.AllocationMode
 	if config.Resources != nil {
 		manager.instanceResources = config.Resources
 	}
+	if config.VLANPools != nil {
+		manager.vlanPools = config.VLANPools
+	}
+	if config.Overlays != nil {
+		manager.overlays = config.Overlays
+	}
+	if config.PortForwards != nil {
+		manager.portForwards = config.PortForwards
+	}
 	log.Printf("<network> config loaded: bridge '%s', gateway '%s', DNS '%s', allocate '%s'",
 		manager.defaultBridge, manager.DHCPGateway, manager.DHCPDNS, manager.allocationMode)
 	return nil
 }
 
-func (manager *NetworkManager) handleAllocateInstanceResource(instance, hwaddress, internal, external string, resp chan NetworkResult) (err error) {
+func (manager *NetworkManager) handleAllocateInstanceResource(instance, hwaddress, internal, external, internalV6, externalV6 string, vlan, vni uint, private bool, resp chan NetworkResult) (err error) {
 	_, exists := manager.instanceResources[instance]
 	if exists {
 		err := fmt.Errorf("resource already allocated for instance '%s'", instance)
@@ -371,6 +559,41 @@ func (manager *NetworkManager) handleAllocateInstanceResource(instance, hwaddres
 				return err
 			}
 		}
+		if "" != internalV6 && !isValidIPv6CIDR(internalV6) {
+			err = fmt.Errorf("invalid internal IPv6 address '%s'", internalV6)
+			resp <- NetworkResult{Error: err}
+			return err
+		}
+		if "" != externalV6 && !isValidIPv6CIDR(externalV6) {
+			err = fmt.Errorf("invalid external IPv6 address '%s'", externalV6)
+			resp <- NetworkResult{Error: err}
+			return err
+		}
+	}
+	if !IsValidVLAN(vlan) {
+		err = fmt.Errorf("invalid VLAN %d", vlan)
+		resp <- NetworkResult{Error: err}
+		return err
+	}
+	if private {
+		if internal, err = manager.preparePrivateAddress(internal, external, vlan, vni); err != nil {
+			resp <- NetworkResult{Error: err}
+			return err
+		}
+	} else if 0 != vni {
+		if DefaultVLAN != vlan {
+			err = fmt.Errorf("VLAN %d not available in overlay network %d", vlan, vni)
+			resp <- NetworkResult{Error: err}
+			return err
+		}
+		if _, exists = manager.overlays[vni]; !exists {
+			err = fmt.Errorf("overlay network %d not available", vni)
+			resp <- NetworkResult{Error: err}
+			return err
+		}
+	} else if _, err = AttachBridgeVLAN(manager.defaultBridge, vlan); err != nil {
+		resp <- NetworkResult{Error: err}
+		return err
 	}
 	var selected = 0
 	var seed = manager.generator.Intn(manager.maxMonitorPort)
@@ -397,9 +620,29 @@ func (manager *NetworkManager) handleAllocateInstanceResource(instance, hwaddres
 		return err
 	}
 	log.Printf("<network> monitor port %d allocated for instance '%s'", selected, instance)
-	manager.instanceResources[instance] = InstanceNetworkResource{selected, hwaddress, internal, external}
+	manager.instanceResources[instance] = InstanceNetworkResource{
+		MonitorPort:       selected,
+		HardwareAddress:   hwaddress,
+		InternalAddress:   internal,
+		ExternalAddress:   external,
+		InternalAddressV6: internalV6,
+		ExternalAddressV6: externalV6,
+		VLAN:              vlan,
+		VNI:               vni,
+		Private:           private,
+	}
 	manager.hwaddressMap[hwaddress] = instance
-	resp <- NetworkResult{MonitorPort: selected}
+	if private {
+		if "" != external {
+			if err = AssignExternalAddress(manager.defaultBridge, external); err != nil {
+				log.Printf("<network> warning: %s", err.Error())
+			} else {
+				manager.announceExternalAddress(external)
+			}
+		}
+		manager.updateNATRules()
+	}
+	resp <- NetworkResult{MonitorPort: selected, Internal: internal}
 	return manager.saveConfig()
 }
 
@@ -446,6 +689,10 @@ func (manager *NetworkManager) handleDeallocateAllResource(instance string, resp
 		log.Printf("<network> resource on MAC '%s' released", resource.HardwareAddress)
 	}
 	delete(manager.instanceResources, instance)
+	if resource.Private {
+		manager.releasePrivateResource(instance, resource)
+		manager.updateNATRules()
+	}
 	log.Printf("<network> resource deallocated for instance '%s'", instance)
 	resp <- nil
 	return manager.saveConfig()
@@ -459,8 +706,19 @@ func (manager *NetworkManager) handleAttachInstances(allocatedResources map[stri
 			respChan <- NetworkResult{Error: err}
 			return err
 		}
+		if resource := allocatedResources[instanceID]; !resource.Private && 0 != resource.VNI {
+			if _, exists := manager.overlays[resource.VNI]; !exists {
+				err = fmt.Errorf("overlay network %d of instance '%s' not available", resource.VNI, instanceID)
+				respChan <- NetworkResult{Error: err}
+				return err
+			}
+		}
 		instances = append(instances, instanceID)
 	}
+	if err = manager.checkMigratingPrivateResources(allocatedResources); err != nil {
+		respChan <- NetworkResult{Error: err}
+		return err
+	}
 	var required = len(allocatedResources)
 	var result = map[string]InstanceNetworkResource{}
 	var selected = 0
@@ -486,7 +744,8 @@ func (manager *NetworkManager) handleAttachInstances(allocatedResources map[stri
 			respChan <- NetworkResult{Error: err}
 			return err
 		}
-		result[instanceID] = InstanceNetworkResource{port, current.HardwareAddress, current.InternalAddress, current.ExternalAddress}
+		current.MonitorPort = port
+		result[instanceID] = current
 		log.Printf("<network> attach monitor port %d for instance '%s'", port, instanceID)
 		selected++
 		if selected >= required {
@@ -506,11 +765,90 @@ func (manager *NetworkManager) handleAttachInstances(allocatedResources map[stri
 	for instanceID, resource := range result {
 		manager.instanceResources[instanceID] = resource
 		manager.hwaddressMap[resource.HardwareAddress] = instanceID
+		if resource.Private && "" != resource.ExternalAddress {
+			//still owned by source cell
+			manager.migrating[instanceID] = true
+		}
 	}
+	manager.attachVLANs()
+	manager.attachPrivateNetwork()
 	respChan <- NetworkResult{Resources: result}
 	return manager.saveConfig()
 }
 
+func (manager *NetworkManager) handleActivateInstances(instances []string, respChan chan error) (err error) {
+	var activated = 0
+	for _, instanceID := range instances {
+		if !manager.migrating[instanceID] {
+			continue
+		}
+		delete(manager.migrating, instanceID)
+		var resource = manager.instanceResources[instanceID]
+		if err = AssignExternalAddress(manager.defaultBridge, resource.ExternalAddress); err != nil {
+			log.Printf("<network> warning: map external address of instance '%s' fail: %s", instanceID, err.Error())
+			continue
+		}
+		manager.announceExternalAddress(resource.ExternalAddress)
+		activated++
+	}
+	if 0 != activated {
+		if err = manager.updateNATRules(); err != nil {
+			respChan <- err
+			return
+		}
+		log.Printf("<network> external address of %d migrated instance(s) activated", activated)
+	}
+	respChan <- nil
+	return nil
+}
+
+// checkMigratingPrivateResources : internal address of migrating guest must be available in private network of this cell
+func (manager *NetworkManager) checkMigratingPrivateResources(resources map[string]InstanceNetworkResource) (err error) {
+	var incoming = map[string]string{}
+	for instanceID, resource := range resources {
+		if !resource.Private {
+			continue
+		}
+		var address string
+		if address, err = manager.preparePrivateAddress(resource.InternalAddress, resource.ExternalAddress,
+			resource.VLAN, resource.VNI); err != nil {
+			err = fmt.Errorf("private address of instance '%s' not available: %s", instanceID, err.Error())
+			return
+		}
+		//allocated when absent
+		resource.InternalAddress = address
+		resources[instanceID] = resource
+		if ip, _, err := net.ParseCIDR(resource.InternalAddress); nil == err {
+			if previous, exists := incoming[ip.String()]; exists {
+				return fmt.Errorf("internal address '%s' of instance '%s' conflicts with instance '%s'",
+					resource.InternalAddress, instanceID, previous)
+			}
+			incoming[ip.String()] = instanceID
+		}
+	}
+	return nil
+}
+
+// attachVLANs : prepare sub-interfaces of VLANs used by address pools and instances
+func (manager *NetworkManager) attachVLANs() {
+	var vlans = map[uint]bool{}
+	for vlan := range manager.vlanPools {
+		vlans[vlan] = true
+	}
+	for _, resource := range manager.instanceResources {
+		if DefaultVLAN != resource.VLAN {
+			vlans[resource.VLAN] = true
+		}
+	}
+	for vlan := range vlans {
+		if device, err := AttachBridgeVLAN(manager.defaultBridge, vlan); err != nil {
+			log.Printf("<network> warning: attach VLAN %d fail: %s", vlan, err.Error())
+		} else {
+			log.Printf("<network> VLAN %d attached to '%s'", vlan, device)
+		}
+	}
+}
+
 func (manager *NetworkManager) handleDetachInstances(instances []string, respChan chan error) (err error) {
 	if 0 == len(instances) {
 		for id, _ := range manager.instanceResources {
@@ -537,13 +875,63 @@ func (manager *NetworkManager) handleDetachInstances(instances []string, respCha
 		}
 		manager.monitorPorts[resource.MonitorPort] = false
 		delete(manager.instanceResources, instanceID)
+		delete(manager.migrating, instanceID)
 		log.Printf("<network> detach monitor port %d for instance '%s'", resource.MonitorPort, instanceID)
+		if resource.Private {
+			//port forwards bound to host ports of this cell, not migrated with instance
+			for _, forward := range manager.portForwards {
+				if instanceID == forward.Instance {
+					log.Printf("<network> warning: port forward %s %d -> %d of detached instance '%s' dropped, create it again on new cell",
+						forward.Protocol, forward.HostPort, forward.GuestPort, instanceID)
+				}
+			}
+			manager.releasePrivateResource(instanceID, resource)
+		}
 	}
+	manager.updateNATRules()
 	respChan <- nil
 	return manager.saveConfig()
 }
 
-func (manager *NetworkManager) handleUpdateAddressAllocation(gateway string, dns []string, allocationMode string, respChan chan error) (err error) {
+// attachOverlays : recreate overlay networks after reboot
+func (manager *NetworkManager) attachOverlays() {
+	if 0 == len(manager.overlays) {
+		return
+	}
+	localAddress, err := GetCurrentIPOfDefaultBridge()
+	if err != nil {
+		log.Printf("<network> warning: get VTEP address fail: %s", err.Error())
+		return
+	}
+	for vni, overlay := range manager.overlays {
+		if err = attachOverlayNetwork(overlay, localAddress); err != nil {
+			log.Printf("<network> warning: attach overlay network %d fail: %s", vni, err.Error())
+		}
+	}
+}
+
+func attachOverlayNetwork(overlay OverlayNetwork, localAddress string) (err error) {
+	//before metadata address assigned to bridge
+	if err = applyOverlayFilter(); err != nil {
+		return
+	}
+	bridge, err := CreateOverlayNetwork(overlay.VNI, localAddress)
+	if err != nil {
+		return
+	}
+	if err = SyncOverlayPeers(overlay.VNI, overlay.Peers); err != nil {
+		return
+	}
+	log.Printf("<network> overlay network %d attached to '%s' with %d peer(s)", overlay.VNI, bridge, len(overlay.Peers))
+	return nil
+}
+
+func (manager *NetworkManager) handleUpdateOverlayNetwork(vni uint, gateway string, dns []string, options DHCPOptions, peers []string, respChan chan error) (err error) {
+	if !IsValidVNI(vni) {
+		err = fmt.Errorf("invalid VNI %d", vni)
+		respChan <- err
+		return
+	}
 	if !isValidIPv4(gateway) {
 		err = fmt.Errorf("invalid gateway '%s'", gateway)
 		respChan <- err
@@ -556,18 +944,495 @@ func (manager *NetworkManager) handleUpdateAddressAllocation(gateway string, dns
 			return
 		}
 	}
-	manager.DHCPDNS = dns
-	manager.DHCPGateway = gateway
+	if err = validateDHCPOptions(options); err != nil {
+		respChan <- err
+		return
+	}
+	localAddress, err := GetCurrentIPOfDefaultBridge()
+	if err != nil {
+		err = fmt.Errorf("get VTEP address fail: %s", err.Error())
+		respChan <- err
+		return
+	}
+	if peers, err = validatePeers(peers, localAddress); err != nil {
+		respChan <- err
+		return
+	}
+	var overlay = OverlayNetwork{
+		VNI:   vni,
+		Peers: peers,
+		Pool:  AddressPool{Gateway: gateway, DNS: dns, Options: options},
+	}
+	if err = attachOverlayNetwork(overlay, localAddress); err != nil {
+		respChan <- err
+		return
+	}
+	_, exists := manager.overlays[vni]
+	manager.overlays[vni] = overlay
+	log.Printf("<network> overlay network %d updated, gateway '%s', DNS: %s, peers: %s",
+		vni, gateway, strings.Join(dns, "/"), strings.Join(peers, "/"))
+	respChan <- nil
+	if manager.OnOverlayUpdated != nil {
+		manager.OnOverlayUpdated(vni, gateway, dns, options)
+	}
+	if !exists && manager.OnOverlayAttached != nil {
+		manager.OnOverlayAttached(vni, true)
+	}
+	return manager.saveConfig()
+}
+
+func (manager *NetworkManager) handleRemoveOverlayNetwork(vni uint, respChan chan error) (err error) {
+	if _, exists := manager.overlays[vni]; !exists {
+		err = fmt.Errorf("invalid overlay network %d", vni)
+		respChan <- err
+		return
+	}
+	for instanceID, resource := range manager.instanceResources {
+		if vni == resource.VNI {
+			err = fmt.Errorf("overlay network %d still used by instance '%s'", vni, instanceID)
+			respChan <- err
+			return
+		}
+	}
+	if err = DeleteOverlayNetwork(vni); err != nil {
+		respChan <- err
+		return
+	}
+	delete(manager.overlays, vni)
+	log.Printf("<network> overlay network %d removed", vni)
+	respChan <- nil
+	if manager.OnOverlayAttached != nil {
+		manager.OnOverlayAttached(vni, false)
+	}
+	return manager.saveConfig()
+}
+
+// preparePrivateAddress : verify or allocate internal address in private network, returned in CIDR
+func (manager *NetworkManager) preparePrivateAddress(internal, external string, vlan, vni uint) (address string, err error) {
+	if nil == manager.privateSubnet {
+		err = errors.New("private network not available")
+		return
+	}
+	if DefaultVLAN != vlan || 0 != vni {
+		err = errors.New("VLAN or overlay network not available in private network")
+		return
+	}
+	var allocated = map[string]bool{}
+	for instanceID, resource := range manager.instanceResources {
+		if !resource.Private {
+			continue
+		}
+		if ip, _, err := net.ParseCIDR(resource.InternalAddress); nil == err {
+			allocated[ip.String()] = true
+		}
+		if "" != external && external == resource.ExternalAddress {
+			err = fmt.Errorf("external address '%s' already mapped to instance '%s'", external, instanceID)
+			return
+		}
+	}
+	if "" != external {
+		if _, err = externalIP(external); err != nil {
+			return
+		}
+	}
+	if "" == internal {
+		return allocatePrivateAddress(manager.privateGateway, manager.privateSubnet, allocated)
+	}
+	ip, _, err := net.ParseCIDR(internal)
+	if err != nil {
+		err = fmt.Errorf("invalid internal address '%s'", internal)
+		return
+	}
+	if !manager.privateSubnet.Contains(ip) || ip.Equal(manager.privateGateway) {
+		err = fmt.Errorf("internal address '%s' not available in private network %s", internal, manager.privateSubnet.String())
+		return
+	}
+	if allocated[ip.String()] {
+		err = fmt.Errorf("internal address '%s' already allocated", internal)
+		return
+	}
+	return internal, nil
+}
+
+// releasePrivateResource : external address and port forwards of instance released
+func (manager *NetworkManager) releasePrivateResource(instanceID string, resource InstanceNetworkResource) {
+	if "" != resource.ExternalAddress {
+		if err := ReleaseExternalAddress(manager.defaultBridge, resource.ExternalAddress); err != nil {
+			log.Printf("<network> warning: %s", err.Error())
+		}
+	}
+	for key, forward := range manager.portForwards {
+		if instanceID == forward.Instance {
+			delete(manager.portForwards, key)
+			log.Printf("<network> port forward %s of instance '%s' removed", key, instanceID)
+		}
+	}
+}
+
+// attachPrivateNetwork : recreate private bridge, external addresses and NAT rules after reboot
+func (manager *NetworkManager) attachPrivateNetwork() {
+	if nil == manager.privateSubnet {
+		for instanceID, resource := range manager.instanceResources {
+			if resource.Private {
+				log.Printf("<network> warning: private network of instance '%s' not configured", instanceID)
+			}
+		}
+		return
+	}
+	if err := CreatePrivateBridge(manager.privateGateway, manager.privateSubnet); err != nil {
+		log.Printf("<network> warning: attach private network fail: %s", err.Error())
+		return
+	}
+	for instanceID, resource := range manager.instanceResources {
+		if resource.Private && "" != resource.ExternalAddress && !manager.migrating[instanceID] {
+			if err := AssignExternalAddress(manager.defaultBridge, resource.ExternalAddress); err != nil {
+				log.Printf("<network> warning: map external address of instance '%s' fail: %s", instanceID, err.Error())
+			} else {
+				//address may move from another host, after migration
+				manager.announceExternalAddress(resource.ExternalAddress)
+			}
+		}
+	}
+	if err := manager.updateNATRules(); err != nil {
+		log.Printf("<network> warning: IPv4 forwarding not enabled for private network without NAT rules")
+		return
+	}
+	if err := EnableIPv4Forwarding(); err != nil {
+		log.Printf("<network> warning: %s", err.Error())
+		return
+	}
+	log.Printf("<network> private network %s attached to '%s'", manager.privateSubnet.String(), PrivateBridgeName)
+}
+
+// updateNATRules : rebuild from resources of private instances and port forwards
+func (manager *NetworkManager) updateNATRules() (err error) {
+	if nil == manager.privateSubnet {
+		return nil
+	}
+	var ruleset = natRuleset{Bridge: PrivateBridgeName, Subnet: manager.privateSubnet.String()}
+	var addresses = map[string]string{}
+	for instanceID, resource := range manager.instanceResources {
+		if !resource.Private {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(resource.InternalAddress)
+		if err != nil {
+			continue
+		}
+		addresses[instanceID] = ip.String()
+		if manager.migrating[instanceID] {
+			continue
+		}
+		if external, err := externalIP(resource.ExternalAddress); nil == err {
+			ruleset.Mappings = append(ruleset.Mappings, natMapping{Internal: ip.String(), External: external.String()})
+		}
+	}
+	for key, forward := range manager.portForwards {
+		address, exists := addresses[forward.Instance]
+		if !exists {
+			log.Printf("<network> warning: ignore port forward %s of invalid instance '%s'", key, forward.Instance)
+			continue
+		}
+		ruleset.Forwards = append(ruleset.Forwards, natForward{Protocol: forward.Protocol, HostPort: forward.HostPort,
+			Address: address, GuestPort: forward.GuestPort})
+	}
+	if err = applyNATRuleset(ruleset); err != nil {
+		log.Printf("<network> warning: %s", err.Error())
+		return
+	}
+	log.Printf("<network> NAT rules updated, %d mapping(s), %d port forward(s)", len(ruleset.Mappings), len(ruleset.Forwards))
+	return nil
+}
+
+func (manager *NetworkManager) handleAddPortForward(forward PortForward, respChan chan error) (err error) {
+	const (
+		MaxPort = 65535
+	)
+	if nil == manager.privateSubnet {
+		err = errors.New("private network not available")
+		respChan <- err
+		return
+	}
+	if !IsValidForwardProtocol(forward.Protocol) {
+		err = fmt.Errorf("invalid protocol '%s'", forward.Protocol)
+		respChan <- err
+		return
+	}
+	if 0 == forward.HostPort || forward.HostPort > MaxPort || 0 == forward.GuestPort || forward.GuestPort > MaxPort {
+		err = fmt.Errorf("invalid port %d => %d", forward.HostPort, forward.GuestPort)
+		respChan <- err
+		return
+	}
+	if int(forward.HostPort) >= manager.monitorPortStart && int(forward.HostPort) < manager.monitorPortEnd {
+		err = fmt.Errorf("host port %d reserved for monitor", forward.HostPort)
+		respChan <- err
+		return
+	}
+	if manager.reservedPorts[forward.HostPort] {
+		err = fmt.Errorf("host port %d reserved for cell", forward.HostPort)
+		respChan <- err
+		return
+	}
+	var key = portForwardKey(forward.Protocol, forward.HostPort)
+	if _, forwarded := manager.portForwards[key]; !forwarded {
+		//DNAT hides local service, such as SSH or DHCP
+		inUse, err := IsHostPortInUse(forward.Protocol, forward.HostPort)
+		if err != nil {
+			respChan <- err
+			return err
+		}
+		if inUse {
+			err = fmt.Errorf("host port %s used by local service", key)
+			respChan <- err
+			return err
+		}
+	}
+	if resource, exists := manager.instanceResources[forward.Instance]; !exists || !resource.Private {
+		err = fmt.Errorf("instance '%s' not in private network", forward.Instance)
+		respChan <- err
+		return
+	}
+	previous, replaced := manager.portForwards[key]
+	manager.portForwards[key] = forward
+	if err = manager.updateNATRules(); err != nil {
+		if replaced {
+			manager.portForwards[key] = previous
+		} else {
+			delete(manager.portForwards, key)
+		}
+		respChan <- err
+		return
+	}
+	log.Printf("<network> port forward %s => %s:%d added", key, forward.Instance, forward.GuestPort)
+	respChan <- nil
+	return manager.saveConfig()
+}
+
+func (manager *NetworkManager) handleRemovePortForward(protocol string, hostPort uint, respChan chan error) (err error) {
+	var key = portForwardKey(protocol, hostPort)
+	if _, exists := manager.portForwards[key]; !exists {
+		err = fmt.Errorf("no port forward for %s", key)
+		respChan <- err
+		return
+	}
+	delete(manager.portForwards, key)
+	if err = manager.updateNATRules(); err != nil {
+		respChan <- err
+		return
+	}
+	log.Printf("<network> port forward %s removed", key)
+	respChan <- nil
+	return manager.saveConfig()
+}
+
+func (manager *NetworkManager) handleQueryPortForwards(respChan chan NetworkResult) (err error) {
+	var result NetworkResult
+	for _, forward := range manager.portForwards {
+		result.Forwards = append(result.Forwards, forward)
+	}
+	sort.Slice(result.Forwards, func(i, j int) bool {
+		if result.Forwards[i].Protocol != result.Forwards[j].Protocol {
+			return result.Forwards[i].Protocol < result.Forwards[j].Protocol
+		}
+		return result.Forwards[i].HostPort < result.Forwards[j].HostPort
+	})
+	respChan <- result
+	return nil
+}
+
+// announceExternalAddress : gratuitous ARP on uplink in background, so that neighbors learn the new owner
+func (manager *NetworkManager) announceExternalAddress(address string) {
+	ip, err := externalIP(address)
+	if err != nil {
+		return
+	}
+	device, err := net.InterfaceByName(manager.defaultBridge)
+	if err != nil {
+		log.Printf("<network> warning: get interface '%s' fail: %s", manager.defaultBridge, err.Error())
+		return
+	}
+	go func() {
+		if err := AnnounceIPv4Address(device, ip); err != nil {
+			log.Printf("<network> warning: announce external address %s fail: %s", ip.String(), err.Error())
+		}
+	}()
+}
+
+// handleAttachExternalAddress : 1:1 NAT requires cell as gateway of guest, so only instance in private network available
+func (manager *NetworkManager) handleAttachExternalAddress(instance, address string, respChan chan error) (err error) {
+	resource, exists := manager.instanceResources[instance]
+	if !exists {
+		err = fmt.Errorf("no network resource for instance '%s'", instance)
+		respChan <- err
+		return
+	}
+	if !resource.Private || nil == manager.privateSubnet {
+		err = fmt.Errorf("floating address available for instance in private network only, instance '%s' not private or private network disabled", instance)
+		respChan <- err
+		return
+	}
+	ip, err := externalIP(address)
+	if err != nil {
+		respChan <- err
+		return
+	}
+	for instanceID, current := range manager.instanceResources {
+		if instanceID == instance || "" == current.ExternalAddress {
+			continue
+		}
+		if used, _ := externalIP(current.ExternalAddress); nil != used && used.Equal(ip) {
+			err = fmt.Errorf("external address %s already used by instance '%s'", ip.String(), instanceID)
+			respChan <- err
+			return
+		}
+	}
+	var previous = resource.ExternalAddress
+	if err = AssignExternalAddress(manager.defaultBridge, address); err != nil {
+		respChan <- err
+		return
+	}
+	resource.ExternalAddress = address
+	manager.instanceResources[instance] = resource
+	if err = manager.updateNATRules(); err != nil {
+		resource.ExternalAddress = previous
+		manager.instanceResources[instance] = resource
+		if previousIP, _ := externalIP(previous); nil == previousIP || !previousIP.Equal(ip) {
+			ReleaseExternalAddress(manager.defaultBridge, address)
+		}
+		respChan <- err
+		return
+	}
+	if previousIP, _ := externalIP(previous); nil != previousIP && !previousIP.Equal(ip) {
+		if err = ReleaseExternalAddress(manager.defaultBridge, previous); err != nil {
+			log.Printf("<network> warning: %s", err.Error())
+		}
+	}
+	manager.announceExternalAddress(address)
+	log.Printf("<network> external address %s attached to instance '%s'", ip.String(), instance)
+	respChan <- nil
+	return manager.saveConfig()
+}
+
+func (manager *NetworkManager) handleDetachExternalAddress(instance string, respChan chan error) (err error) {
+	resource, exists := manager.instanceResources[instance]
+	if !exists {
+		err = fmt.Errorf("no network resource for instance '%s'", instance)
+		respChan <- err
+		return
+	}
+	if "" == resource.ExternalAddress {
+		err = fmt.Errorf("no external address attached to instance '%s'", instance)
+		respChan <- err
+		return
+	}
+	var previous = resource.ExternalAddress
+	resource.ExternalAddress = ""
+	manager.instanceResources[instance] = resource
+	//remove mapping before address, so that no traffic translated to released address
+	if err = manager.updateNATRules(); err != nil {
+		resource.ExternalAddress = previous
+		manager.instanceResources[instance] = resource
+		respChan <- err
+		return
+	}
+	if err = ReleaseExternalAddress(manager.defaultBridge, previous); err != nil {
+		log.Printf("<network> warning: %s", err.Error())
+	}
+	log.Printf("<network> external address %s detached from instance '%s'", previous, instance)
+	respChan <- nil
+	return manager.saveConfig()
+}
+
+func (manager *NetworkManager) handleUpdateAddressAllocation(vlan uint, gateway, gatewayV6 string, dns []string, options DHCPOptions, allocationMode string, respChan chan error) (err error) {
+	if !IsValidVLAN(vlan) {
+		err = fmt.Errorf("invalid VLAN %d", vlan)
+		respChan <- err
+		return
+	}
+	if !isValidIPv4(gateway) {
+		err = fmt.Errorf("invalid gateway '%s'", gateway)
+		respChan <- err
+		return
+	}
+	if "" != gatewayV6 && !isValidIPv6(gatewayV6) {
+		err = fmt.Errorf("invalid IPv6 gateway '%s'", gatewayV6)
+		respChan <- err
+		return
+	}
+	var serversV4, serversV6 []string
+	for _, server := range dns {
+		if isValidIPv4(server) {
+			serversV4 = append(serversV4, server)
+		} else if isValidIPv6(server) {
+			serversV6 = append(serversV6, server)
+		} else {
+			err = fmt.Errorf("invalid DNS server '%s'", server)
+			respChan <- err
+			return
+		}
+	}
+	if err = validateDHCPOptions(options); err != nil {
+		respChan <- err
+		return
+	}
+	if DefaultVLAN == vlan {
+		manager.DHCPDNS = serversV4
+		manager.DHCPGateway = gateway
+		manager.DHCPOptions = options
+		manager.DHCPDNSV6 = serversV6
+		manager.DHCPGatewayV6 = gatewayV6
+	} else {
+		if _, err = AttachBridgeVLAN(manager.defaultBridge, vlan); err != nil {
+			respChan <- err
+			return
+		}
+		manager.vlanPools[vlan] = AddressPool{
+			Gateway:   gateway,
+			DNS:       serversV4,
+			GatewayV6: gatewayV6,
+			DNSV6:     serversV6,
+			Options:   options,
+		}
+	}
 	manager.allocationMode = allocationMode
-	log.Printf("<network> address allocation updated to mode '%s', gateway '%s', DNS: %s",
-		allocationMode, gateway, strings.Join(dns, "/"))
+	log.Printf("<network> address allocation of VLAN %d updated to mode '%s', gateway '%s', DNS: %s",
+		vlan, allocationMode, gateway, strings.Join(serversV4, "/"))
+	if "" != gatewayV6 || 0 != len(serversV6) {
+		log.Printf("<network> IPv6 gateway '%s', DNS: %s", gatewayV6, strings.Join(serversV6, "/"))
+	}
 	respChan <- nil
 	if AddressAllocationDHCP == allocationMode && manager.OnAddressUpdated != nil {
-		manager.OnAddressUpdated(gateway, dns)
+		manager.OnAddressUpdated(vlan, gateway, serversV4, options)
 	}
 	return manager.saveConfig()
 }
 
+func (manager *NetworkManager) handleCheckReservation(hwaddress, address string, vlan uint, respChan chan error) (err error) {
+	if instanceID, exists := manager.hwaddressMap[hwaddress]; exists {
+		err = fmt.Errorf("MAC '%s' used by instance '%s'", hwaddress, instanceID)
+		respChan <- err
+		return
+	}
+	reservedIP, _, err := net.ParseCIDR(address)
+	if err != nil {
+		err = fmt.Errorf("invalid address '%s'", address)
+		respChan <- err
+		return
+	}
+	for instanceID, resource := range manager.instanceResources {
+		if resource.Private || 0 != resource.VNI || vlan != resource.VLAN || "" == resource.InternalAddress {
+			continue
+		}
+		if assignedIP, _, _ := net.ParseCIDR(resource.InternalAddress); reservedIP.Equal(assignedIP) {
+			err = fmt.Errorf("address %s assigned to instance '%s'", reservedIP.String(), instanceID)
+			respChan <- err
+			return
+		}
+	}
+	respChan <- nil
+	return nil
+}
+
 func (manager *NetworkManager) handleGetAddressByHWAddress(hwaddress string, respChan chan NetworkResult) (err error) {
 	instanceID, exists := manager.hwaddressMap[hwaddress]
 	if !exists {
@@ -581,21 +1446,127 @@ func (manager *NetworkManager) handleGetAddressByHWAddress(hwaddress string, res
 		respChan <- NetworkResult{Error: err}
 		return
 	}
-	if "" == resource.InternalAddress {
+	if "" == resource.InternalAddress && "" == resource.InternalAddressV6 {
 		err = fmt.Errorf("no internal address assigned for instance '%s'", instanceID)
 		respChan <- NetworkResult{Error: err}
 		return
 	}
 	var result NetworkResult
-	result.Gateway = manager.DHCPGateway
-	result.DNS = manager.DHCPDNS
+	result.Instance = instanceID
+	result.VLAN = resource.VLAN
+	result.VNI = resource.VNI
 	result.Internal = resource.InternalAddress
 	result.External = resource.ExternalAddress
+	result.Private = resource.Private
+	if resource.Private {
+		if nil == manager.privateSubnet {
+			err = fmt.Errorf("private network of instance '%s' not available", instanceID)
+			respChan <- NetworkResult{Error: err}
+			return
+		}
+		result.Gateway = manager.privateGateway.String()
+		result.DNS = manager.DHCPDNS
+	} else if 0 != resource.VNI {
+		overlay, exists := manager.overlays[resource.VNI]
+		if !exists {
+			err = fmt.Errorf("overlay network %d of instance '%s' not available", resource.VNI, instanceID)
+			respChan <- NetworkResult{Error: err}
+			return
+		}
+		result.Gateway = overlay.Pool.Gateway
+		result.DNS = overlay.Pool.DNS
+	} else if DefaultVLAN == resource.VLAN {
+		result.Gateway = manager.DHCPGateway
+		result.DNS = manager.DHCPDNS
+		result.GatewayV6 = manager.DHCPGatewayV6
+		result.DNSV6 = manager.DHCPDNSV6
+	} else if pool, exists := manager.vlanPools[resource.VLAN]; exists {
+		result.Gateway = pool.Gateway
+		result.DNS = pool.DNS
+		result.GatewayV6 = pool.GatewayV6
+		result.DNSV6 = pool.DNSV6
+	} else {
+		err = fmt.Errorf("no address pool available for VLAN %d of instance '%s'", resource.VLAN, instanceID)
+		respChan <- NetworkResult{Error: err}
+		return
+	}
+	result.InternalV6 = resource.InternalAddressV6
+	result.ExternalV6 = resource.ExternalAddressV6
 	log.Printf("<network> get internal address '%s' for MAC '%s'", resource.InternalAddress, hwaddress)
 	respChan <- result
 	return nil
 }
 
+func validateDHCPOptions(options DHCPOptions) error {
+	const (
+		MinimumMTU   = 68
+		MaximumMTU   = 65535
+		MinimumLease = 60
+		//length octet of DHCP option, long options not split (RFC 3396)
+		MaximumOptionLength = 255
+		//0.0.0.0/0 via pool gateway, prepended when encoding lease
+		DefaultRouteLength = 1 + net.IPv4len
+	)
+	for _, domain := range append([]string{options.Domain}, options.Search...) {
+		if "" != domain && !isValidDomainName(domain) {
+			return fmt.Errorf("invalid domain '%s'", domain)
+		}
+	}
+	for _, server := range options.NTP {
+		if !isValidIPv4(server) {
+			return fmt.Errorf("invalid NTP server '%s'", server)
+		}
+	}
+	if 0 != options.MTU && (options.MTU < MinimumMTU || options.MTU > MaximumMTU) {
+		return fmt.Errorf("invalid MTU %d", options.MTU)
+	}
+	if 0 != options.LeaseTime && options.LeaseTime < MinimumLease {
+		return fmt.Errorf("lease time %d less than %d seconds", options.LeaseTime, MinimumLease)
+	}
+	for _, route := range options.Routes {
+		if _, destination, err := net.ParseCIDR(route.Destination); err != nil || nil == destination.IP.To4() {
+			return fmt.Errorf("invalid route destination '%s'", route.Destination)
+		}
+		if !isValidIPv4(route.Gateway) {
+			return fmt.Errorf("invalid route gateway '%s'", route.Gateway)
+		}
+	}
+	if length := len(encodeDomainSearch(options.Search)); length > MaximumOptionLength {
+		return fmt.Errorf("search domains take %d bytes, exceed limit %d of DHCP option", length, MaximumOptionLength)
+	}
+	if length := len(options.NTP) * net.IPv4len; length > MaximumOptionLength {
+		return fmt.Errorf("%d NTP servers exceed limit %d bytes of DHCP option", len(options.NTP), MaximumOptionLength)
+	}
+	if 0 != len(options.Routes) {
+		encoded, err := encodeClasslessRoutes(options.Routes)
+		if err != nil {
+			return err
+		}
+		if length := len(encoded) + DefaultRouteLength; length > MaximumOptionLength {
+			return fmt.Errorf("static routes take %d bytes with default route, exceed limit %d of DHCP option",
+				length, MaximumOptionLength)
+		}
+	}
+	return nil
+}
+
+func isValidDomainName(value string) bool {
+	const (
+		MaxNameLength  = 253
+		MaxLabelLength = 63
+	)
+	var name = strings.TrimSuffix(value, ".")
+	if "" == name || len(name) > MaxNameLength {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if "" == label || len(label) > MaxLabelLength {
+			return false
+		}
+	}
+	return true
+}
+
 func isValidIPv4(value string) bool {
 	var ip = net.ParseIP(value)
 	if ip == nil {
@@ -604,3 +1575,19 @@ func isValidIPv4(value string) bool {
 	ip = ip.To4()
 	return ip != nil
 }
+
+func isValidIPv6(value string) bool {
+	var ip = net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+	return nil == ip.To4()
+}
+
+func isValidIPv6CIDR(value string) bool {
+	ip, _, err := net.ParseCIDR(value)
+	if err != nil {
+		return false
+	}
+	return nil == ip.To4()
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"fmt"
 	"github.com/libvirt/libvirt-go"
 	"os"
 	"testing"
@@ -62,7 +63,7 @@ func TestNetworkManager_AllocateResource(t *testing.T) {
 	}()
 	//allocate
 	respChan := make(chan NetworkResult, 1)
-	manager.AllocateInstanceResource(instanceID, macAddress, internalAddress, externalAddress, respChan)
+	manager.AllocateInstanceResource(instanceID, macAddress, internalAddress, externalAddress, "", "", DefaultVLAN, 0, false, respChan)
 	var result = <-respChan
 	if result.Error != nil {
 		t.Fatalf("allocate resource fail: %s", result.Error.Error())
@@ -71,3 +72,84 @@ func TestNetworkManager_AllocateResource(t *testing.T) {
 	t.Logf("monitor port %d allocated", monitorPort)
 	t.Log("test network manager allocate resource success")
 }
+
+func TestNetworkManager_CheckReservation(t *testing.T) {
+	var manager = &NetworkManager{
+		hwaddressMap: map[string]string{"00:11:22:33:44:55": "guest"},
+		instanceResources: map[string]InstanceNetworkResource{
+			"guest": {HardwareAddress: "00:11:22:33:44:55", InternalAddress: "192.168.1.10/24", VLAN: 10},
+		},
+	}
+	var cases = []struct {
+		HWAddress string
+		Address   string
+		VLAN      uint
+		Accepted  bool
+	}{
+		{"00:11:22:33:44:55", "192.168.1.20/24", 10, false},
+		{"00:11:22:33:44:66", "192.168.1.10/24", 10, false},
+		{"00:11:22:33:44:66", "192.168.1.10/24", 20, true},
+		{"00:11:22:33:44:66", "192.168.1.20/24", 10, true},
+	}
+	for _, c := range cases {
+		var respChan = make(chan error, 1)
+		_ = manager.handleCheckReservation(c.HWAddress, c.Address, c.VLAN, respChan)
+		if err := <-respChan; c.Accepted != (nil == err) {
+			t.Fatalf("reservation of %s/%s in VLAN %d: %v", c.HWAddress, c.Address, c.VLAN, err)
+		}
+	}
+}
+
+func TestNetworkManager_AttachInstancesWithoutOverlay(t *testing.T) {
+	var manager = &NetworkManager{
+		instanceResources: map[string]InstanceNetworkResource{},
+		overlays:          map[uint]OverlayNetwork{},
+		monitorPorts:      map[int]bool{5901: false},
+		monitorPortStart:  5901,
+		maxMonitorPort:    1,
+	}
+	var respChan = make(chan NetworkResult, 1)
+	var resources = map[string]InstanceNetworkResource{"guest": {VNI: 100}}
+	if err := manager.handleAttachInstances(resources, respChan); err == nil {
+		t.Fatal("instance attached to absent overlay network")
+	}
+	if result := <-respChan; nil == result.Error {
+		t.Fatal("no error responded")
+	}
+	if manager.monitorPorts[5901] {
+		t.Fatal("monitor port allocated")
+	}
+}
+
+func TestValidateDHCPOptions(t *testing.T) {
+	var routes = func(count int) (result []StaticRoute) {
+		for i := 0; i < count; i++ {
+			result = append(result, StaticRoute{Destination: fmt.Sprintf("10.%d.0.0/24", i), Gateway: "192.168.1.1"})
+		}
+		return
+	}
+	var domains = func(count int) (result []string) {
+		for i := 0; i < count; i++ {
+			result = append(result, fmt.Sprintf("zone%02d.example.com", i))
+		}
+		return
+	}
+	var cases = []struct {
+		Name     string
+		Options  DHCPOptions
+		Accepted bool
+	}{
+		{"default", DHCPOptions{}, true},
+		//8 bytes each, 5 bytes of default route
+		{"routes within limit", DHCPOptions{Routes: routes(31)}, true},
+		{"routes exceed limit", DHCPOptions{Routes: routes(32)}, false},
+		//20 bytes each
+		{"search within limit", DHCPOptions{Search: domains(12)}, true},
+		{"search exceed limit", DHCPOptions{Search: domains(13)}, false},
+	}
+	for _, c := range cases {
+		if err := validateDHCPOptions(c.Options); c.Accepted != (nil == err) {
+			t.Fatalf("%s: unexpected result %v", c.Name, err)
+		}
+	}
+}
@@ -154,6 +154,10 @@ func IPv4ToUInt32(input string) uint32 {
 	if "" == input{
 		return 0
 	}
-	var ip = net.ParseIP(input)
-	return binary.BigEndian.Uint32(ip.To4())
+	var ip = net.ParseIP(input).To4()
+	if nil == ip{
+		//IPv6 or CIDR
+		return 0
+	}
+	return binary.BigEndian.Uint32(ip)
 }
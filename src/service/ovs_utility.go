@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"os/exec"
+	"strings"
+)
+
+const (
+	BridgeTypeLinux    = "linux"
+	BridgeTypeOVS      = "openvswitch"
+	VirtualPortTypeOVS = "openvswitch"
+	//type of OVS internal port and datapath in netlink
+	ovsLinkType = "openvswitch"
+	ovsCommand  = "ovs-vsctl"
+)
+
+func IsValidBridgeType(bridgeType string) bool {
+	return BridgeTypeLinux == bridgeType || BridgeTypeOVS == bridgeType
+}
+
+// IsOVSBridge : internal port of OVS bridge, such as "br0"
+func IsOVSBridge(bridge string) bool {
+	link, err := netlink.LinkByName(bridge)
+	if err != nil {
+		return false
+	}
+	return ovsLinkType == link.Type()
+}
+
+// isOVSPort : port attached to OVS datapath
+func isOVSPort(link netlink.Link) bool {
+	if 0 == link.Attrs().MasterIndex {
+		return false
+	}
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		return false
+	}
+	return ovsLinkType == master.Type()
+}
+
+// CreateOVSBridge : existing bridge and port reused
+func CreateOVSBridge(bridge, uplink string) (err error) {
+	if err = runOVSCommand("--may-exist", "add-br", bridge, "--", "--may-exist", "add-port", bridge, uplink); err != nil {
+		err = fmt.Errorf("create OVS bridge '%s' with port '%s' fail: %s", bridge, uplink, err.Error())
+		return
+	}
+	return nil
+}
+
+// TagOVSPort : access port of VLAN, frames untagged in guest side
+func TagOVSPort(port string, vlan uint) (err error) {
+	if err = runOVSCommand("set", "Port", port, fmt.Sprintf("tag=%d", vlan)); err != nil {
+		err = fmt.Errorf("tag VLAN %d to OVS port '%s' fail: %s", vlan, port, err.Error())
+		return
+	}
+	return nil
+}
+
+// ovsInterfaceID : unique UUID of each port, instance ID for first interface, name-based UUID of instance and index for others
+func ovsInterfaceID(instanceID string, index int) string {
+	if 0 == index {
+		return instanceID
+	}
+	var sum = sha1.Sum([]byte(fmt.Sprintf("%s/%d", instanceID, index)))
+	//version 5, variant of RFC 4122
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func runOVSCommand(args ...string) (err error) {
+	var cmd = exec.Command(ovsCommand, args...)
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("%s, %s", err.Error(), strings.TrimSpace(output.String()))
+		return
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestOVSVirtualPort(t *testing.T) {
+	const (
+		InstanceID = "5f0c7a2e-8d4b-4c1e-9a3f-2b6d8e1c4a70"
+	)
+	var define virDomainDefine
+	if err := define.SetPlainNetwork("virtio", DefaultBridgeName, "00:16:3e:12:34:56", "nano-filter", 0, 0); err != nil {
+		t.Fatalf("set plain network fail: %s", err.Error())
+	}
+	define.SetOVSVirtualPort(InstanceID)
+	data, err := xml.Marshal(define.Devices.Interface[0])
+	if err != nil {
+		t.Fatalf("marshal interface fail: %s", err.Error())
+	}
+	var content = string(data)
+	if !strings.Contains(content, `<virtualport type="openvswitch"><parameters interfaceid="`+InstanceID+`"></parameters></virtualport>`) {
+		t.Fatalf("unexpected interface %s", content)
+	}
+	//nwfilter not supported by OVS port
+	if strings.Contains(content, "filterref") {
+		t.Fatalf("filter kept in interface %s", content)
+	}
+	//iface-id unique for each port
+	define.Devices.Interface = append(define.Devices.Interface, define.Devices.Interface[0])
+	define.SetOVSVirtualPort(InstanceID)
+	var secondID = define.Devices.Interface[1].VirtualPort.Parameters.InterfaceID
+	if InstanceID == secondID || 36 != len(secondID) || secondID != ovsInterfaceID(InstanceID, 1) {
+		t.Fatalf("unexpected interface id %s of second port", secondID)
+	}
+	//instance ID not in form of UUID
+	var ports = map[string]bool{}
+	for index := 0; index < 3; index++ {
+		ports[ovsInterfaceID("guest", index)] = true
+	}
+	if 3 != len(ports) {
+		t.Fatalf("duplicate interface id %v", ports)
+	}
+	if IsOVSBridge("lo") || !IsValidBridgeType(BridgeTypeOVS) || IsValidBridgeType("macvtap") {
+		t.Fatal("unexpected bridge type")
+	}
+}
@@ -18,6 +18,12 @@ type InstanceResult struct {
 	User             string
 	Policy           SecurityPolicy
 	NetworkResources map[string]InstanceNetworkResource
+	Metrics          []InstanceMetrics
+	Maintenance      MaintenanceStatus
+	Cores            ResourceCapacity
+	Memory           ResourceCapacity
+	Diagnostic       GuestDiagnostic
+	Diagnostics      []GuestDiagnostic
 }
 
 type InstanceMediaConfig struct {
@@ -48,7 +54,14 @@ type InstanceModule interface {
 	ModifyCPUPriority(guestID string, priority PriorityEnum, resp chan error)
 	ModifyDiskThreshold(guestID string, readSpeed, readIOPS, writeSpeed, writeIOPS uint64, resp chan error)
 	ModifyNetworkThreshold(guestID string, receive, send uint64, resp chan error)
-	ModifyAutoStart(guestID string, enable bool, respChan chan error)
+	ModifyAutoStart(guestID string, enable bool, order int, respChan chan error)
+	ModifyRestartPolicy(guestID string, policy RestartPolicy, retries uint, respChan chan error)
+	CaptureScreenshot(guestID string, respChan chan InstanceResult)
+	DumpMemory(guestID string, respChan chan InstanceResult)
+	QueryDiagnostics(guestID string, respChan chan InstanceResult)
+	SendKeys(guestID string, codeSet KeyCodeSet, keys []string, respChan chan error)
+	InjectNMI(guestID string, respChan chan error)
+	ModifyExternalAddress(guestID, address string, respChan chan error)
 
 	ModifyGuestAuth(id, password, usr string, resp chan InstanceResult)
 	GetGuestAuth(id string, resp chan InstanceResult)
@@ -77,8 +90,16 @@ type InstanceModule interface {
 	ModifySecurityPolicyRule(instanceID string, index int, rule SecurityPolicyRule, respChan chan error)
 	RemoveSecurityPolicyRule(instanceID string, index int, respChan chan error)
 	ChangeDefaultSecurityPolicyAction(instanceID string, accept bool, respChan chan error)
+	ChangeEgressSecurityPolicyAction(instanceID string, accept bool, respChan chan error)
 	PullUpSecurityPolicyRule(instanceID string, index int, respChan chan error)
 	PushDownSecurityPolicyRule(instanceID string, index int, respChan chan error)
+	//metrics
+	QueryInstanceMetrics(instanceID string, begin, end time.Time, granularity time.Duration, respChan chan InstanceResult)
+	//maintenance
+	SetMaintenance(enable, drain bool, respChan chan error)
+	GetMaintenance(respChan chan InstanceResult)
+	//capacity
+	GetCapacity(respChan chan InstanceResult)
 }
 
 type SnapshotConfig struct {
@@ -112,6 +133,10 @@ type StorageResult struct {
 	StorageMode  StoragePoolMode
 	SystemPaths  []string
 	DataPaths    []string
+	//key = pool name, value = queued tasks
+	SchedulerTasks map[string]int
+	RunningTasks   int
+	Capacity       ResourceCapacity
 }
 
 type BootType int
@@ -119,6 +144,8 @@ type BootType int
 const (
 	BootTypeNone = BootType(iota)
 	BootTypeCloudInit
+	//seed from metadata address of overlay network
+	BootTypeCloudInitOverlay
 )
 
 type StorageModule interface {
@@ -144,33 +171,123 @@ type StorageModule interface {
 	QueryStoragePaths(respChan chan StorageResult)
 	ChangeDefaultStoragePath(target string, respChan chan error)
 	ValidateVolumesForStart(groupName string, respChan chan error)
+	GetCapacity(respChan chan StorageResult)
 }
 
 type NetworkResult struct {
 	Error       error
 	Name        string
+	Instance    string
+	VLAN        uint
+	VNI         uint
+	Private     bool
 	MonitorPort int
 	External    string
 	Internal    string
 	Gateway     string
 	DNS         []string
+	ExternalV6  string
+	InternalV6  string
+	GatewayV6   string
+	DNSV6       []string
 	Allocation  string
 	Resources   map[string]InstanceNetworkResource
+	Forwards    []PortForward
 }
 
 type NetworkModule interface {
 	GetBridgeName() string
 	GetCurrentConfig(resp chan NetworkResult)
-	AllocateInstanceResource(instance, hwaddress, internal, external string, resp chan NetworkResult)
+	AllocateInstanceResource(instance, hwaddress, internal, external, internalV6, externalV6 string, vlan, vni uint, private bool, resp chan NetworkResult)
 	DeallocateAllResource(instance string, resp chan error)
 	AttachInstances(resources map[string]InstanceNetworkResource, resp chan NetworkResult)
 	DetachInstances(instances []string, resp chan error)
-	UpdateAddressAllocation(gateway string, dns []string, allocationMode string, resp chan error)
+	ActivateInstances(instances []string, resp chan error)
+	UpdateAddressAllocation(vlan uint, gateway, gatewayV6 string, dns []string, options DHCPOptions, allocationMode string, resp chan error)
 	GetAddressByHWAddress(hwaddress string, resp chan NetworkResult)
+	UpdateOverlayNetwork(vni uint, gateway string, dns []string, options DHCPOptions, peers []string, resp chan error)
+	RemoveOverlayNetwork(vni uint, resp chan error)
+	AddPortForward(forward PortForward, resp chan error)
+	RemovePortForward(protocol string, hostPort uint, resp chan error)
+	QueryPortForwards(resp chan NetworkResult)
+	AttachExternalAddress(instance, address string, resp chan error)
+	DetachExternalAddress(instance string, resp chan error)
+	CheckReservation(hwaddress, address string, vlan uint, resp chan error)
+}
+
+type HostResult struct {
+	Error     error
+	Devices   []HostDeviceIO
+	Inventory HostInventory
+}
+
+type HostModule interface {
+	GetDeviceIO(respChan chan HostResult)
+	GetInventory(refresh bool, respChan chan HostResult)
+}
+
+type AlertModule interface {
+	GetAlerts(respChan chan []Alert)
+}
+
+type ConsoleResult struct {
+	Error    error
+	Token    string
+	Port     uint
+	Protocol string
+	Expire   time.Time
+}
+
+type ConsoleModule interface {
+	IssueToken(guestID string, respChan chan ConsoleResult)
+}
+
+// DiagnosticModule : download server of diagnostics, path available only with signed token before expired
+type DiagnosticModule interface {
+	GetPort() uint
+	SignPath(path string) string
+}
+
+type DHCPResult struct {
+	Error        error
+	Leases       []DHCPLease
+	Reservations []DHCPReservation
+}
+
+type DHCPModule interface {
+	QueryLeases(respChan chan DHCPResult)
+	AddReservation(reservation DHCPReservation, respChan chan error)
+	RemoveReservation(hwaddress string, respChan chan error)
 }
 
 type Configurator struct {
-	operateTimeout time.Duration
+	operateTimeout    time.Duration
+	metricsResolution time.Duration
+	metricsCapacity   int
+	metricsRollup     bool
+	reportDeviceIO    bool
+	cpuOvercommit     float64
+	memoryOvercommit  float64
+	diskOvercommit    float64
+	reservedCores     uint
+	reservedMemory    uint64
+	shutdownTimeout   time.Duration
+	shutdownLimit     time.Duration
+	startConcurrency  int
+	startDelay        time.Duration
+	restartInitial    time.Duration
+	restartMax        time.Duration
+	crashLoopFailures int
+	crashLoopWindow   time.Duration
+	screenshotKeep    int
+	memoryDumpKeep    int
+	monitorListen     string
+	consoleTLS        bool
+	certFingerprint   string
+	ipv6Mode          string
+	ipv6Prefix        string
+	privateNetwork    string
+	bridgeType        string
 }
 
 func (c *Configurator) SetOperateTimeout(timeoutInSeconds int) {
@@ -182,12 +299,234 @@ func (c *Configurator) GetOperateTimeout() time.Duration {
 	return c.operateTimeout
 }
 
+// SetMetricsResolution : set sample interval of instance metrics, rounded to multiple of sync interval
+func (c *Configurator) SetMetricsResolution(resolutionInSeconds int) {
+	var resolution = time.Duration(resolutionInSeconds) * time.Second
+	if resolution < SyncInterval {
+		resolution = SyncInterval
+	}
+	c.metricsResolution = resolution.Truncate(SyncInterval)
+}
+
+// GetMetricsResolution : get sample interval of instance metrics
+func (c *Configurator) GetMetricsResolution() time.Duration {
+	return c.metricsResolution
+}
+
+// SetMetricsCapacity : set max samples of each instance kept in memory
+func (c *Configurator) SetMetricsCapacity(capacity int) {
+	c.metricsCapacity = capacity
+}
+
+// GetMetricsCapacity : get max samples of each instance kept in memory
+func (c *Configurator) GetMetricsCapacity() int {
+	return c.metricsCapacity
+}
+
+// EnableMetricsRollup : save rollups of instance metrics to disk
+func (c *Configurator) EnableMetricsRollup(enable bool) {
+	c.metricsRollup = enable
+}
+
+// IsMetricsRollupEnabled : check if rollups of instance metrics saved to disk
+func (c *Configurator) IsMetricsRollupEnabled() bool {
+	return c.metricsRollup
+}
+
+// EnableReportDeviceIO : attach IO of each physical device to status report
+func (c *Configurator) EnableReportDeviceIO(enable bool) {
+	c.reportDeviceIO = enable
+}
+
+// IsReportDeviceIOEnabled : check if IO of each physical device attached to status report
+func (c *Configurator) IsReportDeviceIOEnabled() bool {
+	return c.reportDeviceIO
+}
+
+// SetOvercommitRatio : committed resource of guests allowed as multiple of available host resource, unlimited when zero
+func (c *Configurator) SetOvercommitRatio(cpu, memory, disk float64) {
+	c.cpuOvercommit = cpu
+	c.memoryOvercommit = memory
+	c.diskOvercommit = disk
+}
+
+// GetOvercommitRatio : get overcommit ratio of cpu, memory and disk
+func (c *Configurator) GetOvercommitRatio() (cpu, memory, disk float64) {
+	return c.cpuOvercommit, c.memoryOvercommit, c.diskOvercommit
+}
+
+// SetHostReservation : cores and memory reserved for hypervisor, excluded from capacity of guests
+func (c *Configurator) SetHostReservation(cores uint, memoryInMB uint) {
+	c.reservedCores = cores
+	c.reservedMemory = uint64(memoryInMB) << 20
+}
+
+// GetHostReservation : get reserved cores and memory in bytes
+func (c *Configurator) GetHostReservation() (cores uint, memory uint64) {
+	return c.reservedCores, c.reservedMemory
+}
+
+// SetGuestShutdownTimeout : shutdown running guests when cell stop, timeout for each guest, disabled when zero
+func (c *Configurator) SetGuestShutdownTimeout(timeoutInSeconds int) {
+	c.shutdownTimeout = time.Duration(timeoutInSeconds) * time.Second
+}
+
+// GetGuestShutdownTimeout : get timeout before force stopping guests when cell stop
+func (c *Configurator) GetGuestShutdownTimeout() time.Duration {
+	return c.shutdownTimeout
+}
+
+// SetGuestShutdownLimit : all remaining guests force stopped when shutdown not finished in limit
+func (c *Configurator) SetGuestShutdownLimit(limitInSeconds int) {
+	c.shutdownLimit = time.Duration(limitInSeconds) * time.Second
+}
+
+// GetGuestShutdownLimit : get total time of shutting down guests when cell stop
+func (c *Configurator) GetGuestShutdownLimit() time.Duration {
+	return c.shutdownLimit
+}
+
+// SetAutoStartPolicy : guests started at most concurrency at a time, each occupies a slot for delay
+func (c *Configurator) SetAutoStartPolicy(concurrency, delayInSeconds int) {
+	c.startConcurrency = concurrency
+	c.startDelay = time.Duration(delayInSeconds) * time.Second
+}
+
+// GetAutoStartPolicy : get concurrency and delay of auto start guests
+func (c *Configurator) GetAutoStartPolicy() (concurrency int, delay time.Duration) {
+	return c.startConcurrency, c.startDelay
+}
+
+// SetRestartBackoff : delay before restarting a failed guest, doubled by each consecutive retry up to max
+func (c *Configurator) SetRestartBackoff(initialInSeconds, maxInSeconds int) {
+	c.restartInitial = time.Duration(initialInSeconds) * time.Second
+	c.restartMax = time.Duration(maxInSeconds) * time.Second
+	if c.restartMax < c.restartInitial {
+		c.restartMax = c.restartInitial
+	}
+}
+
+// GetRestartBackoff : get initial and max delay before restarting a failed guest
+func (c *Configurator) GetRestartBackoff() (initial, max time.Duration) {
+	return c.restartInitial, c.restartMax
+}
+
+// SetCrashLoopDetection : guest marked crash looping when failed specified times within window
+func (c *Configurator) SetCrashLoopDetection(failures, windowInSeconds int) {
+	c.crashLoopFailures = failures
+	c.crashLoopWindow = time.Duration(windowInSeconds) * time.Second
+}
+
+// GetCrashLoopDetection : get failures and window of crash loop detection
+func (c *Configurator) GetCrashLoopDetection() (failures int, window time.Duration) {
+	return c.crashLoopFailures, c.crashLoopWindow
+}
+
+// SetDiagnosticRetention : max screenshots and memory dumps kept for each guest, oldest removed first
+func (c *Configurator) SetDiagnosticRetention(screenshots, memoryDumps int) {
+	c.screenshotKeep = screenshots
+	c.memoryDumpKeep = memoryDumps
+}
+
+// GetDiagnosticRetention : get max screenshots and memory dumps kept for each guest
+func (c *Configurator) GetDiagnosticRetention() (screenshots, memoryDumps int) {
+	return c.screenshotKeep, c.memoryDumpKeep
+}
+
+// SetMonitorListen : listen address of VNC/SPICE monitor, set to loopback when accessed by console proxy only
+func (c *Configurator) SetMonitorListen(address string) {
+	c.monitorListen = address
+}
+
+// GetMonitorListen : get listen address of VNC/SPICE monitor
+func (c *Configurator) GetMonitorListen() string {
+	return c.monitorListen
+}
+
+// SetConsoleTLS : encrypt SPICE with certificate of cell when loaded by libvirt, fingerprint reported in config of guest
+func (c *Configurator) SetConsoleTLS(enabled bool, fingerprint string) {
+	c.consoleTLS = enabled
+	c.certFingerprint = fingerprint
+}
+
+// GetConsoleTLS : get TLS status and fingerprint of certificate
+func (c *Configurator) GetConsoleTLS() (enabled bool, fingerprint string) {
+	return c.consoleTLS, c.certFingerprint
+}
+
+// SetIPv6 : mode of IPv6 address configuration advertised on bridge, prefix required by SLAAC
+func (c *Configurator) SetIPv6(mode, prefix string) {
+	c.ipv6Mode = mode
+	c.ipv6Prefix = prefix
+}
+
+// GetIPv6 : get IPv6 mode and prefix
+func (c *Configurator) GetIPv6() (mode, prefix string) {
+	return c.ipv6Mode, c.ipv6Prefix
+}
+
+// SetPrivateNetwork : gateway of cell-local NAT network in CIDR, disabled when empty
+func (c *Configurator) SetPrivateNetwork(address string) {
+	c.privateNetwork = address
+}
+
+func (c *Configurator) GetPrivateNetwork() string {
+	return c.privateNetwork
+}
+
+// SetBridgeType : type of default bridge, tap of plain network plugged as OVS port when openvswitch
+func (c *Configurator) SetBridgeType(bridgeType string) {
+	c.bridgeType = bridgeType
+}
+
+func (c *Configurator) GetBridgeType() string {
+	return c.bridgeType
+}
+
+// security of monitor reported in config of guest
+const (
+	MonitorSecurityNone = "none"
+	MonitorSecurityTLS  = "tls"
+)
+
 const (
-	defaultOperateTimeout = 10 //10 seconds
+	defaultOperateTimeout    = 10 //10 seconds
+	defaultMetricsResolution = 10 //10 seconds
+	defaultMetricsCapacity   = 360
+	defaultCPUOvercommit     = 4.0
+	defaultMemoryOvercommit  = 0 //unlimited
+	defaultDiskOvercommit    = 1.0
+	defaultReservedMemory    = 0
+	defaultShutdownLimit     = 300 //5 minutes
+	defaultStartConcurrency  = 2
+	defaultStartDelay        = 10 //10 seconds
+	defaultRestartInitial    = 5  //5 seconds
+	defaultRestartMax        = 300
+	defaultCrashLoopFailures = 3
+	defaultCrashLoopWindow   = 600 //10 minutes
+	defaultScreenshotKeep    = 10
+	defaultMemoryDumpKeep    = 3
 )
 
 var globalConfigurator = Configurator{
-	operateTimeout: defaultOperateTimeout * time.Second,
+	operateTimeout:    defaultOperateTimeout * time.Second,
+	metricsResolution: defaultMetricsResolution * time.Second,
+	metricsCapacity:   defaultMetricsCapacity,
+	cpuOvercommit:     defaultCPUOvercommit,
+	memoryOvercommit:  defaultMemoryOvercommit,
+	diskOvercommit:    defaultDiskOvercommit,
+	reservedMemory:    defaultReservedMemory,
+	shutdownLimit:     defaultShutdownLimit * time.Second,
+	startConcurrency:  defaultStartConcurrency,
+	startDelay:        defaultStartDelay * time.Second,
+	restartInitial:    defaultRestartInitial * time.Second,
+	restartMax:        defaultRestartMax * time.Second,
+	crashLoopFailures: defaultCrashLoopFailures,
+	crashLoopWindow:   defaultCrashLoopWindow * time.Second,
+	screenshotKeep:    defaultScreenshotKeep,
+	memoryDumpKeep:    defaultMemoryDumpKeep,
+	monitorListen:     ListenAllAddress,
+	bridgeType:        BridgeTypeLinux,
 }
 
 func GetConfigurator() *Configurator {
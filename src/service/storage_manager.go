@@ -66,6 +66,8 @@ const (
 	storageCommandQueryStoragePaths
 	storageCommandChangeDefaultStoragePath
 	storageCommandValidateForStart
+	storageCommandGetSchedulerStatistic
+	storageCommandGetCapacity
 	storageCommandInvalid
 )
 
@@ -89,6 +91,8 @@ var storageCommandNames = []string{
 	"QueryStoragePaths",
 	"ChangeDefaultStoragePath",
 	"ValidateVolumesForStart",
+	"GetSchedulerStatistic",
+	"GetCapacity",
 }
 
 type storageCommand struct {
@@ -373,6 +377,10 @@ func (manager *StorageManager) handleCommand(cmd storageCommand) {
 		err = manager.handleChangeDefaultStoragePath(cmd.Target, cmd.ErrorChan)
 	case storageCommandValidateForStart:
 		err = manager.handleValidateVolumesForStart(cmd.Instance, cmd.ErrorChan)
+	case storageCommandGetSchedulerStatistic:
+		err = manager.handleGetSchedulerStatistic(cmd.ResultChan)
+	case storageCommandGetCapacity:
+		err = manager.handleGetCapacity(cmd.ResultChan)
 	default:
 		log.Printf("<storage> unsupported command type %d", cmd.Type)
 	}
@@ -465,6 +473,20 @@ func (manager *StorageManager) ValidateVolumesForStart(groupName string, respCha
 	manager.commands <- storageCommand{Type: storageCommandValidateForStart, Instance: groupName, ErrorChan: respChan}
 }
 
+func (manager *StorageManager) GetSchedulerStatistic(respChan chan StorageResult) {
+	manager.commands <- storageCommand{Type: storageCommandGetSchedulerStatistic, ResultChan: respChan}
+}
+
+// GetCapacity : provisioned and limit of current pool
+func (manager *StorageManager) GetCapacity(respChan chan StorageResult) {
+	manager.commands <- storageCommand{Type: storageCommandGetCapacity, ResultChan: respChan}
+}
+
+// GetQueueDepth : count of commands waiting for handle
+func (manager *StorageManager) GetQueueDepth() int {
+	return len(manager.commands)
+}
+
 type storageDataConfig struct {
 	Mode        string                         `json:"mode,omitempty"`
 	SystemPaths []string                       `json:"system_paths,omitempty"`
@@ -690,14 +712,23 @@ func (manager *StorageManager) handleCreateVolumes(instanceID string, systemSize
 	var systemDisk = fmt.Sprintf("%s_sys.%s", instanceID, FormatQcow2Suffix)
 	var volNames = []string{systemDisk}
 	var volSizes = []uint64{systemSize}
+	var totalSize = systemSize
 	if nil != dataSize {
 		dataCount := len(dataSize)
 		for i := 0; i < dataCount; i++ {
 			name := fmt.Sprintf("%s_%d.%s", instanceID, i, FormatQcow2Suffix)
 			volNames = append(volNames, name)
 			volSizes = append(volSizes, dataSize[i])
+			totalSize += dataSize[i]
 		}
 	}
+	if capacity, err := manager.getDiskCapacity(poolName); err != nil {
+		resp <- StorageResult{Error: err}
+		return err
+	} else if err = capacity.Admit("disk", totalSize); err != nil {
+		resp <- StorageResult{Error: err}
+		return err
+	}
 	volumes, err := manager.utility.CreateVolumes(poolName, len(volNames), volNames, volSizes)
 	if err != nil {
 		resp <- StorageResult{Error: err}
@@ -723,8 +754,12 @@ func (manager *StorageManager) handleCreateVolumes(instanceID string, systemSize
 		}
 	}
 	switch bootType {
-	case BootTypeCloudInit:
-		group.BootImage, err = buildCloudInitImage(manager.initiatorIP, pool.Target, instanceID)
+	case BootTypeCloudInit, BootTypeCloudInitOverlay:
+		var initiatorIP = manager.initiatorIP
+		if BootTypeCloudInitOverlay == bootType {
+			initiatorIP = MetadataAddress
+		}
+		group.BootImage, err = buildCloudInitImage(initiatorIP, pool.Target, instanceID)
 		if err != nil {
 			_ = manager.utility.DeleteVolumes(poolName, volNames)
 			resp <- StorageResult{Error: err}
@@ -912,14 +947,17 @@ func (manager *StorageManager) handleResizeVolume(id framework.SessionID, groupN
 	}
 	//choose pool&path
 	var path, poolName string
+	var currentSize uint64
 	if group.System.Name == targetVol {
 		path = group.System.Path
 		poolName = group.System.Pool
+		currentSize = group.System.Capacity
 	} else {
 		for _, vol := range group.Data {
 			if vol.Name == targetVol {
 				path = vol.Path
 				poolName = vol.Pool
+				currentSize = vol.Capacity
 				break
 			}
 		}
@@ -929,6 +967,17 @@ func (manager *StorageManager) handleResizeVolume(id framework.SessionID, groupN
 		respChan <- StorageResult{Error: err}
 		return err
 	}
+	if targetSize > currentSize {
+		var capacity ResourceCapacity
+		if capacity, err = manager.getDiskCapacity(poolName); err != nil {
+			respChan <- StorageResult{Error: err}
+			return err
+		}
+		if err = capacity.Admit("disk", targetSize-currentSize); err != nil {
+			respChan <- StorageResult{Error: err}
+			return err
+		}
+	}
 
 	if _, exists := manager.tasks[id]; exists {
 		err := fmt.Errorf("previous IO task %08X not finished", id)
@@ -1817,11 +1866,35 @@ func (manager *StorageManager) handleDeleteSnapshotCompleted(groupName, snapshot
 }
 
 func (manager *StorageManager) handleQueryStoragePaths(respChan chan StorageResult) (err error) {
+	//mount path of nfs pools also reported, for capacity and mount checking
+	var devices []AttachDeviceInfo
+	for poolName, pool := range manager.pools {
+		if StoragePoolModeNFS != pool.Mode {
+			continue
+		}
+		devices = append(devices, AttachDeviceInfo{
+			Name:     poolName,
+			Protocol: StorageProtocolNFS,
+			Path:     pool.Target,
+			Attached: pool.Attached,
+			Error:    pool.AttachError,
+		})
+	}
 	respChan <- StorageResult{
 		StorageMode: manager.storageMode,
 		SystemPaths: manager.localSystemDiskPaths,
 		DataPaths:   manager.localDataDiskPaths,
+		Devices:     devices,
+	}
+	return nil
+}
+
+func (manager *StorageManager) handleGetSchedulerStatistic(respChan chan StorageResult) (err error) {
+	var queued = map[string]int{}
+	for poolName, scheduler := range manager.schedulers {
+		queued[poolName] = scheduler.GetPendingTasks()
 	}
+	respChan <- StorageResult{SchedulerTasks: queued, RunningTasks: len(manager.tasks)}
 	return nil
 }
 
@@ -537,6 +537,32 @@ func (util *StorageUtility) DeleteVolumes(pool string, volNames []string) error
 	return nil
 }
 
+// GetProvisionedCapacity : total virtual size of all volumes in pool, including volumes created by other cells in shared pool
+func (util *StorageUtility) GetProvisionedCapacity(pool string) (capacity uint64, err error) {
+	virPool, err := util.innerConnect.LookupStoragePoolByName(pool)
+	if err != nil{
+		return
+	}
+	defer virPool.Free()
+	//volumes created by other cells
+	if err = virPool.Refresh(0); err != nil{
+		return
+	}
+	volumes, err := virPool.ListAllStorageVolumes(0)
+	if err != nil{
+		return
+	}
+	for _, volume := range volumes{
+		info, err := volume.GetInfo()
+		volume.Free()
+		if err != nil{
+			continue
+		}
+		capacity += info.Capacity
+	}
+	return capacity, nil
+}
+
 func (util *StorageUtility) GetVolume(pool, name string) (vol StorageVolume, err error) {
 	virPool, err := util.innerConnect.LookupStoragePoolByName(pool)
 	if err != nil{
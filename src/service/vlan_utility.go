@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	//untagged, default VLAN of bridge
+	DefaultVLAN = 0
+	MaxVLAN     = 4094
+	//PVID of bridge ports when VLAN filtering enabled
+	bridgeDefaultPVID = 1
+)
+
+func IsValidVLAN(vlan uint) bool {
+	return DefaultVLAN == vlan || (bridgeDefaultPVID < vlan && vlan <= MaxVLAN)
+}
+
+// VLANInterfaceName : sub-interface of bridge for VLAN, such as "br0.100"
+func VLANInterfaceName(bridge string, vlan uint) string {
+	return fmt.Sprintf("%s.%d", bridge, vlan)
+}
+
+// EnableBridgeVLANFiltering : untagged traffic of existing ports keeps in PVID 1
+func EnableBridgeVLANFiltering(bridge string) (err error) {
+	const (
+		Enabled = "1"
+	)
+	if link, err := netlink.LinkByName(bridge); nil == err {
+		if device, isBridge := link.(*netlink.Bridge); isBridge && nil != device.VlanFiltering && *device.VlanFiltering {
+			return nil
+		}
+	}
+	var switchPath = filepath.Join("/sys/class/net", bridge, "bridge", "vlan_filtering")
+	data, err := os.ReadFile(switchPath)
+	if err != nil {
+		err = fmt.Errorf("check VLAN filtering of bridge '%s' fail: %s", bridge, err.Error())
+		return
+	}
+	if Enabled == strings.TrimSpace(string(data)) {
+		return nil
+	}
+	if err = os.WriteFile(switchPath, []byte(Enabled), 0644); err != nil {
+		err = fmt.Errorf("enable VLAN filtering of bridge '%s' fail: %s", bridge, err.Error())
+		return
+	}
+	return nil
+}
+
+// AttachBridgeVLAN : bridge itself and uplinks join VLAN as tagged member, traffic of VLAN delivered to sub-interface.
+// internal port of OVS bridge trunks all VLANs, so only sub-interface required
+func AttachBridgeVLAN(bridge string, vlan uint) (device string, err error) {
+	if DefaultVLAN == vlan {
+		return bridge, nil
+	}
+	bridgeLink, err := netlink.LinkByName(bridge)
+	if err != nil {
+		err = fmt.Errorf("get bridge '%s' fail: %s", bridge, err.Error())
+		return
+	}
+	if ovsLinkType != bridgeLink.Type() {
+		if err = EnableBridgeVLANFiltering(bridge); err != nil {
+			return
+		}
+		if err = netlink.BridgeVlanAdd(bridgeLink, uint16(vlan), false, false, true, false); err != nil {
+			err = fmt.Errorf("add VLAN %d to bridge '%s' fail: %s", vlan, bridge, err.Error())
+			return
+		}
+		var uplinks []netlink.Link
+		if uplinks, err = bridgeUplinks(bridgeLink); err != nil {
+			return
+		}
+		for _, uplink := range uplinks {
+			if err = netlink.BridgeVlanAdd(uplink, uint16(vlan), false, false, false, true); err != nil {
+				err = fmt.Errorf("add VLAN %d to uplink '%s' fail: %s", vlan, uplink.Attrs().Name, err.Error())
+				return
+			}
+		}
+	}
+	device = VLANInterfaceName(bridge, vlan)
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		var attrs = netlink.NewLinkAttrs()
+		attrs.Name = device
+		attrs.ParentIndex = bridgeLink.Attrs().Index
+		link = &netlink.Vlan{LinkAttrs: attrs, VlanId: int(vlan)}
+		if err = netlink.LinkAdd(link); err != nil {
+			err = fmt.Errorf("create interface '%s' fail: %s", device, err.Error())
+			return
+		}
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		err = fmt.Errorf("set up interface '%s' fail: %s", device, err.Error())
+		return
+	}
+	return device, nil
+}
+
+// bridgeUplinks : ports of bridge except tap devices of guests
+func bridgeUplinks(bridge netlink.Link) (uplinks []netlink.Link, err error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		err = fmt.Errorf("list interfaces fail: %s", err.Error())
+		return
+	}
+	for _, link := range links {
+		if bridge.Attrs().Index != link.Attrs().MasterIndex {
+			continue
+		}
+		if _, isTap := link.(*netlink.Tuntap); isTap {
+			continue
+		}
+		uplinks = append(uplinks, link)
+	}
+	return uplinks, nil
+}
+
+// TagInterfaceVLAN : move bridge port to VLAN, frames untagged in guest side
+func TagInterfaceVLAN(device string, vlan uint) (err error) {
+	if DefaultVLAN == vlan {
+		return nil
+	}
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		err = fmt.Errorf("get interface '%s' fail: %s", device, err.Error())
+		return
+	}
+	if isOVSPort(link) {
+		return TagOVSPort(device, vlan)
+	}
+	if err = netlink.BridgeVlanAdd(link, uint16(vlan), true, true, false, true); err != nil {
+		err = fmt.Errorf("add VLAN %d to interface '%s' fail: %s", vlan, device, err.Error())
+		return
+	}
+	//leave default VLAN, ignore when not member
+	_ = netlink.BridgeVlanDel(link, bridgeDefaultPVID, false, false, false, true)
+	return nil
+}
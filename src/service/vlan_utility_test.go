@@ -0,0 +1,176 @@
+package service
+
+import (
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestVLANInterface(t *testing.T) {
+	for _, vlan := range []uint{DefaultVLAN, 2, 100, MaxVLAN} {
+		if !IsValidVLAN(vlan) {
+			t.Fatalf("valid VLAN %d rejected", vlan)
+		}
+	}
+	for _, vlan := range []uint{bridgeDefaultPVID, MaxVLAN + 1} {
+		if IsValidVLAN(vlan) {
+			t.Fatalf("invalid VLAN %d accepted", vlan)
+		}
+	}
+	if name := VLANInterfaceName("br0", 100); "br0.100" != name {
+		t.Fatalf("unexpected interface name '%s'", name)
+	}
+}
+
+// TestVLANUplink : tagged traffic between VLAN of bridge and peer behind uplink, root required
+func TestVLANUplink(t *testing.T) {
+	const (
+		VLAN         = 100
+		Bridge       = "br0"
+		Uplink       = "uplink0"
+		Peer         = "uplink0p"
+		LocalAddress = "10.1.0.1"
+		PeerAddress  = "10.1.0.2"
+		PeerPort     = 5000
+	)
+	if 0 != os.Geteuid() {
+		t.Skip("root required")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		t.Skipf("get network namespace fail: %s", err.Error())
+	}
+	defer origin.Close()
+	local, err := netns.New()
+	if err != nil {
+		t.Skipf("create network namespace fail: %s", err.Error())
+	}
+	defer local.Close()
+	remote, err := netns.New()
+	if err != nil {
+		netns.Set(origin)
+		t.Skipf("create network namespace fail: %s", err.Error())
+	}
+	defer func() {
+		netns.Set(origin)
+		remote.Close()
+	}()
+	if err = netns.Set(local); err != nil {
+		t.Fatalf("switch network namespace fail: %s", err.Error())
+	}
+
+	//uplink of bridge, peer in another namespace
+	var filtering = true
+	var attrs = netlink.NewLinkAttrs()
+	attrs.Name = Bridge
+	var bridge = &netlink.Bridge{LinkAttrs: attrs, VlanFiltering: &filtering}
+	if err = netlink.LinkAdd(bridge); err == syscall.EOPNOTSUPP {
+		t.Skip("VLAN filtering not supported")
+	} else if err != nil {
+		t.Fatalf("create bridge fail: %s", err.Error())
+	}
+	attrs = netlink.NewLinkAttrs()
+	attrs.Name = Uplink
+	var uplink = &netlink.Veth{LinkAttrs: attrs, PeerName: Peer}
+	if err = netlink.LinkAdd(uplink); err != nil {
+		t.Fatalf("create uplink fail: %s", err.Error())
+	}
+	peer, err := netlink.LinkByName(Peer)
+	if err != nil {
+		t.Fatalf("get peer fail: %s", err.Error())
+	}
+	if err = netlink.LinkSetNsFd(peer, int(remote)); err != nil {
+		t.Fatalf("move peer fail: %s", err.Error())
+	}
+	if err = netlink.LinkSetMaster(uplink, bridge); err != nil {
+		t.Fatalf("attach uplink fail: %s", err.Error())
+	}
+	for _, link := range []netlink.Link{bridge, uplink} {
+		if err = netlink.LinkSetUp(link); err != nil {
+			t.Fatalf("set up '%s' fail: %s", link.Attrs().Name, err.Error())
+		}
+	}
+	device, err := AttachBridgeVLAN(Bridge, VLAN)
+	if err != nil {
+		t.Fatalf("attach VLAN fail: %s", err.Error())
+	}
+	if err = assignTestAddress(device, LocalAddress); err != nil {
+		t.Fatal(err)
+	}
+
+	//tagged sub-interface of peer
+	if err = netns.Set(remote); err != nil {
+		t.Fatalf("switch network namespace fail: %s", err.Error())
+	}
+	if peer, err = netlink.LinkByName(Peer); err != nil {
+		t.Fatalf("get peer fail: %s", err.Error())
+	}
+	if err = netlink.LinkSetUp(peer); err != nil {
+		t.Fatalf("set up peer fail: %s", err.Error())
+	}
+	attrs = netlink.NewLinkAttrs()
+	attrs.Name = VLANInterfaceName(Peer, VLAN)
+	attrs.ParentIndex = peer.Attrs().Index
+	if err = netlink.LinkAdd(&netlink.Vlan{LinkAttrs: attrs, VlanId: VLAN}); err != nil {
+		t.Fatalf("create VLAN of peer fail: %s", err.Error())
+	}
+	if err = assignTestAddress(attrs.Name, PeerAddress); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(PeerAddress), Port: PeerPort})
+	if err != nil {
+		t.Fatalf("listen on peer fail: %s", err.Error())
+	}
+	defer listener.Close()
+
+	if err = netns.Set(local); err != nil {
+		t.Fatalf("switch network namespace fail: %s", err.Error())
+	}
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.ParseIP(PeerAddress), Port: PeerPort})
+	if err != nil {
+		t.Fatalf("dial peer fail: %s", err.Error())
+	}
+	defer conn.Close()
+	var buffer = make([]byte, 16)
+	const (
+		message = "tagged"
+		retries = 5
+	)
+	//first datagram may be lost before neighbor resolved
+	for i := 0; i < retries; i++ {
+		if _, err = conn.Write([]byte(message)); err != nil {
+			t.Fatalf("send to peer fail: %s", err.Error())
+		}
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		var count int
+		if count, _, err = listener.ReadFrom(buffer); nil == err {
+			if message != string(buffer[:count]) {
+				t.Fatalf("unexpected message '%s'", buffer[:count])
+			}
+			return
+		}
+	}
+	t.Fatalf("tagged traffic not crossed uplink: %s", err.Error())
+}
+
+func assignTestAddress(device, address string) (err error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return
+	}
+	addr, err := netlink.ParseAddr(address + "/24")
+	if err != nil {
+		return
+	}
+	if err = netlink.AddrAdd(link, addr); err != nil {
+		return
+	}
+	return netlink.LinkSetUp(link)
+}
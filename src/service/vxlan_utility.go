@@ -0,0 +1,216 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	MinVNI = 1
+	MaxVNI = 1<<24 - 1
+	//IANA assigned
+	VXLANPort = 4789
+	//link-local address of metadata service, assigned to all overlay bridges
+	MetadataAddress = "169.254.169.254"
+	//outer IPv4 + UDP + VXLAN + inner ethernet
+	vxlanOverhead    = 50
+	overlayBridgeTag = "nvpc"
+	vxlanDeviceTag   = "nvx"
+	overlayTableName = "nano_overlay"
+)
+
+var vxlanFloodAddress = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+func IsValidVNI(vni uint) bool {
+	return MinVNI <= vni && vni <= MaxVNI
+}
+
+// OverlayBridgeName : bridge of overlay network that guests attached to, such as "nvpc100"
+func OverlayBridgeName(vni uint) string {
+	return fmt.Sprintf("%s%d", overlayBridgeTag, vni)
+}
+
+func vxlanDeviceName(vni uint) string {
+	return fmt.Sprintf("%s%d", vxlanDeviceTag, vni)
+}
+
+// CreateOverlayNetwork : VXLAN device sourced from local VTEP address enslaved to a new bridge, existing devices reused
+func CreateOverlayNetwork(vni uint, localAddress string) (bridge string, err error) {
+	if !IsValidVNI(vni) {
+		err = fmt.Errorf("invalid VNI %d", vni)
+		return
+	}
+	var localIP = net.ParseIP(localAddress)
+	if nil == localIP || nil == localIP.To4() {
+		err = fmt.Errorf("invalid VTEP address '%s'", localAddress)
+		return
+	}
+	bridge = OverlayBridgeName(vni)
+	bridgeLink, err := netlink.LinkByName(bridge)
+	if err != nil {
+		var attrs = netlink.NewLinkAttrs()
+		attrs.Name = bridge
+		bridgeLink = &netlink.Bridge{LinkAttrs: attrs}
+		if err = netlink.LinkAdd(bridgeLink); err != nil {
+			err = fmt.Errorf("create bridge '%s' fail: %s", bridge, err.Error())
+			return
+		}
+	}
+	var device = vxlanDeviceName(vni)
+	vxlanLink, err := netlink.LinkByName(device)
+	if err != nil {
+		var attrs = netlink.NewLinkAttrs()
+		attrs.Name = device
+		vxlanLink = &netlink.Vxlan{LinkAttrs: attrs, VxlanId: int(vni), SrcAddr: localIP, Port: VXLANPort, Learning: true}
+		if err = netlink.LinkAdd(vxlanLink); err != nil {
+			err = fmt.Errorf("create VXLAN device '%s' fail: %s", device, err.Error())
+			return
+		}
+	}
+	if err = netlink.LinkSetMaster(vxlanLink, bridgeLink); err != nil {
+		err = fmt.Errorf("attach '%s' to bridge '%s' fail: %s", device, bridge, err.Error())
+		return
+	}
+	for _, link := range []netlink.Link{vxlanLink, bridgeLink} {
+		if err = netlink.LinkSetUp(link); err != nil {
+			err = fmt.Errorf("set up interface '%s' fail: %s", link.Attrs().Name, err.Error())
+			return
+		}
+	}
+	metadata, _ := netlink.ParseAddr(MetadataAddress + "/32")
+	if err = netlink.AddrReplace(bridgeLink, metadata); err != nil {
+		err = fmt.Errorf("assign metadata address to '%s' fail: %s", bridge, err.Error())
+		return
+	}
+	return bridge, nil
+}
+
+// applyOverlayFilter : tenants reach only DHCP and metadata service of host through overlay bridges,
+// bridges matched by prefix so that the table covers all overlay networks
+func applyOverlayFilter() (err error) {
+	var cmd = exec.Command(natCommand, "-f", "-")
+	cmd.Stdin = strings.NewReader(buildOverlayFilter())
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("apply overlay filter fail: %s, %s", err.Error(), strings.TrimSpace(output.String()))
+		return
+	}
+	return nil
+}
+
+func buildOverlayFilter() string {
+	var bridges = overlayBridgeTag + "*"
+	var builder strings.Builder
+	//create before delete, so that script works when table absent
+	fmt.Fprintf(&builder, "table inet %s\ndelete table inet %s\n", overlayTableName, overlayTableName)
+	fmt.Fprintf(&builder, "table inet %s {\n", overlayTableName)
+	builder.WriteString("\tchain input {\n\t\ttype filter hook input priority 0; policy accept;\n")
+	fmt.Fprintf(&builder, "\t\tiifname \"%s\" udp dport %d accept\n", bridges, DHCPServerPort)
+	fmt.Fprintf(&builder, "\t\tiifname \"%s\" ip daddr %s tcp dport %d accept\n", bridges, MetadataAddress, InitiatorMagicPort)
+	fmt.Fprintf(&builder, "\t\tiifname \"%s\" drop\n", bridges)
+	builder.WriteString("\t}\n}\n")
+	return builder.String()
+}
+
+// DeleteOverlayNetwork : absent devices ignored
+func DeleteOverlayNetwork(vni uint) (err error) {
+	for _, name := range []string{vxlanDeviceName(vni), OverlayBridgeName(vni)} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			continue
+		}
+		if err = netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("delete interface '%s' fail: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// SyncOverlayPeers : head-end replication to VTEP of remote cells, stale peers removed
+func SyncOverlayPeers(vni uint, peers []string) (err error) {
+	var device = vxlanDeviceName(vni)
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		err = fmt.Errorf("get VXLAN device '%s' fail: %s", device, err.Error())
+		return
+	}
+	entries, err := netlink.NeighList(link.Attrs().Index, syscall.AF_BRIDGE)
+	if err != nil {
+		err = fmt.Errorf("list forwarding entries of '%s' fail: %s", device, err.Error())
+		return
+	}
+	var current []string
+	for _, entry := range entries {
+		if nil != entry.IP && vxlanFloodAddress.String() == entry.HardwareAddr.String() {
+			current = append(current, entry.IP.String())
+		}
+	}
+	added, removed := diffPeers(current, peers)
+	for _, peer := range added {
+		if err = netlink.NeighAppend(overlayFloodEntry(link, peer)); err != nil {
+			return fmt.Errorf("add peer %s to '%s' fail: %s", peer, device, err.Error())
+		}
+	}
+	for _, peer := range removed {
+		if err = netlink.NeighDel(overlayFloodEntry(link, peer)); err != nil {
+			return fmt.Errorf("remove peer %s from '%s' fail: %s", peer, device, err.Error())
+		}
+	}
+	return nil
+}
+
+func overlayFloodEntry(link netlink.Link, peer string) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		State:        netlink.NUD_PERMANENT | netlink.NUD_NOARP,
+		Flags:        netlink.NTF_SELF,
+		IP:           net.ParseIP(peer),
+		HardwareAddr: vxlanFloodAddress,
+	}
+}
+
+// diffPeers : both result sorted
+func diffPeers(current, expected []string) (added, removed []string) {
+	var exists = map[string]bool{}
+	for _, peer := range current {
+		exists[peer] = true
+	}
+	var required = map[string]bool{}
+	for _, peer := range expected {
+		required[peer] = true
+		if !exists[peer] {
+			added = append(added, peer)
+			exists[peer] = true
+		}
+	}
+	for _, peer := range current {
+		if !required[peer] {
+			removed = append(removed, peer)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return
+}
+
+// validatePeers : IPv4 VTEP address of remote cells, local address excluded
+func validatePeers(peers []string, localAddress string) (result []string, err error) {
+	for _, peer := range peers {
+		if !isValidIPv4(peer) {
+			err = fmt.Errorf("invalid VTEP address '%s'", peer)
+			return
+		}
+		if peer != localAddress {
+			result = append(result, peer)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"os"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestOverlayPeers(t *testing.T) {
+	for _, vni := range []uint{MinVNI, 100, MaxVNI} {
+		if !IsValidVNI(vni) {
+			t.Fatalf("valid VNI %d rejected", vni)
+		}
+	}
+	for _, vni := range []uint{0, MaxVNI + 1} {
+		if IsValidVNI(vni) {
+			t.Fatalf("invalid VNI %d accepted", vni)
+		}
+	}
+	added, removed := diffPeers([]string{"10.0.0.2", "10.0.0.3"}, []string{"10.0.0.4", "10.0.0.3", "10.0.0.4"})
+	if !reflect.DeepEqual([]string{"10.0.0.4"}, added) || !reflect.DeepEqual([]string{"10.0.0.2"}, removed) {
+		t.Fatalf("unexpected peers added %v, removed %v", added, removed)
+	}
+	peers, err := validatePeers([]string{"10.0.0.1", "10.0.0.2"}, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("validate peers fail: %s", err.Error())
+	}
+	if !reflect.DeepEqual([]string{"10.0.0.2"}, peers) {
+		t.Fatalf("local address not excluded: %v", peers)
+	}
+	if _, err = validatePeers([]string{"fd00::2"}, "10.0.0.1"); err == nil {
+		t.Fatal("IPv6 peer accepted")
+	}
+}
+
+func TestOverlayFilter(t *testing.T) {
+	var script = buildOverlayFilter()
+	var expected = "table inet nano_overlay\ndelete table inet nano_overlay\n" +
+		"table inet nano_overlay {\n" +
+		"\tchain input {\n\t\ttype filter hook input priority 0; policy accept;\n" +
+		"\t\tiifname \"nvpc*\" udp dport 67 accept\n" +
+		"\t\tiifname \"nvpc*\" ip daddr 169.254.169.254 tcp dport 25469 accept\n" +
+		"\t\tiifname \"nvpc*\" drop\n" +
+		"\t}\n}\n"
+	if expected != script {
+		t.Fatalf("unexpected overlay filter:\n%s", script)
+	}
+}
+
+// TestOverlayNetwork : run in a new network namespace, root required
+func TestOverlayNetwork(t *testing.T) {
+	const (
+		VNI          = 100
+		LocalAddress = "10.0.0.1"
+		Underlay     = "vtep0"
+	)
+	if 0 != os.Geteuid() {
+		t.Skip("root required")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		t.Skipf("get network namespace fail: %s", err.Error())
+	}
+	defer origin.Close()
+	namespace, err := netns.New()
+	if err != nil {
+		t.Skipf("create network namespace fail: %s", err.Error())
+	}
+	defer func() {
+		netns.Set(origin)
+		namespace.Close()
+	}()
+
+	var attrs = netlink.NewLinkAttrs()
+	attrs.Name = Underlay
+	var underlay = &netlink.Veth{LinkAttrs: attrs, PeerName: Underlay + "p"}
+	if err = netlink.LinkAdd(underlay); err != nil {
+		t.Fatalf("create underlay fail: %s", err.Error())
+	}
+	address, _ := netlink.ParseAddr(LocalAddress + "/24")
+	if err = netlink.AddrAdd(underlay, address); err != nil {
+		t.Fatalf("assign underlay address fail: %s", err.Error())
+	}
+	if err = netlink.LinkSetUp(underlay); err != nil {
+		t.Fatalf("set up underlay fail: %s", err.Error())
+	}
+
+	bridge, err := CreateOverlayNetwork(VNI, LocalAddress)
+	if err != nil {
+		t.Fatalf("create overlay network fail: %s", err.Error())
+	}
+	if OverlayBridgeName(VNI) != bridge {
+		t.Fatalf("unexpected bridge '%s'", bridge)
+	}
+	//reentrant
+	if _, err = CreateOverlayNetwork(VNI, LocalAddress); err != nil {
+		t.Fatalf("recreate overlay network fail: %s", err.Error())
+	}
+	var expectPeers = func(expected []string) {
+		link, err := netlink.LinkByName(vxlanDeviceName(VNI))
+		if err != nil {
+			t.Fatalf("get VXLAN device fail: %s", err.Error())
+		}
+		entries, err := netlink.NeighList(link.Attrs().Index, syscall.AF_BRIDGE)
+		if err != nil {
+			t.Fatalf("list forwarding entries fail: %s", err.Error())
+		}
+		var current []string
+		for _, entry := range entries {
+			if nil != entry.IP && vxlanFloodAddress.String() == entry.HardwareAddr.String() {
+				current = append(current, entry.IP.String())
+			}
+		}
+		if added, removed := diffPeers(current, expected); 0 != len(added) || 0 != len(removed) {
+			t.Fatalf("unexpected peers %v, expected %v", current, expected)
+		}
+	}
+	if err = SyncOverlayPeers(VNI, []string{"10.0.0.2", "10.0.0.3"}); err != nil {
+		t.Fatalf("sync peers fail: %s", err.Error())
+	}
+	expectPeers([]string{"10.0.0.2", "10.0.0.3"})
+	if err = SyncOverlayPeers(VNI, []string{"10.0.0.3"}); err != nil {
+		t.Fatalf("sync peers fail: %s", err.Error())
+	}
+	expectPeers([]string{"10.0.0.3"})
+
+	if err = DeleteOverlayNetwork(VNI); err != nil {
+		t.Fatalf("delete overlay network fail: %s", err.Error())
+	}
+	if _, err = netlink.LinkByName(bridge); err == nil {
+		t.Fatalf("bridge '%s' not deleted", bridge)
+	}
+}
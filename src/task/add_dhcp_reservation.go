@@ -0,0 +1,59 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"strings"
+)
+
+type AddDHCPReservationExecutor struct {
+	Sender     framework.MessageSender
+	DHCPModule service.DHCPModule
+}
+
+// Execute : optional gateway in ParamKeyGateway, DNS servers joined by ',' in ParamKeyServer, hostname in ParamKeyName, lease time in seconds in ParamKeyLimit, VLAN in ParamKeyNetwork
+func (executor *AddDHCPReservationExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var reservation service.DHCPReservation
+	if reservation.HardwareAddress, err = request.GetString(framework.ParamKeyHardware); err != nil {
+		err = fmt.Errorf("get hardware address fail: %s", err.Error())
+		return
+	}
+	if reservation.Address, err = request.GetString(framework.ParamKeyAddress); err != nil {
+		err = fmt.Errorf("get address fail: %s", err.Error())
+		return
+	}
+	if gateway, err := request.GetString(framework.ParamKeyGateway); nil == err {
+		reservation.Gateway = gateway
+	}
+	if servers, err := request.GetString(framework.ParamKeyServer); nil == err && "" != servers {
+		reservation.DNS = strings.Split(servers, ",")
+	}
+	if hostname, err := request.GetString(framework.ParamKeyName); nil == err {
+		reservation.Hostname = hostname
+	}
+	if leaseTime, err := request.GetUInt(framework.ParamKeyLimit); nil == err {
+		reservation.LeaseTime = leaseTime
+	}
+	if vlan, err := request.GetUInt(framework.ParamKeyNetwork); nil == err {
+		reservation.VLAN = vlan
+	}
+	resp, _ := framework.CreateJsonMessage(AddDHCPReservationResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.DHCPModule.AddReservation(reservation, respChan)
+	err = <-respChan
+	if err != nil {
+		log.Printf("[%08X] add DHCP reservation for '%s' fail: %s", id, reservation.HardwareAddress, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] address %s reserved for '%s'", id, reservation.Address, reservation.HardwareAddress)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
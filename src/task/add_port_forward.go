@@ -0,0 +1,57 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type AddPortForwardExecutor struct {
+	Sender        framework.MessageSender
+	NetworkModule service.NetworkModule
+}
+
+// Execute : instance in ParamKeyInstance, protocol in ParamKeyProtocol, ports in ParamKeyPort as [host port, guest port]
+func (executor *AddPortForwardExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	const (
+		ValidPortCount = 2
+	)
+	var forward service.PortForward
+	if forward.Instance, err = request.GetString(framework.ParamKeyInstance); err != nil {
+		err = fmt.Errorf("get instance id fail: %s", err.Error())
+		return
+	}
+	if forward.Protocol, err = request.GetString(framework.ParamKeyProtocol); err != nil {
+		err = fmt.Errorf("get protocol fail: %s", err.Error())
+		return
+	}
+	ports, err := request.GetUIntArray(framework.ParamKeyPort)
+	if err != nil {
+		err = fmt.Errorf("get ports fail: %s", err.Error())
+		return
+	}
+	if ValidPortCount != len(ports) {
+		err = fmt.Errorf("unexpect port count %d", len(ports))
+		return
+	}
+	forward.HostPort, forward.GuestPort = uint(ports[0]), uint(ports[1])
+	resp, _ := framework.CreateJsonMessage(AddPortForwardResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.NetworkModule.AddPortForward(forward, respChan)
+	err = <-respChan
+	if err != nil {
+		log.Printf("[%08X] add port forward for instance '%s' fail: %s", id, forward.Instance, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] %s port %d forwarded to port %d of instance '%s'", id, forward.Protocol, forward.HostPort,
+		forward.GuestPort, forward.Instance)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
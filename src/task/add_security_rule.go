@@ -16,7 +16,7 @@ func (executor *AddSecurityRuleExecutor) Execute(id framework.SessionID, request
 	incoming chan framework.Message, terminate chan bool) (err error) {
 	var instanceID string
 	var accept bool
-	var fromIP, toIP, toPort, protocol uint
+	var fromIP, toIP, toPort, toPortEnd, protocol uint
 	if instanceID, err = request.GetString(framework.ParamKeyInstance); err != nil{
 		err = fmt.Errorf("get instance id fail: %s", err.Error())
 		return
@@ -25,11 +25,25 @@ func (executor *AddSecurityRuleExecutor) Execute(id framework.SessionID, request
 		err = fmt.Errorf("get action fail: %s", err.Error())
 		return
 	}
-	if fromIP, err = request.GetUInt(framework.ParamKeyFrom); err != nil{
+	//source in string for IPv6 or CIDR, instead of IPv4 in integer
+	sourceAddress, _ := request.GetString(framework.ParamKeySource)
+	if "" != sourceAddress{
+		if !service.IsValidRuleAddress(sourceAddress){
+			err = fmt.Errorf("invalid source address '%s'", sourceAddress)
+			return
+		}
+	}else if fromIP, err = request.GetUInt(framework.ParamKeyFrom); err != nil{
 		err = fmt.Errorf("get source address fail: %s", err.Error())
 		return
 	}
-	if toIP, err = request.GetUInt(framework.ParamKeyTo); err != nil{
+	//target in string for IPv6 or CIDR, instead of IPv4 in integer
+	targetAddress, _ := request.GetString(framework.ParamKeyTarget)
+	if "" != targetAddress{
+		if !service.IsValidRuleAddress(targetAddress){
+			err = fmt.Errorf("invalid target address '%s'", targetAddress)
+			return
+		}
+	}else if toIP, err = request.GetUInt(framework.ParamKeyTo); err != nil{
 		err = fmt.Errorf("get target address fail: %s", err.Error())
 		return
 	}
@@ -40,6 +54,18 @@ func (executor *AddSecurityRuleExecutor) Execute(id framework.SessionID, request
 		err = fmt.Errorf("invalid target port %d", toPort)
 		return
 	}
+	//optional end of port range
+	toPortEnd, _ = request.GetUInt(framework.ParamKeyLimit)
+	if 0 != toPortEnd && (toPortEnd < toPort || toPortEnd > 0xFFFF){
+		err = fmt.Errorf("invalid target port range %d ~ %d", toPort, toPortEnd)
+		return
+	}
+	//optional, incoming when omitted
+	direction, _ := request.GetString(framework.ParamKeyType)
+	if !service.IsValidRuleDirection(direction){
+		err = fmt.Errorf("invalid direction '%s'", direction)
+		return
+	}
 	if protocol, err = request.GetUInt(framework.ParamKeyProtocol); err != nil{
 		err = fmt.Errorf("get protocol fail: %s", err.Error())
 		return
@@ -51,8 +77,12 @@ func (executor *AddSecurityRuleExecutor) Execute(id framework.SessionID, request
 
 	var rule = service.SecurityPolicyRule{
 		Accept: accept,
+		Direction: direction,
 		TargetPort: toPort,
 	}
+	if toPortEnd > toPort{
+		rule.TargetPortEnd = toPortEnd
+	}
 
 	switch protocol {
 	case service.PolicyRuleProtocolIndexTCP:
@@ -65,8 +95,16 @@ func (executor *AddSecurityRuleExecutor) Execute(id framework.SessionID, request
 		err = fmt.Errorf("invalid protocol %d for security rule", protocol)
 		return
 	}
-	rule.SourceAddress = service.UInt32ToIPv4(uint32(fromIP))
-	rule.TargetAddress = service.UInt32ToIPv4(uint32(toIP))
+	if "" != sourceAddress{
+		rule.SourceAddress = sourceAddress
+	}else{
+		rule.SourceAddress = service.UInt32ToIPv4(uint32(fromIP))
+	}
+	if "" != targetAddress{
+		rule.TargetAddress = targetAddress
+	}else{
+		rule.TargetAddress = service.UInt32ToIPv4(uint32(toIP))
+	}
 
 	var respChan = make(chan error, 1)
 	executor.InstanceModule.AddSecurityPolicyRule(instanceID, rule, respChan)
@@ -77,11 +115,11 @@ func (executor *AddSecurityRuleExecutor) Execute(id framework.SessionID, request
 		resp.SetError(err.Error())
 	}else{
 		if accept{
-			log.Printf("[%08X] add security rule to instance '%s': accept protocol '%s' from '%s' to '%s:%d'",
-				id, instanceID, rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort)
+			log.Printf("[%08X] add security rule to instance '%s': accept %s",
+				id, instanceID, rule.String())
 		}else{
-			log.Printf("[%08X] add security rule to instance '%s': reject protocol '%s' from '%s' to '%s:%d'",
-				id, instanceID, rule.Protocol, rule.SourceAddress, rule.TargetAddress, rule.TargetPort)
+			log.Printf("[%08X] add security rule to instance '%s': reject %s",
+				id, instanceID, rule.String())
 		}
 		resp.SetSuccess(true)
 	}
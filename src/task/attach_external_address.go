@@ -0,0 +1,72 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type AttachExternalAddressExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+	NetworkModule  service.NetworkModule
+}
+
+// Execute : floating address in ParamKeyAddress, previous address of instance replaced
+func (executor *AttachExternalAddressExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	instanceID, err := request.GetString(framework.ParamKeyInstance)
+	if err != nil {
+		err = fmt.Errorf("get instance id fail: %s", err.Error())
+		return
+	}
+	address, err := request.GetString(framework.ParamKeyAddress)
+	if err != nil {
+		err = fmt.Errorf("get external address fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(AttachExternalAddressResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var configChan = make(chan service.InstanceResult, 1)
+	executor.InstanceModule.GetInstanceConfig(instanceID, configChan)
+	var result = <-configChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] get config of instance '%s' fail: %s", id, instanceID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var previous = result.Instance.ExternalAddress
+	var errChan = make(chan error, 1)
+	executor.NetworkModule.AttachExternalAddress(instanceID, address, errChan)
+	if err = <-errChan; err != nil {
+		log.Printf("[%08X] attach external address %s to instance '%s' fail: %s", id, address, instanceID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	if previous != address {
+		executor.InstanceModule.ModifyExternalAddress(instanceID, address, errChan)
+		err = <-errChan
+	}
+	if err != nil {
+		log.Printf("[%08X] update external address of instance '%s' fail: %s", id, instanceID, err.Error())
+		//restore data plane to previous address
+		if "" == previous {
+			executor.NetworkModule.DetachExternalAddress(instanceID, errChan)
+		} else {
+			executor.NetworkModule.AttachExternalAddress(instanceID, previous, errChan)
+		}
+		if rollbackError := <-errChan; rollbackError != nil {
+			log.Printf("[%08X] warning: restore external address of instance '%s' fail: %s", id, instanceID, rollbackError.Error())
+		}
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] external address %s attached to instance '%s'", id, address, instanceID)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -112,6 +112,7 @@ func (executor *AttachInstanceExecutor) Execute(id framework.SessionID, request
 			executor.detachResource(id, idList, true, true, true)
 			return nil
 		}
+		executor.activateNetwork(id, idList)
 
 		notify, _ := framework.CreateJsonMessage(framework.InstanceMigratedEvent)
 		notify.SetSuccess(true)
@@ -157,6 +158,7 @@ func (executor *AttachInstanceExecutor) Execute(id framework.SessionID, request
 				executor.detachResource(id, idList, true, true, true)
 				return nil
 			}
+			executor.activateNetwork(id, idList)
 
 			notify, _ := framework.CreateJsonMessage(framework.InstanceMigratedEvent)
 			notify.SetSuccess(true)
@@ -180,6 +182,15 @@ func (executor *AttachInstanceExecutor) Execute(id framework.SessionID, request
 	}
 }
 
+// activateNetwork : external addresses released by source cell, announced after migrated instances started
+func (executor *AttachInstanceExecutor) activateNetwork(id framework.SessionID, instances []string) {
+	var respChan = make(chan error, 1)
+	executor.NetworkModule.ActivateInstances(instances, respChan)
+	if err := <-respChan; err != nil {
+		log.Printf("[%08X] warning: activate network of migrated instance fail: %s", id, err.Error())
+	}
+}
+
 func (executor *AttachInstanceExecutor) detachResource(id framework.SessionID, instances []string, detachNetwork, detachVolume, detachInstance bool) {
 	var respChan = make(chan error, 1)
 	var err error
@@ -0,0 +1,67 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type CaptureGuestScreenshotExecutor struct {
+	Sender           framework.MessageSender
+	InstanceModule   service.InstanceModule
+	DiagnosticModule service.DiagnosticModule
+}
+
+// Execute : response diagnostic of screenshot, download port is 0 when diagnostic server disabled
+func (executor *CaptureGuestScreenshotExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(CaptureGuestScreenshotResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.InstanceResult, 1)
+	executor.InstanceModule.CaptureScreenshot(guestID, respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] capture screenshot of guest '%s' fail: %s", id, guestID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	setDiagnostic(resp, guestID, result.Diagnostic, executor.DiagnosticModule)
+	log.Printf("[%08X] screenshot '%s' of guest '%s' captured", id, result.Diagnostic.Name, guestID)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
+
+// diagnosticDownloadPath : signed path with token, unsigned when diagnostic server disabled
+func diagnosticDownloadPath(module service.DiagnosticModule, guestID, name string) string {
+	var path = fmt.Sprintf("/diagnostics/%s/%s", guestID, name)
+	if nil == module {
+		return path
+	}
+	return module.SignPath(path)
+}
+
+func diagnosticDownloadPort(module service.DiagnosticModule) uint {
+	if nil == module {
+		return 0
+	}
+	return module.GetPort()
+}
+
+func setDiagnostic(resp framework.Message, guestID string, diagnostic service.GuestDiagnostic, module service.DiagnosticModule) {
+	resp.SetString(framework.ParamKeyName, diagnostic.Name)
+	resp.SetString(framework.ParamKeyType, diagnostic.Kind)
+	resp.SetUInt(framework.ParamKeySize, uint(diagnostic.Size))
+	resp.SetString(framework.ParamKeyCreate, diagnostic.CreateTime)
+	resp.SetString(framework.ParamKeyPath, diagnosticDownloadPath(module, guestID, diagnostic.Name))
+	resp.SetUInt(framework.ParamKeyPort, diagnosticDownloadPort(module))
+}
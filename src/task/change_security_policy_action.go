@@ -24,17 +24,37 @@ func (executor *ChangeDefaultSecurityActionExecutor) Execute(id framework.Sessio
 		err = fmt.Errorf("get action fail: %s", err.Error())
 		return
 	}
+	//optional, default action of incoming traffic when omitted
+	direction, _ := request.GetString(framework.ParamKeyType)
+	if !service.IsValidRuleDirection(direction){
+		err = fmt.Errorf("invalid direction '%s'", direction)
+		return
+	}
+	var egress = service.PolicyRuleDirectionOut == direction
 	resp, _ := framework.CreateJsonMessage(framework.ChangeGuestRuleDefaultActionResponse)
 	resp.SetFromSession(id)
 	resp.SetToSession(request.GetFromSession())
 	resp.SetSuccess(false)
 	var respChan = make(chan error, 1)
-	executor.InstanceModule.ChangeDefaultSecurityPolicyAction(instanceID, accept, respChan)
+	if egress{
+		executor.InstanceModule.ChangeEgressSecurityPolicyAction(instanceID, accept, respChan)
+	}else{
+		executor.InstanceModule.ChangeDefaultSecurityPolicyAction(instanceID, accept, respChan)
+	}
 	err = <- respChan
 	if nil != err{
 		log.Printf("[%08X] change default security policy action of instance '%s' fail: %s",
 			id, instanceID, err.Error())
 		resp.SetError(err.Error())
+	}else if egress{
+		if accept{
+			log.Printf("[%08X] egress security policy action of instance '%s' changed to accept",
+				id, instanceID)
+		}else{
+			log.Printf("[%08X] egress security policy action of instance '%s' changed to drop",
+				id, instanceID)
+		}
+		resp.SetSuccess(true)
 	}else{
 		if accept{
 			log.Printf("[%08X] default security policy action of instance '%s' changed to accept",
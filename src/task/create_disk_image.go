@@ -39,6 +39,11 @@ func (executor *CreateDiskImageExecutor) Execute(id framework.SessionID, request
 	resp.SetSuccess(false)
 	resp.SetFromSession(id)
 	resp.SetToSession(request.GetFromSession())
+	if err = checkMaintenance(executor.InstanceModule); err != nil {
+		log.Printf("[%08X] create disk image rejected: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
 	var targetVolume string
 	{
 		var respChan = make(chan service.InstanceResult)
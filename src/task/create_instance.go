@@ -82,7 +82,13 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 			OptionOffsetTablet
 			ValidOptionCount
 		)
-		if ValidOptionCount != len(templateOptions) {
+		const (
+			//optional device actions
+			OptionOffsetWatchdog = ValidOptionCount + iota
+			OptionOffsetPanic
+			ExtendedOptionCount
+		)
+		if ValidOptionCount != len(templateOptions) && ExtendedOptionCount != len(templateOptions) {
 			err = fmt.Errorf("template options count mismatch %d / %d", len(templateOptions), ValidOptionCount)
 			return executor.ResponseFail(resp, err.Error(), request.GetSender())
 		}
@@ -95,6 +101,19 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 			USB:             service.TemplateUSBModel(templateOptions[OptionOffsetUSB]).ToString(),
 			Tablet:          service.TemplateTabletModel(templateOptions[OptionOffsetTablet]).ToString(),
 		}
+		if ExtendedOptionCount == len(templateOptions) {
+			var watchdogAction = service.TemplateDeviceAction(templateOptions[OptionOffsetWatchdog])
+			var panicAction = service.TemplateDeviceAction(templateOptions[OptionOffsetPanic])
+			if watchdogAction >= service.TemplateDeviceActionInvalid || panicAction >= service.TemplateDeviceActionInvalid {
+				err = fmt.Errorf("invalid device action %d / %d", watchdogAction, panicAction)
+				return executor.ResponseFail(resp, err.Error(), request.GetSender())
+			}
+			t.Watchdog = watchdogAction.ToString()
+			t.Panic = panicAction.ToString()
+			if "" != t.Watchdog || "" != t.Panic {
+				log.Printf("[%08X] watchdog action '%s', panic action '%s'", id, t.Watchdog, t.Panic)
+			}
+		}
 		config.Template = &t
 	}
 
@@ -102,13 +121,30 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 		return err
 	} else {
 		const (
-			ValidModeCount = 2 //[network, storage]
+			ValidModeCount   = 2 //[network, storage]
+			SegmentModeCount = 3 //[network, storage, VLAN of plain network or VNI of VPC network]
 		)
-		if ValidModeCount != len(modeArray) {
+		if ValidModeCount != len(modeArray) && SegmentModeCount != len(modeArray) {
 			return fmt.Errorf("unexpect mode params count %d", len(modeArray))
 		}
 		config.NetworkMode = service.InstanceNetworkMode(modeArray[0])
 		config.StorageMode = service.InstanceStorageMode(modeArray[1])
+		var segment uint
+		if SegmentModeCount == len(modeArray) {
+			segment = uint(modeArray[2])
+		}
+		switch config.NetworkMode {
+		case service.NetworkModePlain:
+			if !service.IsValidVLAN(segment) {
+				return fmt.Errorf("invalid VLAN %d", segment)
+			}
+			config.NetworkVLAN = segment
+		case service.NetworkModeVPC:
+			if !service.IsValidVNI(segment) {
+				return fmt.Errorf("invalid VNI %d", segment)
+			}
+			config.NetworkVNI = segment
+		}
 	}
 	var cloneFromImage = false
 	var imageID, mediaHost string
@@ -130,14 +166,19 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 	}
 	if assignedAddress, err := request.GetStringArray(framework.ParamKeyAddress); err == nil {
 		const (
-			ValidAssignedLength = 2
+			ValidAssignedLength     = 2
+			DualStackAssignedLength = 4 //internal, external, internal IPv6, external IPv6
 		)
-		if len(assignedAddress) != ValidAssignedLength {
+		if len(assignedAddress) != ValidAssignedLength && len(assignedAddress) != DualStackAssignedLength {
 			err = fmt.Errorf("unexpect assigned addresses count %d", len(assignedAddress))
 			return executor.ResponseFail(resp, err.Error(), request.GetSender())
 		}
 		config.InternalAddress = assignedAddress[0]
 		config.ExternalAddress = assignedAddress[1]
+		if DualStackAssignedLength == len(assignedAddress) {
+			config.InternalAddressV6 = assignedAddress[2]
+			config.ExternalAddressV6 = assignedAddress[3]
+		}
 	}
 	//QoS
 	{
@@ -191,6 +232,12 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 			}
 			var parameterCount = len(policyParameters)
 			var ruleCount = parameterCount / validPolicyElementCount
+			//optional source in string for IPv6 or CIDR, one for each rule
+			sources, _ := request.GetStringArray(framework.ParamKeySource)
+			if 0 != len(sources) && len(sources) != ruleCount {
+				err = fmt.Errorf("unexpected source addresses count %d for %d rule(s)", len(sources), ruleCount)
+				return executor.ResponseFail(resp, err.Error(), request.GetSender())
+			}
 			var securityPolicy service.SecurityPolicy
 			if securityPolicy.Accept, err = request.GetBoolean(framework.ParamKeyAction); err != nil {
 				err = fmt.Errorf("get default security action fail: %s", err.Error())
@@ -217,6 +264,13 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 				rule.SourceAddress = service.UInt32ToIPv4(uint32(policyParameters[start+offsetFrom]))
 				rule.TargetAddress = service.UInt32ToIPv4(uint32(policyParameters[start+offsetTo]))
 				rule.TargetPort = uint(policyParameters[start+offsetPort])
+				if 0 != len(sources) && "" != sources[start/validPolicyElementCount] {
+					rule.SourceAddress = sources[start/validPolicyElementCount]
+					if !service.IsValidRuleAddress(rule.SourceAddress) {
+						err = fmt.Errorf("invalid source address '%s'", rule.SourceAddress)
+						return executor.ResponseFail(resp, err.Error(), request.GetSender())
+					}
+				}
 				securityPolicy.Rules = append(securityPolicy.Rules, rule)
 				//log.Printf("[%08X] debug: policy parameters %d, %d, %d, %d, %d",
 				//	id, policyParameters[start + offsetAccept], policyParameters[start + offsetProtocol], policyParameters[start + offsetFrom],
@@ -334,6 +388,10 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 		}
 	}
 
+	if err = checkMaintenance(executor.InstanceModule); err != nil {
+		log.Printf("[%08X] create instance '%s' rejected: %s", id, config.Name, err.Error())
+		return executor.ResponseFail(resp, err.Error(), request.GetSender())
+	}
 	{
 		//network prepare
 		if "" == config.HardwareAddress {
@@ -347,7 +405,7 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 		}
 
 		switch config.NetworkMode {
-		case service.NetworkModePlain:
+		case service.NetworkModePlain, service.NetworkModeVPC, service.NetworkModePrivate:
 			{
 				//find bridge
 				var respChan = make(chan service.NetworkResult)
@@ -359,6 +417,12 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 					return executor.ResponseFail(resp, err.Error(), request.GetSender())
 				}
 				config.NetworkSource = result.Name
+				switch config.NetworkMode {
+				case service.NetworkModeVPC:
+					config.NetworkSource = service.OverlayBridgeName(config.NetworkVNI)
+				case service.NetworkModePrivate:
+					config.NetworkSource = service.PrivateBridgeName
+				}
 				config.AddressAllocation = result.Allocation
 				log.Printf("[%08X] network bridge '%s' (mode '%s') allocated for instance '%s'",
 					id, config.NetworkSource, config.AddressAllocation, config.Name)
@@ -366,7 +430,9 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 			{
 				//monitor port
 				var respChan = make(chan service.NetworkResult)
-				executor.NetworkModule.AllocateInstanceResource(config.ID, config.HardwareAddress, config.InternalAddress, config.ExternalAddress, respChan)
+				executor.NetworkModule.AllocateInstanceResource(config.ID, config.HardwareAddress, config.InternalAddress, config.ExternalAddress,
+					config.InternalAddressV6, config.ExternalAddressV6, config.NetworkVLAN, config.NetworkVNI,
+					service.NetworkModePrivate == config.NetworkMode, respChan)
 				result := <-respChan
 				if result.Error != nil {
 					err = result.Error
@@ -375,6 +441,15 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 				}
 				config.MonitorPort = uint(result.MonitorPort)
 				log.Printf("[%08X] monitor port %d allocated", id, config.MonitorPort)
+				if service.DefaultVLAN != config.NetworkVLAN {
+					log.Printf("[%08X] instance '%s' tagged with VLAN %d", id, config.Name, config.NetworkVLAN)
+				} else if service.NetworkModeVPC == config.NetworkMode {
+					log.Printf("[%08X] instance '%s' attached to overlay network %d", id, config.Name, config.NetworkVNI)
+				} else if service.NetworkModePrivate == config.NetworkMode {
+					//allocated by cell when not specified
+					config.InternalAddress = result.Internal
+					log.Printf("[%08X] private address %s allocated for instance '%s'", id, config.InternalAddress, config.Name)
+				}
 			}
 
 			break
@@ -391,7 +466,11 @@ func (executor *CreateInstanceExecutor) Execute(id framework.SessionID, request
 		respChan := make(chan service.StorageResult)
 		var bootType = service.BootTypeNone
 		if config.CloudInitAvailable {
-			bootType = service.BootTypeCloudInit
+			if service.NetworkModeVPC == config.NetworkMode {
+				bootType = service.BootTypeCloudInitOverlay
+			} else {
+				bootType = service.BootTypeCloudInit
+			}
 		}
 
 		executor.StorageModule.CreateVolumes(volGroup, systemSize, dataSize, bootType, respChan)
@@ -48,7 +48,7 @@ func (executor *DeleteInstanceExecutor) Execute(id framework.SessionID, request
 	{
 		//todo: detach network
 		switch config.NetworkMode {
-		case service.NetworkModePlain:
+		case service.NetworkModePlain, service.NetworkModeVPC, service.NetworkModePrivate:
 			var respChan = make(chan error)
 			executor.NetworkModule.DeallocateAllResource(instanceID, respChan)
 			err := <- respChan
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type DetachExternalAddressExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+	NetworkModule  service.NetworkModule
+}
+
+func (executor *DetachExternalAddressExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	instanceID, err := request.GetString(framework.ParamKeyInstance)
+	if err != nil {
+		err = fmt.Errorf("get instance id fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(DetachExternalAddressResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var errChan = make(chan error, 1)
+	executor.NetworkModule.DetachExternalAddress(instanceID, errChan)
+	if err = <-errChan; err != nil {
+		log.Printf("[%08X] detach external address of instance '%s' fail: %s", id, instanceID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	executor.InstanceModule.ModifyExternalAddress(instanceID, "", errChan)
+	if err = <-errChan; err != nil {
+		//address already released, config of instance updated by next synchronize
+		log.Printf("[%08X] warning: clear external address of instance '%s' fail: %s", id, instanceID, err.Error())
+	}
+	log.Printf("[%08X] external address detached from instance '%s'", id, instanceID)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
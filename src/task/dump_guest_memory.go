@@ -0,0 +1,43 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type DumpGuestMemoryExecutor struct {
+	Sender           framework.MessageSender
+	InstanceModule   service.InstanceModule
+	DiagnosticModule service.DiagnosticModule
+}
+
+// Execute : response after dump finished, may take minutes for guest with large memory
+func (executor *DumpGuestMemoryExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(DumpGuestMemoryResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.InstanceResult, 1)
+	executor.InstanceModule.DumpMemory(guestID, respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] dump memory of guest '%s' fail: %s", id, guestID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	setDiagnostic(resp, guestID, result.Diagnostic, executor.DiagnosticModule)
+	log.Printf("[%08X] memory of guest '%s' dumped to '%s', %d MiB", id, guestID,
+		result.Diagnostic.Name, result.Diagnostic.Size>>20)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
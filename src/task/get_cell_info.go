@@ -11,7 +11,7 @@ type GetCellInfoExecutor struct {
 	InstanceModule  service.InstanceModule
 	StorageModule   service.StorageModule
 	NetworkModule   service.NetworkModule
-
+	HostModule      service.HostModule
 }
 
 func (executor *GetCellInfoExecutor) Execute(id framework.SessionID, request framework.Message,
@@ -50,6 +50,17 @@ func (executor *GetCellInfoExecutor) Execute(id framework.SessionID, request fra
 		resp.SetUIntArray(framework.ParamKeyAttach, attached)
 		log.Printf("[%08X] %d device(s) available", id, len(names))
 	}
+	{
+		//physical disks and interfaces
+		var respChan = make(chan service.HostResult, 1)
+		executor.HostModule.GetDeviceIO(respChan)
+		var result = <- respChan
+		if result.Error != nil{
+			log.Printf("[%08X] warning: fetch device io fail: %s", id, result.Error.Error())
+		}else{
+			service.MarshalHostDeviceIO(resp, result.Devices)
+		}
+	}
 	resp.SetSuccess(true)
 	return executor.Sender.SendMessage(resp, request.GetSender())
 }
\ No newline at end of file
@@ -0,0 +1,40 @@
+package task
+
+import (
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type GetCellInventoryExecutor struct {
+	Sender     framework.MessageSender
+	HostModule service.HostModule
+}
+
+// Execute : set immediate to collect inventory again instead of the cached one
+func (executor *GetCellInventoryExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	refresh, _ := request.GetBoolean(framework.ParamKeyImmediate)
+	resp, _ := framework.CreateJsonMessage(GetCellInventoryResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.HostResult, 1)
+	executor.HostModule.GetInventory(refresh, respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] get host inventory fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	if err = result.Inventory.Marshal(resp); err != nil {
+		log.Printf("[%08X] marshal host inventory fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] host inventory collected at %s", id, result.Inventory.CollectTime)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,65 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type GetCellMaintenanceExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+}
+
+// Execute : response with drain progress of each guest in order of shutdown
+func (executor *GetCellMaintenanceExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	resp, _ := framework.CreateJsonMessage(GetCellMaintenanceResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.InstanceResult, 1)
+	executor.InstanceModule.GetMaintenance(respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] get maintenance status fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var status = result.Maintenance
+	var ids, names, errMessages []string
+	var progress []uint64
+	for _, guest := range status.Guests {
+		ids = append(ids, guest.ID)
+		names = append(names, guest.Name)
+		errMessages = append(errMessages, guest.Error)
+		progress = append(progress, uint64(guest.Status))
+	}
+	resp.SetBoolean(framework.ParamKeyEnable, status.Enabled)
+	resp.SetBoolean(framework.ParamKeyAction, status.Draining)
+	//maintenance since, drain begin, drain end
+	resp.SetStringArray(framework.ParamKeyCreate, []string{status.Since, status.DrainBegin, status.DrainEnd})
+	resp.SetStringArray(framework.ParamKeyInstance, ids)
+	resp.SetStringArray(framework.ParamKeyName, names)
+	resp.SetStringArray(framework.ParamKeyError, errMessages)
+	resp.SetUIntArray(framework.ParamKeyStatus, progress)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
+
+// checkMaintenance : reject operations before any resource allocated when cell in maintenance mode
+func checkMaintenance(module service.InstanceModule) (err error) {
+	var respChan = make(chan service.InstanceResult, 1)
+	module.GetMaintenance(respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.Maintenance.Enabled {
+		return fmt.Errorf("cell in maintenance mode since %s", result.Maintenance.Since)
+	}
+	return nil
+}
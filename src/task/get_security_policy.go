@@ -35,11 +35,20 @@ func (executor *GetSecurityPolicyExecutor) Execute(id framework.SessionID, reque
 		resp.SetError(err.Error())
 	}else{
 		var policy = result.Policy
-		var fromIP, toIP, toPort, protocols, actions []uint64
+		var fromIP, toIP, toPort, toPortEnd, protocols, actions []uint64
+		var sources, targets, directions []string
 		for index, rule := range policy.Rules{
 			fromIP = append(fromIP, uint64(service.IPv4ToUInt32(rule.SourceAddress)))
+			sources = append(sources, rule.SourceAddress)
 			toIP = append(toIP, uint64(service.IPv4ToUInt32(rule.TargetAddress)))
 			toPort = append(toPort, uint64(rule.TargetPort))
+			targets = append(targets, rule.TargetAddress)
+			toPortEnd = append(toPortEnd, uint64(rule.TargetPortEnd))
+			if rule.IsEgress(){
+				directions = append(directions, service.PolicyRuleDirectionOut)
+			}else{
+				directions = append(directions, service.PolicyRuleDirectionIn)
+			}
 			switch rule.Protocol {
 			case service.PolicyRuleProtocolTCP:
 				protocols = append(protocols, uint64(service.PolicyRuleProtocolIndexTCP))
@@ -68,8 +77,20 @@ func (executor *GetSecurityPolicyExecutor) Execute(id framework.SessionID, reque
 				id, len(toPort), instanceID)
 		}
 		resp.SetUIntArray(framework.ParamKeyFrom, fromIP)
+		//complete source address, including IPv6 and CIDR
+		resp.SetStringArray(framework.ParamKeySource, sources)
 		resp.SetUIntArray(framework.ParamKeyTo, toIP)
 		resp.SetUIntArray(framework.ParamKeyPort, toPort)
+		//complete target address, end of port range and direction
+		resp.SetStringArray(framework.ParamKeyTarget, targets)
+		resp.SetUIntArray(framework.ParamKeyLimit, toPortEnd)
+		resp.SetStringArray(framework.ParamKeyType, directions)
+		//default action of outgoing traffic
+		if policy.RejectEgress{
+			resp.SetUInt(framework.ParamKeyPolicy, service.PolicyRuleActionReject)
+		}else{
+			resp.SetUInt(framework.ParamKeyPolicy, service.PolicyRuleActionAccept)
+		}
 		resp.SetUIntArray(framework.ParamKeyProtocol, protocols)
 		resp.SetUIntArray(framework.ParamKeyAction, actions)
 		resp.SetSuccess(true)
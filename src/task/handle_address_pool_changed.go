@@ -5,6 +5,7 @@ import (
 	"github.com/project-nano/framework"
 	"github.com/project-nano/cell/service"
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -23,6 +24,13 @@ func (executor *HandleAddressPoolChangedExecutor) Execute(id framework.SessionID
 	if dns, err = request.GetStringArray(framework.ParamKeyServer); err != nil{
 		return
 	}
+	var gatewayV6 = getIPv6Gateway(request)
+	var vlan = getPoolVLAN(request)
+	options, err := getDHCPOptions(request)
+	if err != nil{
+		err = fmt.Errorf("get DHCP options fail: %s", err.Error())
+		return
+	}
 	if allocationMode, err = request.GetString(framework.ParamKeyMode); err != nil{
 		err = fmt.Errorf("get allocation mode fail: %s", err.Error())
 		return
@@ -37,17 +45,87 @@ func (executor *HandleAddressPoolChangedExecutor) Execute(id framework.SessionID
 		return
 	}
 	var respChan = make(chan error, 1)
-	executor.NetworkModule.UpdateAddressAllocation(gateway, dns, allocationMode, respChan)
+	executor.NetworkModule.UpdateAddressAllocation(vlan, gateway, gatewayV6, dns, options, allocationMode, respChan)
 	err = <- respChan
 	if err != nil{
 		log.Printf("[%08X] update address allocation fail when address pool changed from %s.[%08X]: %s",
 			id, request.GetSender(), request.GetFromSession(), err.Error())
 	}else{
-		log.Printf("[%08X] address allocation updated to mode %s, gateway: %s, DNS: %s",
-			id, allocationMode, gateway, strings.Join(dns, "/"))
+		log.Printf("[%08X] address allocation of VLAN %d updated to mode %s, gateway: %s, DNS: %s",
+			id, vlan, allocationMode, gateway, strings.Join(dns, "/"))
+		if "" != gatewayV6{
+			log.Printf("[%08X] IPv6 gateway: %s", id, gatewayV6)
+		}
 		if service.AddressAllocationNone != allocationMode{
 			executor.InstanceModule.SyncAddressAllocation(allocationMode)
 		}
 	}
 	return nil
 }
+
+//getIPv6Gateway : optional, carried as second element of gateway array [IPv4, IPv6]
+func getIPv6Gateway(request framework.Message) string{
+	if gateways, err := request.GetStringArray(framework.ParamKeyGateway); nil == err && len(gateways) > 1{
+		return gateways[1]
+	}
+	return ""
+}
+
+//getPoolVLAN : optional VLAN of address pool in ParamKeyNetwork, untagged when absent
+func getPoolVLAN(request framework.Message) uint{
+	if vlan, err := request.GetUInt(framework.ParamKeyNetwork); nil == err{
+		return vlan
+	}
+	return service.DefaultVLAN
+}
+
+//getDHCPOptions : optional, carried as "name=value" in ParamKeyOption,
+//available names: domain, search, ntp, mtu, lease(seconds), route("destination CIDR,gateway", repeatable),
+//multiple search domains or NTP servers separated by ','
+func getDHCPOptions(request framework.Message) (options service.DHCPOptions, err error){
+	const (
+		OptionSeparator = "="
+		ValueSeparator  = ","
+	)
+	values, err := request.GetStringArray(framework.ParamKeyOption)
+	if err != nil{
+		return options, nil
+	}
+	for _, value := range values{
+		var pair = strings.SplitN(value, OptionSeparator, 2)
+		if 2 != len(pair){
+			err = fmt.Errorf("invalid option '%s'", value)
+			return
+		}
+		var name, content = strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+		switch name {
+		case "domain":
+			options.Domain = content
+		case "search":
+			options.Search = append(options.Search, strings.Split(content, ValueSeparator)...)
+		case "ntp":
+			options.NTP = append(options.NTP, strings.Split(content, ValueSeparator)...)
+		case "mtu", "lease":
+			number, err := strconv.ParseUint(content, 10, 32)
+			if err != nil{
+				return options, fmt.Errorf("invalid %s '%s'", name, content)
+			}
+			if "mtu" == name{
+				options.MTU = uint(number)
+			}else{
+				options.LeaseTime = uint(number)
+			}
+		case "route":
+			var route = strings.Split(content, ValueSeparator)
+			if 2 != len(route){
+				err = fmt.Errorf("invalid route '%s'", content)
+				return
+			}
+			options.Routes = append(options.Routes, service.StaticRoute{Destination: route[0], Gateway: route[1]})
+		default:
+			err = fmt.Errorf("unsupported option '%s'", name)
+			return
+		}
+	}
+	return options, nil
+}
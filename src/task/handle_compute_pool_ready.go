@@ -12,6 +12,7 @@ type HandleComputePoolReadyExecutor struct {
 	InstanceModule service.InstanceModule
 	StorageModule  service.StorageModule
 	NetworkModule  service.NetworkModule
+	HostModule     service.HostModule
 }
 
 func (executor *HandleComputePoolReadyExecutor) Execute(id framework.SessionID, request framework.Message,
@@ -106,8 +107,13 @@ func (executor *HandleComputePoolReadyExecutor) Execute(id framework.SessionID,
 			err = fmt.Errorf("invalid allocation mode :%s", allocationMode)
 			return
 		}
+		var options service.DHCPOptions
+		if options, err = getDHCPOptions(request); err != nil{
+			err = fmt.Errorf("get DHCP options fail: %s", err.Error())
+			return
+		}
 		var respChan = make(chan error, 1)
-		executor.NetworkModule.UpdateAddressAllocation(gateway, dns, allocationMode, respChan)
+		executor.NetworkModule.UpdateAddressAllocation(getPoolVLAN(request), gateway, getIPv6Gateway(request), dns, options, allocationMode, respChan)
 		err = <- respChan
 		if err != nil{
 			resp.SetError(err.Error())
@@ -128,7 +134,10 @@ func (executor *HandleComputePoolReadyExecutor) Execute(id framework.SessionID,
 	resp.SetUInt(framework.ParamKeyCount, count)
 	if 0 == count{
 		log.Printf("[%08X] no instance configured", id)
-		return executor.Sender.SendMessage(resp, request.GetSender())
+		if err = executor.Sender.SendMessage(resp, request.GetSender()); err != nil{
+			return
+		}
+		return executor.reportInventory(id, request.GetSender())
 	}
 
 	var names, ids, users, groups, secrets, addresses, systems, createTime, internal, external, hardware []string
@@ -203,5 +212,28 @@ func (executor *HandleComputePoolReadyExecutor) Execute(id framework.SessionID,
 	resp.SetUIntArray(framework.ParamKeyPriority, cpuPriorities)
 	resp.SetUIntArray(framework.ParamKeyLimit, ioLimits)
 	log.Printf("[%08X] %d instance config(s) reported", id, count)
-	return executor.Sender.SendMessage(resp, request.GetSender())
+	if err = executor.Sender.SendMessage(resp, request.GetSender()); err != nil{
+		return
+	}
+	return executor.reportInventory(id, request.GetSender())
+}
+
+//reportInventory : send host inventory along with registration, so Core could schedule by capability
+func (executor *HandleComputePoolReadyExecutor) reportInventory(id framework.SessionID, target string) (err error){
+	var respChan = make(chan service.HostResult, 1)
+	executor.HostModule.GetInventory(false, respChan)
+	var result = <- respChan
+	if result.Error != nil{
+		log.Printf("[%08X] warning: get host inventory fail: %s", id, result.Error.Error())
+		return nil
+	}
+	event, _ := framework.CreateJsonMessage(CellInventoryEvent)
+	event.SetFromSession(id)
+	if err = result.Inventory.Marshal(event); err != nil{
+		log.Printf("[%08X] warning: marshal host inventory fail: %s", id, err.Error())
+		return nil
+	}
+	event.SetSuccess(true)
+	log.Printf("[%08X] host inventory reported", id)
+	return executor.Sender.SendMessage(event, target)
 }
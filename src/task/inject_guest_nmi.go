@@ -0,0 +1,37 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type InjectGuestNMIExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+}
+
+func (executor *InjectGuestNMIExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(InjectGuestNMIResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.InstanceModule.InjectNMI(guestID, respChan)
+	if err = <-respChan; err != nil {
+		log.Printf("[%08X] inject NMI to guest '%s' fail: %s", id, guestID, err.Error())
+		resp.SetError(err.Error())
+	} else {
+		log.Printf("[%08X] NMI injected to guest '%s'", id, guestID)
+		resp.SetSuccess(true)
+	}
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,52 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"time"
+)
+
+type IssueConsoleTokenExecutor struct {
+	Sender        framework.MessageSender
+	ConsoleModule service.ConsoleModule
+}
+
+// Execute : token in ParamKeySecret, proxy port in ParamKeyPort, url path in ParamKeyPath, seconds before expire in ParamKeyLimit
+func (executor *IssueConsoleTokenExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(IssueConsoleTokenResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	if nil == executor.ConsoleModule {
+		err = fmt.Errorf("console proxy disabled")
+		log.Printf("[%08X] issue console token fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var respChan = make(chan service.ConsoleResult, 1)
+	executor.ConsoleModule.IssueToken(guestID, respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] issue console token for guest '%s' fail: %s", id, guestID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	resp.SetString(framework.ParamKeySecret, result.Token)
+	resp.SetUInt(framework.ParamKeyPort, result.Port)
+	resp.SetString(framework.ParamKeyProtocol, result.Protocol)
+	resp.SetString(framework.ParamKeyPath, fmt.Sprintf("/console?token=%s", result.Token))
+	resp.SetUInt(framework.ParamKeyLimit, uint(time.Until(result.Expire).Seconds()))
+	log.Printf("[%08X] console token issued for guest '%s'", id, guestID)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
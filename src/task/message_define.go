@@ -0,0 +1,65 @@
+package task
+
+// message types not defined in framework yet, allocated from a reserved range
+// to avoid collision with framework.
+// move to framework when both Core and Cell updated
+const (
+	extendedMessageBase = 0x7F00
+)
+
+const (
+	QueryInstanceMetricsRequest = extendedMessageBase + iota
+	QueryInstanceMetricsResponse
+	GetCellInventoryRequest
+	GetCellInventoryResponse
+	CellInventoryEvent
+	QueryCellAlertRequest
+	QueryCellAlertResponse
+	CellAlertRaisedEvent
+	CellAlertClearedEvent
+	SetCellMaintenanceRequest
+	SetCellMaintenanceResponse
+	GetCellMaintenanceRequest
+	GetCellMaintenanceResponse
+	QueryCellCapacityRequest
+	QueryCellCapacityResponse
+	ModifyRestartPolicyRequest
+	ModifyRestartPolicyResponse
+	GuestRestartPolicyEvent
+	GuestWatchdogEvent
+	GuestPanicEvent
+	CaptureGuestScreenshotRequest
+	CaptureGuestScreenshotResponse
+	DumpGuestMemoryRequest
+	DumpGuestMemoryResponse
+	QueryGuestDiagnosticRequest
+	QueryGuestDiagnosticResponse
+	SendGuestKeysRequest
+	SendGuestKeysResponse
+	InjectGuestNMIRequest
+	InjectGuestNMIResponse
+	IssueConsoleTokenRequest
+	IssueConsoleTokenResponse
+	QueryDHCPLeaseRequest
+	QueryDHCPLeaseResponse
+	AddDHCPReservationRequest
+	AddDHCPReservationResponse
+	RemoveDHCPReservationRequest
+	RemoveDHCPReservationResponse
+	AddressConflictEvent
+	UpdateOverlayNetworkRequest
+	UpdateOverlayNetworkResponse
+	RemoveOverlayNetworkRequest
+	RemoveOverlayNetworkResponse
+	AddPortForwardRequest
+	AddPortForwardResponse
+	RemovePortForwardRequest
+	RemovePortForwardResponse
+	QueryPortForwardRequest
+	QueryPortForwardResponse
+	AttachExternalAddressRequest
+	AttachExternalAddressResponse
+	DetachExternalAddressRequest
+	DetachExternalAddressResponse
+	InstanceEventAcknowledgeEvent
+)
@@ -24,12 +24,17 @@ func (executor *ModifyAutoStartExecutor)Execute(id framework.SessionID, request
 		err = fmt.Errorf("get enable flag fail: %s", err.Error())
 		return
 	}
+	//optional start order, keep current when omitted
+	var order = -1
+	if index, err := request.GetInt(framework.ParamKeyIndex); nil == err && index >= 0 {
+		order = index
+	}
 	resp, _ := framework.CreateJsonMessage(framework.ModifyAutoStartResponse)
 	resp.SetToSession(request.GetFromSession())
 	resp.SetFromSession(id)
 	resp.SetSuccess(false)
 	var respChan = make(chan error, 1)
-	executor.InstanceModule.ModifyAutoStart(guestID, enable, respChan)
+	executor.InstanceModule.ModifyAutoStart(guestID, enable, order, respChan)
 	if err = <- respChan; err != nil{
 		log.Printf("[%08X] modify auto start fail: %s", id, err.Error())
 		resp.SetError(err.Error())
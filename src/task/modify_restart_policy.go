@@ -0,0 +1,48 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type ModifyRestartPolicyExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+}
+
+// Execute : policy in ParamKeyPolicy, max retries in ParamKeyLimit, unlimited when omitted
+func (executor *ModifyRestartPolicyExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	var policy uint
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	if policy, err = request.GetUInt(framework.ParamKeyPolicy); err != nil {
+		err = fmt.Errorf("get restart policy fail: %s", err.Error())
+		return
+	}
+	var retries uint = 0
+	if limit, err := request.GetUInt(framework.ParamKeyLimit); nil == err {
+		retries = limit
+	}
+	resp, _ := framework.CreateJsonMessage(ModifyRestartPolicyResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.InstanceModule.ModifyRestartPolicy(guestID, service.RestartPolicy(policy), retries, respChan)
+	if err = <-respChan; err != nil {
+		log.Printf("[%08X] modify restart policy fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+	} else {
+		log.Printf("[%08X] restart policy of guest '%s' changed to %s, max retries %d",
+			id, guestID, service.RestartPolicy(policy).ToString(), retries)
+		resp.SetSuccess(true)
+	}
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
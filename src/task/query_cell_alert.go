@@ -0,0 +1,39 @@
+package task
+
+import (
+	"errors"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"time"
+)
+
+type QueryCellAlertExecutor struct {
+	Sender      framework.MessageSender
+	AlertModule service.AlertModule
+}
+
+func (executor *QueryCellAlertExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	resp, _ := framework.CreateJsonMessage(QueryCellAlertResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan []service.Alert, 1)
+	executor.AlertModule.GetAlerts(respChan)
+	var timer = time.NewTimer(service.GetConfigurator().GetOperateTimeout())
+	select {
+	case <-timer.C:
+		err = errors.New("request timeout")
+		log.Printf("[%08X] query alerts timeout", id)
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	case alerts := <-respChan:
+		timer.Stop()
+		service.MarshalAlerts(resp, alerts)
+		log.Printf("[%08X] %d alert(s) available", id, len(alerts))
+		resp.SetSuccess(true)
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+}
@@ -0,0 +1,62 @@
+package task
+
+import (
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type QueryCellCapacityExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+	StorageModule  service.StorageModule
+}
+
+// Execute : core, memory and disk in [host, reserved, limit, committed], overcommit ratio of them in limit, scaled by 100
+func (executor *QueryCellCapacityExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	const (
+		ratioScale = 100
+	)
+	resp, _ := framework.CreateJsonMessage(QueryCellCapacityResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var cores, memory, disk service.ResourceCapacity
+	{
+		var respChan = make(chan service.InstanceResult, 1)
+		executor.InstanceModule.GetCapacity(respChan)
+		var result = <-respChan
+		if result.Error != nil {
+			err = result.Error
+			log.Printf("[%08X] get instance capacity fail: %s", id, err.Error())
+			resp.SetError(err.Error())
+			return executor.Sender.SendMessage(resp, request.GetSender())
+		}
+		cores = result.Cores
+		memory = result.Memory
+	}
+	{
+		var respChan = make(chan service.StorageResult, 1)
+		executor.StorageModule.GetCapacity(respChan)
+		var result = <-respChan
+		if result.Error != nil {
+			err = result.Error
+			log.Printf("[%08X] get storage capacity fail: %s", id, err.Error())
+			resp.SetError(err.Error())
+			return executor.Sender.SendMessage(resp, request.GetSender())
+		}
+		disk = result.Capacity
+		resp.SetString(framework.ParamKeyPool, result.Pool)
+	}
+	resp.SetUIntArray(framework.ParamKeyCore, []uint64{cores.Host, cores.Reserved, cores.Limit, cores.Committed})
+	resp.SetUIntArray(framework.ParamKeyMemory, []uint64{memory.Host, memory.Reserved, memory.Limit, memory.Committed})
+	resp.SetUIntArray(framework.ParamKeyDisk, []uint64{disk.Host, disk.Reserved, disk.Limit, disk.Committed})
+	resp.SetUIntArray(framework.ParamKeyLimit, []uint64{uint64(cores.Ratio * ratioScale),
+		uint64(memory.Ratio * ratioScale), uint64(disk.Ratio * ratioScale)})
+	log.Printf("[%08X] committed %d / %d cores, %d / %d MiB memory, %d / %d GiB disk", id,
+		cores.Committed, cores.Limit, memory.Committed>>20, memory.Limit>>20, disk.Committed>>30, disk.Limit>>30)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,76 @@
+package task
+
+import (
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"strings"
+	"time"
+)
+
+type QueryDHCPLeaseExecutor struct {
+	Sender     framework.MessageSender
+	DHCPModule service.DHCPModule
+}
+
+const (
+	dhcpEntryLease = iota
+	dhcpEntryReservation
+)
+
+// Execute : entries in parallel arrays, ParamKeyType for lease(0) or reservation(1), DNS servers joined by ',' in ParamKeyServer,
+// seconds before expire of lease or lease time of reservation in ParamKeyLimit, VLAN in ParamKeyNetwork
+func (executor *QueryDHCPLeaseExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	resp, _ := framework.CreateJsonMessage(QueryDHCPLeaseResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.DHCPResult, 1)
+	executor.DHCPModule.QueryLeases(respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] query DHCP lease fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var types, limits, vlans []uint64
+	var hwaddresses, addresses, gateways, servers, hostnames []string
+	for _, lease := range result.Leases {
+		var remain uint64
+		if seconds := time.Until(lease.Expire).Seconds(); seconds > 0 {
+			remain = uint64(seconds)
+		}
+		types = append(types, dhcpEntryLease)
+		hwaddresses = append(hwaddresses, lease.HardwareAddress)
+		addresses = append(addresses, lease.Address)
+		gateways = append(gateways, lease.Gateway)
+		servers = append(servers, strings.Join(lease.DNS, ","))
+		hostnames = append(hostnames, lease.Hostname)
+		limits = append(limits, remain)
+		vlans = append(vlans, uint64(lease.VLAN))
+	}
+	for _, reservation := range result.Reservations {
+		types = append(types, dhcpEntryReservation)
+		hwaddresses = append(hwaddresses, reservation.HardwareAddress)
+		addresses = append(addresses, reservation.Address)
+		gateways = append(gateways, reservation.Gateway)
+		servers = append(servers, strings.Join(reservation.DNS, ","))
+		hostnames = append(hostnames, reservation.Hostname)
+		limits = append(limits, uint64(reservation.LeaseTime))
+		vlans = append(vlans, uint64(reservation.VLAN))
+	}
+	resp.SetUIntArray(framework.ParamKeyType, types)
+	resp.SetStringArray(framework.ParamKeyHardware, hwaddresses)
+	resp.SetStringArray(framework.ParamKeyAddress, addresses)
+	resp.SetStringArray(framework.ParamKeyGateway, gateways)
+	resp.SetStringArray(framework.ParamKeyServer, servers)
+	resp.SetStringArray(framework.ParamKeyName, hostnames)
+	resp.SetUIntArray(framework.ParamKeyLimit, limits)
+	resp.SetUIntArray(framework.ParamKeyNetwork, vlans)
+	log.Printf("[%08X] %d DHCP lease(s), %d reservation(s) available", id, len(result.Leases), len(result.Reservations))
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,56 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type QueryGuestDiagnosticExecutor struct {
+	Sender           framework.MessageSender
+	InstanceModule   service.InstanceModule
+	DiagnosticModule service.DiagnosticModule
+}
+
+// Execute : diagnostics in arrays of name, type, size, create time and download path, oldest first
+func (executor *QueryGuestDiagnosticExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(QueryGuestDiagnosticResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.InstanceResult, 1)
+	executor.InstanceModule.QueryDiagnostics(guestID, respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] query diagnostics of guest '%s' fail: %s", id, guestID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var names, kinds, createTimes, paths []string
+	var sizes []uint64
+	for _, diagnostic := range result.Diagnostics {
+		names = append(names, diagnostic.Name)
+		kinds = append(kinds, diagnostic.Kind)
+		sizes = append(sizes, diagnostic.Size)
+		createTimes = append(createTimes, diagnostic.CreateTime)
+		paths = append(paths, diagnosticDownloadPath(executor.DiagnosticModule, guestID, diagnostic.Name))
+	}
+	resp.SetStringArray(framework.ParamKeyName, names)
+	resp.SetStringArray(framework.ParamKeyType, kinds)
+	resp.SetUIntArray(framework.ParamKeySize, sizes)
+	resp.SetStringArray(framework.ParamKeyCreate, createTimes)
+	resp.SetStringArray(framework.ParamKeyPath, paths)
+	resp.SetUInt(framework.ParamKeyPort, diagnosticDownloadPort(executor.DiagnosticModule))
+	log.Printf("[%08X] %d diagnostic(s) of guest '%s' available", id, len(names), guestID)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,76 @@
+package task
+
+import (
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"time"
+)
+
+type QueryInstanceMetricsExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+}
+
+// Execute : request params instance, from/to in unix seconds, size as granularity in seconds(optional)
+func (executor *QueryInstanceMetricsExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	const (
+		defaultGranularity = time.Minute
+		usageScale         = 100 //percent in 0.01%
+	)
+	var instanceID string
+	var beginValue, endValue uint
+	if instanceID, err = request.GetString(framework.ParamKeyInstance); err != nil {
+		return
+	}
+	if beginValue, err = request.GetUInt(framework.ParamKeyFrom); err != nil {
+		return
+	}
+	if endValue, err = request.GetUInt(framework.ParamKeyTo); err != nil {
+		return
+	}
+	var granularity = defaultGranularity
+	if seconds, err := request.GetUInt(framework.ParamKeySize); nil == err && 0 != seconds {
+		granularity = time.Duration(seconds) * time.Second
+	}
+	var begin = time.Unix(int64(beginValue), 0)
+	var end = time.Unix(int64(endValue), 0)
+
+	resp, _ := framework.CreateJsonMessage(QueryInstanceMetricsResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.InstanceResult, 1)
+	executor.InstanceModule.QueryInstanceMetrics(instanceID, begin, end, granularity, respChan)
+	var result = <-respChan
+	if nil != result.Error {
+		err = result.Error
+		log.Printf("[%08X] query metrics of instance '%s' fail: %s", id, instanceID, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var count = len(result.Metrics)
+	var timestamps = make([]uint64, 0, count)
+	var usages = make([]uint64, 0, count)
+	var memory = make([]uint64, 0, count)
+	var iops = make([]uint64, 0, count*2)
+	var io = make([]uint64, 0, count*4)
+	for _, metrics := range result.Metrics {
+		timestamps = append(timestamps, uint64(metrics.Timestamp.Unix()))
+		usages = append(usages, uint64(metrics.CpuUsage*usageScale))
+		memory = append(memory, metrics.AvailableMemory)
+		iops = append(iops, metrics.ReadIOPS, metrics.WriteIOPS)
+		io = append(io, metrics.BytesRead, metrics.BytesWritten, metrics.BytesReceived, metrics.BytesSent)
+	}
+	resp.SetString(framework.ParamKeyInstance, instanceID)
+	resp.SetUIntArray(framework.ParamKeyFrom, timestamps)
+	resp.SetUIntArray(framework.ParamKeyUsage, usages)
+	resp.SetUIntArray(framework.ParamKeyAvailable, memory)
+	resp.SetUIntArray(framework.ParamKeyDisk, iops)
+	resp.SetUIntArray(framework.ParamKeyIO, io)
+	resp.SetSuccess(true)
+	log.Printf("[%08X] %d metrics of instance '%s' returned", id, count, instanceID)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
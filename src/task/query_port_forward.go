@@ -0,0 +1,47 @@
+package task
+
+import (
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type QueryPortForwardExecutor struct {
+	Sender        framework.MessageSender
+	NetworkModule service.NetworkModule
+}
+
+// Execute : forwards in parallel arrays, protocol in ParamKeyProtocol, host port in ParamKeyPort,
+// instance in ParamKeyInstance, guest port in ParamKeyTarget
+func (executor *QueryPortForwardExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	resp, _ := framework.CreateJsonMessage(QueryPortForwardResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan service.NetworkResult, 1)
+	executor.NetworkModule.QueryPortForwards(respChan)
+	var result = <-respChan
+	if result.Error != nil {
+		err = result.Error
+		log.Printf("[%08X] query port forward fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	var protocols, instances []string
+	var hostPorts, guestPorts []uint64
+	for _, forward := range result.Forwards {
+		protocols = append(protocols, forward.Protocol)
+		hostPorts = append(hostPorts, uint64(forward.HostPort))
+		instances = append(instances, forward.Instance)
+		guestPorts = append(guestPorts, uint64(forward.GuestPort))
+	}
+	resp.SetStringArray(framework.ParamKeyProtocol, protocols)
+	resp.SetUIntArray(framework.ParamKeyPort, hostPorts)
+	resp.SetStringArray(framework.ParamKeyInstance, instances)
+	resp.SetUIntArray(framework.ParamKeyTarget, guestPorts)
+	log.Printf("[%08X] %d port forward(s) available", id, len(result.Forwards))
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
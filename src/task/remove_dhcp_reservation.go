@@ -0,0 +1,38 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type RemoveDHCPReservationExecutor struct {
+	Sender     framework.MessageSender
+	DHCPModule service.DHCPModule
+}
+
+func (executor *RemoveDHCPReservationExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var hwaddress string
+	if hwaddress, err = request.GetString(framework.ParamKeyHardware); err != nil {
+		err = fmt.Errorf("get hardware address fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(RemoveDHCPReservationResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.DHCPModule.RemoveReservation(hwaddress, respChan)
+	err = <-respChan
+	if err != nil {
+		log.Printf("[%08X] remove DHCP reservation of '%s' fail: %s", id, hwaddress, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] DHCP reservation of '%s' removed", id, hwaddress)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,39 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type RemoveOverlayNetworkExecutor struct {
+	Sender        framework.MessageSender
+	NetworkModule service.NetworkModule
+}
+
+// Execute : VNI in ParamKeyNetwork
+func (executor *RemoveOverlayNetworkExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	vni, err := request.GetUInt(framework.ParamKeyNetwork)
+	if err != nil {
+		err = fmt.Errorf("get VNI fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(RemoveOverlayNetworkResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.NetworkModule.RemoveOverlayNetwork(vni, respChan)
+	err = <-respChan
+	if err != nil {
+		log.Printf("[%08X] remove overlay network %d fail: %s", id, vni, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] overlay network %d removed", id, vni)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
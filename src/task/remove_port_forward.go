@@ -0,0 +1,44 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type RemovePortForwardExecutor struct {
+	Sender        framework.MessageSender
+	NetworkModule service.NetworkModule
+}
+
+// Execute : protocol in ParamKeyProtocol, host port in ParamKeyPort
+func (executor *RemovePortForwardExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	protocol, err := request.GetString(framework.ParamKeyProtocol)
+	if err != nil {
+		err = fmt.Errorf("get protocol fail: %s", err.Error())
+		return
+	}
+	hostPort, err := request.GetUInt(framework.ParamKeyPort)
+	if err != nil {
+		err = fmt.Errorf("get host port fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(RemovePortForwardResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.NetworkModule.RemovePortForward(protocol, hostPort, respChan)
+	err = <-respChan
+	if err != nil {
+		log.Printf("[%08X] remove port forward %s/%d fail: %s", id, protocol, hostPort, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] port forward %s/%d removed", id, protocol, hostPort)
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -40,6 +40,11 @@ func (executor *ResetGuestSystemExecutor) Execute(id framework.SessionID, reques
 	resp.SetFromSession(id)
 	resp.SetToSession(request.GetFromSession())
 	resp.SetSuccess(false)
+	if err = checkMaintenance(executor.InstanceModule); err != nil {
+		log.Printf("[%08X] reset system rejected: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
 
 	var systemVolume string
 	var systemSize uint64
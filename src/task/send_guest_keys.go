@@ -0,0 +1,48 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"strings"
+)
+
+type SendGuestKeysExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+}
+
+// Execute : keys pressed together in ParamKeyData, names or raw codes, code set in ParamKeyType, linux when omitted
+func (executor *SendGuestKeysExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var guestID string
+	var keys []string
+	if guestID, err = request.GetString(framework.ParamKeyGuest); err != nil {
+		err = fmt.Errorf("get guest id fail: %s", err.Error())
+		return
+	}
+	if keys, err = request.GetStringArray(framework.ParamKeyData); err != nil {
+		err = fmt.Errorf("get keys fail: %s", err.Error())
+		return
+	}
+	var codeSet = service.KeyCodeSetLinux
+	if value, err := request.GetUInt(framework.ParamKeyType); nil == err {
+		codeSet = service.KeyCodeSet(value)
+	}
+	resp, _ := framework.CreateJsonMessage(SendGuestKeysResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.InstanceModule.SendKeys(guestID, codeSet, keys, respChan)
+	if err = <-respChan; err != nil {
+		log.Printf("[%08X] send keys to guest '%s' fail: %s", id, guestID, err.Error())
+		resp.SetError(err.Error())
+	} else {
+		log.Printf("[%08X] keys '%s' sent to guest '%s'", id, strings.Join(keys, "+"), guestID)
+		resp.SetSuccess(true)
+	}
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
@@ -0,0 +1,38 @@
+package task
+
+import (
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+)
+
+type SetCellMaintenanceExecutor struct {
+	Sender         framework.MessageSender
+	InstanceModule service.InstanceModule
+}
+
+// Execute : enable for entering/leaving maintenance mode, action for draining running guests
+func (executor *SetCellMaintenanceExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var enable bool
+	if enable, err = request.GetBoolean(framework.ParamKeyEnable); err != nil {
+		return
+	}
+	drain, _ := request.GetBoolean(framework.ParamKeyAction)
+	log.Printf("[%08X] recv set maintenance to %t (drain %t) from %s.[%08X]",
+		id, enable, drain, request.GetSender(), request.GetFromSession())
+	resp, _ := framework.CreateJsonMessage(SetCellMaintenanceResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.InstanceModule.SetMaintenance(enable, drain, respChan)
+	if err = <-respChan; err != nil {
+		log.Printf("[%08X] set maintenance fail: %s", id, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
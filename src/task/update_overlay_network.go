@@ -0,0 +1,58 @@
+package task
+
+import (
+	"fmt"
+	"github.com/project-nano/cell/service"
+	"github.com/project-nano/framework"
+	"log"
+	"strings"
+)
+
+type UpdateOverlayNetworkExecutor struct {
+	Sender        framework.MessageSender
+	NetworkModule service.NetworkModule
+}
+
+// Execute : VNI in ParamKeyNetwork, gateway in ParamKeyGateway, DNS servers in ParamKeyServer, optional VTEP address of remote cells in ParamKeyHost
+func (executor *UpdateOverlayNetworkExecutor) Execute(id framework.SessionID, request framework.Message,
+	incoming chan framework.Message, terminate chan bool) (err error) {
+	var vni uint
+	var gateway string
+	var dns, peers []string
+	if vni, err = request.GetUInt(framework.ParamKeyNetwork); err != nil {
+		err = fmt.Errorf("get VNI fail: %s", err.Error())
+		return
+	}
+	if gateway, err = request.GetString(framework.ParamKeyGateway); err != nil {
+		err = fmt.Errorf("get gateway fail: %s", err.Error())
+		return
+	}
+	if dns, err = request.GetStringArray(framework.ParamKeyServer); err != nil {
+		err = fmt.Errorf("get DNS servers fail: %s", err.Error())
+		return
+	}
+	if hosts, err := request.GetStringArray(framework.ParamKeyHost); nil == err {
+		peers = hosts
+	}
+	options, err := getDHCPOptions(request)
+	if err != nil {
+		err = fmt.Errorf("get DHCP options fail: %s", err.Error())
+		return
+	}
+	resp, _ := framework.CreateJsonMessage(UpdateOverlayNetworkResponse)
+	resp.SetFromSession(id)
+	resp.SetToSession(request.GetFromSession())
+	resp.SetSuccess(false)
+
+	var respChan = make(chan error, 1)
+	executor.NetworkModule.UpdateOverlayNetwork(vni, gateway, dns, options, peers, respChan)
+	err = <-respChan
+	if err != nil {
+		log.Printf("[%08X] update overlay network %d fail: %s", id, vni, err.Error())
+		resp.SetError(err.Error())
+		return executor.Sender.SendMessage(resp, request.GetSender())
+	}
+	log.Printf("[%08X] overlay network %d updated, gateway: %s, peers: %s", id, vni, gateway, strings.Join(peers, "/"))
+	resp.SetSuccess(true)
+	return executor.Sender.SendMessage(resp, request.GetSender())
+}
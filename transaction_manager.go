@@ -16,7 +16,9 @@ type TransactionManager struct {
 }
 
 func CreateTransactionManager(sender framework.MessageSender, instanceModule *service.InstanceManager,
-	storageModule *service.StorageManager, networkModule *service.NetworkManager) (manager *TransactionManager, err error) {
+	storageModule *service.StorageManager, networkModule *service.NetworkManager, hostModule service.HostModule,
+	alertModule service.AlertModule, consoleModule service.ConsoleModule, dhcpModule service.DHCPModule,
+	diagnosticModule service.DiagnosticModule) (manager *TransactionManager, err error) {
 	var engine *framework.TransactionEngine
 	if engine, err = framework.CreateTransactionEngine(); err != nil {
 		return nil, err
@@ -28,7 +30,7 @@ func CreateTransactionManager(sender framework.MessageSender, instanceModule *se
 
 	manager = &TransactionManager{engine}
 	if err = manager.RegisterExecutor(framework.GetComputePoolCellRequest,
-		&task.GetCellInfoExecutor{sender, instanceModule, storageModule, networkModule}); err != nil {
+		&task.GetCellInfoExecutor{sender, instanceModule, storageModule, networkModule, hostModule}); err != nil {
 		return nil, err
 	}
 
@@ -112,7 +114,7 @@ func CreateTransactionManager(sender framework.MessageSender, instanceModule *se
 	}
 
 	if err = manager.RegisterExecutor(framework.ComputePoolReadyEvent,
-		&task.HandleComputePoolReadyExecutor{sender, instanceModule, storageModule, networkModule}); err != nil {
+		&task.HandleComputePoolReadyExecutor{sender, instanceModule, storageModule, networkModule, hostModule}); err != nil {
 		return nil, err
 	}
 	if err = manager.RegisterExecutor(framework.ComputeCellRemovedEvent,
@@ -236,5 +238,195 @@ func CreateTransactionManager(sender framework.MessageSender, instanceModule *se
 		err = fmt.Errorf("register modify auto start fail: %s", err.Error())
 		return
 	}
+	if err = manager.RegisterExecutor(task.ModifyRestartPolicyRequest,
+		&task.ModifyRestartPolicyExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+		}); err != nil {
+		err = fmt.Errorf("register modify restart policy fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.QueryInstanceMetricsRequest,
+		&task.QueryInstanceMetricsExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+		}); err != nil {
+		err = fmt.Errorf("register query instance metrics fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.GetCellInventoryRequest,
+		&task.GetCellInventoryExecutor{
+			Sender:     sender,
+			HostModule: hostModule,
+		}); err != nil {
+		err = fmt.Errorf("register get cell inventory fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.QueryCellAlertRequest,
+		&task.QueryCellAlertExecutor{
+			Sender:      sender,
+			AlertModule: alertModule,
+		}); err != nil {
+		err = fmt.Errorf("register query cell alert fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.SetCellMaintenanceRequest,
+		&task.SetCellMaintenanceExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+		}); err != nil {
+		err = fmt.Errorf("register set cell maintenance fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.GetCellMaintenanceRequest,
+		&task.GetCellMaintenanceExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+		}); err != nil {
+		err = fmt.Errorf("register get cell maintenance fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.QueryCellCapacityRequest,
+		&task.QueryCellCapacityExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+			StorageModule:  storageModule,
+		}); err != nil {
+		err = fmt.Errorf("register query cell capacity fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.CaptureGuestScreenshotRequest,
+		&task.CaptureGuestScreenshotExecutor{
+			Sender:           sender,
+			InstanceModule:   instanceModule,
+			DiagnosticModule: diagnosticModule,
+		}); err != nil {
+		err = fmt.Errorf("register capture guest screenshot fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.DumpGuestMemoryRequest,
+		&task.DumpGuestMemoryExecutor{
+			Sender:           sender,
+			InstanceModule:   instanceModule,
+			DiagnosticModule: diagnosticModule,
+		}); err != nil {
+		err = fmt.Errorf("register dump guest memory fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.QueryGuestDiagnosticRequest,
+		&task.QueryGuestDiagnosticExecutor{
+			Sender:           sender,
+			InstanceModule:   instanceModule,
+			DiagnosticModule: diagnosticModule,
+		}); err != nil {
+		err = fmt.Errorf("register query guest diagnostic fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.SendGuestKeysRequest,
+		&task.SendGuestKeysExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+		}); err != nil {
+		err = fmt.Errorf("register send guest keys fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.InjectGuestNMIRequest,
+		&task.InjectGuestNMIExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+		}); err != nil {
+		err = fmt.Errorf("register inject guest nmi fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.IssueConsoleTokenRequest,
+		&task.IssueConsoleTokenExecutor{
+			Sender:        sender,
+			ConsoleModule: consoleModule,
+		}); err != nil {
+		err = fmt.Errorf("register issue console token fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.QueryDHCPLeaseRequest,
+		&task.QueryDHCPLeaseExecutor{
+			Sender:     sender,
+			DHCPModule: dhcpModule,
+		}); err != nil {
+		err = fmt.Errorf("register query dhcp lease fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.AddDHCPReservationRequest,
+		&task.AddDHCPReservationExecutor{
+			Sender:     sender,
+			DHCPModule: dhcpModule,
+		}); err != nil {
+		err = fmt.Errorf("register add dhcp reservation fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.RemoveDHCPReservationRequest,
+		&task.RemoveDHCPReservationExecutor{
+			Sender:     sender,
+			DHCPModule: dhcpModule,
+		}); err != nil {
+		err = fmt.Errorf("register remove dhcp reservation fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.UpdateOverlayNetworkRequest,
+		&task.UpdateOverlayNetworkExecutor{
+			Sender:        sender,
+			NetworkModule: networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register update overlay network fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.RemoveOverlayNetworkRequest,
+		&task.RemoveOverlayNetworkExecutor{
+			Sender:        sender,
+			NetworkModule: networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register remove overlay network fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.AddPortForwardRequest,
+		&task.AddPortForwardExecutor{
+			Sender:        sender,
+			NetworkModule: networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register add port forward fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.RemovePortForwardRequest,
+		&task.RemovePortForwardExecutor{
+			Sender:        sender,
+			NetworkModule: networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register remove port forward fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.QueryPortForwardRequest,
+		&task.QueryPortForwardExecutor{
+			Sender:        sender,
+			NetworkModule: networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register query port forward fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.AttachExternalAddressRequest,
+		&task.AttachExternalAddressExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+			NetworkModule:  networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register attach external address fail: %s", err.Error())
+		return
+	}
+	if err = manager.RegisterExecutor(task.DetachExternalAddressRequest,
+		&task.DetachExternalAddressExecutor{
+			Sender:         sender,
+			InstanceModule: instanceModule,
+			NetworkModule:  networkModule,
+		}); err != nil {
+		err = fmt.Errorf("register detach external address fail: %s", err.Error())
+		return
+	}
 	return manager, nil
 }